@@ -0,0 +1,249 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Check the bearer token on an admin request against the configured admin token
+// Both are hashed first so the comparison takes the same time no matter how long the tokens are
+func isAdminRequest(r *http.Request) bool {
+	header := r.Header.Get("Authorization")
+	token := strings.TrimPrefix(header, "Bearer ")
+	if token == header || token == "" {
+		return false
+	}
+
+	got := sha256.Sum256([]byte(token))
+	want := sha256.Sum256([]byte(config.AdminToken))
+	return subtle.ConstantTimeCompare(got[:], want[:]) == 1
+}
+
+// Wrap a handler so it can only be used with the admin token, passed in an `Authorization: Bearer <token>` header.
+// If no admin token is configured, the admin routes are disabled and always return 404
+func adminOnly(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if config.AdminToken == "" {
+			writeJSONError(w, http.StatusNotFound, errCodeNotFound, "not found")
+			return
+		}
+
+		if !isAdminRequest(r) {
+			logEvent("rejected admin request with a bad token", Fields{"remote_addr": r.RemoteAddr, "path": r.URL.Path})
+			writeJSONError(w, http.StatusUnauthorized, errCodeUnauthorized, "bad admin token")
+			return
+		}
+
+		h(w, r)
+	}
+}
+
+// DELETE /api/admin/instances/{teamId}
+// Forcibly destroy a team's instance of a challenge (from the challengeId query parameter, like the other instance routes)
+// 200 means the instance was destroyed, 404 means the team doesn't have an instance, 409 means it's being modified
+func (h *Handlers) adminDestroyInstanceRequest(w http.ResponseWriter, r *http.Request) {
+	teamId := mux.Vars(r)["teamId"]
+
+	// make sure the challenge exists
+	challengeId, ok := getRequestChallengeId(r)
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, errCodeUnknownChallenge, "unknown challenge")
+		return
+	}
+
+	if di := h.im.GetDeploymentInstance(teamId, challengeId); di == nil || di.getState() == Destroyed {
+		writeJSONError(w, http.StatusNotFound, errCodeNoInstance, "the team doesn't have an instance")
+		return
+	}
+
+	logEvent("admin is destroying instance", Fields{"team_id": teamId, "challenge_id": challengeId, "remote_addr": r.RemoteAddr})
+
+	if err := h.im.DestroyDeployment(r.Context(), teamId, challengeId); errors.Is(err, ErrNoInstance) {
+		writeJSONError(w, http.StatusNotFound, errCodeNoInstance, "the team doesn't have an instance")
+		return
+	} else if errors.Is(err, ErrBusy) {
+		logEvent("couldn't destroy instance", Fields{"team_id": teamId, "challenge_id": challengeId, "error": err.Error()})
+		writeJSONError(w, http.StatusConflict, errCodeBusy, "the instance is being modified, try again in a bit")
+		return
+	} else if errors.Is(err, ErrShuttingDown) {
+		writeShuttingDownError(w)
+		return
+	} else if err != nil {
+		logEvent("couldn't destroy instance", Fields{"team_id": teamId, "challenge_id": challengeId, "error": err.Error()})
+		writeInternalError(w)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+type AdminInstance struct {
+	TeamId      string `json:"teamId"`
+	ChallengeId string `json:"challengeId"`
+	AppName     string `json:"appName"`
+	Namespace   string `json:"namespace"`
+	State       string `json:"state"`
+	ExpTime     string `json:"expTime,omitempty"` // RFC3339
+	Host        string `json:"host,omitempty"`    // host:port string, only set for running instances
+	Flag        string `json:"flag,omitempty"`    // only set if flags are generated
+
+	DestroyFailures int `json:"destroyFailures,omitempty"` // how many times in a row destroying it has failed
+}
+
+// AdminInstanceFilter picks which instances are listed by the admin API. The zero value lists every instance
+type AdminInstanceFilter struct {
+	// only instances in this state, if set
+	State string
+
+	// only instances of this challenge, if set
+	ChallengeId string
+
+	// only instances that expire before this, if set
+	ExpiresBefore *time.Time
+}
+
+// check if an instance is picked by the filter
+func (f AdminInstanceFilter) matches(key InstanceKey, di *DeploymentInstance) bool {
+	if f.State != "" && di.getState().String() != f.State {
+		return false
+	}
+	if f.ChallengeId != "" && key.ChallengeId != f.ChallengeId {
+		return false
+	}
+	if expTime := di.getExpiration(); f.ExpiresBefore != nil && (expTime == nil || !expTime.Before(*f.ExpiresBefore)) {
+		return false
+	}
+
+	return true
+}
+
+// Get the instances this replica knows about that match the filter, sorted by team and challenge.
+// The instance map is only walked to take a snapshot of it, and the filtering is done on the snapshot
+func (h *Handlers) listAdminInstances(filter AdminInstanceFilter) []AdminInstance {
+	instances := []AdminInstance{}
+	for key, di := range h.im.Instances.Snapshot() {
+		if !filter.matches(key, di) {
+			continue
+		}
+
+		state := di.getState()
+		appName, namespace := di.getNames()
+		instance := AdminInstance{
+			TeamId:      key.TeamId,
+			ChallengeId: key.ChallengeId,
+			AppName:     appName,
+			Namespace:   namespace,
+			State:       state.String(),
+
+			DestroyFailures: di.getDestroyFailures(),
+		}
+		if expTime := di.getExpiration(); expTime != nil {
+			instance.ExpTime = expTime.Format(time.RFC3339)
+		}
+		if state == Running {
+			instance.Host = di.GetCxn()
+		}
+		if config.FlagTemplate != "" {
+			instance.Flag = generateFlag(key.TeamId, key.ChallengeId)
+		}
+
+		instances = append(instances, instance)
+	}
+
+	sort.Slice(instances, func(i, j int) bool {
+		if instances[i].TeamId != instances[j].TeamId {
+			return instances[i].TeamId < instances[j].TeamId
+		}
+		return instances[i].ChallengeId < instances[j].ChallengeId
+	})
+
+	return instances
+}
+
+// Get a page of instances, from offset. A limit of 0 means the rest of them
+func paginate(instances []AdminInstance, offset, limit int) []AdminInstance {
+	if offset >= len(instances) {
+		return []AdminInstance{}
+	}
+	instances = instances[offset:]
+
+	if limit > 0 && limit < len(instances) {
+		instances = instances[:limit]
+	}
+
+	return instances
+}
+
+// parse a non-negative int query parameter, which is 0 if it isn't set
+func getQueryInt(r *http.Request, name string) (int, bool) {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		return 0, true
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+
+	return n, true
+}
+
+// GET /api/admin/instances
+// List the instances, optionally filtered with ?state=deploying|running|destroying|destroyed|failed, ?challengeId=<id>, and
+// ?expiresWithin=<duration> (e.g., 10m), and paginated with ?limit=<n>&offset=<n>
+// Returns a JSON array of instances with the number of matching instances (before pagination) in the
+// X-Total-Count header, or 400 if a parameter is invalid
+func (h *Handlers) adminListInstancesRequest(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := AdminInstanceFilter{State: query.Get("state"), ChallengeId: query.Get("challengeId")}
+
+	if filter.State != "" && !Contains([]string{Deploying.String(), Running.String(), Destroying.String(), Destroyed.String(), Failed.String()}, filter.State) {
+		writeJSONError(w, http.StatusBadRequest, errCodeBadRequest, "state must be deploying, running, destroying, destroyed, or failed")
+		return
+	}
+
+	if s := query.Get("expiresWithin"); s != "" {
+		within, err := time.ParseDuration(s)
+		if err != nil || within <= 0 {
+			writeJSONError(w, http.StatusBadRequest, errCodeBadRequest, "expiresWithin must be a positive duration, like 10m")
+			return
+		}
+		expiresBefore := time.Now().UTC().Add(within)
+		filter.ExpiresBefore = &expiresBefore
+	}
+
+	limit, ok := getQueryInt(r, "limit")
+	if !ok {
+		writeJSONError(w, http.StatusBadRequest, errCodeBadRequest, "limit must be a non-negative number")
+		return
+	}
+	offset, ok := getQueryInt(r, "offset")
+	if !ok {
+		writeJSONError(w, http.StatusBadRequest, errCodeBadRequest, "offset must be a non-negative number")
+		return
+	}
+
+	instances := h.listAdminInstances(filter)
+	w.Header().Set("X-Total-Count", strconv.Itoa(len(instances)))
+
+	respBytes, err := json.Marshal(paginate(instances, offset, limit))
+	if err != nil {
+		log.Printf("error handling admin list instances request, couldn't marshal response data: %v", err)
+		writeInternalError(w)
+		return
+	}
+
+	w.Header().Add("Content-type", "application/json")
+	w.Write(respBytes)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/captainGeech42/chaldeploy/internal/generic_map"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+const testAdminToken = "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+
+func TestAdminOnly(t *testing.T) {
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}
+	h := adminOnly(ok)
+
+	request := func(header string) int {
+		r := httptest.NewRequest(http.MethodGet, "/api/admin/instances", nil)
+		if header != "" {
+			r.Header.Set("Authorization", header)
+		}
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w.Code
+	}
+
+	// disabled without an admin token
+	config = &Config{}
+	assert.Equal(t, http.StatusNotFound, request("Bearer "))
+
+	config.AdminToken = testAdminToken
+	assert.Equal(t, http.StatusUnauthorized, request(""))
+	assert.Equal(t, http.StatusUnauthorized, request("Bearer "))
+	assert.Equal(t, http.StatusUnauthorized, request(testAdminToken))
+	assert.Equal(t, http.StatusUnauthorized, request("Bearer "+testAdminToken+"b"))
+	assert.Equal(t, http.StatusOK, request("Bearer "+testAdminToken))
+}
+
+func TestAdminDestroyInstanceNotFound(t *testing.T) {
+	config = &Config{AdminToken: testAdminToken, Challenges: map[string]ChallengeSpec{DefaultChallengeId: {}}}
+	im = &InstanceManager{Instances: new(generic_map.MapOf[InstanceKey, *DeploymentInstance])}
+	h := NewHandlers(im)
+	im.Instances.Store(InstanceKey{TeamId: "team2", ChallengeId: DefaultChallengeId}, &DeploymentInstance{State: Destroyed})
+
+	for _, teamId := range []string{"team1", "team2"} {
+		r := httptest.NewRequest(http.MethodDelete, "/api/admin/instances/"+teamId, nil)
+		r = mux.SetURLVars(r, map[string]string{"teamId": teamId})
+		w := httptest.NewRecorder()
+
+		h.adminDestroyInstanceRequest(w, r)
+		assert.Equal(t, http.StatusNotFound, w.Code, teamId)
+	}
+
+	// unknown challenge
+	r := httptest.NewRequest(http.MethodDelete, "/api/admin/instances/team1?challengeId=asdf", nil)
+	r = mux.SetURLVars(r, map[string]string{"teamId": "team1"})
+	w := httptest.NewRecorder()
+	h.adminDestroyInstanceRequest(w, r)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestAdminListInstances(t *testing.T) {
+	config = &Config{}
+	expTime := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	im = &InstanceManager{Instances: new(generic_map.MapOf[InstanceKey, *DeploymentInstance])}
+	h := NewHandlers(im)
+	im.Instances.Store(InstanceKey{TeamId: "team2", ChallengeId: "default"}, &DeploymentInstance{AppName: "app2", Namespace: "app2", State: Destroyed})
+	im.Instances.Store(InstanceKey{TeamId: "team1", ChallengeId: "default"}, &DeploymentInstance{AppName: "app1", Namespace: "app1", State: Running, ExpTime: &expTime, Hostname: "1.2.3.4", Port: 31337})
+
+	list := func(query string) (int, []AdminInstance) {
+		w := httptest.NewRecorder()
+		h.adminListInstancesRequest(w, httptest.NewRequest(http.MethodGet, "/api/admin/instances"+query, nil))
+
+		instances := []AdminInstance{}
+		if w.Code == http.StatusOK {
+			assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &instances))
+		}
+		return w.Code, instances
+	}
+
+	code, instances := list("")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []AdminInstance{
+		{TeamId: "team1", ChallengeId: "default", AppName: "app1", Namespace: "app1", State: "running", ExpTime: "2022-10-01T12:00:00Z", Host: "1.2.3.4:31337"},
+		{TeamId: "team2", ChallengeId: "default", AppName: "app2", Namespace: "app2", State: "destroyed"},
+	}, instances)
+
+	code, instances = list("?state=running")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Len(t, instances, 1)
+	assert.Equal(t, "team1", instances[0].TeamId)
+
+	code, instances = list("?state=destroying")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Empty(t, instances)
+
+	code, _ = list("?state=asdf")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, _ = list("?limit=-1")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = list("?expiresWithin=asdf")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// flags are included if they're generated
+	config.FlagTemplate = "flag{team_%s}"
+	code, instances = list("")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "flag{team_team1}", instances[0].Flag)
+	assert.Equal(t, "flag{team_team2}", instances[1].Flag)
+}
+
+func TestAdminListInstancesPagination(t *testing.T) {
+	config = &Config{}
+	now := time.Now().UTC()
+	im = &InstanceManager{Instances: new(generic_map.MapOf[InstanceKey, *DeploymentInstance])}
+	h := NewHandlers(im)
+	for i := 0; i < 5; i++ {
+		expTime := now.Add(time.Duration(i+1) * time.Minute)
+		teamId := fmt.Sprintf("team%d", i)
+		im.Instances.Store(InstanceKey{TeamId: teamId, ChallengeId: "default"}, &DeploymentInstance{State: Running, ExpTime: &expTime})
+		im.Instances.Store(InstanceKey{TeamId: teamId, ChallengeId: "web"}, &DeploymentInstance{State: Destroyed})
+	}
+
+	list := func(query string) ([]string, string) {
+		w := httptest.NewRecorder()
+		h.adminListInstancesRequest(w, httptest.NewRequest(http.MethodGet, "/api/admin/instances"+query, nil))
+		assert.Equal(t, http.StatusOK, w.Code, query)
+
+		instances := []AdminInstance{}
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &instances))
+		keys := []string{}
+		for _, instance := range instances {
+			keys = append(keys, instance.TeamId+"/"+instance.ChallengeId)
+		}
+		return keys, w.Header().Get("X-Total-Count")
+	}
+
+	keys, total := list("?limit=3")
+	assert.Equal(t, []string{"team0/default", "team0/web", "team1/default"}, keys)
+	assert.Equal(t, "10", total)
+
+	keys, total = list("?challengeId=web&limit=2&offset=3")
+	assert.Equal(t, []string{"team3/web", "team4/web"}, keys)
+	assert.Equal(t, "5", total)
+
+	keys, total = list("?offset=100")
+	assert.Empty(t, keys)
+	assert.Equal(t, "10", total)
+
+	// destroyed instances don't have an expiration time, so they never match
+	keys, total = list("?expiresWithin=150s")
+	assert.Equal(t, []string{"team0/default", "team1/default"}, keys)
+	assert.Equal(t, "2", total)
+}
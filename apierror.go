@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// error codes for ErrorResponse, so clients can handle errors without parsing the messages
+const (
+	errCodeNotAuthenticated      = "not_authenticated"
+	errCodeUnauthorized          = "unauthorized"
+	errCodeAuthFailed            = "auth_failed"
+	errCodeCSRF                  = "csrf_failed"
+	errCodeBadOrigin             = "bad_origin"
+	errCodeMalformedToken        = "malformed_token"
+	errCodeBadRequest            = "bad_request"
+	errCodeNotFound              = "not_found"
+	errCodeUnknownChallenge      = "unknown_challenge"
+	errCodeNoInstance            = "no_instance"
+	errCodeBusy                  = "busy"
+	errCodeInstanceFailed        = "instance_failed"
+	errCodeCooldown              = "cooldown"
+	errCodeMaxExtensions         = "max_extensions"
+	errCodeRateLimited           = "rate_limited"
+	errCodeCapacityReached       = "capacity_reached"
+	errCodeTeamLimitReached      = "team_limit_reached"
+	errCodeScoreboardUnavailable = "scoreboard_unavailable"
+	errCodeShuttingDown          = "shutting_down"
+	errCodeInternal              = "internal_error"
+)
+
+// ErrorResponse is the body of every error from the API routes
+type ErrorResponse struct {
+	// human readable message, safe to show to the team
+	Error string `json:"error"`
+
+	// one of the errCode constants
+	Code string `json:"code"`
+}
+
+// Write an error response as JSON with the status code. Any headers (e.g., Retry-After) need to be set before calling this
+func writeJSONError(w http.ResponseWriter, status int, code, msg string) {
+	respBytes, err := json.Marshal(ErrorResponse{Error: msg, Code: code})
+	if err != nil {
+		log.Printf("couldn't marshal error response: %v", err)
+		w.WriteHeader(status)
+		return
+	}
+
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(status)
+	w.Write(respBytes)
+}
+
+// Write a 503 error for an instance operation that was started while chaldeploy is shutting down
+func writeShuttingDownError(w http.ResponseWriter) {
+	writeJSONError(w, http.StatusServiceUnavailable, errCodeShuttingDown, "chaldeploy is restarting, try again in a bit")
+}
+
+// Write a generic 500 error. The details are logged by the caller, and aren't sent to the client
+func writeInternalError(w http.ResponseWriter) {
+	writeJSONError(w, http.StatusInternalServerError, errCodeInternal, "internal server error, contact an admin")
+}
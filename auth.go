@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// returned when the token from the user isn't in the format the auth provider expects
+var ErrMalformedToken = errors.New("malformed token")
+
+// returned when the scoreboard can't be reached, or keeps erroring (5xx)
+var ErrScoreboardUnavailable = errors.New("scoreboard unavailable")
+
+// how many times a request to the scoreboard is tried before giving up on it
+const scoreboardMaxAttempts = 3
+
+// how many idle connections to the scoreboard are kept around to be reused
+const scoreboardMaxIdleConns = 32
+
+// delay before the first retry of a scoreboard request, doubled for each retry after that. a var so tests can shorten it
+var scoreboardRetryDelay = 500 * time.Millisecond
+
+// Secret is a token that should never be logged. Formatting it (with any verb) or marshalling it to JSON
+// gives a placeholder instead of the value, so a struct holding one can be logged safely
+type Secret string
+
+func (s Secret) String() string {
+	return "[redacted]"
+}
+
+func (s Secret) GoString() string {
+	return s.String()
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// Team info from the scoreboard
+type UserInfo struct {
+	TeamName string
+	Id       string
+}
+
+// AuthProvider authenticates teams against a CTF scoreboard
+type AuthProvider interface {
+	// Validate the token from the user and get a auth token back
+	// If comms are successful but auth is bad, returns ("", nil)
+	// If the token isn't in the expected format, returns an ErrMalformedToken error without calling the scoreboard
+	Authenticate(token string) (string, error)
+
+	// Get the team info for an auth token
+	UserInfo(authToken string) (UserInfo, error)
+}
+
+// Send a request to the scoreboard, retrying with backoff if it can't be reached or returns a 5xx.
+// newReq is called for each attempt, since a request body can only be read once.
+// If every attempt fails, the error wraps ErrScoreboardUnavailable
+func doScoreboardRequest(client *http.Client, newReq func() (*http.Request, error)) (*http.Response, error) {
+	var lastErr error
+
+	for attempt := 0; attempt < scoreboardMaxAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(scoreboardRetryDelay << (attempt - 1))
+		}
+
+		req, err := newReq()
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if resp.StatusCode >= 500 {
+			resp.Body.Close()
+			lastErr = fmt.Errorf("got status %d from %s", resp.StatusCode, req.URL.Path)
+			continue
+		}
+
+		return resp, nil
+	}
+
+	return nil, fmt.Errorf("%w after %d attempts: %v", ErrScoreboardUnavailable, scoreboardMaxAttempts, lastErr)
+}
+
+// Create the HTTP client used for all of the requests to the scoreboard.
+// It's shared so connections are reused, and has a timeout so a slow scoreboard can't hang requests forever
+func newScoreboardClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = scoreboardMaxIdleConns
+	transport.MaxIdleConnsPerHost = scoreboardMaxIdleConns
+	transport.IdleConnTimeout = 90 * time.Second
+	transport.TLSHandshakeTimeout = config.AuthTimeout
+	transport.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+
+	return &http.Client{
+		Timeout:   config.AuthTimeout,
+		Transport: transport,
+	}
+}
+
+// Create the auth provider selected by the config, using client for the requests to the scoreboard
+func newAuthProvider(client *http.Client) (AuthProvider, error) {
+	switch config.AuthProvider {
+	case "rctf":
+		return &RctfProvider{Url: strings.TrimSuffix(config.RctfServer, "/"), Client: client}, nil
+	case "ctfd":
+		return &CtfdProvider{Url: strings.TrimSuffix(config.CtfdServer, "/"), Client: client}, nil
+	default:
+		return nil, fmt.Errorf("unknown auth provider: %s", config.AuthProvider)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDoScoreboardRequest(t *testing.T) {
+	scoreboardRetryDelay = time.Millisecond
+	defer func() { scoreboardRetryDelay = 500 * time.Millisecond }()
+
+	// fails twice, then works
+	attempts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	newReq := func() (*http.Request, error) {
+		return http.NewRequest(http.MethodGet, srv.URL, nil)
+	}
+
+	resp, err := doScoreboardRequest(http.DefaultClient, newReq)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp.Body.Close()
+	assert.Equal(t, 3, attempts)
+
+	// keeps failing
+	attempts = -10
+	_, err = doScoreboardRequest(http.DefaultClient, newReq)
+	assert.ErrorIs(t, err, ErrScoreboardUnavailable)
+	assert.Equal(t, -10+scoreboardMaxAttempts, attempts)
+
+	// unreachable
+	srv.Close()
+	_, err = doScoreboardRequest(http.DefaultClient, newReq)
+	assert.ErrorIs(t, err, ErrScoreboardUnavailable)
+
+	// client errors aren't retried
+	attempts = 0
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+	resp, err = doScoreboardRequest(http.DefaultClient, func() (*http.Request, error) {
+		return http.NewRequest(http.MethodGet, srv.URL, nil)
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp.Body.Close()
+	assert.Equal(t, 1, attempts)
+}
+
+func TestScoreboardClientTimeout(t *testing.T) {
+	scoreboardRetryDelay = time.Millisecond
+	defer func() { scoreboardRetryDelay = 500 * time.Millisecond }()
+
+	config = &Config{AuthTimeout: 50 * time.Millisecond}
+	client := newScoreboardClient()
+	assert.Equal(t, config.AuthTimeout, client.Timeout)
+
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer srv.Close()
+	defer close(done)
+
+	start := time.Now()
+	_, err := doScoreboardRequest(client, func() (*http.Request, error) {
+		return http.NewRequest(http.MethodGet, srv.URL, nil)
+	})
+	assert.ErrorIs(t, err, ErrScoreboardUnavailable)
+	assert.Less(t, time.Since(start), 2*time.Second)
+}
+
+func TestAuthRequestScoreboardDown(t *testing.T) {
+	scoreboardRetryDelay = time.Millisecond
+	defer func() { scoreboardRetryDelay = 500 * time.Millisecond }()
+
+	config = &Config{AuthProvider: "rctf"}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+	authProvider = &RctfProvider{Url: srv.URL, Client: http.DefaultClient}
+
+	r := httptest.NewRequest(http.MethodPost, "/api/auth", strings.NewReader("c2VjcmV0bG9naW50b2tlbg=="))
+	w := httptest.NewRecorder()
+	authRequest(w, r, sessions.NewSession(sessions.NewCookieStore([]byte("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")), "session"))
+
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+	assert.Contains(t, w.Body.String(), errCodeScoreboardUnavailable)
+}
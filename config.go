@@ -1,76 +1,575 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"reflect"
 	"strconv"
 	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
 )
 
+// id of the challenge configured by $CHALDEPLOY_NAME, $CHALDEPLOY_IMAGE, and $CHALDEPLOY_PORT
+const DefaultChallengeId = "default"
+
+// ChallengeSpec describes a single challenge that can be deployed
+type ChallengeSpec struct {
+	// Name of the challenge
+	Name string `json:"name"`
+
+	// Image path for the challenge
+	Image string `json:"image"`
+
+	// Port exposed by the challenge, must be 1-65535
+	Port int `json:"port"`
+
+	// Security context for the challenge container. If set, it replaces the default one entirely
+	// (e.g., for pwn challenges that need specific capabilities)
+	SecurityContext *corev1.SecurityContext `json:"securityContext,omitempty"`
+
+	// Seccomp profile for the challenge pod, in the same format as $CHALDEPLOY_SECCOMP_PROFILE. If not set, the global one is used
+	SeccompProfile string `json:"seccompProfile,omitempty"`
+
+	// Path for an HTTP GET probe, for web challenges. If not set, the probes are TCP connections to the port
+	ProbeHttpPath string `json:"probeHttpPath,omitempty"`
+
+	// Deployment strategy for the challenge, RollingUpdate or Recreate. If not set, the global one is used
+	DeploymentStrategy string `json:"deploymentStrategy,omitempty"`
+
+	// Protocol for the challenge port, TCP or UDP. If not set, the global one is used
+	Protocol string `json:"protocol,omitempty"`
+
+	// Ports exposed by the challenge, for challenges with more than one. Can't be set along with Port
+	Ports []PortSpec `json:"ports,omitempty"`
+
+	// Template for the instructions on how to connect to an instance, in the same format as $CHALDEPLOY_INSTRUCTIONS.
+	// If not set, the global one is used
+	Instructions string `json:"instructions,omitempty"`
+
+	// Command (entrypoint) for the challenge container, for an image that's shared between challenges. If not set, the image's is used
+	Command []string `json:"command,omitempty"`
+
+	// Args for the challenge container's command. If not set, the image's are used
+	Args []string `json:"args,omitempty"`
+
+	// Working directory for the challenge container, must be absolute. If not set, the image's is used
+	WorkingDir string `json:"workingDir,omitempty"`
+}
+
+// A port exposed by a challenge container
+type PortSpec struct {
+	// Name of the port, shown to teams if the challenge has more than one public port. Must be a valid k8s port name
+	Name string `json:"name"`
+
+	// Port the challenge listens on, must be 1-65535
+	ContainerPort int `json:"containerPort"`
+
+	// Protocol for the port, TCP or UDP. If not set, the challenge's protocol is used
+	Protocol string `json:"protocol,omitempty"`
+
+	// Expose the port to teams through the instance's service. Ports that aren't public are only reachable from inside the pod
+	Public bool `json:"public"`
+}
+
 type Config struct {
 	// $CHALDEPLOY_NAME: Name of the challenge to deploy
 	ChallengeName string `env:"CHALDEPLOY_NAME"`
 
-	// $CHALDEPLOY_PORT: Port exposed by the challenge, must be 1-65535
-	ChallengePort int `env:"CHALDEPLOY_PORT"`
+	// $CHALDEPLOY_PORT: Port exposed by the challenge, must be 1-65535. Required unless $CHALDEPLOY_PORTS is set
+	ChallengePort int `env:"CHALDEPLOY_PORT,optional"`
+
+	// $CHALDEPLOY_PORTS (optional): JSON array of ports ({"name", "containerPort", "protocol", "public"}) for a challenge that exposes more than one
+	ChallengePorts []PortSpec `env:"CHALDEPLOY_PORTS,optional"`
 
 	// $CHALDEPLOY_IMAGE: Image path for the challenge
 	ChallengeImage string `env:"CHALDEPLOY_IMAGE"`
 
+	// $CHALDEPLOY_COMMAND (optional): JSON array to override the entrypoint of the challenge container. If not set, the image's is used
+	ChallengeCommand []string `env:"CHALDEPLOY_COMMAND,optional"`
+
+	// $CHALDEPLOY_ARGS (optional): JSON array to override the args (CMD) of the challenge container. If not set, the image's are used
+	ChallengeArgs []string `env:"CHALDEPLOY_ARGS,optional"`
+
+	// $CHALDEPLOY_WORKING_DIR (optional): Working directory for the challenge container, must be absolute. If not set, the image's is used
+	ChallengeWorkingDir string `env:"CHALDEPLOY_WORKING_DIR,optional"`
+
+	// $CHALDEPLOY_INSTRUCTIONS (optional): Go template for the instructions on how to connect to an instance, shown to teams
+	// once it's running (e.g., "nc {{.Hostname}} {{.Port}}"). If not set, teams only get the host
+	ChallengeInstructions string `env:"CHALDEPLOY_INSTRUCTIONS,optional"`
+
 	// $CHALDEPLOY_SESSION_KEY: Secret key used to authenticate session data. Must be 32 or 64 chars long
 	SessionKey string `env:"CHALDEPLOY_SESSION_KEY"`
 
-	// $CHALDEPLOY_RCTF_SERVER: rCTF server to auth against
-	RctfServer string `env:"CHALDEPLOY_RCTF_SERVER"`
+	// $CHALDEPLOY_PREVIOUS_SESSION_KEYS (optional): JSON array of old session keys. Sessions signed with them are still accepted,
+	// but new sessions are always signed with $CHALDEPLOY_SESSION_KEY, so the key can be rotated without logging everyone out
+	PreviousSessionKeys []string `env:"CHALDEPLOY_PREVIOUS_SESSION_KEYS,optional"`
+
+	// $CHALDEPLOY_COOKIE_SECURE (optional): Only send the session cookie over https. Defaults to true
+	CookieSecure bool `env:"CHALDEPLOY_COOKIE_SECURE" default:"true"`
+
+	// $CHALDEPLOY_COOKIE_SAME_SITE (optional): SameSite mode for the session cookie, Strict, Lax, or None. Defaults to Lax
+	CookieSameSite string `env:"CHALDEPLOY_COOKIE_SAME_SITE" default:"Lax"`
+
+	// $CHALDEPLOY_COOKIE_DOMAIN (optional): Domain for the session cookie. If not set, the cookie is only sent to the host that set it
+	CookieDomain string `env:"CHALDEPLOY_COOKIE_DOMAIN,optional"`
+
+	// $CHALDEPLOY_SESSION_MAX_AGE (optional): How long a session lasts before the team has to auth again. Defaults to 720h (30 days)
+	SessionMaxAge time.Duration `env:"CHALDEPLOY_SESSION_MAX_AGE" default:"720h"`
+
+	// $CHALDEPLOY_CSRF_ENABLED (optional): Require a CSRF token on the create/extend/destroy routes. Defaults to true
+	CSRFEnabled bool `env:"CHALDEPLOY_CSRF_ENABLED" default:"true"`
+
+	// $CHALDEPLOY_ALLOWED_ORIGINS (optional): JSON array of origins (e.g., https://ctf.example.com) that can use the API cross-origin.
+	// If not set, the API is same-origin only
+	AllowedOrigins []string `env:"CHALDEPLOY_ALLOWED_ORIGINS,optional"`
+
+	// $CHALDEPLOY_ADMIN_TOKEN (optional): Bearer token for the admin API, must be at least 32 chars long. If not set, the admin API is disabled
+	AdminToken string `env:"CHALDEPLOY_ADMIN_TOKEN,optional"`
+
+	// $CHALDEPLOY_AUTH_PROVIDER (optional): Scoreboard used to authenticate teams, rctf or ctfd. Defaults to rctf
+	AuthProvider string `env:"CHALDEPLOY_AUTH_PROVIDER" default:"rctf"`
+
+	// $CHALDEPLOY_RCTF_SERVER (optional): Base url of the rCTF server to auth against. Required if the auth provider is rctf
+	RctfServer string `env:"CHALDEPLOY_RCTF_SERVER,optional"`
+
+	// $CHALDEPLOY_CTFD_SERVER (optional): Base url of the CTFd server to auth against. Required if the auth provider is ctfd
+	CtfdServer string `env:"CHALDEPLOY_CTFD_SERVER,optional"`
+
+	// $CHALDEPLOY_AUTH_TIMEOUT (optional): Timeout for each request to the scoreboard. Defaults to 10s
+	AuthTimeout time.Duration `env:"CHALDEPLOY_AUTH_TIMEOUT" default:"10s"`
+
+	// $CHALDEPLOY_USER_INFO_CACHE_TTL (optional): How long the team info from the scoreboard is cached for. Set to 0 to disable the cache. Defaults to 1m
+	UserInfoCacheTTL time.Duration `env:"CHALDEPLOY_USER_INFO_CACHE_TTL" default:"1m"`
+
+	// $CHALDEPLOY_USER_INFO_CACHE_SIZE (optional): Max number of auth tokens to cache the team info for. Defaults to 1000
+	UserInfoCacheSize int `env:"CHALDEPLOY_USER_INFO_CACHE_SIZE" default:"1000"`
 
 	// $CHALDEPLOY_K8SCONFIG (optional): Path to the k8s config. If not set, k8s config will be loaded from /var/run/secrets or ~/.kube
 	K8sConfigPath string `env:"CHALDEPLOY_K8SCONFIG,optional"`
+
+	// $CHALDEPLOY_K8S_QPS (optional): How many requests per second the k8s client can make to the API server, on average. Defaults to 50
+	K8sQPS int `env:"CHALDEPLOY_K8S_QPS" default:"50"`
+
+	// $CHALDEPLOY_K8S_BURST (optional): How many requests the k8s client can make to the API server in a burst, above the QPS. Defaults to 100
+	K8sBurst int `env:"CHALDEPLOY_K8S_BURST" default:"100"`
+
+	// $CHALDEPLOY_SERVICE_TYPE (optional): Type of k8s service used to expose the challenge, LoadBalancer or NodePort. Defaults to LoadBalancer
+	ServiceType string `env:"CHALDEPLOY_SERVICE_TYPE" default:"LoadBalancer"`
+
+	// $CHALDEPLOY_NODE_ADDRESS (optional): External address of the cluster nodes. Required if the service type is NodePort
+	NodeAddress string `env:"CHALDEPLOY_NODE_ADDRESS,optional"`
+
+	// $CHALDEPLOY_INSTANCE_TTL (optional): How long an instance runs before it is destroyed, as a Go duration string. Defaults to 1h
+	InstanceTTL time.Duration `env:"CHALDEPLOY_INSTANCE_TTL" default:"1h"`
+
+	// $CHALDEPLOY_EXTEND_DURATION (optional): How much time is added to an instance when it is extended. Defaults to 1h
+	ExtendDuration time.Duration `env:"CHALDEPLOY_EXTEND_DURATION" default:"1h"`
+
+	// $CHALDEPLOY_MAX_TTL (optional): Max amount of time an instance can have left after being extended. If not set, there is no cap
+	MaxTTL time.Duration `env:"CHALDEPLOY_MAX_TTL,optional"`
+
+	// $CHALDEPLOY_MAX_EXTENSIONS (optional): Max number of times an instance can be extended. If not set, there is no cap
+	MaxExtensions int `env:"CHALDEPLOY_MAX_EXTENSIONS,optional"`
+
+	// $CHALDEPLOY_MAX_DESTROY_RETRIES (optional): How many times a failed destroy is retried (with a backoff) before giving up on it. Defaults to 5
+	MaxDestroyRetries int `env:"CHALDEPLOY_MAX_DESTROY_RETRIES" default:"5"`
+
+	// $CHALDEPLOY_FAILED_INSTANCE_GRACE_PERIOD (optional): How long an instance that failed to deploy is kept around (so an
+	// organizer can see what went wrong) before the reaper cleans it up. Defaults to 10m
+	FailedInstanceGracePeriod time.Duration `env:"CHALDEPLOY_FAILED_INSTANCE_GRACE_PERIOD" default:"10m"`
+
+	// $CHALDEPLOY_REAPER_CONCURRENCY (optional): How many expired instances the reaper destroys at once. Defaults to 4
+	ReaperConcurrency int `env:"CHALDEPLOY_REAPER_CONCURRENCY" default:"4"`
+
+	// $CHALDEPLOY_EXPIRY_WARNING_WINDOW (optional): How long before an instance expires to warn the team, so they can extend it. Set to 0 to disable the warning. Defaults to 5m
+	ExpiryWarningWindow time.Duration `env:"CHALDEPLOY_EXPIRY_WARNING_WINDOW" default:"5m"`
+
+	// $CHALDEPLOY_PROTOCOL (optional): Protocol for the challenge port, TCP or UDP. Defaults to TCP
+	ChallengeProtocol string `env:"CHALDEPLOY_PROTOCOL" default:"TCP"`
+
+	// $CHALDEPLOY_CHALLENGES (optional): JSON object of challenge id -> {"name", "image", "port", "securityContext", "seccompProfile", "probeHttpPath", "deploymentStrategy", "protocol", "ports"} for additional challenges to serve.
+	// The challenge from $CHALDEPLOY_NAME/$CHALDEPLOY_IMAGE/$CHALDEPLOY_PORT is always available as "default"
+	Challenges map[string]ChallengeSpec `env:"CHALDEPLOY_CHALLENGES,optional"`
+
+	// $CHALDEPLOY_CHALLENGE_ENV (optional): JSON object of env var name -> value to set in challenge containers.
+	// Values are Go templates, with the fields of EnvTemplateData available (e.g., {{.TeamID}})
+	ChallengeEnv map[string]string `env:"CHALDEPLOY_CHALLENGE_ENV,optional"`
+
+	// $CHALDEPLOY_CHALLENGE_SECRET_ENV (optional): Same as $CHALDEPLOY_CHALLENGE_ENV, but the values are stored in a secret
+	// in the instance namespace instead of in the deployment
+	ChallengeSecretEnv map[string]string `env:"CHALDEPLOY_CHALLENGE_SECRET_ENV,optional"`
+
+	// $CHALDEPLOY_CHALLENGE_FILES (optional): JSON object of file name -> contents to mount into challenge containers.
+	// Contents are Go templates, like $CHALDEPLOY_CHALLENGE_ENV
+	ChallengeFiles map[string]string `env:"CHALDEPLOY_CHALLENGE_FILES,optional"`
+
+	// $CHALDEPLOY_CHALLENGE_MOUNT_PATH (optional): Directory the challenge files are mounted at, must be absolute. Defaults to /challenge
+	ChallengeMountPath string `env:"CHALDEPLOY_CHALLENGE_MOUNT_PATH" default:"/challenge"`
+
+	// $CHALDEPLOY_INIT_CONTAINER_IMAGE (optional): Image for a container that runs to completion before the challenge container starts,
+	// for per-instance setup. If not set, there's no init container
+	InitContainerImage string `env:"CHALDEPLOY_INIT_CONTAINER_IMAGE,optional"`
+
+	// $CHALDEPLOY_INIT_CONTAINER_COMMAND (optional): JSON array for the command of the init container. If not set, the image's entrypoint is used
+	InitContainerCommand []string `env:"CHALDEPLOY_INIT_CONTAINER_COMMAND,optional"`
+
+	// $CHALDEPLOY_SHARED_VOLUME (optional): Directory to mount an empty volume at in the init and challenge containers, must be absolute.
+	// If not set, there's no shared volume
+	SharedVolume string `env:"CHALDEPLOY_SHARED_VOLUME,optional"`
+
+	// $CHALDEPLOY_SCRATCH_VOLUME_SIZE_LIMIT (optional): Size limit for a writable scratch volume in challenge containers, as a k8s quantity,
+	// e.g. for /tmp with a read-only root filesystem. If not set, there's no scratch volume
+	ScratchVolumeSizeLimit string `env:"CHALDEPLOY_SCRATCH_VOLUME_SIZE_LIMIT,optional"`
+
+	// $CHALDEPLOY_SCRATCH_MOUNT_PATH (optional): Directory the scratch volume is mounted at, must be absolute. Defaults to /tmp
+	ScratchMountPath string `env:"CHALDEPLOY_SCRATCH_MOUNT_PATH" default:"/tmp"`
+
+	// $CHALDEPLOY_SCRATCH_VOLUME_IN_MEMORY (optional): Back the scratch volume with memory (tmpfs) instead of the node's disk.
+	// It counts against the container's memory limit. Defaults to false
+	ScratchVolumeInMemory bool `env:"CHALDEPLOY_SCRATCH_VOLUME_IN_MEMORY" default:"false"`
+
+	// $CHALDEPLOY_SIDECAR_IMAGE (optional): Image for a container that runs next to the challenge container, e.g. a proxy.
+	// It shares the pod network, so it can reach the challenge on localhost. If not set, there's no sidecar
+	SidecarImage string `env:"CHALDEPLOY_SIDECAR_IMAGE,optional"`
+
+	// $CHALDEPLOY_SIDECAR_COMMAND (optional): JSON array for the command of the sidecar. If not set, the image's entrypoint is used
+	SidecarCommand []string `env:"CHALDEPLOY_SIDECAR_COMMAND,optional"`
+
+	// $CHALDEPLOY_SIDECAR_CPU_LIMIT (optional): CPU limit for the sidecar, as a k8s quantity. Defaults to 100m
+	SidecarCPULimit string `env:"CHALDEPLOY_SIDECAR_CPU_LIMIT" default:"100m"`
+
+	// $CHALDEPLOY_SIDECAR_MEMORY_LIMIT (optional): Memory limit for the sidecar, as a k8s quantity. Defaults to 64Mi
+	SidecarMemoryLimit string `env:"CHALDEPLOY_SIDECAR_MEMORY_LIMIT" default:"64Mi"`
+
+	// $CHALDEPLOY_SIDECAR_CPU_REQUEST (optional): CPU request for the sidecar, as a k8s quantity. Defaults to 10m
+	SidecarCPURequest string `env:"CHALDEPLOY_SIDECAR_CPU_REQUEST" default:"10m"`
+
+	// $CHALDEPLOY_SIDECAR_MEMORY_REQUEST (optional): Memory request for the sidecar, as a k8s quantity. Defaults to 16Mi
+	SidecarMemoryRequest string `env:"CHALDEPLOY_SIDECAR_MEMORY_REQUEST" default:"16Mi"`
+
+	// $CHALDEPLOY_TERMINATION_GRACE_PERIOD (optional): How long challenge pods get to shut down after being sent SIGTERM before they're killed,
+	// in whole seconds. Defaults to 30s (the k8s default)
+	TerminationGracePeriod time.Duration `env:"CHALDEPLOY_TERMINATION_GRACE_PERIOD" default:"30s"`
+
+	// $CHALDEPLOY_PRESTOP_COMMAND (optional): JSON array for a command to run in the challenge container before it's sent SIGTERM.
+	// If not set, there's no preStop hook
+	PreStopCommand []string `env:"CHALDEPLOY_PRESTOP_COMMAND,optional"`
+
+	// $CHALDEPLOY_FLAG_TEMPLATE (optional): Format string for a unique per-team flag (e.g., flag{team_%s}), with one %s for the team value.
+	// If set, the flag is injected into challenge containers as a secret env var
+	FlagTemplate string `env:"CHALDEPLOY_FLAG_TEMPLATE,optional"`
+
+	// $CHALDEPLOY_FLAG_SECRET (optional): Secret key used to generate the flags with an HMAC, must be at least 32 chars long.
+	// If not set, the team id is used in the flag, which other teams could guess
+	FlagSecret string `env:"CHALDEPLOY_FLAG_SECRET,optional"`
+
+	// $CHALDEPLOY_FLAG_ENV (optional): Name of the env var the flag is injected as. Defaults to FLAG
+	FlagEnv string `env:"CHALDEPLOY_FLAG_ENV" default:"FLAG"`
+
+	// $CHALDEPLOY_REPLICAS (optional): Number of pods for each challenge instance, must be at least 1. Defaults to 1
+	Replicas int `env:"CHALDEPLOY_REPLICAS" default:"1"`
+
+	// $CHALDEPLOY_READINESS_PROBE_TCP (optional): Add a readiness probe on the challenge port. An instance isn't ready until
+	// the probe passes. Defaults to false
+	ReadinessProbeTCP bool `env:"CHALDEPLOY_READINESS_PROBE_TCP,optional"`
+
+	// $CHALDEPLOY_LIVENESS_PROBE_TCP (optional): Add a liveness probe on the challenge port, so a challenge that stops
+	// responding gets restarted. Defaults to false
+	LivenessProbeTCP bool `env:"CHALDEPLOY_LIVENESS_PROBE_TCP,optional"`
+
+	// $CHALDEPLOY_CPU_LIMIT (optional): CPU limit for challenge containers, as a k8s quantity. Defaults to 500m
+	CPULimit string `env:"CHALDEPLOY_CPU_LIMIT" default:"500m"`
+
+	// $CHALDEPLOY_MEMORY_LIMIT (optional): Memory limit for challenge containers, as a k8s quantity. Defaults to 256Mi
+	MemoryLimit string `env:"CHALDEPLOY_MEMORY_LIMIT" default:"256Mi"`
+
+	// $CHALDEPLOY_CPU_REQUEST (optional): CPU request for challenge containers, as a k8s quantity. Defaults to 100m
+	CPURequest string `env:"CHALDEPLOY_CPU_REQUEST" default:"100m"`
+
+	// $CHALDEPLOY_MEMORY_REQUEST (optional): Memory request for challenge containers, as a k8s quantity. Defaults to 64Mi
+	MemoryRequest string `env:"CHALDEPLOY_MEMORY_REQUEST" default:"64Mi"`
+
+	// $CHALDEPLOY_EPHEMERAL_STORAGE_LIMIT (optional): Ephemeral storage limit for challenge containers, as a k8s quantity. Defaults to 1Gi
+	EphemeralStorageLimit string `env:"CHALDEPLOY_EPHEMERAL_STORAGE_LIMIT" default:"1Gi"`
+
+	// $CHALDEPLOY_EPHEMERAL_STORAGE_REQUEST (optional): Ephemeral storage request for challenge containers, as a k8s quantity. Defaults to 100Mi
+	EphemeralStorageRequest string `env:"CHALDEPLOY_EPHEMERAL_STORAGE_REQUEST" default:"100Mi"`
+
+	// $CHALDEPLOY_IMAGE_PULL_POLICY (optional): Pull policy for challenge images, Always, IfNotPresent, or Never. Defaults to IfNotPresent
+	ImagePullPolicy string `env:"CHALDEPLOY_IMAGE_PULL_POLICY" default:"IfNotPresent"`
+
+	// $CHALDEPLOY_IMAGE_PULL_SECRET (optional): Name of a secret used to pull challenge images from a private registry.
+	// The secret is copied into each instance namespace
+	ImagePullSecret string `env:"CHALDEPLOY_IMAGE_PULL_SECRET,optional"`
+
+	// $CHALDEPLOY_IMAGE_PULL_SECRET_NAMESPACE (optional): Namespace the image pull secret is in. Defaults to default
+	ImagePullSecretNamespace string `env:"CHALDEPLOY_IMAGE_PULL_SECRET_NAMESPACE" default:"default"`
+
+	// $CHALDEPLOY_INGRESS_ENABLED (optional): Expose HTTP challenges with an Ingress on a per-instance subdomain of $CHALDEPLOY_BASE_DOMAIN,
+	// instead of a LoadBalancer/NodePort service. Defaults to false
+	IngressEnabled bool `env:"CHALDEPLOY_INGRESS_ENABLED,optional"`
+
+	// $CHALDEPLOY_BASE_DOMAIN (optional): Wildcard domain the instance subdomains are under. Required if ingresses are enabled
+	BaseDomain string `env:"CHALDEPLOY_BASE_DOMAIN,optional"`
+
+	// $CHALDEPLOY_INGRESS_CLASS (optional): Ingress class for the instance ingresses. If not set, the cluster default is used
+	IngressClass string `env:"CHALDEPLOY_INGRESS_CLASS,optional"`
+
+	// $CHALDEPLOY_INGRESS_TLS_SECRET (optional): Name of a (wildcard) TLS secret for the instance ingresses. If set, the instances are served over https
+	IngressTLSSecret string `env:"CHALDEPLOY_INGRESS_TLS_SECRET,optional"`
+
+	// $CHALDEPLOY_INGRESS_TLS_SECRET_NAMESPACE (optional): Namespace the ingress TLS secret is in. Defaults to default
+	IngressTLSSecretNamespace string `env:"CHALDEPLOY_INGRESS_TLS_SECRET_NAMESPACE" default:"default"`
+
+	// $CHALDEPLOY_TLS_ENABLED (optional): Have cert-manager issue a certificate for each instance ingress, and serve the instances over https.
+	// Can't be used with $CHALDEPLOY_INGRESS_TLS_SECRET. Defaults to false
+	TLSEnabled bool `env:"CHALDEPLOY_TLS_ENABLED,optional"`
+
+	// $CHALDEPLOY_CERT_ISSUER (optional): Name of the cert-manager issuer for the instance certificates. Required if TLS is enabled
+	CertIssuer string `env:"CHALDEPLOY_CERT_ISSUER,optional"`
+
+	// $CHALDEPLOY_CERT_ISSUER_ANNOTATION (optional): Ingress annotation the issuer is set in. Defaults to cert-manager.io/cluster-issuer
+	CertIssuerAnnotation string `env:"CHALDEPLOY_CERT_ISSUER_ANNOTATION" default:"cert-manager.io/cluster-issuer"`
+
+	// $CHALDEPLOY_NETWORK_POLICY_ENABLED (optional): Isolate each instance namespace with a NetworkPolicy that only allows
+	// ingress on the challenge port, and blocks all egress. Defaults to false
+	NetworkPolicyEnabled bool `env:"CHALDEPLOY_NETWORK_POLICY_ENABLED,optional"`
+
+	// $CHALDEPLOY_RUN_AS_NON_ROOT (optional): Require challenge containers to run as a non-root user. Defaults to true
+	RunAsNonRoot bool `env:"CHALDEPLOY_RUN_AS_NON_ROOT" default:"true"`
+
+	// $CHALDEPLOY_READ_ONLY_ROOT_FS (optional): Mount the root filesystem of challenge containers as read-only. Defaults to false
+	ReadOnlyRootFS bool `env:"CHALDEPLOY_READ_ONLY_ROOT_FS,optional"`
+
+	// $CHALDEPLOY_SECCOMP_PROFILE (optional): Seccomp profile for challenge pods, RuntimeDefault, Unconfined, or localhost/<path>
+	// for a profile on the node. Defaults to RuntimeDefault
+	SeccompProfile string `env:"CHALDEPLOY_SECCOMP_PROFILE" default:"RuntimeDefault"`
+
+	// $CHALDEPLOY_DEPLOYMENT_STRATEGY (optional): Deployment strategy for challenges, RollingUpdate or Recreate.
+	// Recreate makes sure the old pod is gone before a new one starts, for challenges that can't have two pods at once. Defaults to RollingUpdate
+	DeploymentStrategy string `env:"CHALDEPLOY_DEPLOYMENT_STRATEGY" default:"RollingUpdate"`
+
+	// $CHALDEPLOY_WORKLOAD (optional): Kind of workload the challenge pods are run with, Deployment or StatefulSet.
+	// A StatefulSet gets a persistent volume and a stable pod name, for challenges that keep state. Defaults to Deployment
+	Workload string `env:"CHALDEPLOY_WORKLOAD" default:"Deployment"`
+
+	// $CHALDEPLOY_STORAGE_SIZE (optional): Size of the persistent volume for StatefulSet challenges, as a k8s quantity. Required for a StatefulSet
+	StorageSize string `env:"CHALDEPLOY_STORAGE_SIZE,optional"`
+
+	// $CHALDEPLOY_STORAGE_CLASS (optional): Storage class for the persistent volumes. If not set, the cluster default is used
+	StorageClass string `env:"CHALDEPLOY_STORAGE_CLASS,optional"`
+
+	// $CHALDEPLOY_STORAGE_MOUNT_PATH (optional): Directory the persistent volume is mounted at, must be absolute. Defaults to /data
+	StorageMountPath string `env:"CHALDEPLOY_STORAGE_MOUNT_PATH" default:"/data"`
+
+	// $CHALDEPLOY_NODE_SELECTOR (optional): JSON object of node label -> value that challenge pods are scheduled on
+	NodeSelector map[string]string `env:"CHALDEPLOY_NODE_SELECTOR,optional"`
+
+	// $CHALDEPLOY_TOLERATIONS (optional): JSON array of k8s tolerations for challenge pods, e.g. to run them on tainted dedicated nodes
+	Tolerations []corev1.Toleration `env:"CHALDEPLOY_TOLERATIONS,optional"`
+
+	// $CHALDEPLOY_POD_ANTI_AFFINITY (optional): Prefer scheduling instances of the same challenge on different nodes. Defaults to false
+	PodAntiAffinity bool `env:"CHALDEPLOY_POD_ANTI_AFFINITY,optional"`
+
+	// $CHALDEPLOY_EXTRA_POD_LABELS (optional): JSON object of extra labels for challenge pods. Keys can't start with chaldeploy.captaingee.ch/
+	ExtraPodLabels map[string]string `env:"CHALDEPLOY_EXTRA_POD_LABELS,optional"`
+
+	// $CHALDEPLOY_EXTRA_POD_ANNOTATIONS (optional): JSON object of extra annotations for challenge pods. Keys can't start with chaldeploy.captaingee.ch/
+	ExtraPodAnnotations map[string]string `env:"CHALDEPLOY_EXTRA_POD_ANNOTATIONS,optional"`
+
+	// $CHALDEPLOY_NAMESPACE_PREFIX (optional): Prefix for the instance namespaces, must be a DNS-1123 label of at most 29 chars. Defaults to chaldeploy
+	NamespacePrefix string `env:"CHALDEPLOY_NAMESPACE_PREFIX" default:"chaldeploy"`
+
+	// $CHALDEPLOY_EVENT_ID (optional): Id for this CTF, set as a label on the instance namespaces, so CTFs sharing a cluster
+	// don't pick up each other's instances. Must be a valid label value
+	EventId string `env:"CHALDEPLOY_EVENT_ID,optional"`
+
+	// $CHALDEPLOY_EXTRA_NAMESPACE_LABELS (optional): JSON object of extra labels for instance namespaces. Keys can't start with chaldeploy.captaingee.ch/
+	ExtraNamespaceLabels map[string]string `env:"CHALDEPLOY_EXTRA_NAMESPACE_LABELS,optional"`
+
+	// $CHALDEPLOY_DEPLOY_TIMEOUT (optional): How long creating an instance (including waiting for it to become ready) can take before giving up on it. Defaults to 5m
+	DeployTimeout time.Duration `env:"CHALDEPLOY_DEPLOY_TIMEOUT" default:"5m"`
+
+	// $CHALDEPLOY_DESTROY_TIMEOUT (optional): How long to wait for an instance namespace to finish terminating. Defaults to 5m
+	DestroyTimeout time.Duration `env:"CHALDEPLOY_DESTROY_TIMEOUT" default:"5m"`
+
+	// $CHALDEPLOY_DRAIN_TIMEOUT (optional): How long to wait for in-progress requests and instance operations to finish
+	// when shutting down. Defaults to 5m
+	DrainTimeout time.Duration `env:"CHALDEPLOY_DRAIN_TIMEOUT" default:"5m"`
+
+	// $CHALDEPLOY_INSTANCE_STORE (optional): Where instance expiration times are saved, namespace or redis. Defaults to namespace
+	InstanceStore string `env:"CHALDEPLOY_INSTANCE_STORE" default:"namespace"`
+
+	// $CHALDEPLOY_REDIS_URL (optional): URL of the redis server to use. Required if the instance store is redis
+	RedisUrl string `env:"CHALDEPLOY_REDIS_URL,optional"`
+
+	// $CHALDEPLOY_MEMCACHE_SERVERS (optional): Comma separated list of memcache servers used to share instances between replicas.
+	// If not set, the instance cache is disabled
+	MemcacheServers string `env:"CHALDEPLOY_MEMCACHE_SERVERS,optional"`
+
+	// $CHALDEPLOY_LOCKER (optional): How instances are locked across replicas, none or lease (k8s Lease objects). Defaults to none
+	Locker string `env:"CHALDEPLOY_LOCKER" default:"none"`
+
+	// $CHALDEPLOY_LOCK_NAMESPACE (optional): Namespace the lock leases are created in. Defaults to default
+	LockNamespace string `env:"CHALDEPLOY_LOCK_NAMESPACE" default:"default"`
+
+	// $CHALDEPLOY_LOCK_TTL (optional): How long a lock is held before it expires, in case a replica crashes while holding it.
+	// Must be longer than the deploy and destroy timeouts. Defaults to 15m
+	LockTTL time.Duration `env:"CHALDEPLOY_LOCK_TTL" default:"15m"`
+
+	// $CHALDEPLOY_CREATE_RATE_PER_MINUTE (optional): How many create/extend/destroy requests each team can make per minute.
+	// Set to 0 to disable rate limiting. Defaults to 5
+	CreateRatePerMinute int `env:"CHALDEPLOY_CREATE_RATE_PER_MINUTE" default:"5"`
+
+	// $CHALDEPLOY_REDEPLOY_COOLDOWN (optional): How long a team has to wait to redeploy an instance after destroying it.
+	// If not set, there is no cooldown
+	RedeployCooldown time.Duration `env:"CHALDEPLOY_REDEPLOY_COOLDOWN,optional"`
+
+	// $CHALDEPLOY_DESTROY_ON_LOGOUT (optional): Destroy a team's running instances when they log out. Defaults to false
+	DestroyOnLogout bool `env:"CHALDEPLOY_DESTROY_ON_LOGOUT" default:"false"`
+
+	// $CHALDEPLOY_MAX_CONCURRENT_INSTANCES (optional): Max number of instances (across all teams and challenges) that can exist at once.
+	// If not set, there is no cap
+	MaxConcurrentInstances int `env:"CHALDEPLOY_MAX_CONCURRENT_INSTANCES,optional"`
+
+	// $CHALDEPLOY_MAX_INSTANCES_PER_TEAM (optional): Max number of instances (across all challenges) a team can have at once.
+	// If not set, there is no cap
+	MaxInstancesPerTeam int `env:"CHALDEPLOY_MAX_INSTANCES_PER_TEAM,optional"`
+
+	// $CHALDEPLOY_WARM_POOL_SIZE (optional): Number of instances of each challenge to keep deployed ahead of time, so a team
+	// can be given one right away. If not set, there is no warm pool
+	WarmPoolSize int `env:"CHALDEPLOY_WARM_POOL_SIZE,optional"`
+
+	// $CHALDEPLOY_WEBHOOK_URL (optional): URL to POST a JSON event to when an instance is created, destroyed, or expires. If not set, no events are sent
+	WebhookURL string `env:"CHALDEPLOY_WEBHOOK_URL,optional"`
+
+	// $CHALDEPLOY_WEBHOOK_SECRET (optional): Secret for signing the webhook events with HMAC-SHA256. If not set, the events aren't signed
+	WebhookSecret string `env:"CHALDEPLOY_WEBHOOK_SECRET,optional"`
+
+	// $CHALDEPLOY_WEBHOOK_TIMEOUT (optional): Timeout for each request to the webhook. Defaults to 5s
+	WebhookTimeout time.Duration `env:"CHALDEPLOY_WEBHOOK_TIMEOUT" default:"5s"`
+
+	// $CHALDEPLOY_METRICS_ENABLED (optional): Expose prometheus metrics on /metrics. Defaults to false
+	MetricsEnabled bool `env:"CHALDEPLOY_METRICS_ENABLED,optional"`
+
+	// $CHALDEPLOY_DRY_RUN (optional): Don't talk to a k8s cluster at all. Instances are only tracked in memory, with made up
+	// connection info. For local development. Defaults to false
+	DryRun bool `env:"CHALDEPLOY_DRY_RUN,optional"`
+
+	// $CHALDEPLOY_LOG_FORMAT (optional): Format for the logs, either text or json. Defaults to text
+	LogFormat string `env:"CHALDEPLOY_LOG_FORMAT" default:"text"`
+}
+
+// Load the config from env vars, and the config file from $CHALDEPLOY_CONFIG if it's set (see LoadFromFile)
+func loadConfig() (*Config, error) {
+	config := &Config{}
+
+	var err error
+	if path := os.Getenv(configFileEnv); path != "" {
+		err = config.LoadFromFile(path)
+	} else {
+		err = config.load(nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return config, nil
 }
 
-// Load the config from env vars. Supports int and string types, along with an 'optional' modifier
+// Load the config from env vars, falling back to the values from a config file (keyed by getConfigFileKey), if there are any.
+// Supports int, bool, duration, and string types, along with maps and slices as JSON.
+// Fields can also have an 'optional' modifier.
+// A `default` tag can be set on a field to use a value when the env var isn't set
 // ref:
 //   - https://linuxhint.com/golang-struct-tags/
 //   - https://stackoverflow.com/a/6396678
-func loadConfig() (*Config, error) {
-	// init an empty config
-	config := Config{}
-
+func (c *Config) load(fileValues map[string]string) error {
 	// loop over each field in the struct
-	t := reflect.TypeOf(config)
+	t := reflect.TypeOf(*c)
 	for i := 0; i < t.NumField(); i++ {
 		// get the tag data
 		f := t.Field(i)
 		tag, ok := f.Tag.Lookup("env")
 		if !ok {
-			return nil, fmt.Errorf("config struct has an invalid field: %s", f.Name)
+			return fmt.Errorf("config struct has an invalid field: %s", f.Name)
 		}
 
 		// split the tag data
 		tagParts := strings.Split(tag, ",")
 
-		// get the env data
+		// get the env data, falling back to the config file and then the default if there is one
 		data := os.Getenv(tagParts[0])
+		if data == "" {
+			data = fileValues[getConfigFileKey(tagParts[0])]
+		}
+		if def, ok := f.Tag.Lookup("default"); ok && data == "" {
+			data = def
+		}
 
 		// make sure it's set if not optional
 		if data != "" || Contains(tagParts[1:], "optional") {
 			// set the value
-			if f.Type.Kind() == reflect.Int {
+			if data == "" {
+				// optional and not set, leave the zero value
+				continue
+			} else if f.Type == reflect.TypeOf(time.Duration(0)) {
+				// need to parse as a duration
+				if durVal, err := time.ParseDuration(data); err != nil {
+					return fmt.Errorf("couldn't convert value to duration: %s", data)
+				} else {
+					reflect.ValueOf(c).Elem().Field(i).Set(reflect.ValueOf(durVal))
+				}
+			} else if f.Type.Kind() == reflect.Map || f.Type.Kind() == reflect.Slice {
+				// need to parse as a JSON object/array
+				jsonVal := reflect.New(f.Type)
+				if err := json.Unmarshal([]byte(data), jsonVal.Interface()); err != nil {
+					return fmt.Errorf("couldn't parse value as JSON: %v", err)
+				}
+				reflect.ValueOf(c).Elem().Field(i).Set(jsonVal.Elem())
+			} else if f.Type.Kind() == reflect.Bool {
+				// need to parse as a bool
+				if boolVal, err := strconv.ParseBool(data); err != nil {
+					return fmt.Errorf("couldn't convert value to bool: %s", data)
+				} else {
+					reflect.ValueOf(c).Elem().Field(i).Set(reflect.ValueOf(boolVal))
+				}
+			} else if f.Type.Kind() == reflect.Int {
 				// need to save as an int
 				if intVal, err := strconv.Atoi(data); err != nil {
-					return nil, fmt.Errorf("couldn't convert value to integer: %s", data)
+					return fmt.Errorf("couldn't convert value to integer: %s", data)
 				} else {
-					reflect.ValueOf(&config).Elem().Field(i).Set(reflect.ValueOf(intVal))
+					reflect.ValueOf(c).Elem().Field(i).Set(reflect.ValueOf(intVal))
 				}
 			} else {
 				// can save as a string
-				reflect.ValueOf(&config).Elem().Field(i).Set(reflect.ValueOf(data))
+				reflect.ValueOf(c).Elem().Field(i).Set(reflect.ValueOf(data))
 			}
 		} else {
 			// a value was needed, error
-			return nil, fmt.Errorf("a necessary environment variable was not set: $%s", tagParts[0])
+			return fmt.Errorf("a necessary environment variable was not set: $%s", tagParts[0])
 		}
 	}
 
-	return &config, nil
+	// the top level challenge is always available, under an id that can't be used for another challenge
+	if c.Challenges == nil {
+		c.Challenges = map[string]ChallengeSpec{}
+	}
+	if _, ok := c.Challenges[DefaultChallengeId]; ok {
+		return fmt.Errorf("the challenge id %q is reserved for the challenge from $CHALDEPLOY_NAME/$CHALDEPLOY_IMAGE/$CHALDEPLOY_PORT, give the other challenge a different id", DefaultChallengeId)
+	}
+	c.Challenges[DefaultChallengeId] = ChallengeSpec{
+		Name:       c.ChallengeName,
+		Image:      c.ChallengeImage,
+		Port:       c.ChallengePort,
+		Ports:      c.ChallengePorts,
+		Command:    c.ChallengeCommand,
+		Args:       c.ChallengeArgs,
+		WorkingDir: c.ChallengeWorkingDir,
+	}
+
+	return nil
 }
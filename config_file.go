@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// env var with the path to a config file, see LoadFromFile
+const configFileEnv = "CHALDEPLOY_CONFIG"
+
+// Get the key for a setting in the config file, from its env var: the name without the CHALDEPLOY_ prefix, in lowercase
+// (e.g., instance_ttl for $CHALDEPLOY_INSTANCE_TTL)
+func getConfigFileKey(envName string) string {
+	return strings.ToLower(strings.TrimPrefix(envName, "CHALDEPLOY_"))
+}
+
+// Read the settings from a YAML or JSON config file, as the same strings they'd have as env vars: strings are used as is,
+// and everything else (numbers, bools, objects, and arrays) as JSON. Unknown keys are an error, so a typo doesn't
+// silently fall back to the default
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read the config file: %v", err)
+	}
+
+	// JSON is valid YAML, so this handles both
+	jsonData, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse the config file: %v", err)
+	}
+
+	raw := map[string]json.RawMessage{}
+	if err := json.Unmarshal(jsonData, &raw); err != nil {
+		return nil, fmt.Errorf("the config file must be an object of settings: %v", err)
+	}
+
+	keys := map[string]bool{}
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		tag := t.Field(i).Tag.Get("env")
+		keys[getConfigFileKey(strings.Split(tag, ",")[0])] = true
+	}
+
+	values := map[string]string{}
+	for key, value := range raw {
+		if !keys[key] {
+			return nil, fmt.Errorf("unknown setting in the config file: %s", key)
+		}
+
+		var s string
+		if string(value) == "null" {
+			continue
+		} else if err := json.Unmarshal(value, &s); err == nil {
+			values[key] = s
+		} else {
+			values[key] = string(value)
+		}
+	}
+
+	return values, nil
+}
+
+// Load the config from a YAML or JSON file, with the env vars overriding the values in it. The keys are the env var
+// names without the CHALDEPLOY_ prefix, in lowercase, and maps and slices can be written out instead of as JSON strings
+func (c *Config) LoadFromFile(path string) error {
+	values, err := readConfigFile(path)
+	if err != nil {
+		return err
+	}
+
+	return c.load(values)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// write a config file to a temp dir and return its path
+func writeConfigFile(t *testing.T, name, contents string) string {
+	path := filepath.Join(t.TempDir(), name)
+	assert.Nil(t, os.WriteFile(path, []byte(contents), 0o600))
+	return path
+}
+
+func TestConfigFileKey(t *testing.T) {
+	assert.Equal(t, "instance_ttl", getConfigFileKey("CHALDEPLOY_INSTANCE_TTL"))
+	assert.Equal(t, "k8sconfig", getConfigFileKey("CHALDEPLOY_K8SCONFIG"))
+}
+
+func TestLoadFromFile(t *testing.T) {
+	path := writeConfigFile(t, "config.yaml", `
+name: test chal name
+image: testimg:latest
+port: 12345
+session_key: aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa
+rctf_server: https://2021.redpwn.net
+instance_ttl: 2h
+ingress_enabled: true
+challenges:
+  web:
+    name: my web chal
+    image: webchal:latest
+    port: 8080
+challenge_env:
+  MODE: "{{.TeamID}}"
+allowed_origins: ["https://ctf.example.com"]
+`)
+
+	// env vars win over the file, and the file wins over the defaults
+	t.Setenv("CHALDEPLOY_IMAGE", "envimg:latest")
+	t.Setenv("CHALDEPLOY_PORT", "")
+
+	config := &Config{}
+	assert.Nil(t, config.LoadFromFile(path))
+	assert.Equal(t, "test chal name", config.ChallengeName)
+	assert.Equal(t, "envimg:latest", config.ChallengeImage)
+	assert.Equal(t, 12345, config.ChallengePort)
+	assert.Equal(t, 2*time.Hour, config.InstanceTTL)
+	assert.Equal(t, time.Hour, config.ExtendDuration)
+	assert.True(t, config.IngressEnabled)
+	assert.Equal(t, ChallengeSpec{Name: "my web chal", Image: "webchal:latest", Port: 8080}, config.Challenges["web"])
+	assert.Equal(t, "envimg:latest", config.Challenges[DefaultChallengeId].Image)
+	assert.Equal(t, map[string]string{"MODE": "{{.TeamID}}"}, config.ChallengeEnv)
+	assert.Equal(t, []string{"https://ctf.example.com"}, config.AllowedOrigins)
+}
+
+func TestLoadFromJSONFile(t *testing.T) {
+	// maps and slices can also be given as JSON strings, like the env vars
+	path := writeConfigFile(t, "config.json", `{
+		"name": "test chal name",
+		"image": "testimg:latest",
+		"port": 12345,
+		"session_key": "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+		"rctf_server": "https://2021.redpwn.net",
+		"max_ttl": null,
+		"challenges": "{\"web\": {\"name\": \"my web chal\", \"image\": \"webchal:latest\", \"port\": 8080}}"
+	}`)
+	t.Setenv(configFileEnv, path)
+
+	config, err := loadConfig()
+	assert.Nil(t, err)
+	assert.Equal(t, "testimg:latest", config.ChallengeImage)
+	assert.Equal(t, time.Duration(0), config.MaxTTL)
+	assert.Equal(t, "my web chal", config.Challenges["web"].Name)
+}
+
+func TestInvalidConfigFile(t *testing.T) {
+	config := &Config{}
+	assert.ErrorContains(t, config.LoadFromFile(filepath.Join(t.TempDir(), "nope.yaml")), "couldn't read the config file")
+	assert.ErrorContains(t, config.LoadFromFile(writeConfigFile(t, "config.yaml", "- a\n- b\n")), "must be an object")
+	assert.ErrorContains(t, config.LoadFromFile(writeConfigFile(t, "config.yaml", "imgae: testimg:latest\n")), "unknown setting in the config file: imgae")
+
+	// required settings still have to be set somewhere
+	assert.ErrorContains(t, config.LoadFromFile(writeConfigFile(t, "config.yaml", "name: test chal name\n")), "a necessary environment variable was not set")
+}
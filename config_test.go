@@ -2,8 +2,10 @@ package main
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
 )
 
 func TestFullConfig(t *testing.T) {
@@ -21,9 +23,13 @@ func TestFullConfig(t *testing.T) {
 	assert.Equal(t, "test chal name", config.ChallengeName)
 	assert.Equal(t, 12345, config.ChallengePort)
 	assert.Equal(t, "testimg:latest", config.ChallengeImage)
+	assert.Equal(t, "rctf", config.AuthProvider)
 	assert.Equal(t, "https://2021.redpwn.net", config.RctfServer)
 	assert.Equal(t, "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", config.SessionKey)
 	assert.Equal(t, "/asdf/zxcv", config.K8sConfigPath)
+	assert.Equal(t, "LoadBalancer", config.ServiceType)
+	assert.Equal(t, time.Hour, config.InstanceTTL)
+	assert.Equal(t, ChallengeSpec{Name: "test chal name", Image: "testimg:latest", Port: 12345}, config.Challenges[DefaultChallengeId])
 }
 
 func TestPartialConfig(t *testing.T) {
@@ -66,3 +72,138 @@ func TestInvalidPortConfig(t *testing.T) {
 	assert.NotNil(t, err)
 	assert.Nil(t, config)
 }
+
+func TestDefaultOverrideConfig(t *testing.T) {
+	t.Setenv("CHALDEPLOY_NAME", "test chal name")
+	t.Setenv("CHALDEPLOY_PORT", "12345")
+	t.Setenv("CHALDEPLOY_IMAGE", "testimg:latest")
+	t.Setenv("CHALDEPLOY_RCTF_SERVER", "https://2021.redpwn.net")
+	t.Setenv("CHALDEPLOY_SESSION_KEY", "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	t.Setenv("CHALDEPLOY_SERVICE_TYPE", "NodePort")
+	t.Setenv("CHALDEPLOY_NODE_ADDRESS", "chals.example.com")
+	t.Setenv("CHALDEPLOY_INSTANCE_TTL", "90m")
+
+	config, err := loadConfig()
+	assert.Nil(t, err)
+	assert.NotNil(t, config)
+
+	assert.Equal(t, "NodePort", config.ServiceType)
+	assert.Equal(t, "chals.example.com", config.NodeAddress)
+	assert.Equal(t, 90*time.Minute, config.InstanceTTL)
+}
+
+func TestInvalidDurationConfig(t *testing.T) {
+	t.Setenv("CHALDEPLOY_NAME", "test chal name")
+	t.Setenv("CHALDEPLOY_PORT", "12345")
+	t.Setenv("CHALDEPLOY_IMAGE", "testimg:latest")
+	t.Setenv("CHALDEPLOY_RCTF_SERVER", "https://2021.redpwn.net")
+	t.Setenv("CHALDEPLOY_SESSION_KEY", "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	t.Setenv("CHALDEPLOY_INSTANCE_TTL", "an hour")
+
+	config, err := loadConfig()
+	assert.NotNil(t, err)
+	assert.Nil(t, config)
+}
+
+func TestChallengesConfig(t *testing.T) {
+	t.Setenv("CHALDEPLOY_NAME", "test chal name")
+	t.Setenv("CHALDEPLOY_PORT", "12345")
+	t.Setenv("CHALDEPLOY_IMAGE", "testimg:latest")
+	t.Setenv("CHALDEPLOY_RCTF_SERVER", "https://2021.redpwn.net")
+	t.Setenv("CHALDEPLOY_SESSION_KEY", "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	t.Setenv("CHALDEPLOY_CHALLENGES", `{"web": {"name": "my web chal", "image": "webchal:latest", "port": 8080}}`)
+
+	config, err := loadConfig()
+	assert.Nil(t, err)
+	assert.NotNil(t, config)
+
+	assert.Len(t, config.Challenges, 2)
+	assert.Equal(t, ChallengeSpec{Name: "my web chal", Image: "webchal:latest", Port: 8080}, config.Challenges["web"])
+	assert.Equal(t, "test chal name", config.Challenges[DefaultChallengeId].Name)
+}
+
+func TestChallengeCommandConfig(t *testing.T) {
+	t.Setenv("CHALDEPLOY_NAME", "test chal name")
+	t.Setenv("CHALDEPLOY_PORT", "12345")
+	t.Setenv("CHALDEPLOY_IMAGE", "testimg:latest")
+	t.Setenv("CHALDEPLOY_RCTF_SERVER", "https://2021.redpwn.net")
+	t.Setenv("CHALDEPLOY_SESSION_KEY", "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	t.Setenv("CHALDEPLOY_COMMAND", `["/bin/sh", "-c"]`)
+	t.Setenv("CHALDEPLOY_ARGS", `["exec /chal/run"]`)
+	t.Setenv("CHALDEPLOY_CHALLENGES", `{"web": {"name": "my web chal", "image": "webchal:latest", "port": 8080, "args": ["--web"]}}`)
+
+	config, err := loadConfig()
+	assert.Nil(t, err)
+
+	assert.Equal(t, []string{"/bin/sh", "-c"}, config.Challenges[DefaultChallengeId].Command)
+	assert.Equal(t, []string{"exec /chal/run"}, config.Challenges[DefaultChallengeId].Args)
+	assert.Nil(t, config.Challenges["web"].Command)
+	assert.Equal(t, []string{"--web"}, config.Challenges["web"].Args)
+}
+
+func TestInvalidChallengesConfig(t *testing.T) {
+	t.Setenv("CHALDEPLOY_NAME", "test chal name")
+	t.Setenv("CHALDEPLOY_PORT", "12345")
+	t.Setenv("CHALDEPLOY_IMAGE", "testimg:latest")
+	t.Setenv("CHALDEPLOY_RCTF_SERVER", "https://2021.redpwn.net")
+	t.Setenv("CHALDEPLOY_SESSION_KEY", "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	t.Setenv("CHALDEPLOY_CHALLENGES", `{"web": `)
+
+	config, err := loadConfig()
+	assert.NotNil(t, err)
+	assert.Nil(t, config)
+}
+
+func TestDefaultChallengeIdReserved(t *testing.T) {
+	t.Setenv("CHALDEPLOY_NAME", "test chal name")
+	t.Setenv("CHALDEPLOY_PORT", "12345")
+	t.Setenv("CHALDEPLOY_IMAGE", "testimg:latest")
+	t.Setenv("CHALDEPLOY_RCTF_SERVER", "https://2021.redpwn.net")
+	t.Setenv("CHALDEPLOY_SESSION_KEY", "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	t.Setenv("CHALDEPLOY_CHALLENGES", `{"default": {"name": "my web chal", "image": "webchal:latest", "port": 8080}}`)
+
+	config, err := loadConfig()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "reserved")
+	assert.Nil(t, config)
+}
+
+func TestBoolConfig(t *testing.T) {
+	t.Setenv("CHALDEPLOY_NAME", "test chal name")
+	t.Setenv("CHALDEPLOY_PORT", "12345")
+	t.Setenv("CHALDEPLOY_IMAGE", "testimg:latest")
+	t.Setenv("CHALDEPLOY_RCTF_SERVER", "https://2021.redpwn.net")
+	t.Setenv("CHALDEPLOY_SESSION_KEY", "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+
+	config, err := loadConfig()
+	assert.Nil(t, err)
+	assert.False(t, config.MetricsEnabled)
+
+	t.Setenv("CHALDEPLOY_METRICS_ENABLED", "true")
+	config, err = loadConfig()
+	assert.Nil(t, err)
+	assert.True(t, config.MetricsEnabled)
+
+	t.Setenv("CHALDEPLOY_METRICS_ENABLED", "sure")
+	config, err = loadConfig()
+	assert.NotNil(t, err)
+	assert.Nil(t, config)
+}
+
+func TestSliceConfig(t *testing.T) {
+	t.Setenv("CHALDEPLOY_NAME", "test chal name")
+	t.Setenv("CHALDEPLOY_PORT", "12345")
+	t.Setenv("CHALDEPLOY_IMAGE", "testimg:latest")
+	t.Setenv("CHALDEPLOY_RCTF_SERVER", "https://2021.redpwn.net")
+	t.Setenv("CHALDEPLOY_SESSION_KEY", "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	t.Setenv("CHALDEPLOY_TOLERATIONS", `[{"key": "challenges", "operator": "Exists", "effect": "NoSchedule"}]`)
+
+	config, err := loadConfig()
+	assert.Nil(t, err)
+	assert.Equal(t, []corev1.Toleration{{Key: "challenges", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoSchedule}}, config.Tolerations)
+
+	t.Setenv("CHALDEPLOY_TOLERATIONS", `{"key": "challenges"}`)
+	config, err = loadConfig()
+	assert.NotNil(t, err)
+	assert.Nil(t, config)
+}
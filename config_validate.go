@@ -0,0 +1,458 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// ConfigError is returned by Config.Validate, with every problem it found
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("the config has %d problem(s):\n  - %s", len(e.Problems), strings.Join(e.Problems, "\n  - "))
+}
+
+// Check the config for bad values up front, so chaldeploy fails to start with a clear error instead of misbehaving
+// (or panicking) once it's running. Every check is run, and all of the problems are returned together in a
+// ConfigError, so they can all be fixed at once
+func (c *Config) Validate() error {
+	problems := []string{}
+	fail := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	// validate the log format
+	if !Contains([]string{"text", "json"}, c.LogFormat) {
+		fail("invalid log format (%s), must be one of text or json", c.LogFormat)
+	}
+
+	// validate the auth config
+	if !Contains([]string{"rctf", "ctfd"}, c.AuthProvider) {
+		fail("the auth provider is invalid: %s (must be rctf or ctfd)", c.AuthProvider)
+	}
+	if c.AuthProvider == "rctf" && c.RctfServer == "" {
+		fail("an rCTF server must be set when using the rctf auth provider")
+	}
+	if c.AuthProvider == "ctfd" && c.CtfdServer == "" {
+		fail("a CTFd server must be set when using the ctfd auth provider")
+	}
+	servers := map[string]string{"rCTF": c.RctfServer, "CTFd": c.CtfdServer}
+	for _, name := range SortedKeys(servers) {
+		server := servers[name]
+		if server != "" && !IsAbsoluteUrl(server) {
+			fail("the %s server is invalid: %s (must be an absolute http(s) url)", name, server)
+		}
+	}
+
+	// validate the service config
+	if !Contains([]string{"LoadBalancer", "NodePort"}, c.ServiceType) {
+		fail("the service type is invalid: %s (must be LoadBalancer or NodePort)", c.ServiceType)
+	}
+	if c.ServiceType == "NodePort" && c.NodeAddress == "" {
+		fail("a node address must be set when using a NodePort service")
+	}
+	if c.IngressEnabled && c.BaseDomain == "" {
+		fail("a base domain must be set when ingresses are enabled")
+	}
+	if c.TLSEnabled {
+		if !c.IngressEnabled {
+			fail("ingresses must be enabled to use TLS")
+		}
+		if c.IngressTLSSecret != "" {
+			fail("TLS can't be enabled when an ingress TLS secret is set, use one or the other")
+		}
+		if c.CertIssuer == "" {
+			fail("a cert issuer must be set when TLS is enabled")
+		}
+	}
+	if strings.Contains(c.BaseDomain, "/") || strings.HasPrefix(c.BaseDomain, ".") {
+		fail("the base domain is invalid: %s (must be a domain name, like chals.example.com)", c.BaseDomain)
+	}
+
+	// validate the image pull policy
+	if !Contains([]string{"Always", "IfNotPresent", "Never"}, c.ImagePullPolicy) {
+		fail("the image pull policy is invalid: %s (must be Always, IfNotPresent, or Never)", c.ImagePullPolicy)
+	}
+
+	// validate the instance store config
+	if !Contains([]string{"namespace", "redis"}, c.InstanceStore) {
+		fail("the instance store is invalid: %s (must be namespace or redis)", c.InstanceStore)
+	}
+	if c.InstanceStore == "redis" && c.RedisUrl == "" {
+		fail("a redis url must be set when using the redis instance store")
+	}
+
+	// validate the locker config
+	if !Contains([]string{"none", "lease"}, c.Locker) {
+		fail("the locker is invalid: %s (must be none or lease)", c.Locker)
+	}
+	if c.Locker == "lease" && (c.LockTTL <= c.DeployTimeout || c.LockTTL <= c.DestroyTimeout) {
+		fail("the lock TTL (%s) must be longer than the deploy and destroy timeouts", c.LockTTL)
+	}
+
+	// validate the rate limit
+	if c.CreateRatePerMinute < 0 {
+		fail("the create rate is invalid: %d (must be at least 0)", c.CreateRatePerMinute)
+	}
+
+	// validate the k8s client, reaper, and extension config
+	if c.K8sQPS <= 0 || c.K8sBurst <= 0 {
+		fail("the k8s client QPS and burst must be positive")
+	}
+	if c.K8sBurst < c.K8sQPS {
+		fail("the k8s client burst (%d) can't be less than the QPS (%d)", c.K8sBurst, c.K8sQPS)
+	}
+	if c.MaxDestroyRetries < 0 {
+		fail("the max destroy retries can't be negative")
+	}
+	if c.FailedInstanceGracePeriod < 0 {
+		fail("the failed instance grace period can't be negative")
+	}
+	if c.ReaperConcurrency < 1 {
+		fail("the reaper concurrency must be at least 1")
+	}
+	if c.ExpiryWarningWindow < 0 {
+		fail("the expiry warning window can't be negative")
+	}
+	if c.MaxExtensions < 0 {
+		fail("the max extensions is invalid: %d (must be at least 0)", c.MaxExtensions)
+	}
+
+	// validate the redeploy cooldown
+	if c.RedeployCooldown < 0 {
+		fail("the redeploy cooldown is invalid: %s (must be at least 0)", c.RedeployCooldown)
+	}
+
+	// validate the instance cap
+	if c.MaxConcurrentInstances < 0 {
+		fail("the max concurrent instances is invalid: %d (must be at least 0)", c.MaxConcurrentInstances)
+	}
+	if c.MaxInstancesPerTeam < 0 {
+		fail("the max instances per team is invalid: %d (must be at least 0)", c.MaxInstancesPerTeam)
+	}
+
+	// validate the challenges. the port is also the service port, so this covers NodePort services too
+	// (the node port itself is picked by k8s from the cluster's node port range)
+	if err := validateChallengeNames(c.Challenges); err != nil {
+		fail("the challenges are invalid: %v", err)
+	}
+	if !isValidProtocol(c.ChallengeProtocol) {
+		fail("the challenge protocol is invalid: %s (must be TCP or UDP)", c.ChallengeProtocol)
+	}
+	for _, id := range SortedKeys(c.Challenges) {
+		spec := c.Challenges[id]
+		if spec.Protocol != "" && !isValidProtocol(spec.Protocol) {
+			fail("the protocol for challenge %s is invalid: %s (must be TCP or UDP)", id, spec.Protocol)
+		}
+		if spec.Image == "" {
+			fail("challenge %s doesn't have an image", id)
+		}
+		if err := c.validatePorts(spec); err != nil {
+			fail("the ports for challenge %s are invalid: %v", id, err)
+			continue
+		}
+		if spec.WorkingDir != "" && !path.IsAbs(spec.WorkingDir) {
+			fail("the working directory for challenge %s must be absolute: %s", id, spec.WorkingDir)
+		}
+		if c.getPrimaryPort(spec).Protocol == string(corev1.ProtocolUDP) {
+			if spec.ProbeHttpPath != "" {
+				fail("challenge %s uses UDP, so it can't have an HTTP probe", id)
+			}
+			if c.IngressEnabled {
+				fail("challenge %s uses UDP, so it can't be exposed with an ingress", id)
+			}
+		}
+	}
+
+	// validate the seccomp profiles
+	if _, err := parseSeccompProfile(c.SeccompProfile); err != nil {
+		fail("the seccomp profile is invalid: %v", err)
+	}
+	for _, id := range SortedKeys(c.Challenges) {
+		spec := c.Challenges[id]
+		if spec.SeccompProfile == "" {
+			continue
+		}
+		if _, err := parseSeccompProfile(spec.SeccompProfile); err != nil {
+			fail("the seccomp profile for challenge %s is invalid: %v", id, err)
+		}
+	}
+
+	// validate the deployment strategies
+	if !isValidDeploymentStrategy(c.DeploymentStrategy) {
+		fail("the deployment strategy is invalid: %s (must be RollingUpdate or Recreate)", c.DeploymentStrategy)
+	}
+	for _, id := range SortedKeys(c.Challenges) {
+		spec := c.Challenges[id]
+		if spec.DeploymentStrategy != "" && !isValidDeploymentStrategy(spec.DeploymentStrategy) {
+			fail("the deployment strategy for challenge %s is invalid: %s (must be RollingUpdate or Recreate)", id, spec.DeploymentStrategy)
+		}
+	}
+
+	// validate the workload config
+	if !isValidWorkload(c.Workload) {
+		fail("the workload is invalid: %s (must be Deployment or StatefulSet)", c.Workload)
+	}
+	if c.Workload == workloadStatefulSet {
+		if c.StorageSize == "" {
+			fail("a storage size is required for StatefulSet challenges")
+		}
+		if _, err := resource.ParseQuantity(c.StorageSize); err != nil {
+			fail("the storage size is invalid: %v", err)
+		}
+		if !path.IsAbs(c.StorageMountPath) {
+			fail("the storage mount path must be absolute: %s", c.StorageMountPath)
+		}
+	} else if c.StorageSize != "" || c.StorageClass != "" {
+		fail("the storage size and class are only used with StatefulSet challenges")
+	}
+
+	// validate the connection instructions
+	if err := validateInstructions(c.ChallengeInstructions); err != nil {
+		fail("the connection instructions are invalid: %v", err)
+	}
+	for _, id := range SortedKeys(c.Challenges) {
+		spec := c.Challenges[id]
+		if err := validateInstructions(spec.Instructions); err != nil {
+			fail("the connection instructions for challenge %s are invalid: %v", id, err)
+		}
+	}
+
+	// validate the challenge env vars
+	envs := map[string]map[string]string{"env vars": c.ChallengeEnv, "secret env vars": c.ChallengeSecretEnv}
+	for _, name := range SortedKeys(envs) {
+		env := envs[name]
+		if err := validateEnv(env); err != nil {
+			fail("the challenge %s are invalid: %v", name, err)
+		}
+	}
+	for _, name := range SortedKeys(c.ChallengeSecretEnv) {
+		if _, ok := c.ChallengeEnv[name]; ok {
+			fail("the challenge env var %s can't be both a plain and a secret env var", name)
+		}
+	}
+
+	// validate the challenge files
+	if err := validateChallengeFiles(c.ChallengeFiles); err != nil {
+		fail("the challenge files are invalid: %v", err)
+	}
+	if !path.IsAbs(c.ChallengeMountPath) {
+		fail("the challenge mount path must be absolute: %s", c.ChallengeMountPath)
+	}
+
+	// validate the init container config
+	if len(c.InitContainerCommand) > 0 && c.InitContainerImage == "" {
+		fail("the init container command is set, but there's no init container image")
+	}
+	if c.SharedVolume != "" {
+		if !path.IsAbs(c.SharedVolume) {
+			fail("the shared volume path must be absolute: %s", c.SharedVolume)
+		}
+		if len(c.ChallengeFiles) > 0 && path.Clean(c.SharedVolume) == path.Clean(c.ChallengeMountPath) {
+			fail("the shared volume can't be mounted at the same path as the challenge files")
+		}
+	}
+
+	// validate the scratch volume config
+	if c.ScratchVolumeSizeLimit != "" {
+		if _, err := resource.ParseQuantity(c.ScratchVolumeSizeLimit); err != nil {
+			fail("the scratch volume size limit is invalid: %v", err)
+		}
+		if !path.IsAbs(c.ScratchMountPath) {
+			fail("the scratch volume path must be absolute: %s", c.ScratchMountPath)
+		}
+		if len(c.ChallengeFiles) > 0 && path.Clean(c.ScratchMountPath) == path.Clean(c.ChallengeMountPath) {
+			fail("the scratch volume can't be mounted at the same path as the challenge files")
+		}
+		if c.SharedVolume != "" && path.Clean(c.ScratchMountPath) == path.Clean(c.SharedVolume) {
+			fail("the scratch volume can't be mounted at the same path as the shared volume")
+		}
+	}
+
+	// validate the flag config
+	if c.FlagTemplate != "" {
+		if err := validateFlagTemplate(c.FlagTemplate); err != nil {
+			fail("the flag template is invalid: %v", err)
+		}
+		if !envVarNameRegex.MatchString(c.FlagEnv) {
+			fail("the flag env var name is invalid: %s", c.FlagEnv)
+		}
+		if _, ok := c.ChallengeEnv[c.FlagEnv]; ok {
+			fail("the flag env var %s is already set as a challenge env var", c.FlagEnv)
+		}
+		if _, ok := c.ChallengeSecretEnv[c.FlagEnv]; ok {
+			fail("the flag env var %s is already set as a challenge secret env var", c.FlagEnv)
+		}
+	} else if c.FlagSecret != "" {
+		fail("the flag secret is set, but there's no flag template")
+	}
+	if c.FlagSecret != "" && len(c.FlagSecret) < 32 {
+		fail("the flag secret is too short: %d (must be at least 32 chars)", len(c.FlagSecret))
+	}
+
+	// validate the warm pool config. the instances are deployed before there's a team, so nothing can depend on the team
+	if c.WarmPoolSize < 0 {
+		fail("the warm pool size is invalid: %d (must be at least 0)", c.WarmPoolSize)
+	}
+	if c.WarmPoolSize > 0 {
+		if c.FlagTemplate != "" {
+			fail("the warm pool can't be used with per-team flags")
+		}
+		if templatesUseTeamId(c.ChallengeEnv) || templatesUseTeamId(c.ChallengeSecretEnv) || templatesUseTeamId(c.ChallengeFiles) {
+			fail("the warm pool can't be used with env vars or files that use the team id")
+		}
+	}
+
+	// validate the pod scheduling config
+	if err := validateNodeSelector(c.NodeSelector); err != nil {
+		fail("the node selector is invalid: %v", err)
+	}
+	if err := validateTolerations(c.Tolerations); err != nil {
+		fail("the tolerations are invalid: %v", err)
+	}
+
+	// validate the extra labels and annotations
+	extraLabels := map[string]map[string]string{"pod labels": c.ExtraPodLabels, "namespace labels": c.ExtraNamespaceLabels}
+	for _, name := range SortedKeys(extraLabels) {
+		labels := extraLabels[name]
+		if err := validateExtraLabels(labels); err != nil {
+			fail("the extra %s are invalid: %v", name, err)
+		}
+	}
+	if err := validateExtraAnnotations(c.ExtraPodAnnotations); err != nil {
+		fail("the extra pod annotations are invalid: %v", err)
+	}
+
+	// validate the namespace prefix and event id
+	if err := validateNamespacePrefix(c.NamespacePrefix); err != nil {
+		fail("the namespace prefix is invalid: %v", err)
+	}
+	if err := validateEventId(c.EventId); err != nil {
+		fail("the event id is invalid: %v", err)
+	}
+
+	// validate the replica count
+	if c.Replicas < 1 {
+		fail("the replica count is invalid: %d (must be at least 1)", c.Replicas)
+	}
+
+	// validate the resource quantities now, rather than panicking when deploying an instance.
+	// the requests can only be compared to the limits once they all parse, since that uses MustParse
+	quantitiesValid := true
+	quantities := map[string]string{
+		"CPU limit":                 c.CPULimit,
+		"memory limit":              c.MemoryLimit,
+		"CPU request":               c.CPURequest,
+		"memory request":            c.MemoryRequest,
+		"ephemeral storage limit":   c.EphemeralStorageLimit,
+		"ephemeral storage request": c.EphemeralStorageRequest,
+		"sidecar CPU limit":         c.SidecarCPULimit,
+		"sidecar memory limit":      c.SidecarMemoryLimit,
+		"sidecar CPU request":       c.SidecarCPURequest,
+		"sidecar memory request":    c.SidecarMemoryRequest,
+	}
+	for _, name := range SortedKeys(quantities) {
+		quantity := quantities[name]
+		if _, err := resource.ParseQuantity(quantity); err != nil {
+			fail("the %s is invalid: %s (%v)", name, quantity, err)
+			quantitiesValid = false
+		}
+	}
+	if quantitiesValid {
+		if err := checkRequestsWithinLimits(c.getResourceRequirements()); err != nil {
+			fail("the resource requests are invalid: %v", err)
+		}
+		if err := checkRequestsWithinLimits(c.getSidecarResourceRequirements()); err != nil {
+			fail("the sidecar resource requests are invalid: %v", err)
+		}
+	}
+	if len(c.SidecarCommand) > 0 && c.SidecarImage == "" {
+		fail("the sidecar command is set, but there's no sidecar image")
+	}
+
+	// validate the pod shutdown config
+	if c.TerminationGracePeriod < 0 {
+		fail("the termination grace period is invalid: %s (must be at least 0)", c.TerminationGracePeriod)
+	}
+
+	// validate the admin token, if the admin API is enabled
+	if c.AdminToken != "" && len(c.AdminToken) < 32 {
+		fail("the admin token is too short: %d (must be at least 32 chars)", len(c.AdminToken))
+	}
+
+	// validate the timeouts and durations
+	durations := map[string]time.Duration{
+		"instance TTL":    c.InstanceTTL,
+		"extend duration": c.ExtendDuration,
+		"deploy timeout":  c.DeployTimeout,
+		"destroy timeout": c.DestroyTimeout,
+		"auth timeout":    c.AuthTimeout,
+		"session max age": c.SessionMaxAge,
+	}
+	for _, name := range SortedKeys(durations) {
+		d := durations[name]
+		if d <= 0 {
+			fail("the %s is invalid: %s (must be positive)", name, d)
+		}
+	}
+	if c.MaxTTL < 0 {
+		fail("the max TTL is invalid: %s (must be at least 0)", c.MaxTTL)
+	}
+	if c.DrainTimeout < 0 {
+		fail("the drain timeout is invalid: %s (must be at least 0)", c.DrainTimeout)
+	}
+
+	// validate the session config
+	for i, key := range append([]string{c.SessionKey}, c.PreviousSessionKeys...) {
+		if keyLen := len(key); !Contains([]int{32, 64}, keyLen) {
+			fail("session key %d is an invalid length: %d (must be 32 or 64)", i, keyLen)
+		}
+	}
+	if _, err := parseSameSite(c.CookieSameSite); err != nil {
+		fail("the cookie SameSite mode is invalid: %v", err)
+	}
+	if strings.EqualFold(c.CookieSameSite, "none") && !c.CookieSecure {
+		fail("the cookie SameSite mode can only be None if the cookie is Secure")
+	}
+
+	// validate the CORS origins. they're compared against the Origin header exactly, so they have to be in the same format
+	for _, origin := range c.AllowedOrigins {
+		if u, err := url.Parse(origin); err != nil || !IsAbsoluteUrl(origin) || u.Path != "" || u.RawQuery != "" {
+			fail("the allowed origin is invalid: %s (must be a scheme and host, like https://ctf.example.com)", origin)
+		}
+	}
+
+	// validate the user info cache
+	if c.UserInfoCacheTTL < 0 {
+		fail("the user info cache ttl can't be negative")
+	}
+	if c.UserInfoCacheTTL > 0 && c.UserInfoCacheSize <= 0 {
+		fail("the user info cache size must be positive")
+	}
+
+	// validate the webhook config
+	if c.WebhookURL != "" {
+		if !IsAbsoluteUrl(c.WebhookURL) {
+			fail("the webhook url is invalid: %s", c.WebhookURL)
+		}
+		if c.WebhookTimeout <= 0 {
+			fail("the webhook timeout must be positive")
+		}
+	} else if c.WebhookSecret != "" {
+		fail("the webhook secret is set, but there's no webhook url")
+	}
+
+	if len(problems) > 0 {
+		return &ConfigError{Problems: problems}
+	}
+
+	return nil
+}
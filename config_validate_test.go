@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// load a valid config from the env into the config global, since some of the checks read it
+func loadValidConfig(t *testing.T) {
+	t.Setenv("CHALDEPLOY_NAME", "test chal name")
+	t.Setenv("CHALDEPLOY_PORT", "12345")
+	t.Setenv("CHALDEPLOY_IMAGE", "testimg:latest")
+	t.Setenv("CHALDEPLOY_RCTF_SERVER", "https://2021.redpwn.net")
+	t.Setenv("CHALDEPLOY_SESSION_KEY", "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+
+	c, err := loadConfig()
+	assert.Nil(t, err)
+	config = c
+}
+
+func TestValidateConfig(t *testing.T) {
+	loadValidConfig(t)
+	assert.Nil(t, config.Validate())
+}
+
+func TestValidateConfigAllProblems(t *testing.T) {
+	loadValidConfig(t)
+	config.RctfServer = "2021.redpwn.net"
+	config.Challenges[DefaultChallengeId] = ChallengeSpec{Name: "test chal name", Port: 70000}
+	config.InstanceTTL = 0
+	config.WebhookSecret = "asdf"
+
+	err := config.Validate()
+	var configErr *ConfigError
+	assert.True(t, errors.As(err, &configErr))
+	assert.Equal(t, []string{
+		"the rCTF server is invalid: 2021.redpwn.net (must be an absolute http(s) url)",
+		"challenge default doesn't have an image",
+		"the ports for challenge default are invalid: the port is invalid: 70000 (must be 1-65535)",
+		"the instance TTL is invalid: 0s (must be positive)",
+		"the webhook secret is set, but there's no webhook url",
+	}, configErr.Problems)
+	assert.Contains(t, err.Error(), "the config has 5 problem(s)")
+}
+
+func TestValidateConfigProblemOrder(t *testing.T) {
+	loadValidConfig(t)
+	for _, id := range []string{"c", "a", "d", "b"} {
+		config.Challenges[id] = ChallengeSpec{Name: "chal " + id, Port: 1337}
+	}
+	config.InstanceTTL = 0
+	config.DeployTimeout = 0
+
+	// the problems from the map checks come out in the same order every time
+	for i := 0; i < 10; i++ {
+		var configErr *ConfigError
+		assert.True(t, errors.As(config.Validate(), &configErr))
+		assert.Equal(t, []string{
+			"challenge a doesn't have an image",
+			"challenge b doesn't have an image",
+			"challenge c doesn't have an image",
+			"challenge d doesn't have an image",
+			"the deploy timeout is invalid: 0s (must be positive)",
+			"the instance TTL is invalid: 0s (must be positive)",
+		}, configErr.Problems)
+	}
+}
+
+func TestValidateConfigResourceRequests(t *testing.T) {
+	loadValidConfig(t)
+
+	// requests are only compared to the limits if they parse
+	config.CPURequest = "lots"
+	config.MemoryRequest = "1Gi"
+	err := config.Validate()
+	assert.NotContains(t, err.Error(), "resource requests")
+
+	config.CPURequest = "100m"
+	assert.ErrorContains(t, config.Validate(), "the resource requests are invalid: the memory request (1Gi) is more than the limit (256Mi)")
+
+	config.MemoryRequest = "128Mi"
+	config.DrainTimeout = -time.Second
+	assert.ErrorContains(t, config.Validate(), "the drain timeout is invalid")
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// name of the emptyDir volume shared between the init container and the challenge container
+const sharedVolumeName = "shared"
+
+// get the emptyDir volume for $CHALDEPLOY_SHARED_VOLUME. it's deleted along with the pod
+func getSharedVolume() corev1.Volume {
+	return corev1.Volume{
+		Name:         sharedVolumeName,
+		VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+	}
+}
+
+// name of the emptyDir volume for scratch space
+const scratchVolumeName = "scratch"
+
+// get the emptyDir volume for $CHALDEPLOY_SCRATCH_VOLUME_SIZE_LIMIT. it's deleted along with the pod, and is a tmpfs if it's in memory.
+// the size limit is validated at startup, so MustParse won't panic here
+func (c *Config) getScratchVolume() corev1.Volume {
+	sizeLimit := resource.MustParse(c.ScratchVolumeSizeLimit)
+	emptyDir := &corev1.EmptyDirVolumeSource{SizeLimit: &sizeLimit}
+	if c.ScratchVolumeInMemory {
+		emptyDir.Medium = corev1.StorageMediumMemory
+	}
+
+	return corev1.Volume{
+		Name:         scratchVolumeName,
+		VolumeSource: corev1.VolumeSource{EmptyDir: emptyDir},
+	}
+}
+
+// get the init container for the challenge pod, or nil if one isn't configured. it gets the same env vars,
+// volumes, and security context as the challenge container, so it can set things up for the instance
+func (c *Config) getInitContainers(spec ChallengeSpec, env []corev1.EnvVar, volumeMounts []corev1.VolumeMount) []corev1.Container {
+	if c.InitContainerImage == "" {
+		return nil
+	}
+
+	return []corev1.Container{
+		{
+			Name:            "init",
+			Image:           c.InitContainerImage,
+			Command:         c.InitContainerCommand,
+			Resources:       c.getResourceRequirements(),
+			ImagePullPolicy: corev1.PullPolicy(c.ImagePullPolicy),
+			SecurityContext: c.getSecurityContext(spec),
+			Env:             env,
+			VolumeMounts:    volumeMounts,
+		},
+	}
+}
+
+// get the resource limits and requests for the sidecar container, which are separate from the challenge container's.
+// the quantities are validated at startup, so MustParse won't panic here
+func (c *Config) getSidecarResourceRequirements() corev1.ResourceRequirements {
+	limits := corev1.ResourceList{}
+	requests := corev1.ResourceList{}
+
+	if c.SidecarCPULimit != "" {
+		limits[corev1.ResourceCPU] = resource.MustParse(c.SidecarCPULimit)
+	}
+	if c.SidecarMemoryLimit != "" {
+		limits[corev1.ResourceMemory] = resource.MustParse(c.SidecarMemoryLimit)
+	}
+	if c.SidecarCPURequest != "" {
+		requests[corev1.ResourceCPU] = resource.MustParse(c.SidecarCPURequest)
+	}
+	if c.SidecarMemoryRequest != "" {
+		requests[corev1.ResourceMemory] = resource.MustParse(c.SidecarMemoryRequest)
+	}
+
+	return corev1.ResourceRequirements{Limits: limits, Requests: requests}
+}
+
+// get the sidecar container for the challenge pod, or nil if one isn't configured. containers in a pod share
+// the network, so the sidecar can reach the challenge on localhost (and vice versa). it gets the same env vars
+// (e.g., the flag) and volumes as the challenge container, but the default security context, since a challenge's
+// security context is for the challenge binary
+func (c *Config) getSidecarContainers(env []corev1.EnvVar, volumeMounts []corev1.VolumeMount) []corev1.Container {
+	if c.SidecarImage == "" {
+		return nil
+	}
+
+	return []corev1.Container{
+		{
+			Name:            "sidecar",
+			Image:           c.SidecarImage,
+			Command:         c.SidecarCommand,
+			Resources:       c.getSidecarResourceRequirements(),
+			ImagePullPolicy: corev1.PullPolicy(c.ImagePullPolicy),
+			SecurityContext: c.getSecurityContext(ChallengeSpec{}),
+			Env:             env,
+			VolumeMounts:    volumeMounts,
+		},
+	}
+}
+
+// get how long the challenge pods get to shut down, in seconds. k8s only takes whole seconds, so it's rounded down
+func (c *Config) getTerminationGracePeriod() *int64 {
+	seconds := int64(c.TerminationGracePeriod / time.Second)
+	return &seconds
+}
+
+// get the lifecycle hooks for the challenge container, or nil if there's no preStop command. the hook runs before
+// the container is sent SIGTERM, and counts against the termination grace period
+func (c *Config) getLifecycle() *corev1.Lifecycle {
+	if len(c.PreStopCommand) == 0 {
+		return nil
+	}
+
+	return &corev1.Lifecycle{
+		PreStop: &corev1.LifecycleHandler{
+			Exec: &corev1.ExecAction{Command: c.PreStopCommand},
+		},
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestInitContainer(t *testing.T) {
+	config = &Config{}
+	spec := ChallengeSpec{Name: "my chal", Image: "captaingeech/test-nc:latest", Port: 31337}
+	env := []corev1.EnvVar{{Name: "TEAM", Value: "team-id"}}
+
+	// no init container by default
+	pod := config.getDeployment("chaldeploy-test", "team-id", spec, env).Spec.Template.Spec
+	assert.Empty(t, pod.InitContainers)
+	assert.Empty(t, pod.Volumes)
+
+	config.InitContainerImage = "init:latest"
+	config.InitContainerCommand = []string{"/bin/sh", "-c", "echo hi > /shared/hi"}
+	config.SharedVolume = "/shared"
+	pod = config.getDeployment("chaldeploy-test", "team-id", spec, env).Spec.Template.Spec
+
+	assert.Len(t, pod.InitContainers, 1)
+	init := pod.InitContainers[0]
+	assert.Equal(t, "init:latest", init.Image)
+	assert.Equal(t, config.InitContainerCommand, init.Command)
+	assert.Equal(t, env, init.Env)
+
+	// the shared volume is mounted in both containers
+	assert.Equal(t, []corev1.Volume{getSharedVolume()}, pod.Volumes)
+	mount := corev1.VolumeMount{Name: sharedVolumeName, MountPath: "/shared"}
+	assert.Equal(t, []corev1.VolumeMount{mount}, init.VolumeMounts)
+	assert.Equal(t, []corev1.VolumeMount{mount}, pod.Containers[0].VolumeMounts)
+}
+
+func TestSidecarContainer(t *testing.T) {
+	config = &Config{CPULimit: "500m", SidecarCPULimit: "100m", SidecarMemoryRequest: "16Mi"}
+	spec := ChallengeSpec{Name: "my chal", Image: "captaingeech/test-nc:latest", Port: 31337}
+
+	// no sidecar by default
+	assert.Len(t, config.getDeployment("chaldeploy-test", "team-id", spec, nil).Spec.Template.Spec.Containers, 1)
+
+	config.SidecarImage = "proxy:latest"
+	config.SidecarCommand = []string{"/proxy", "--upstream", "localhost:31337"}
+	containers := config.getDeployment("chaldeploy-test", "team-id", spec, nil).Spec.Template.Spec.Containers
+	assert.Len(t, containers, 2)
+	assert.Equal(t, "captaingeech/test-nc:latest", containers[0].Image)
+
+	sidecar := containers[1]
+	assert.Equal(t, "sidecar", sidecar.Name)
+	assert.Equal(t, "proxy:latest", sidecar.Image)
+	assert.Equal(t, config.SidecarCommand, sidecar.Command)
+
+	// the resources are separate from the challenge container's
+	assert.Equal(t, "100m", sidecar.Resources.Limits.Cpu().String())
+	assert.Equal(t, "16Mi", sidecar.Resources.Requests.Memory().String())
+	assert.Equal(t, "500m", containers[0].Resources.Limits.Cpu().String())
+
+	// a challenge's security context doesn't apply to the sidecar
+	privileged := true
+	spec.SecurityContext = &corev1.SecurityContext{Privileged: &privileged}
+	containers = config.getDeployment("chaldeploy-test", "team-id", spec, nil).Spec.Template.Spec.Containers
+	assert.Equal(t, spec.SecurityContext, containers[0].SecurityContext)
+	assert.Nil(t, containers[1].SecurityContext.Privileged)
+}
+
+func TestPodShutdown(t *testing.T) {
+	config = &Config{TerminationGracePeriod: 5500 * time.Millisecond}
+	spec := ChallengeSpec{Name: "my chal", Image: "captaingeech/test-nc:latest", Port: 31337}
+
+	// rounded down, and no hook by default
+	podSpec := config.getDeployment("chaldeploy-test", "team-id", spec, nil).Spec.Template.Spec
+	assert.Equal(t, int64(5), *podSpec.TerminationGracePeriodSeconds)
+	assert.Nil(t, podSpec.Containers[0].Lifecycle)
+
+	config.TerminationGracePeriod = 0
+	config.PreStopCommand = []string{"/bin/sh", "-c", "sync"}
+	podSpec = config.getDeployment("chaldeploy-test", "team-id", spec, nil).Spec.Template.Spec
+	assert.Equal(t, int64(0), *podSpec.TerminationGracePeriodSeconds)
+	assert.Equal(t, config.PreStopCommand, podSpec.Containers[0].Lifecycle.PreStop.Exec.Command)
+}
+
+func TestScratchVolume(t *testing.T) {
+	config = &Config{ScratchMountPath: "/tmp"}
+	spec := ChallengeSpec{Name: "my chal", Image: "captaingeech/test-nc:latest", Port: 31337}
+
+	// no scratch volume by default
+	pod := config.getDeployment("chaldeploy-test", "team-id", spec, nil).Spec.Template.Spec
+	assert.Empty(t, pod.Volumes)
+
+	config.ScratchVolumeSizeLimit = "64Mi"
+	pod = config.getDeployment("chaldeploy-test", "team-id", spec, nil).Spec.Template.Spec
+	assert.Len(t, pod.Volumes, 1)
+	assert.Equal(t, scratchVolumeName, pod.Volumes[0].Name)
+	assert.Equal(t, "64Mi", pod.Volumes[0].EmptyDir.SizeLimit.String())
+	assert.Equal(t, corev1.StorageMediumDefault, pod.Volumes[0].EmptyDir.Medium)
+	assert.Equal(t, []corev1.VolumeMount{{Name: scratchVolumeName, MountPath: "/tmp"}}, pod.Containers[0].VolumeMounts)
+
+	// tmpfs
+	config.ScratchVolumeInMemory = true
+	pod = config.getDeployment("chaldeploy-test", "team-id", spec, nil).Spec.Template.Spec
+	assert.Equal(t, corev1.StorageMediumMemory, pod.Volumes[0].EmptyDir.Medium)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Check if a request's origin is the same as the host it was sent to. Browsers send an Origin header on
+// same-origin POSTs too, so those need to be let through without being in the allowed origins
+func isSameOrigin(r *http.Request, origin string) bool {
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+// Wrap a handler with CORS for the /api/ routes. Origins in config.AllowedOrigins get the CORS headers (including
+// credentials, so the session cookie is sent), and their preflight requests are answered. Cross-origin requests
+// from any other origin are rejected. If no origins are allowed, the API is same-origin only.
+// This wraps the whole router instead of being router middleware, since mux doesn't run middleware for the
+// OPTIONS preflight requests (the routes only match their own methods)
+func corsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || !strings.HasPrefix(r.URL.Path, "/api/") || isSameOrigin(r, origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		if !Contains(config.AllowedOrigins, origin) {
+			log.Printf("rejecting %s request from %s to %s, %s isn't an allowed origin", r.Method, r.RemoteAddr, r.URL.Path, origin)
+			writeJSONError(w, http.StatusForbidden, errCodeBadOrigin, "origin isn't allowed")
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+		w.Header().Set("Access-Control-Expose-Headers", "Retry-After, "+csrfHeader)
+
+		// preflight
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE")
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, "+csrfHeader)
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
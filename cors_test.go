@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCorsMiddleware(t *testing.T) {
+	config = &Config{AllowedOrigins: []string{"https://ctf.example.com"}}
+	h := corsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	request := func(method, path, origin string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "http://deploy.example.com"+path, nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		if method == http.MethodOptions {
+			r.Header.Set("Access-Control-Request-Method", "POST")
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	// allowed origin
+	w := request(http.MethodPost, "/api/create", "https://ctf.example.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "https://ctf.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+
+	// preflight
+	w = request(http.MethodOptions, "/api/create", "https://ctf.example.com")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "https://ctf.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Contains(t, w.Header().Get("Access-Control-Allow-Methods"), "POST")
+	assert.Contains(t, w.Header().Get("Access-Control-Allow-Headers"), csrfHeader)
+
+	// other origins aren't echoed back
+	for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodOptions} {
+		w = request(method, "/api/status", "https://evil.example.com")
+		assert.Equal(t, http.StatusForbidden, w.Code, method)
+		assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"), method)
+	}
+
+	// same-origin, no origin, and non-api requests go straight through
+	for _, origin := range []string{"http://deploy.example.com", ""} {
+		w = request(http.MethodPost, "/api/create", origin)
+		assert.Equal(t, http.StatusOK, w.Code, origin)
+		assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"), origin)
+	}
+	w = request(http.MethodGet, "/main.js", "https://evil.example.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// same-origin only by default
+	config.AllowedOrigins = nil
+	w = request(http.MethodPost, "/api/create", "https://ctf.example.com")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/sessions"
+)
+
+// header the CSRF token is sent to the frontend in, and sent back on state changing requests
+const csrfHeader = "X-CSRF-Token"
+
+// Generate a new random CSRF token
+func newCSRFToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("couldn't generate a csrf token: %v", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Get the CSRF token for a session, generating it if the session doesn't have one yet (e.g., sessions from before CSRF
+// protection was enabled). The token is sent back in the X-CSRF-Token header. If a token is generated, the session
+// is saved, so this has to be called before anything is written to the response
+func issueCSRFToken(w http.ResponseWriter, r *http.Request, s *sessions.Session) error {
+	if !config.CSRFEnabled {
+		return nil
+	}
+
+	token, ok := s.Values["csrfToken"].(string)
+	if !ok || token == "" {
+		var err error
+		if token, err = newCSRFToken(); err != nil {
+			return err
+		}
+
+		s.Values["csrfToken"] = token
+		if err := s.Save(r, w); err != nil {
+			return fmt.Errorf("couldn't save the csrf token to the session: %v", err)
+		}
+	}
+
+	w.Header().Set(csrfHeader, token)
+	return nil
+}
+
+// Wrap a state changing handler so it can only be called with the session's CSRF token in the X-CSRF-Token header.
+// A malicious page can make a logged in team's browser send the request with their session cookie, but it can't read
+// the token to send along with it. Unauthenticated requests are passed through, since the handler rejects them anyways
+func csrfProtected(h sessionHandler) sessionHandler {
+	return func(w http.ResponseWriter, r *http.Request, s *sessions.Session) {
+		if config.CSRFEnabled {
+			if teamId, ok := getSessionTeamId(s); ok {
+				token, _ := s.Values["csrfToken"].(string)
+				got := r.Header.Get(csrfHeader)
+
+				if token == "" || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+					log.Printf("rejecting request from %s (ID: %s) to %s with a bad csrf token", s.Values["teamName"], teamId, r.URL.Path)
+					writeJSONError(w, http.StatusForbidden, errCodeCSRF, "bad csrf token, please refresh the page")
+					return
+				}
+			}
+		}
+
+		h(w, r, s)
+	}
+}
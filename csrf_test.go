@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/sessions"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCSRFProtected(t *testing.T) {
+	config = &Config{CSRFEnabled: true}
+	h := csrfProtected(func(w http.ResponseWriter, r *http.Request, s *sessions.Session) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	s := sessions.NewSession(sessions.NewCookieStore([]byte("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")), "session")
+	s.IsNew = false
+	s.Values["id"] = "team1"
+	s.Values["csrfToken"] = "token"
+
+	request := func(s *sessions.Session, token string) int {
+		r := httptest.NewRequest(http.MethodPost, "/api/create", nil)
+		if token != "" {
+			r.Header.Set(csrfHeader, token)
+		}
+		w := httptest.NewRecorder()
+		h(w, r, s)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, request(s, "token"))
+	assert.Equal(t, http.StatusForbidden, request(s, ""))
+	assert.Equal(t, http.StatusForbidden, request(s, "tokenn"))
+
+	// sessions without a token are rejected too
+	delete(s.Values, "csrfToken")
+	assert.Equal(t, http.StatusForbidden, request(s, ""))
+
+	// unauthenticated requests are left to the handler
+	assert.Equal(t, http.StatusOK, request(sessions.NewSession(nil, "session"), ""))
+
+	// disabled
+	config.CSRFEnabled = false
+	assert.Equal(t, http.StatusOK, request(s, ""))
+}
+
+func TestIssueCSRFToken(t *testing.T) {
+	config = &Config{CSRFEnabled: true}
+	s := sessions.NewSession(sessions.NewCookieStore([]byte("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")), "session")
+
+	// generated and saved if the session doesn't have one
+	w := httptest.NewRecorder()
+	assert.Nil(t, issueCSRFToken(w, httptest.NewRequest(http.MethodGet, "/api/status", nil), s))
+	token := w.Header().Get(csrfHeader)
+	assert.Len(t, token, 43)
+	assert.Equal(t, token, s.Values["csrfToken"])
+	assert.NotEmpty(t, w.Header().Get("Set-Cookie"))
+
+	// reused after that
+	w = httptest.NewRecorder()
+	assert.Nil(t, issueCSRFToken(w, httptest.NewRequest(http.MethodGet, "/api/status", nil), s))
+	assert.Equal(t, token, w.Header().Get(csrfHeader))
+	assert.Empty(t, w.Header().Get("Set-Cookie"))
+
+	// not sent when disabled
+	config.CSRFEnabled = false
+	w = httptest.NewRecorder()
+	assert.Nil(t, issueCSRFToken(w, httptest.NewRequest(http.MethodGet, "/api/status", nil), s))
+	assert.Empty(t, w.Header().Get(csrfHeader))
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// Fields always present in an API response from CTFd
+type CtfdResponse struct {
+	Success bool `json:"success"`
+}
+
+// Partial struct for the data from /api/v1/users/me
+type CtfdUserData struct {
+	Id     int    `json:"id"`
+	Name   string `json:"name"`
+	TeamId *int   `json:"team_id"`
+}
+
+// Response to /api/v1/users/me
+type CtfdUserResponse struct {
+	CtfdResponse
+	Data CtfdUserData `json:"data"`
+}
+
+// Partial struct for the data from /api/v1/teams/me
+type CtfdTeamData struct {
+	Id   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// Response to /api/v1/teams/me
+type CtfdTeamResponse struct {
+	CtfdResponse
+	Data CtfdTeamData `json:"data"`
+}
+
+// CtfdProvider authenticates teams with a CTFd access token (generated from the user's settings page)
+// The access token is used directly as the auth token
+type CtfdProvider struct {
+	// base url of the CTFd server, without a trailing slash
+	Url string
+
+	// client for the CTFd API, with a timeout so a slow server can't hang auth requests
+	Client *http.Client
+}
+
+// Make a GET request to the CTFd API with an access token, and parse the response into v
+// Returns the HTTP status code of the response
+func (p *CtfdProvider) get(path, token string, v any) (int, error) {
+	resp, err := doScoreboardRequest(p.Client, func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodGet, p.Url+path, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Token "+token)
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	// CTFd doesn't send back JSON for bad tokens, so don't try to parse it
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, nil
+	}
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, err
+	}
+
+	return resp.StatusCode, json.Unmarshal(respBody, v)
+}
+
+// Validate the access token from the user
+// If there is an error talking to CTFd, returns ("", error)
+// If comms are successful but auth is bad, returns ("", nil)
+// Otherwise, returns (token, nil)
+func (p *CtfdProvider) Authenticate(token string) (string, error) {
+	ctfdResp := CtfdUserResponse{}
+	status, err := p.get("/api/v1/users/me", token, &ctfdResp)
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return "", nil
+	case status != http.StatusOK:
+		return "", fmt.Errorf("got an unexpected status from the CTFd api: %d", status)
+	case !ctfdResp.Success:
+		return "", nil
+	}
+
+	return token, nil
+}
+
+// Get the team info for the user from the CTFd API
+// If the CTF is in user mode (or the user isn't on a team), the user is treated as their own team
+func (p *CtfdProvider) UserInfo(authToken string) (UserInfo, error) {
+	userResp := CtfdUserResponse{}
+	if status, err := p.get("/api/v1/users/me", authToken, &userResp); err != nil {
+		return UserInfo{}, err
+	} else if status != http.StatusOK || !userResp.Success {
+		return UserInfo{}, fmt.Errorf("got bad data from the CTFd api for the user (status: %d)", status)
+	}
+
+	if userResp.Data.TeamId == nil {
+		return UserInfo{TeamName: userResp.Data.Name, Id: "user" + strconv.Itoa(userResp.Data.Id)}, nil
+	}
+
+	teamResp := CtfdTeamResponse{}
+	if status, err := p.get("/api/v1/teams/me", authToken, &teamResp); err != nil {
+		return UserInfo{}, err
+	} else if status != http.StatusOK || !teamResp.Success {
+		return UserInfo{}, fmt.Errorf("got bad data from the CTFd api for the team (status: %d)", status)
+	}
+
+	return UserInfo{TeamName: teamResp.Data.Name, Id: "team" + strconv.Itoa(teamResp.Data.Id)}, nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fake CTFd api. "teamtoken" is a user on a team, "usertoken" is a user without a team
+func newTestCtfdServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get("Authorization")
+		if token != "Token teamtoken" && token != "Token usertoken" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("<html>forbidden</html>"))
+			return
+		}
+
+		switch r.URL.Path {
+		case "/api/v1/users/me":
+			if token == "Token teamtoken" {
+				w.Write([]byte(`{"success": true, "data": {"id": 3, "name": "player", "team_id": 7}}`))
+			} else {
+				w.Write([]byte(`{"success": true, "data": {"id": 4, "name": "solo player", "team_id": null}}`))
+			}
+		case "/api/v1/teams/me":
+			w.Write([]byte(`{"success": true, "data": {"id": 7, "name": "the team"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestCtfdAuthenticate(t *testing.T) {
+	server := newTestCtfdServer()
+	defer server.Close()
+
+	p := &CtfdProvider{Url: server.URL, Client: http.DefaultClient}
+
+	authToken, err := p.Authenticate("teamtoken")
+	assert.Nil(t, err)
+	assert.Equal(t, "teamtoken", authToken)
+
+	// bad tokens aren't an error
+	authToken, err = p.Authenticate("badtoken")
+	assert.Nil(t, err)
+	assert.Equal(t, "", authToken)
+
+	// CTFd being unreachable is
+	p.Url = "http://127.0.0.1:0"
+	_, err = p.Authenticate("teamtoken")
+	assert.NotNil(t, err)
+}
+
+func TestCtfdUserInfo(t *testing.T) {
+	server := newTestCtfdServer()
+	defer server.Close()
+
+	p := &CtfdProvider{Url: server.URL, Client: http.DefaultClient}
+
+	userInfo, err := p.UserInfo("teamtoken")
+	assert.Nil(t, err)
+	assert.Equal(t, UserInfo{TeamName: "the team", Id: "team7"}, userInfo)
+
+	// users without a team are their own team
+	userInfo, err = p.UserInfo("usertoken")
+	assert.Nil(t, err)
+	assert.Equal(t, UserInfo{TeamName: "solo player", Id: "user4"}, userInfo)
+
+	_, err = p.UserInfo("badtoken")
+	assert.NotNil(t, err)
+}
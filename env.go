@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// EnvTemplateData is the data available to the templated env var values and files for challenge containers
+type EnvTemplateData struct {
+	// id of the team the instance is for, from the scoreboard
+	TeamID string
+
+	// id of the challenge in config.Challenges
+	ChallengeID string
+
+	// value for the `app` label, also used as the name of the deployment and service
+	AppName string
+
+	// k8s namespace used for the instance
+	Namespace string
+}
+
+// env var names have to be C identifiers
+var envVarNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Render the templated values (env vars or files) for an instance
+func renderTemplates(templates map[string]string, data EnvTemplateData) (map[string]string, error) {
+	rendered := map[string]string{}
+
+	for _, name := range SortedKeys(templates) {
+		value := templates[name]
+		t, err := template.New(name).Option("missingkey=error").Parse(value)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't parse the template for %s: %v", name, err)
+		}
+
+		sb := &strings.Builder{}
+		if err := t.Execute(sb, data); err != nil {
+			return nil, fmt.Errorf("couldn't render the template for %s: %v", name, err)
+		}
+		rendered[name] = sb.String()
+	}
+
+	return rendered, nil
+}
+
+// Make sure the env var names are valid, and the templates only use the available data
+func validateEnv(env map[string]string) error {
+	for _, name := range SortedKeys(env) {
+		if !envVarNameRegex.MatchString(name) {
+			return fmt.Errorf("%s isn't a valid env var name", name)
+		}
+	}
+
+	_, err := renderTemplates(env, EnvTemplateData{})
+	return err
+}
+
+// get the name of the secret that holds the secret env vars for an instance
+func getEnvSecretName(appName string) string {
+	return appName + "-env"
+}
+
+// get the secret that holds the rendered secret env vars for an instance
+func getEnvSecret(appName, teamId string, spec ChallengeSpec, values map[string]string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: getEnvSecretName(appName),
+			Labels: map[string]string{
+				"app":                              appName,
+				"app.kubernetes.io/managed-by":     "chaldeploy",
+				"chaldeploy.captaingee.ch/chal":    HashString(spec.Name),
+				"chaldeploy.captaingee.ch/team-id": teamId,
+			},
+		},
+		Type:       corev1.SecretTypeOpaque,
+		StringData: values,
+	}
+}
+
+// get the env vars for the challenge container, sorted by name. plain values are set directly,
+// and secret values are referenced from the instance's env secret so they aren't in the deployment spec
+func getContainerEnv(appName string, plain, secret map[string]string) []corev1.EnvVar {
+	env := []corev1.EnvVar{}
+
+	for name, value := range plain {
+		env = append(env, corev1.EnvVar{Name: name, Value: value})
+	}
+
+	for name := range secret {
+		env = append(env, corev1.EnvVar{
+			Name: name,
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: getEnvSecretName(appName)},
+					Key:                  name,
+				},
+			},
+		})
+	}
+
+	sort.Slice(env, func(i, j int) bool {
+		return env[i].Name < env[j].Name
+	})
+
+	return env
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestRenderTemplates(t *testing.T) {
+	data := EnvTemplateData{TeamID: "team-id", ChallengeID: "web", AppName: "chaldeploy-asdf-teamid", Namespace: "chaldeploy-asdf-teamid"}
+
+	env, err := renderTemplates(map[string]string{"TEAM": "{{.TeamID}}", "URL": "http://{{.AppName}}.{{.Namespace}}/{{.ChallengeID}}", "MODE": "ctf"}, data)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"TEAM": "team-id", "URL": "http://chaldeploy-asdf-teamid.chaldeploy-asdf-teamid/web", "MODE": "ctf"}, env)
+
+	// unknown fields and bad templates
+	_, err = renderTemplates(map[string]string{"TEAM": "{{.TeamName}}"}, data)
+	assert.NotNil(t, err)
+	_, err = renderTemplates(map[string]string{"TEAM": "{{.TeamID"}, data)
+	assert.NotNil(t, err)
+}
+
+func TestValidateEnv(t *testing.T) {
+	assert.Nil(t, validateEnv(nil))
+	assert.Nil(t, validateEnv(map[string]string{"TEAM_ID": "{{.TeamID}}", "_X1": "y"}))
+	assert.NotNil(t, validateEnv(map[string]string{"1TEAM": "x"}))
+	assert.NotNil(t, validateEnv(map[string]string{"TEAM-ID": "x"}))
+	assert.NotNil(t, validateEnv(map[string]string{"TEAM": "{{.Nope}}"}))
+}
+
+func TestContainerEnv(t *testing.T) {
+	env := getContainerEnv("chaldeploy-test", map[string]string{"B": "plain"}, map[string]string{"A": "secret"})
+
+	assert.Len(t, env, 2)
+	assert.Equal(t, "A", env[0].Name)
+	assert.Empty(t, env[0].Value)
+	assert.Equal(t, "chaldeploy-test-env", env[0].ValueFrom.SecretKeyRef.Name)
+	assert.Equal(t, "A", env[0].ValueFrom.SecretKeyRef.Key)
+	assert.Equal(t, corev1.EnvVar{Name: "B", Value: "plain"}, env[1])
+
+	secret := getEnvSecret("chaldeploy-test", "team-id", ChallengeSpec{Name: "my chal"}, map[string]string{"A": "secret"})
+	assert.Equal(t, "chaldeploy-test-env", secret.Name)
+	assert.Equal(t, "secret", secret.StringData["A"])
+}
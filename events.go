@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+// how many events can be waiting for a subscriber before new ones are dropped for it
+const eventBufferSize = 16
+
+// how often a comment is sent on an idle event stream, so proxies don't close it
+var eventKeepaliveInterval = 30 * time.Second
+
+// the event sent when an instance is about to expire. the other events are named after the instance's state
+const eventExpiringSoon = "expiring-soon"
+
+// the event sent when a deploy fails. the state is error, but EventSource uses that name for its own connection errors
+const eventDeployFailed = "deploy_failed"
+
+// get the event name for an instance state
+func getEventName(state string) string {
+	if state == "error" {
+		return eventDeployFailed
+	}
+	return state
+}
+
+// the broker for the instance events, shared by every event stream
+var instanceEvents = NewEventBroker()
+
+// InstanceEvent is a change to one of a team's instances. State is the SSE event name (usually the instance's state),
+// and the data is the same as /api/status
+type InstanceEvent struct {
+	ChallengeId string
+	State       string
+	Status      StatusResponse
+}
+
+// EventBroker hands out instance events to the event streams that are subscribed to a team
+type EventBroker struct {
+	mu sync.Mutex
+
+	// map of team id -> subscribed channels
+	subs map[string]map[chan InstanceEvent]struct{}
+
+	// set once the broker is closed, so new subscribers are closed right away
+	closed bool
+}
+
+func NewEventBroker() *EventBroker {
+	return &EventBroker{subs: map[string]map[chan InstanceEvent]struct{}{}}
+}
+
+// Subscribe to the events for a team. The channel is closed if the broker is closed, and the returned func
+// has to be called once the subscriber is done with it
+func (b *EventBroker) Subscribe(teamId string) (<-chan InstanceEvent, func()) {
+	ch := make(chan InstanceEvent, eventBufferSize)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		close(ch)
+		return ch, func() {}
+	}
+
+	if b.subs[teamId] == nil {
+		b.subs[teamId] = map[chan InstanceEvent]struct{}{}
+	}
+	b.subs[teamId][ch] = struct{}{}
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		// the channel is already closed (and removed) if the broker was closed
+		if _, ok := b.subs[teamId][ch]; !ok {
+			return
+		}
+		delete(b.subs[teamId], ch)
+		if len(b.subs[teamId]) == 0 {
+			delete(b.subs, teamId)
+		}
+		close(ch)
+	}
+}
+
+// Send an event to a team's subscribers. Never blocks: a subscriber that isn't keeping up misses the event
+func (b *EventBroker) Publish(teamId string, event InstanceEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subs[teamId] {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// Close every subscriber's channel, so the event streams end when chaldeploy shuts down
+func (b *EventBroker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, chans := range b.subs {
+		for ch := range chans {
+			close(ch)
+		}
+	}
+	b.subs = map[string]map[chan InstanceEvent]struct{}{}
+	b.closed = true
+}
+
+// Count the subscribers for a team
+func (b *EventBroker) numSubscribers(teamId string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return len(b.subs[teamId])
+}
+
+// Send the current state of an instance to the team's event streams
+func (di *DeploymentInstance) publishState() {
+	di.publishEvent("")
+}
+
+// Send an event with the current state of an instance to the team's event streams. If name is empty, the event is named after the state
+func (di *DeploymentInstance) publishEvent(name string) {
+	status := getStatusResponse(di, time.Now())
+	if name == "" {
+		name = getEventName(status.State)
+	}
+	instanceEvents.Publish(di.Key.TeamId, InstanceEvent{ChallengeId: di.Key.ChallengeId, State: name, Status: status})
+}
+
+// write an event to an event stream
+func writeEvent(w http.ResponseWriter, event InstanceEvent) error {
+	data, err := json.Marshal(event.Status)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.State, data)
+	return err
+}
+
+// GET /api/events
+// Stream the state changes of the team's instance of a challenge as server-sent events, instead of polling /api/status.
+// The current state is sent first, then an event is sent each time it changes. The event name is the state
+// (deploying, active, destroying, or inactive), deploy_failed if the deploy failed, or expiring-soon as a warning
+// before it expires, and the data is the same JSON as /api/status
+func (h *Handlers) eventsRequest(w http.ResponseWriter, r *http.Request, s *sessions.Session) {
+	// make sure the session is valid
+	teamId, ok := getSessionTeamId(s)
+	if !ok {
+		writeJSONError(w, http.StatusForbidden, errCodeNotAuthenticated, "not authenticated, please auth again")
+		return
+	}
+
+	// make sure the challenge exists
+	challengeId, ok := getRequestChallengeId(r)
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, errCodeUnknownChallenge, "unknown challenge")
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		log.Println("error handling events request, the response writer can't be flushed")
+		writeInternalError(w)
+		return
+	}
+
+	// subscribe before getting the current state, so a change in between isn't missed
+	events, unsubscribe := instanceEvents.Subscribe(teamId)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	status := getStatusResponse(h.im.GetDeploymentInstance(teamId, challengeId), time.Now())
+	if err := writeEvent(w, InstanceEvent{ChallengeId: challengeId, State: getEventName(status.State), Status: status}); err != nil {
+		return
+	}
+	flusher.Flush()
+
+	keepalive := time.NewTicker(eventKeepaliveInterval)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			// the client disconnected
+			return
+		case event, ok := <-events:
+			if !ok {
+				// shutting down
+				return
+			}
+			if event.ChallengeId != challengeId {
+				continue
+			}
+			if err := writeEvent(w, event); err != nil {
+				return
+			}
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
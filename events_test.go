@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestEventBroker(t *testing.T) {
+	b := NewEventBroker()
+
+	ch1, unsubscribe1 := b.Subscribe("team1")
+	ch2, unsubscribe2 := b.Subscribe("team2")
+	assert.Equal(t, 1, b.numSubscribers("team1"))
+
+	// events only go to the team's subscribers
+	b.Publish("team1", InstanceEvent{ChallengeId: "default", State: "active"})
+	assert.Equal(t, "active", (<-ch1).State)
+	assert.Len(t, ch2, 0)
+
+	// a subscriber that isn't keeping up doesn't block publishing
+	for i := 0; i < eventBufferSize+10; i++ {
+		b.Publish("team1", InstanceEvent{State: "active"})
+	}
+	assert.Len(t, ch1, eventBufferSize)
+
+	unsubscribe1()
+	assert.Equal(t, 0, b.numSubscribers("team1"))
+	unsubscribe1()
+
+	// closing the broker closes the rest of the channels
+	b.Close()
+	_, ok := <-ch2
+	assert.False(t, ok)
+	unsubscribe2()
+
+	ch3, _ := b.Subscribe("team3")
+	_, ok = <-ch3
+	assert.False(t, ok)
+}
+
+func TestEventsRequest(t *testing.T) {
+	clientset := newTestInstanceManager()
+	h := NewHandlers(im)
+	instanceEvents = NewEventBroker()
+	ctx := context.Background()
+
+	s := sessions.NewSession(sessions.NewCookieStore([]byte("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")), "session")
+	s.Values["id"] = "team1"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.eventsRequest(w, r, s)
+	}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/api/events")
+	assert.Nil(t, err)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	reader := bufio.NewReader(resp.Body)
+
+	// get the name of the next event
+	nextEvent := func() string {
+		line, err := reader.ReadString('\n')
+		assert.Nil(t, err)
+		reader.ReadString('\n') // data
+		reader.ReadString('\n') // blank line between events
+		return strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+	}
+
+	// the current state comes first
+	assert.Equal(t, "inactive", nextEvent())
+
+	_, err = im.CreateDeployment(ctx, "team1", DefaultChallengeId)
+	assert.Nil(t, err)
+	assert.Equal(t, "deploying", nextEvent())
+	assert.Equal(t, "active", nextEvent())
+
+	// other teams' instances aren't sent
+	_, err = im.CreateDeployment(ctx, "team2", DefaultChallengeId)
+	assert.Nil(t, err)
+
+	assert.Nil(t, im.DestroyDeployment(ctx, "team1", DefaultChallengeId))
+	assert.Equal(t, "destroying", nextEvent())
+	assert.Equal(t, "inactive", nextEvent())
+
+	// a failed deploy isn't named error, since EventSource uses that for its own errors
+	clientset.PrependReactor("create", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("nope")
+	})
+	_, err = im.CreateDeployment(ctx, "team1", DefaultChallengeId)
+	assert.NotNil(t, err)
+	assert.Equal(t, "deploying", nextEvent())
+	assert.Equal(t, "deploy_failed", nextEvent())
+
+	// the subscription goes away when the client disconnects
+	resp.Body.Close()
+	assert.Eventually(t, func() bool { return instanceEvents.numSubscribers("team1") == 0 }, time.Second, 10*time.Millisecond)
+}
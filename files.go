@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// name of the pod volume the challenge files are mounted from
+const filesVolumeName = "challenge-files"
+
+// ConfigMap keys become the file names, so they can't have slashes
+var challengeFileNameRegex = regexp.MustCompile(`^[-._a-zA-Z0-9]+$`)
+
+// Make sure the challenge file names are valid ConfigMap keys, and the templates only use the available data
+func validateChallengeFiles(files map[string]string) error {
+	for _, name := range SortedKeys(files) {
+		if !challengeFileNameRegex.MatchString(name) || name == "." || name == ".." {
+			return fmt.Errorf("%s isn't a valid file name", name)
+		}
+	}
+
+	_, err := renderTemplates(files, EnvTemplateData{})
+	return err
+}
+
+// get the name of the ConfigMap that holds the challenge files for an instance
+func getFilesConfigMapName(appName string) string {
+	return appName + "-files"
+}
+
+// get the ConfigMap that holds the rendered challenge files for an instance
+func getFilesConfigMap(appName, teamId string, spec ChallengeSpec, files map[string]string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: getFilesConfigMapName(appName),
+			Labels: map[string]string{
+				"app":                              appName,
+				"app.kubernetes.io/managed-by":     "chaldeploy",
+				"chaldeploy.captaingee.ch/chal":    HashString(spec.Name),
+				"chaldeploy.captaingee.ch/team-id": teamId,
+			},
+		},
+		Data: files,
+	}
+}
+
+// get the pod volume for the challenge files ConfigMap
+func getFilesVolume(appName string) corev1.Volume {
+	return corev1.Volume{
+		Name: filesVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: getFilesConfigMapName(appName)},
+			},
+		},
+	}
+}
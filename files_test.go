@@ -0,0 +1,16 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateChallengeFiles(t *testing.T) {
+	assert.Nil(t, validateChallengeFiles(nil))
+	assert.Nil(t, validateChallengeFiles(map[string]string{"team.txt": "{{.TeamID}}", ".config": "x", "data-1_2": "y"}))
+	assert.NotNil(t, validateChallengeFiles(map[string]string{"dir/team.txt": "x"}))
+	assert.NotNil(t, validateChallengeFiles(map[string]string{"..": "x"}))
+	assert.NotNil(t, validateChallengeFiles(map[string]string{"": "x"}))
+	assert.NotNil(t, validateChallengeFiles(map[string]string{"team.txt": "{{.Nope}}"}))
+}
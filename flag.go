@@ -0,0 +1,35 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Generate the flag for a team's instance of a challenge from config.FlagTemplate.
+// If config.FlagSecret is set, the template is filled in with an HMAC of the challenge and team ids, so the flags
+// are unique per team but can't be guessed from the team id. Otherwise, it's filled in with the team id
+func generateFlag(teamId, challengeId string) string {
+	value := teamId
+	if config.FlagSecret != "" {
+		mac := hmac.New(sha256.New, []byte(config.FlagSecret))
+		mac.Write([]byte(challengeId + "\x00" + teamId))
+		value = hex.EncodeToString(mac.Sum(nil))[:32]
+	}
+
+	return fmt.Sprintf(config.FlagTemplate, value)
+}
+
+// Make sure the flag template has exactly one %s for the team value, and no other format verbs
+func validateFlagTemplate(tmpl string) error {
+	if strings.Count(tmpl, "%s") != 1 {
+		return fmt.Errorf("%s must have exactly one %%s", tmpl)
+	}
+	if strings.Contains(fmt.Sprintf(tmpl, ""), "%!") {
+		return fmt.Errorf("%s has an invalid format verb", tmpl)
+	}
+
+	return nil
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateFlag(t *testing.T) {
+	config = &Config{FlagTemplate: "flag{team_%s}"}
+	assert.Equal(t, "flag{team_team1}", generateFlag("team1", DefaultChallengeId))
+
+	config.FlagSecret = "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+	flag := generateFlag("team1", DefaultChallengeId)
+	assert.Regexp(t, `^flag\{team_[0-9a-f]{32}\}$`, flag)
+
+	// deterministic, but unique per team, challenge, and secret
+	assert.Equal(t, flag, generateFlag("team1", DefaultChallengeId))
+	assert.NotEqual(t, flag, generateFlag("team2", DefaultChallengeId))
+	assert.NotEqual(t, flag, generateFlag("team1", "web"))
+	config.FlagSecret = "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+	assert.NotEqual(t, flag, generateFlag("team1", DefaultChallengeId))
+}
+
+func TestValidateFlagTemplate(t *testing.T) {
+	assert.Nil(t, validateFlagTemplate("flag{team_%s}"))
+	assert.Nil(t, validateFlagTemplate("flag{100%%_%s}"))
+	assert.NotNil(t, validateFlagTemplate("flag{static}"))
+	assert.NotNil(t, validateFlagTemplate("flag{%s_%s}"))
+	assert.NotNil(t, validateFlagTemplate("flag{%d_%s}"))
+}
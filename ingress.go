@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// get the per-instance host for an ingress. the app name is hashed so the host doesn't reveal the team id,
+// and truncated to fit in a DNS label
+func (c *Config) getIngressHost(appName string) string {
+	return fmt.Sprintf("%s.%s", HashString(appName)[:16], c.BaseDomain)
+}
+
+// get the url teams connect to for an ingress host
+func (c *Config) getIngressURL(host string) string {
+	if c.IngressTLSSecret != "" || c.TLSEnabled {
+		return "https://" + host
+	}
+	return "http://" + host
+}
+
+// get the name of the secret cert-manager stores the certificate for an instance in
+func getIngressTLSSecretName(appName string) string {
+	return appName + "-tls"
+}
+
+// get the ingress that routes the instance's host to its service
+func (c *Config) getIngress(appName, teamId string, spec ChallengeSpec) *networkingv1.Ingress {
+	host := c.getIngressHost(appName)
+	pathType := networkingv1.PathTypePrefix
+
+	ingress := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: appName,
+			Labels: map[string]string{
+				"app":                              appName,
+				"app.kubernetes.io/managed-by":     "chaldeploy",
+				"chaldeploy.captaingee.ch/chal":    HashString(spec.Name),
+				"chaldeploy.captaingee.ch/team-id": teamId,
+			},
+		},
+		Spec: networkingv1.IngressSpec{
+			Rules: []networkingv1.IngressRule{
+				{
+					Host: host,
+					IngressRuleValue: networkingv1.IngressRuleValue{
+						HTTP: &networkingv1.HTTPIngressRuleValue{
+							Paths: []networkingv1.HTTPIngressPath{
+								{
+									Path:     "/",
+									PathType: &pathType,
+									Backend: networkingv1.IngressBackend{
+										Service: &networkingv1.IngressServiceBackend{
+											Name: appName,
+											Port: networkingv1.ServiceBackendPort{Number: int32(c.getPrimaryPort(spec).ContainerPort)},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	if c.IngressClass != "" {
+		ingressClass := c.IngressClass
+		ingress.Spec.IngressClassName = &ingressClass
+	}
+	if c.IngressTLSSecret != "" {
+		ingress.Spec.TLS = []networkingv1.IngressTLS{{Hosts: []string{host}, SecretName: c.IngressTLSSecret}}
+	} else if c.TLSEnabled {
+		// cert-manager sees the annotation and issues a certificate for the host into the TLS secret
+		ingress.Annotations = map[string]string{c.CertIssuerAnnotation: c.CertIssuer}
+		ingress.Spec.TLS = []networkingv1.IngressTLS{{Hosts: []string{host}, SecretName: getIngressTLSSecretName(appName)}}
+	}
+
+	return ingress
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+// InstanceCache shares the instance state between chaldeploy replicas.
+//
+// Consistency model: each replica's in-memory instance map is checked first, and the cache is only used
+// to fill in instances that the replica doesn't know about (e.g., ones created through another replica).
+// The cache is written through after every state change, and entries expire along with the instance.
+// Cache errors are logged rather than failing the request, since the cluster is the real source of truth
+// and is reconciled on startup. This means a replica can briefly serve stale state for an instance that
+// another replica changed after this one cached it.
+type InstanceCache interface {
+	// Get a cached instance. Returns nil if it isn't cached
+	Get(key InstanceKey) (*DeploymentInstance, error)
+
+	// Cache an instance until it expires
+	Set(di *DeploymentInstance) error
+
+	// Remove an instance from the cache
+	Delete(key InstanceKey) error
+}
+
+// MemcacheInstanceCache caches instances as JSON in memcache
+type MemcacheInstanceCache struct {
+	Client *memcache.Client
+}
+
+// Create a memcache instance cache from a comma separated list of servers
+func newMemcacheInstanceCache(servers string) *MemcacheInstanceCache {
+	return &MemcacheInstanceCache{Client: memcache.New(strings.Split(servers, ",")...)}
+}
+
+// get the memcache key for an instance
+func getMemcacheKey(key InstanceKey) string {
+	return fmt.Sprintf("chaldeploy:instance:%s:%s", key.TeamId, key.ChallengeId)
+}
+
+// Serialize an instance into a memcache item that expires along with the instance
+func getMemcacheItem(di *DeploymentInstance) (*memcache.Item, error) {
+	if di.ExpTime == nil {
+		return nil, fmt.Errorf("instance for %s doesn't have an expiration time", di.Key)
+	}
+
+	value, err := json.Marshal(di)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't serialize instance for %s: %v", di.Key, err)
+	}
+
+	// memcache treats expirations over 30 days as a unix timestamp, so the expiration time can be used directly
+	return &memcache.Item{
+		Key:        getMemcacheKey(di.Key),
+		Value:      value,
+		Expiration: int32(di.ExpTime.Unix()),
+	}, nil
+}
+
+func (c *MemcacheInstanceCache) Get(key InstanceKey) (*DeploymentInstance, error) {
+	item, err := c.Client.Get(getMemcacheKey(key))
+	if err == memcache.ErrCacheMiss {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("couldn't get instance for %s from memcache: %v", key, err)
+	}
+
+	di := &DeploymentInstance{}
+	if err := json.Unmarshal(item.Value, di); err != nil {
+		return nil, fmt.Errorf("couldn't deserialize instance for %s: %v", key, err)
+	}
+
+	return di, nil
+}
+
+func (c *MemcacheInstanceCache) Set(di *DeploymentInstance) error {
+	item, err := getMemcacheItem(di)
+	if err != nil {
+		return err
+	}
+
+	if err := c.Client.Set(item); err != nil {
+		return fmt.Errorf("couldn't cache instance for %s in memcache: %v", di.Key, err)
+	}
+
+	return nil
+}
+
+func (c *MemcacheInstanceCache) Delete(key InstanceKey) error {
+	if err := c.Client.Delete(getMemcacheKey(key)); err != nil && err != memcache.ErrCacheMiss {
+		return fmt.Errorf("couldn't delete instance for %s from memcache: %v", key, err)
+	}
+
+	return nil
+}
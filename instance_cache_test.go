@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/captainGeech42/chaldeploy/internal/generic_map"
+	"github.com/stretchr/testify/assert"
+)
+
+// in-memory InstanceCache for testing
+type testInstanceCache map[InstanceKey]*DeploymentInstance
+
+func (c testInstanceCache) Get(key InstanceKey) (*DeploymentInstance, error) {
+	return c[key], nil
+}
+
+func (c testInstanceCache) Set(di *DeploymentInstance) error {
+	c[di.Key] = di
+	return nil
+}
+
+func (c testInstanceCache) Delete(key InstanceKey) error {
+	delete(c, key)
+	return nil
+}
+
+func TestMemcacheItem(t *testing.T) {
+	exp := time.Date(2022, 11, 3, 12, 30, 0, 0, time.UTC)
+	di := &DeploymentInstance{
+		Key:       InstanceKey{TeamId: "team", ChallengeId: DefaultChallengeId},
+		Challenge: ChallengeSpec{Name: "test chal", Image: "test:latest", Port: 1337},
+		AppName:   "chaldeploy-asdf-team",
+		Namespace: "chaldeploy-asdf-team",
+		ExpTime:   &exp,
+		State:     Running,
+		mu:        &sync.Mutex{},
+		Hostname:  "1.2.3.4",
+		Port:      1337,
+	}
+
+	item, err := getMemcacheItem(di)
+	assert.Nil(t, err)
+	assert.Equal(t, "chaldeploy:instance:team:default", item.Key)
+	assert.Equal(t, int32(exp.Unix()), item.Expiration)
+
+	// should round trip everything but the lock
+	parsed := &DeploymentInstance{}
+	assert.Nil(t, json.Unmarshal(item.Value, parsed))
+	parsed.mu = di.mu
+	assert.Equal(t, di, parsed)
+
+	// can't cache an instance that doesn't expire
+	di.ExpTime = nil
+	_, err = getMemcacheItem(di)
+	assert.NotNil(t, err)
+}
+
+func TestLoadInstanceFromCache(t *testing.T) {
+	exp := time.Now().UTC().Add(time.Hour)
+	key := InstanceKey{TeamId: "team", ChallengeId: DefaultChallengeId}
+	cache := testInstanceCache{}
+
+	im := &InstanceManager{Instances: new(generic_map.MapOf[InstanceKey, *DeploymentInstance])}
+
+	// cache disabled
+	_, ok := im.loadInstance(key)
+	assert.False(t, ok)
+
+	// nothing cached
+	im.Cache = cache
+	_, ok = im.loadInstance(key)
+	assert.False(t, ok)
+
+	// a cached instance that isn't running is ignored
+	cache[key] = &DeploymentInstance{Key: key, State: Destroyed}
+	_, ok = im.loadInstance(key)
+	assert.False(t, ok)
+
+	// a running instance gets added to the instance map
+	cache[key] = &DeploymentInstance{Key: key, State: Running, ExpTime: &exp, Hostname: "1.2.3.4", Port: 1337}
+	di, ok := im.loadInstance(key)
+	assert.True(t, ok)
+	assert.Equal(t, "1.2.3.4:1337", di.GetCxn())
+	assert.NotNil(t, di.mu)
+
+	local, ok := im.Instances.Load(key)
+	assert.True(t, ok)
+	assert.Same(t, di, local)
+
+	// the instance map takes priority over the cache
+	delete(cache, key)
+	di, ok = im.loadInstance(key)
+	assert.True(t, ok)
+	assert.Same(t, local, di)
+}
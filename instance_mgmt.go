@@ -4,11 +4,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"math"
 	"os"
 	"path/filepath"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -17,6 +17,9 @@ import (
 
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/client-go/kubernetes"
@@ -26,9 +29,70 @@ import (
 	"k8s.io/client-go/util/homedir"
 )
 
-// how long an instance will run, or how much time will be added to the expiration
-// TODO: configify this
-const INSTANCE_RUNTIME = time.Duration(1) * time.Hour
+const (
+	// max number of log lines a team can get from their instance at once
+	maxLogLines = 500
+
+	// how long getting the logs for an instance can take
+	logsTimeout = 10 * time.Second
+
+	// how long the readiness check waits on the k8s API
+	clusterCheckTimeout = 5 * time.Second
+
+	// annotations on the namespace of an instance that failed to deploy, so it's still Failed if chaldeploy restarts
+	failedAtAnnotation    = "chaldeploy.captaingee.ch/failed-at"
+	deployErrorAnnotation = "chaldeploy.captaingee.ch/deploy-error"
+)
+
+// max size of the logs a team can get from their instance at once
+var maxLogBytes int64 = 1 << 20
+
+var (
+	// returned when an operation needs a running instance for a team, but there isn't one
+	ErrNoInstance = errors.New("no running instance")
+
+	// returned when trying to create an instance for a team that already has one running
+	ErrAlreadyDeployed = errors.New("instance is already deployed")
+
+	// returned when an instance is in the middle of being created or destroyed
+	ErrBusy = errors.New("instance is busy")
+
+	// returned when creating an instance would go over the cap on concurrent instances
+	ErrCapacityReached = errors.New("too many instances are running")
+
+	// returned when an instance has already been extended as many times as it can be
+	ErrMaxExtensions = errors.New("instance can't be extended any more")
+
+	// returned when creating an instance for a team whose last deploy failed, and hasn't been cleaned up yet
+	ErrFailed = errors.New("instance failed to deploy")
+
+	// returned when starting an instance operation after chaldeploy has started shutting down
+	ErrShuttingDown = errors.New("shutting down")
+
+	// returned by destroyInstance when the instance didn't need to be destroyed (it isn't running anymore, or
+	// isn't expired/failed anymore). it never makes it out of the instance manager
+	errNothingToDestroy = errors.New("instance doesn't need to be destroyed")
+)
+
+// CooldownError is returned when a team tries to redeploy an instance too soon after it was destroyed
+type CooldownError struct {
+	// how long until the instance can be deployed again
+	Remaining time.Duration
+}
+
+func (e *CooldownError) Error() string {
+	return fmt.Sprintf("instance was destroyed too recently, can be redeployed in %s", e.Remaining.Round(time.Second))
+}
+
+// TeamLimitError is returned when a team tries to create an instance while it already has as many as it's allowed
+type TeamLimitError struct {
+	// the max number of instances a team can have at once
+	Limit int
+}
+
+func (e *TeamLimitError) Error() string {
+	return fmt.Sprintf("team already has %d instance(s), the most it can have at once", e.Limit)
+}
 
 type InstanceState int64
 
@@ -45,6 +109,13 @@ const (
 	// a Destroyed instance doesn't exist anymore, and can be (re)deployed.
 	// This is the first state of a DeploymentInstance
 	Destroyed
+
+	// a Deploying instance is being created in the background, and will end up Running (or Failed if it fails)
+	Deploying
+
+	// a Failed instance didn't finish deploying. Its namespace is kept around so an organizer can see what went
+	// wrong, until the team destroys it or the reaper cleans it up after config.FailedInstanceGracePeriod
+	Failed
 )
 
 func (s InstanceState) String() string {
@@ -55,13 +126,36 @@ func (s InstanceState) String() string {
 		return "destroying"
 	case Destroyed:
 		return "destroyed"
+	case Deploying:
+		return "deploying"
+	case Failed:
+		return "failed"
 	default:
 		return "(unknown enum value)"
 	}
 }
 
+// InstanceKey identifies the deployment of a single challenge for a single team
+type InstanceKey struct {
+	// rCTF team id
+	TeamId string
+
+	// id of the challenge in config.Challenges
+	ChallengeId string
+}
+
+func (k InstanceKey) String() string {
+	return fmt.Sprintf("%s (challenge: %s)", k.TeamId, k.ChallengeId)
+}
+
 // DeploymentInstance is a single deployment of a challenge for a team
 type DeploymentInstance struct {
+	// the team/challenge the instance is for
+	Key InstanceKey
+
+	// the challenge that is deployed
+	Challenge ChallengeSpec
+
 	// value for the `app` label
 	AppName string
 
@@ -77,11 +171,52 @@ type DeploymentInstance struct {
 	// lock for mutating the state of the instance
 	mu *sync.Mutex
 
+	// lock for State, ExpTime, AppName/Namespace, DestroyFailures, and the connection info, which are read without
+	// holding mu (e.g. for status requests, the instance counts, and the admin API), since mu is held for the whole
+	// create/destroy. they're only changed while holding mu, so code that holds it can read them directly, anything
+	// else has to use getState/getExpiration/getNames/getDestroyFailures/GetCxn
+	stateMu sync.RWMutex
+
+	// the instance manager the instance belongs to
+	im *InstanceManager
+
+	// when the instance was last destroyed, for the redeploy cooldown. kept once the instance is Destroyed
+	LastDestroyed *time.Time
+
 	// hostname for connecting to the instance
 	Hostname string
 
-	// port for connecting to the instance
+	// port for connecting to the instance. if the challenge has more than one public port, this is the first one
 	Port int
+
+	// all of the public ports for connecting to the instance
+	Ports []InstancePort
+
+	// url for connecting to the instance, if it's exposed with an ingress instead of its service
+	URL string
+
+	// the expiration time the team was last warned about, so the warning is only sent once (until it is extended)
+	WarnedExpTime *time.Time
+
+	// when the instance started being destroyed, set while it's Destroying
+	DestroyingSince *time.Time
+
+	// how many times in a row destroying the instance has failed, and when it'll be tried again (nil if it won't be)
+	DestroyFailures  int
+	NextDestroyRetry *time.Time
+
+	// why the last deploy failed, shown to the team until the instance is deployed again. empty if it didn't fail
+	DeployError string
+
+	// when the deploy failed, set while the instance is Failed
+	FailedAt *time.Time
+
+	// when the instance last became Destroyed, for removing it from the instance map (see PruneDestroyed)
+	DestroyedAt *time.Time
+
+	// how many times the instance has been extended since it was created. this isn't saved in the instance
+	// store, so it starts over if chaldeploy restarts (unless another replica has it in the instance cache)
+	Extensions int
 }
 
 // implement sync.Locker on DeploymentInstance
@@ -93,38 +228,184 @@ func (di *DeploymentInstance) Unlock() {
 	di.mu.Unlock()
 }
 
+// Set the state of the instance. The caller has to hold mu
+func (di *DeploymentInstance) setState(state InstanceState) {
+	di.stateMu.Lock()
+	defer di.stateMu.Unlock()
+
+	di.State = state
+}
+
+// Get the state of the instance, without holding mu
+func (di *DeploymentInstance) getState() InstanceState {
+	di.stateMu.RLock()
+	defer di.stateMu.RUnlock()
+
+	return di.State
+}
+
+// Set the expiration time of the instance. The caller has to hold mu
+func (di *DeploymentInstance) setExpTime(expTime *time.Time) {
+	di.stateMu.Lock()
+	defer di.stateMu.Unlock()
+
+	di.ExpTime = expTime
+}
+
+// Get the expiration time of the instance, without holding mu
+func (di *DeploymentInstance) getExpiration() *time.Time {
+	di.stateMu.RLock()
+	defer di.stateMu.RUnlock()
+
+	return di.ExpTime
+}
+
+// Set the app and namespace names of the instance. The caller has to hold mu
+func (di *DeploymentInstance) setNames(appName, namespace string) {
+	di.stateMu.Lock()
+	defer di.stateMu.Unlock()
+
+	di.AppName = appName
+	di.Namespace = namespace
+}
+
+// Get the app and namespace names of the instance, without holding mu
+func (di *DeploymentInstance) getNames() (appName, namespace string) {
+	di.stateMu.RLock()
+	defer di.stateMu.RUnlock()
+
+	return di.AppName, di.Namespace
+}
+
+// Set how many times in a row destroying the instance has failed. The caller has to hold mu
+func (di *DeploymentInstance) setDestroyFailures(failures int) {
+	di.stateMu.Lock()
+	defer di.stateMu.Unlock()
+
+	di.DestroyFailures = failures
+}
+
+// Get how many times in a row destroying the instance has failed, without holding mu
+func (di *DeploymentInstance) getDestroyFailures() int {
+	di.stateMu.RLock()
+	defer di.stateMu.RUnlock()
+
+	return di.DestroyFailures
+}
+
+// Set the connection info of a running instance. The caller has to hold mu
+func (di *DeploymentInstance) setCxn(hostname string, port int, ports []InstancePort, url string) {
+	di.stateMu.Lock()
+	defer di.stateMu.Unlock()
+
+	di.Hostname = hostname
+	di.Port = port
+	di.Ports = ports
+	di.URL = url
+}
+
+// get the connection string for the instance, the ingress URL or a host:port string.
+// if the challenge has more than one public port, each of them is listed with its name
 func (di *DeploymentInstance) GetCxn() string {
+	di.stateMu.RLock()
+	defer di.stateMu.RUnlock()
+
+	return di.getCxnLocked()
+}
+
+// get the connection string for the instance, see GetCxn. The caller has to hold stateMu or mu
+func (di *DeploymentInstance) getCxnLocked() string {
+	if di.URL != "" {
+		return di.URL
+	}
+
+	if len(di.Ports) > 1 {
+		cxns := []string{}
+		for _, p := range di.Ports {
+			cxns = append(cxns, fmt.Sprintf("%s:%d (%s)", di.Hostname, p.Port, p.Name))
+		}
+		return strings.Join(cxns, ", ")
+	}
+
 	return fmt.Sprintf("%s:%d", di.Hostname, di.Port)
 }
 
 // InstanceManager stores the necessary data for creating and destroying challenge instances on a k8s cluster
 type InstanceManager struct {
+	// chaldeploy config. main uses the config global, tests can give each instance manager its own
+	Config *Config
+
 	// k8s config
-	Config *rest.Config
+	K8sConfig *rest.Config
 
-	// k8s client
-	Clientset *kubernetes.Clientset
+	// k8s client. an interface so a fake clientset can be used in tests
+	Clientset kubernetes.Interface
 
 	// mutex for controlling access to the instance map
 	Lock *sync.RWMutex
 
-	// map of team id -> instance
-	Instances *generic_map.MapOf[string, *DeploymentInstance]
+	// map of (team id, challenge id) -> instance
+	Instances *generic_map.MapOf[InstanceKey, *DeploymentInstance]
+
+	// where the instance state that isn't stored on the k8s objects is saved
+	Store InstanceStore
+
+	// cache for sharing instances between replicas. nil if caching is disabled
+	Cache InstanceCache
+
+	// lock for modifying instances across replicas
+	Locker Locker
+
+	// lock for checking the instance caps, and the number of instances that passed the check but aren't running yet
+	// (in total, and for each team)
+	capacityMu         sync.Mutex
+	pendingCreates     int
+	pendingTeamCreates map[string]int
+
+	// the creates/extends/destroys that are in progress, so they can finish before shutting down.
+	// they're started with beginOperation, which checks draining under drainMu, so none are added once Drain is waiting
+	inFlight sync.WaitGroup
+	drainMu  sync.Mutex
+	draining bool
+
+	// held while the reaper is running, so the passes don't overlap
+	reapMu sync.Mutex
+
+	// signalled when an instance is taken from the warm pool, so it's refilled right away. nil if there's no warm pool
+	warmPoolWake chan struct{}
+}
+
+// Make sure the k8s API is reachable with a cheap request. There's no cluster in dry run mode, so it always is
+func (im *InstanceManager) CheckCluster(ctx context.Context) error {
+	if im.Config.DryRun {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, clusterCheckTimeout)
+	defer cancel()
+
+	_, err := im.Clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{Limit: 1})
+	return err
 }
 
 // Initialize the instance manager object, including authing to the cluster
 // TODO: ensure necessary permissions are obtained
-func (im *InstanceManager) Init() error {
+func (im *InstanceManager) Init(ctx context.Context) error {
+	// in dry run mode, there is no cluster to talk to
+	if im.Config.DryRun {
+		return im.initDryRun()
+	}
+
 	// load the cluster config
-	k8sConfig, err := getConfigForCluster()
+	k8sConfig, err := im.Config.getConfigForCluster()
 	if err != nil {
 		return err
 	} else {
-		im.Config = k8sConfig
+		im.K8sConfig = k8sConfig
 	}
 
 	// create the clientset
-	clientset, err := kubernetes.NewForConfig(im.Config)
+	clientset, err := kubernetes.NewForConfig(im.K8sConfig)
 	if err != nil {
 		return err
 	} else {
@@ -132,12 +413,71 @@ func (im *InstanceManager) Init() error {
 	}
 
 	// initialize the map
-	im.Instances = new(generic_map.MapOf[string, *DeploymentInstance])
+	im.Instances = new(generic_map.MapOf[InstanceKey, *DeploymentInstance])
+
+	// initialize the instance store
+	if store, err := newInstanceStore(im.Clientset); err != nil {
+		return err
+	} else {
+		im.Store = store
+	}
+
+	// initialize the instance cache, if enabled
+	if im.Config.MemcacheServers != "" {
+		im.Cache = newMemcacheInstanceCache(im.Config.MemcacheServers)
+	}
+
+	// initialize the locker, identifying this replica by its hostname (the pod name when running on k8s)
+	identity, err := os.Hostname()
+	if err != nil {
+		return fmt.Errorf("couldn't get the hostname to identify this replica: %v", err)
+	}
+	if locker, err := newLocker(im.Clientset, identity); err != nil {
+		return err
+	} else {
+		im.Locker = locker
+	}
+
+	// pick up any instances that were deployed before chaldeploy (re)started
+	return im.discoverExistingInstances(ctx)
+}
+
+// Initialize the instance manager without a cluster, for dry run mode
+func (im *InstanceManager) initDryRun() error {
+	im.Instances = new(generic_map.MapOf[InstanceKey, *DeploymentInstance])
+
+	if store, err := newInstanceStore(nil); err != nil {
+		return err
+	} else {
+		im.Store = store
+	}
+
+	if im.Config.MemcacheServers != "" {
+		im.Cache = newMemcacheInstanceCache(im.Config.MemcacheServers)
+	}
+
+	if locker, err := newLocker(nil, ""); err != nil {
+		return err
+	} else {
+		im.Locker = locker
+	}
 
-	// get the chaldeploy namespaces for this challenge
+	return nil
+}
+
+// Populate the instance map from the chaldeploy namespaces that already exist on the cluster
+// This lets chaldeploy restart without forgetting about (and leaking) the running instances
+func (im *InstanceManager) discoverExistingInstances(ctx context.Context) error {
+	// map the challenge label values back to the challenge ids
+	challengeIds := map[string]string{}
+	for id, spec := range im.Config.Challenges {
+		challengeIds[HashString(spec.Name)] = id
+	}
+
+	// get the chaldeploy namespaces
 	namespaceClient := im.Clientset.CoreV1().Namespaces()
-	cdNamespaces, err := namespaceClient.List(context.TODO(), metav1.ListOptions{
-		LabelSelector: fmt.Sprintf("chaldeploy.captaingee.ch/managed-by=yes,chaldeploy.captaingee.ch/chal=%s", HashString(config.ChallengeName)),
+	cdNamespaces, err := namespaceClient.List(ctx, metav1.ListOptions{
+		LabelSelector: im.Config.getNamespaceSelector(),
 	})
 	if err != nil {
 		return err
@@ -148,46 +488,71 @@ func (im *InstanceManager) Init() error {
 
 		// store info for each valid namespace identified
 		for _, ns := range cdNamespaces.Items {
+			// warm pool instances don't belong to a team yet, the pool filler keeps track of them
+			if isWarmNamespace(&ns) {
+				continue
+			}
+
+			// make sure the namespace is for a challenge that is still configured
+			challengeId, ok := challengeIds[ns.Labels["chaldeploy.captaingee.ch/chal"]]
+			if !ok {
+				log.Printf("namespace %s isn't for a configured challenge, ignoring it", ns.Name)
+				continue
+			}
+
+			key := InstanceKey{TeamId: ns.Labels["chaldeploy.captaingee.ch/team-id"], ChallengeId: challengeId}
+
 			di := &DeploymentInstance{
+				Key:       key,
+				Challenge: im.Config.Challenges[challengeId],
 				AppName:   ns.Name,
 				Namespace: ns.Name,
 				State:     Running,
 				mu:        &sync.Mutex{},
+				im:        im,
 			}
 
-			teamId := ns.Labels["chaldeploy.captaingee.ch/team-id"]
+			// an instance that failed to deploy stays Failed, so it's cleaned up by ReapFailed
+			if failedAt, err := time.Parse(time.RFC3339, ns.Annotations[failedAtAnnotation]); err == nil {
+				di.State = Failed
+				di.FailedAt = &failedAt
+				di.DeployError = ns.Annotations[deployErrorAnnotation]
+			}
 
 			// get the expiration time for the deployment instance
-			if expTimeInt, err := strconv.Atoi(ns.Labels["chaldeploy.captaingee.ch/expiration-time"]); err != nil {
-				log.Printf("couldn't parse expiration time for %s as int, setting 1hr expiration: %s", ns.Name, ns.Labels["chaldeploy.captaingee.ch/expiration-time"])
-				expTime := time.Now().UTC().Add(INSTANCE_RUNTIME)
-				di.ExpTime = &expTime
+			if expTime, err := im.Store.Load(ctx, di); err != nil || expTime == nil {
+				ttl := im.Config.snapshot().InstanceTTL
+				log.Printf("couldn't load expiration time for %s, setting %s expiration (err: %v)", ns.Name, ttl, err)
+				newExpTime := time.Now().UTC().Add(ttl)
+				di.setExpTime(&newExpTime)
 			} else {
-				expTime := time.Unix(int64(expTimeInt), 0).UTC()
-				di.ExpTime = &expTime
+				di.setExpTime(expTime)
 			}
 
 			// get the connection info
-			servicesClient := clientset.CoreV1().Services(di.Namespace)
-			if service, err := servicesClient.Get(context.TODO(), di.AppName, metav1.GetOptions{}); err == nil {
-				// found a running service, check if gcp assigned an lb to it
-				if len(service.Status.LoadBalancer.Ingress) > 0 {
-					// it did, save it
-					di.Hostname = service.Status.LoadBalancer.Ingress[0].IP
-					di.Port = config.ChallengePort
+			servicesClient := im.Clientset.CoreV1().Services(di.Namespace)
+			if im.Config.IngressEnabled {
+				di.URL = im.Config.getIngressURL(im.Config.getIngressHost(di.AppName))
+			} else if service, err := servicesClient.Get(ctx, di.AppName, metav1.GetOptions{}); err == nil {
+				// found a running service, check if it has been assigned an address
+				if hostname, port, ok := im.Config.getServiceCxnInfo(service); ok {
+					// it has, save it
+					di.Hostname = hostname
+					di.Port = port
+					di.Ports = getInstancePorts(service)
 				}
 			} else {
 				log.Printf("couldn't get service when enumerating existing deployments: %v", err)
 			}
 
 			// if we couldn't get info from the running service, fill it out as unknown
-			if di.Hostname == "" {
+			if di.Hostname == "" && di.URL == "" {
 				di.Hostname = "<unknown>"
 				di.Port = -1
 			}
 
 			// save the deployment
-			im.Instances.Store(teamId, di)
+			im.Instances.Store(key, di)
 		}
 	}
 
@@ -195,251 +560,1506 @@ func (im *InstanceManager) Init() error {
 }
 
 // Deploy an instance of a challenge for a team
-// Returns the connection string and error
+// Returns the connection string and error. Creating is idempotent: if the team already has a running instance, its
+// connection string is returned. If the instance is in the middle of being created/destroyed, ErrBusy is returned.
+// Blocks until the challenge is ready, or the context is cancelled. If it doesn't become ready, the
+// instance is left Failed and an error is returned
 // ref:
 //   - https://github.com/kubernetes/client-go/blob/master/examples/in-cluster-client-configuration/main.go
 //   - https://github.com/kubernetes/client-go/blob/master/examples/create-update-delete-deployment/main.go
-func (im *InstanceManager) CreateDeployment(teamId string) (string, error) {
-	// compute a unique identifer for this deployment
-	uniqName := strings.ToLower(fmt.Sprintf("chaldeploy-%s-%s", HashString(config.ChallengeName), strings.ReplaceAll(teamId, "-", "")))
-
-	// initialize the DeploymentInstance
-	di := &DeploymentInstance{
-		AppName:   uniqName,
-		Namespace: uniqName,
-		State:     Destroyed,
-		mu:        &sync.Mutex{},
+func (im *InstanceManager) CreateDeployment(ctx context.Context, teamId, challengeId string) (string, error) {
+	if err := im.beginOperation(); err != nil {
+		return "", err
 	}
-	di, _ = im.Instances.LoadOrStore(teamId, di)
-
-	di.mu.Lock()
-	defer di.mu.Unlock()
-	if di.State == Destroyed {
-		// get the k8s objects
-		// TODO: create the other necessary resources ref rcds
-		namespace := getNamespace(uniqName, teamId)
-		deployment := getDeployment(di.AppName, teamId)
-		service := getService(di.AppName, teamId)
-
-		// set the expiration time
-		now := time.Now().UTC()
-		expTime := now.Add(INSTANCE_RUNTIME)
-		namespace.ObjectMeta.Labels["chaldeploy.captaingee.ch/expiration-time"] = strconv.Itoa(int(expTime.Unix()))
-		di.ExpTime = &expTime
-
-		// create the k8s objects
-		namespaceClient := im.Clientset.CoreV1().Namespaces()
-		if _, err := namespaceClient.Create(context.TODO(), namespace, metav1.CreateOptions{}); err != nil {
-			return "", fmt.Errorf("failed to create the namespace for %s: %v", uniqName, err)
-		}
-		deploymentsClient := im.Clientset.AppsV1().Deployments(di.Namespace)
-		if _, err := deploymentsClient.Create(context.TODO(), deployment, metav1.CreateOptions{}); err != nil {
-			return "", fmt.Errorf("failed to create the deployment for %s: %v", uniqName, err)
-		}
-		servicesClient := im.Clientset.CoreV1().Services(di.Namespace)
-		if _, err := servicesClient.Create(context.TODO(), service, metav1.CreateOptions{}); err != nil {
-			return "", fmt.Errorf("failed to create the service for %s: %v", uniqName, err)
-		}
-
-		// block until deployment is finished
-		if !di.BlockUntilDeployed(20, 6) {
-			return "", fmt.Errorf("timed out waiting for challenge to finish deploying for %s", uniqName)
-		}
+	defer im.inFlight.Done()
 
-		// update the instance state
-		createdService, err := servicesClient.Get(context.TODO(), di.AppName, metav1.GetOptions{})
-		if err != nil {
-			return "", fmt.Errorf("failed to retrieve connection info for %s: %v", uniqName, err)
-		} else {
-			di.State = Running
-			di.Hostname = createdService.Status.LoadBalancer.Ingress[0].IP
-			di.Port = config.ChallengePort
-		}
+	ctx, cancel := context.WithTimeout(ctx, im.Config.DeployTimeout)
+	defer cancel()
 
+	cxn, err := im.createDeployment(ctx, teamId, challengeId, func() {})
+	if errors.Is(err, ErrAlreadyDeployed) {
+		return cxn, nil
 	}
-
-	return di.GetCxn(), nil
+	recordOperation("create", err)
+	return cxn, err
 }
 
-// get the deployment instance for a team, if there is one.
-// if the return value is nil, that means there is no deployment
-func (im *InstanceManager) GetDeploymentInstance(teamId string) *DeploymentInstance {
-	di, _ := im.Instances.Load(teamId)
-	return di
-}
-
-// Extend the expiration time of a deployment by 1hr
-// Returns the new expiration time
-func (im *InstanceManager) ExtendDeployment(teamId string) (string, error) {
-	// get a ptr to the instance
-	di, ok := im.Instances.Load(teamId)
-	if !ok || di == nil {
-		return "", fmt.Errorf("tried to extend a non-exist deployment for %s", teamId)
-	}
-
-	// validate state
-	if di.State != Running {
-		return "", fmt.Errorf("tried to extend a non-running deployment for %s (current state: %s)", teamId, di.State)
-	}
+// Start deploying an instance of a challenge for a team in the background, so the caller doesn't have to wait for it to be ready.
+// The same checks as CreateDeployment are done first, and their errors are returned right away. Once they pass, the instance
+// is Deploying and nil is returned. It becomes Running once it's ready, or Failed with DeployError set if it fails.
+// If the team already has a running instance, nothing is deployed and ErrAlreadyDeployed is returned, so the caller
+// can hand back the existing instance
+func (im *InstanceManager) StartDeployment(teamId, challengeId string) error {
+	// only the first of these is read: nil once the deploy is accepted, or the error if it fails before that
+	accepted := make(chan error, 1)
 
-	if di.ExpTime.Before(time.Now().UTC()) {
-		return "", fmt.Errorf("tried to extend an already expired deployment for %s (exp time: %s)", teamId, di.GetExpTime())
+	if err := im.beginOperation(); err != nil {
+		return err
 	}
+	go func() {
+		defer im.inFlight.Done()
 
-	// update the di instance
-	newExp := di.ExpTime.Add(INSTANCE_RUNTIME)
-	di.ExpTime = &newExp
+		// the request that started the deploy is long gone by the time it's ready, so it has its own context
+		ctx, cancel := context.WithTimeout(context.Background(), im.Config.DeployTimeout)
+		defer cancel()
 
-	// update the namespace label
-	namespacesClient := im.Clientset.CoreV1().Namespaces()
-	ns, err := namespacesClient.Get(context.TODO(), di.Namespace, metav1.GetOptions{})
-	if err != nil {
-		return "", fmt.Errorf("couldn't get namespace object from k8s to extend instance for %s", teamId)
-	}
+		_, err := im.createDeployment(ctx, teamId, challengeId, func() { accepted <- nil })
+		if !errors.Is(err, ErrAlreadyDeployed) {
+			recordOperation("create", err)
+		}
 
-	ns.ObjectMeta.Labels["chaldeploy.captaingee.ch/expiration-time"] = strconv.Itoa(int(newExp.Unix()))
-	if _, err := namespacesClient.Update(context.TODO(), ns, metav1.UpdateOptions{}); err != nil {
-		return "", fmt.Errorf("couldn't update namespace in k8s to extend instance for %s", teamId)
-	}
+		select {
+		case accepted <- err:
+		default:
+		}
+	}()
 
-	return di.GetExpTime(), nil
+	return <-accepted
 }
 
-// Destroy a challenge deployment
-func (im *InstanceManager) DestroyDeployment(teamId string) error {
-	// get a ptr to the instance
-	di, ok := im.Instances.Load(teamId)
-	if !ok || di == nil {
-		return fmt.Errorf("tried to destroy a non-exist deployment for %s", teamId)
+// Get the message shown to a team about why their instance failed to deploy. The error itself can have
+// cluster details in it, so it's only logged
+func getDeployErrorMessage(err error) string {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return "your instance didn't start in time, try again or contact an admin"
 	}
 
-	return di.DestroyInstance()
+	return "your instance couldn't be deployed, try again or contact an admin"
 }
 
-func (im *InstanceManager) DestroyExpiredInstances() error {
-	var retErr error = nil
+// Deploy an instance of a challenge for a team, see CreateDeployment. accepted is called once the instance is
+// Deploying, after the checks that can fail without anything being deployed (see StartDeployment)
+func (im *InstanceManager) createDeployment(ctx context.Context, teamId, challengeId string, accepted func()) (cxn string, err error) {
+	// get the challenge to deploy
+	spec, ok := im.Config.Challenges[challengeId]
+	if !ok {
+		return "", fmt.Errorf("tried to deploy an unknown challenge for %s: %s", teamId, challengeId)
+	}
 
-	now := time.Now().UTC()
+	// compute a unique identifer for this deployment
+	uniqName, err := im.Config.getInstanceName(spec, teamId)
+	if err != nil {
+		return "", err
+	}
 
-	im.Instances.Range(func(key string, value *DeploymentInstance) bool {
-		if value.ExpTime != nil && value.ExpTime.Before(now) {
-			if err := value.DestroyInstance(); err != nil {
-				retErr = err
-				return false
+	// initialize the DeploymentInstance
+	key := InstanceKey{TeamId: teamId, ChallengeId: challengeId}
+	var di *DeploymentInstance
+	for {
+		di, ok = im.loadInstance(key)
+		if !ok {
+			di = &DeploymentInstance{
+				Key:       key,
+				Challenge: spec,
+				AppName:   uniqName,
+				Namespace: uniqName,
+				State:     Destroyed,
+				mu:        &sync.Mutex{},
+				im:        im,
 			}
+			di, _ = im.Instances.LoadOrStore(key, di)
 		}
 
-		return true
-	})
+		// if another request is already creating/destroying this instance, don't wait around for it.
+		// concurrent first creates all end up with the same di from LoadOrStore, so only one of them gets the lock
+		if !di.mu.TryLock() {
+			return "", fmt.Errorf("deployment for %s is already being modified: %w", key, ErrBusy)
+		}
 
-	return retErr
-}
+		// the instance may have been pruned between loading and locking it (see PruneDestroyed). deploying it then
+		// would leave an instance that isn't in the map, so start over with a new one
+		if current, ok := im.Instances.Load(key); ok && current == di {
+			break
+		}
+		di.mu.Unlock()
+	}
+	defer di.mu.Unlock()
 
-// destroy a deployment
-func (di *DeploymentInstance) DestroyInstance() error {
-	if di.State != Running {
-		// deployment isn't running, probably already being destroyed, don't try to destroy it again
-		return nil
+	// destroys hold the lock for their whole teardown, and leave the instance Destroying if the namespace didn't
+	// finish terminating, so only a Destroyed instance is safe to create. anything else would race the old
+	// namespace being torn down
+	switch di.State {
+	case Destroyed:
+		if remaining := di.cooldownRemaining(time.Now()); remaining > 0 {
+			return "", fmt.Errorf("deployment for %s can't be redeployed yet: %w", key, &CooldownError{Remaining: remaining})
+		}
+	case Running:
+		return di.GetCxn(), fmt.Errorf("deployment for %s is already running: %w", key, ErrAlreadyDeployed)
+	case Destroying:
+		return "", fmt.Errorf("deployment for %s is still being destroyed: %w", key, ErrBusy)
+	case Deploying:
+		return "", fmt.Errorf("deployment for %s is already being deployed: %w", key, ErrBusy)
+	case Failed:
+		return "", fmt.Errorf("deployment for %s failed and hasn't been cleaned up yet: %w", key, ErrFailed)
+	default:
+		return "", fmt.Errorf("deployment for %s is in an unknown state (%s): %w", key, di.State, ErrBusy)
 	}
 
-	// acquire the lock on the deployment and mark it as being destroyed
-	di.mu.Lock()
-	di.State = Destroying
-	di.mu.Unlock()
+	// make sure there's room for another instance
+	if err := im.reserveCapacity(); err != nil {
+		return "", fmt.Errorf("couldn't deploy an instance for %s: %w", key, err)
+	}
+	defer im.releaseCapacity()
+	if err := im.reserveTeamCapacity(teamId); err != nil {
+		return "", fmt.Errorf("couldn't deploy an instance for %s: %w", key, err)
+	}
+	defer im.releaseTeamCapacity(teamId)
 
-	// init client
-	client := im.Clientset.CoreV1().Namespaces()
+	// make sure another replica isn't modifying the instance too
+	if err := im.Locker.Lock(ctx, key); err != nil {
+		return "", err
+	}
+	defer im.unlockInstance(key)
+
+	// nothing can stop the deploy from starting now. if it fails from here on out, the team is told why
+	di.setState(Deploying)
+	di.DeployError = ""
+	di.publishState()
+	accepted()
+
+	// once the namespace exists, a failed deploy leaves the instance Failed, and the namespace is kept until it's
+	// cleaned up (see ReapFailed). before that, there's nothing to clean up, so it's just Destroyed
+	namespaceCreated := false
+	defer func() {
+		if err != nil {
+			fields := di.logFields()
+			fields["error"] = err.Error()
+			logEvent("instance failed to deploy", fields)
+
+			// the state only changes once everything else is set, so status requests go straight from deploying
+			// to the failure
+			now := time.Now().UTC()
+			di.DeployError = getDeployErrorMessage(err)
+			if namespaceCreated {
+				di.FailedAt = &now
+				im.saveFailedMarker(di)
+				di.setState(Failed)
+			} else {
+				di.DestroyedAt = &now
+				di.setState(Destroyed)
+			}
+			di.publishState()
+		}
+	}()
 
-	// check if the namespace exists, return if it doesn't
-	if namespace, err := client.Get(context.TODO(), di.AppName, metav1.GetOptions{}); err != nil || namespace == nil {
-		// TODO: investigate how err can be set (e.g., failed to lookup vs successfully looked up and confirmed non-existent)
-		return nil
+	if im.Config.DryRun {
+		return im.createDryRunDeployment(ctx, di)
 	}
 
-	// delete resources
-	di.mu.Lock()
-	defer di.mu.Unlock()
-	deletePolicy := metav1.DeletePropagationForeground
+	// use an instance from the warm pool if one is available, otherwise deploy a new one
+	start := time.Now()
+	di.setNames(uniqName, uniqName)
+	warm := im.claimWarmInstance(ctx, di)
+	if !warm {
+		namespace := im.Config.getNamespace(uniqName, teamId, spec)
+		if _, err := im.Clientset.CoreV1().Namespaces().Create(ctx, namespace, metav1.CreateOptions{}); err != nil {
+			return "", fmt.Errorf("failed to create the namespace for %s: %v", uniqName, err)
+		}
+	}
+	namespaceCreated = true
+
+	// set and save the expiration time. a new instance gets a fresh set of extensions
+	expTime := time.Now().UTC().Add(im.Config.snapshot().InstanceTTL)
+	di.setExpTime(&expTime)
+	di.Extensions = 0
+	if err := im.Store.Save(ctx, di); err != nil {
+		return "", fmt.Errorf("failed to save the expiration time for %s: %v", di.AppName, err)
+	}
 
-	if err := client.Delete(context.TODO(), di.Namespace, metav1.DeleteOptions{
-		PropagationPolicy: &deletePolicy,
-	}); err != nil {
-		return fmt.Errorf("failed to delete namespace %s: %v", di.Namespace, err)
+	if !warm {
+		if err := im.createInstanceResources(ctx, di); err != nil {
+			return "", err
+		}
 	}
 
-	if !di.BlockUntilTerminated(20, 6) {
-		return fmt.Errorf("failed to delete namespace %s: took too long to delete resource from k8s", di.Namespace)
+	// block until deployment is finished. ctx has the deploy timeout on it already
+	if err := di.BlockUntilDeployed(ctx); err != nil {
+		return "", fmt.Errorf("failed waiting for the challenge to be ready for %s: %v", di.AppName, err)
+	}
+	metricDeployReadySeconds.Observe(time.Since(start).Seconds())
+
+	// update the instance state
+	if im.Config.IngressEnabled {
+		// the ingress host is known up front, the ingress controller's address is shared by every instance
+		di.URL = im.Config.getIngressURL(im.Config.getIngressHost(di.AppName))
+	} else {
+		createdService, err := im.Clientset.CoreV1().Services(di.Namespace).Get(ctx, di.AppName, metav1.GetOptions{})
+		if err != nil {
+			return "", fmt.Errorf("failed to retrieve connection info for %s: %v", di.AppName, err)
+		}
+
+		hostname, port, ok := im.Config.getServiceCxnInfo(createdService)
+		if !ok {
+			return "", fmt.Errorf("the %s service for %s doesn't have an address", im.Config.ServiceType, di.AppName)
+		}
+		di.Hostname = hostname
+		di.Port = port
+		di.Ports = getInstancePorts(createdService)
 	}
 
-	di.State = Destroyed
+	di.setState(Running)
+	im.cacheInstance(di)
 
-	return nil
+	fields := di.logFields()
+	fields["duration_ms"] = time.Since(start).Milliseconds()
+	logEvent("instance deployed", fields)
+	webhooks.Notify(webhookEventCreated, di)
+	di.publishState()
 
+	return di.GetCxn(), nil
 }
 
-// Expontential backoff spin until the deployment service has an external IP assigned
-// Returns true if blocked until successful deployment, otherwise false.
-func (di *DeploymentInstance) BlockUntilDeployed(wait int, maxTries int) bool {
-	client := im.Clientset.CoreV1().Services(di.Namespace)
-	counter := 0
+// Render the env vars and files for an instance, and create everything that goes in its namespace: the copied secrets,
+// env secret, files, network policy, deployment, service, and ingress. The namespace has to exist already
+func (im *InstanceManager) createInstanceResources(ctx context.Context, di *DeploymentInstance) error {
+	teamId, challengeId, spec := di.Key.TeamId, di.Key.ChallengeId, di.Challenge
 
-	if wait > 0 {
-		time.Sleep(time.Duration(wait) * time.Second)
+	// render the env vars for the challenge container
+	envData := EnvTemplateData{TeamID: teamId, ChallengeID: challengeId, AppName: di.AppName, Namespace: di.Namespace}
+	plainEnv, err := renderTemplates(im.Config.ChallengeEnv, envData)
+	if err != nil {
+		return fmt.Errorf("failed to render the env vars for %s: %v", di.AppName, err)
+	}
+	secretEnv, err := renderTemplates(im.Config.ChallengeSecretEnv, envData)
+	if err != nil {
+		return fmt.Errorf("failed to render the secret env vars for %s: %v", di.AppName, err)
+	}
+	if im.Config.FlagTemplate != "" {
+		// the flag goes in the env secret so it isn't readable from the deployment
+		secretEnv[im.Config.FlagEnv] = generateFlag(teamId, challengeId)
+	}
+	files, err := renderTemplates(im.Config.ChallengeFiles, envData)
+	if err != nil {
+		return fmt.Errorf("failed to render the challenge files for %s: %v", di.AppName, err)
 	}
 
-	for {
-		service, err := client.Get(context.TODO(), di.AppName, metav1.GetOptions{})
-		if err == nil {
-			if len(service.Status.LoadBalancer.Ingress) > 0 {
-				if service.Status.LoadBalancer.Ingress[0].IP != "" {
-					return true
-				}
-			}
-		}
+	// get the k8s objects
+	// TODO: create the other necessary resources ref rcds
+	env := getContainerEnv(di.AppName, plainEnv, secretEnv)
+	service := im.Config.getService(di.AppName, teamId, spec)
 
-		counter += 1
-		if counter == maxTries {
-			return false
+	if im.Config.ImagePullSecret != "" {
+		// pull secrets are namespace scoped, so it needs to be in the instance namespace before the pods can use it
+		if err := im.copySecret(ctx, im.Config.ImagePullSecretNamespace, im.Config.ImagePullSecret, di.Namespace); err != nil {
+			return fmt.Errorf("failed to copy the image pull secret for %s: %v", di.AppName, err)
 		}
-
-		time.Sleep(time.Duration(math.Pow(2, float64(counter))) * time.Second)
 	}
-}
-
-// Exponential backoff spin until the deployment is terminated.
-// Returns true if blocked until successful deletion, otherwise false.
-func (di *DeploymentInstance) BlockUntilTerminated(wait int, maxTries int) bool {
-	client := im.Clientset.CoreV1().Namespaces()
-	counter := 0
-
-	if wait > 0 {
-		time.Sleep(time.Duration(wait) * time.Second)
+	if im.Config.IngressEnabled && im.Config.IngressTLSSecret != "" {
+		// same as the pull secret, the ingress can only use a TLS secret in its own namespace
+		if err := im.copySecret(ctx, im.Config.IngressTLSSecretNamespace, im.Config.IngressTLSSecret, di.Namespace); err != nil {
+			return fmt.Errorf("failed to copy the ingress TLS secret for %s: %v", di.AppName, err)
+		}
 	}
-
-	for {
-		// namespace won't be deleted until all of the resources contained within it are terminated
-		// wait for the ns to disappear
-		_, err := client.Get(context.TODO(), di.Namespace, metav1.GetOptions{})
-		if err != nil && strings.Contains(err.Error(), " not found") {
-			return true
+	if len(secretEnv) > 0 {
+		envSecret := getEnvSecret(di.AppName, teamId, spec, secretEnv)
+		if _, err := im.Clientset.CoreV1().Secrets(di.Namespace).Create(ctx, envSecret, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("failed to create the env secret for %s: %v", di.AppName, err)
 		}
-
-		counter += 1
-		if counter == maxTries {
-			return false
+	}
+	if len(files) > 0 {
+		// mounted into the container by the deployment, and cleaned up along with the namespace
+		filesConfigMap := getFilesConfigMap(di.AppName, teamId, spec, files)
+		if _, err := im.Clientset.CoreV1().ConfigMaps(di.Namespace).Create(ctx, filesConfigMap, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("failed to create the challenge files for %s: %v", di.AppName, err)
 		}
-
-		time.Sleep(time.Duration(math.Pow(2, float64(counter))) * time.Second)
 	}
+	if im.Config.NetworkPolicyEnabled {
+		// the policy lives in the instance namespace, so it gets cleaned up along with it
+		networkPolicy := im.Config.getNetworkPolicy(di.AppName, teamId, spec)
+		if _, err := im.Clientset.NetworkingV1().NetworkPolicies(di.Namespace).Create(ctx, networkPolicy, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("failed to create the network policy for %s: %v", di.AppName, err)
+		}
+	}
+	var ingress *networkingv1.Ingress
+	if im.Config.IngressEnabled {
+		ingress = im.Config.getIngress(di.AppName, teamId, spec)
+	}
+	return im.createInstanceObjects(ctx, di, env, service, ingress)
 }
 
-// Get a human readable string for the expiration time of a deployment
-func (di *DeploymentInstance) GetExpTime() string {
-	if di.ExpTime == nil {
-		return "<unknown>"
+// Create the workload (see createWorkload), service, and ingress (if it isn't nil) for an instance at the same time.
+// They only depend on the namespace (and the secrets, etc.) already being there, not on each other, so this
+// takes about as long as one round trip to the API server instead of one for each of them.
+// These are plain creates rather than server-side apply, since the namespace is always new and there's nothing to merge with.
+// The first error is returned, and it's up to the caller to clean up the namespace
+func (im *InstanceManager) createInstanceObjects(ctx context.Context, di *DeploymentInstance, env []corev1.EnvVar, service *corev1.Service, ingress *networkingv1.Ingress) error {
+	creates := []func() error{
+		func() error {
+			return im.createWorkload(ctx, di, env)
+		},
+		func() error {
+			if _, err := im.Clientset.CoreV1().Services(di.Namespace).Create(ctx, service, metav1.CreateOptions{}); err != nil {
+				return fmt.Errorf("failed to create the service for %s: %v", di.AppName, err)
+			}
+			return nil
+		},
+	}
+	if ingress != nil {
+		creates = append(creates, func() error {
+			if _, err := im.Clientset.NetworkingV1().Ingresses(di.Namespace).Create(ctx, ingress, metav1.CreateOptions{}); err != nil {
+				return fmt.Errorf("failed to create the ingress for %s: %v", di.AppName, err)
+			}
+			return nil
+		})
 	}
 
-	return di.ExpTime.Format("2006-01-02 15:04:05 UTC")
+	errs := make([]error, len(creates))
+	var wg sync.WaitGroup
+	for i, create := range creates {
+		i, create := i, create
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = create()
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Mark an instance as running without deploying anything to the cluster, for dry run mode.
+// The connection info is made up from the challenge port
+func (im *InstanceManager) createDryRunDeployment(ctx context.Context, di *DeploymentInstance) (string, error) {
+	expTime := time.Now().UTC().Add(im.Config.snapshot().InstanceTTL)
+	di.setExpTime(&expTime)
+	di.Extensions = 0
+	if err := im.Store.Save(ctx, di); err != nil {
+		return "", fmt.Errorf("failed to save the expiration time for %s: %v", di.Key, err)
+	}
+
+	di.Hostname = "localhost"
+	di.Port = im.Config.getPrimaryPort(di.Challenge).ContainerPort
+	di.Ports = []InstancePort{}
+	for _, p := range im.Config.getPublicPorts(di.Challenge) {
+		di.Ports = append(di.Ports, InstancePort{Name: p.Name, Port: p.ContainerPort})
+	}
+	di.setState(Running)
+	im.cacheInstance(di)
+
+	fields := di.logFields()
+	fields["dry_run"] = true
+	logEvent("instance deployed", fields)
+	webhooks.Notify(webhookEventCreated, di)
+	di.publishState()
+
+	return di.GetCxn(), nil
+}
+
+// Count the instances in a state
+func (im *InstanceManager) countInstances(state InstanceState) int {
+	count := 0
+	im.Instances.Range(func(key InstanceKey, di *DeploymentInstance) bool {
+		if di.getState() == state {
+			count += 1
+		}
+		return true
+	})
+
+	return count
+}
+
+// Mark an instance's namespace as failed to deploy, with when and why, so discoverExistingInstances can tell it apart
+// from a running instance. The deploy's context may have timed out already, so this uses its own. Best effort: if it
+// can't be saved, the instance is only Failed until chaldeploy restarts
+func (im *InstanceManager) saveFailedMarker(di *DeploymentInstance) {
+	ctx, cancel := context.WithTimeout(context.Background(), im.Config.DestroyTimeout)
+	defer cancel()
+
+	namespacesClient := im.Clientset.CoreV1().Namespaces()
+	ns, err := namespacesClient.Get(ctx, di.Namespace, metav1.GetOptions{})
+	if err == nil {
+		if ns.Annotations == nil {
+			ns.Annotations = map[string]string{}
+		}
+		ns.Annotations[failedAtAnnotation] = di.FailedAt.UTC().Format(time.RFC3339)
+		ns.Annotations[deployErrorAnnotation] = di.DeployError
+		_, err = namespacesClient.Update(ctx, ns, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		log.Printf("couldn't mark namespace %s as failed: %v", di.Namespace, err)
+	}
+}
+
+// Count the instances that are using cluster resources, i.e. ones that are running, still being destroyed, or failed
+// (their namespaces are kept for a while, see ReapFailed)
+func (im *InstanceManager) countActiveInstances() int {
+	count := 0
+	im.Instances.Range(func(key InstanceKey, di *DeploymentInstance) bool {
+		if state := di.getState(); state == Running || state == Destroying || state == Failed {
+			count += 1
+		}
+		return true
+	})
+
+	return count
+}
+
+// Reserve room for a new instance under the cap on concurrent instances, returning ErrCapacityReached if there isn't any.
+// The count and reservation happen under one lock, so simultaneous creates can't both slip past the cap.
+// Every successful reservation must be followed by a call to releaseCapacity once the create is finished.
+// Reservations are counted even without a cap, so they still add up if one is set by a reload
+func (im *InstanceManager) reserveCapacity() error {
+	im.capacityMu.Lock()
+	defer im.capacityMu.Unlock()
+
+	if max := im.Config.snapshot().MaxConcurrentInstances; max > 0 && im.countActiveInstances()+im.pendingCreates >= max {
+		return ErrCapacityReached
+	}
+
+	im.pendingCreates += 1
+	return nil
+}
+
+// Release a reservation from reserveCapacity. If the create succeeded, the instance is counted as Running from here on
+func (im *InstanceManager) releaseCapacity() {
+	im.capacityMu.Lock()
+	defer im.capacityMu.Unlock()
+
+	im.pendingCreates -= 1
+}
+
+// Count a team's instances (of any challenge) that are Running, Destroying, or Failed
+func (im *InstanceManager) countTeamInstances(teamId string) int {
+	count := 0
+	im.Instances.Range(func(key InstanceKey, di *DeploymentInstance) bool {
+		if state := di.getState(); key.TeamId == teamId && (state == Running || state == Destroying || state == Failed) {
+			count += 1
+		}
+		return true
+	})
+
+	return count
+}
+
+// Reserve room for a new instance under the cap on instances per team, returning a TeamLimitError if there isn't any.
+// Like reserveCapacity, a successful reservation must be followed by a call to releaseTeamCapacity
+func (im *InstanceManager) reserveTeamCapacity(teamId string) error {
+	im.capacityMu.Lock()
+	defer im.capacityMu.Unlock()
+
+	if max := im.Config.snapshot().MaxInstancesPerTeam; max > 0 && im.countTeamInstances(teamId)+im.pendingTeamCreates[teamId] >= max {
+		return &TeamLimitError{Limit: max}
+	}
+
+	if im.pendingTeamCreates == nil {
+		im.pendingTeamCreates = map[string]int{}
+	}
+	im.pendingTeamCreates[teamId] += 1
+	return nil
+}
+
+// Release a reservation from reserveTeamCapacity
+func (im *InstanceManager) releaseTeamCapacity(teamId string) {
+	im.capacityMu.Lock()
+	defer im.capacityMu.Unlock()
+
+	im.pendingTeamCreates[teamId] -= 1
+	if im.pendingTeamCreates[teamId] <= 0 {
+		delete(im.pendingTeamCreates, teamId)
+	}
+}
+
+// Delete the namespace for a deployment that failed partway through being created.
+// This is best effort, and doesn't wait for the namespace to finish terminating
+func (im *InstanceManager) cleanupFailedDeployment(namespace string) {
+	deletePolicy := metav1.DeletePropagationForeground
+
+	// the request context may have been cancelled, which is why the deployment failed, so don't use it here
+	if err := im.Clientset.CoreV1().Namespaces().Delete(context.Background(), namespace, metav1.DeleteOptions{
+		PropagationPolicy: &deletePolicy,
+	}); err != nil && !apierrors.IsNotFound(err) {
+		log.Printf("couldn't clean up namespace %s after a failed deployment: %v", namespace, err)
+	}
+}
+
+// Copy a secret (e.g., the image pull secret) into an instance namespace, since secrets are namespace scoped
+// If the secret is already in the namespace (e.g., on a redeploy), it is updated to match the source secret
+func (im *InstanceManager) copySecret(ctx context.Context, srcNamespace, name, namespace string) error {
+	src, err := im.Clientset.CoreV1().Secrets(srcNamespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("couldn't get secret %s/%s: %v", srcNamespace, name, err)
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: src.Name,
+			Labels: map[string]string{
+				"app.kubernetes.io/managed-by": "chaldeploy",
+			},
+		},
+		Type: src.Type,
+		Data: src.Data,
+	}
+
+	secretsClient := im.Clientset.CoreV1().Secrets(namespace)
+	if _, err := secretsClient.Create(ctx, secret, metav1.CreateOptions{}); apierrors.IsAlreadyExists(err) {
+		_, err = secretsClient.Update(ctx, secret, metav1.UpdateOptions{})
+		return err
+	} else {
+		return err
+	}
+}
+
+// get the deployment instance of a challenge for a team, if there is one.
+// if the return value is nil, that means there is no deployment.
+func (im *InstanceManager) GetDeploymentInstance(teamId, challengeId string) *DeploymentInstance {
+	di, _ := im.loadInstance(InstanceKey{TeamId: teamId, ChallengeId: challengeId})
+	return di
+}
+
+// Get the last lines of the logs for a team's instance of a challenge. Only the pods in the team's own instance
+// namespace are read. If there's more than one replica, the logs are from the newest pod.
+// Returns an ErrNoInstance error if the team doesn't have a running instance, or it doesn't have a pod yet
+func (im *InstanceManager) GetInstanceLogs(ctx context.Context, teamId, challengeId string, lines int64) (string, error) {
+	key := InstanceKey{TeamId: teamId, ChallengeId: challengeId}
+	di := im.GetDeploymentInstance(teamId, challengeId)
+	if di == nil || di.getState() != Running {
+		return "", fmt.Errorf("tried to get logs for a non-running deployment for %s: %w", key, ErrNoInstance)
+	}
+
+	// nothing is actually running in dry run mode
+	if im.Config.DryRun {
+		return "", nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, logsTimeout)
+	defer cancel()
+
+	podsClient := im.Clientset.CoreV1().Pods(di.Namespace)
+	pods, err := podsClient.List(ctx, metav1.ListOptions{
+		// just the app label, since an instance from the warm pool has pods that were labelled before it had a team
+		LabelSelector: metav1.FormatLabelSelector(&metav1.LabelSelector{MatchLabels: map[string]string{"app": di.AppName}}),
+	})
+	if err != nil {
+		return "", fmt.Errorf("couldn't list the pods for %s: %v", key, err)
+	}
+	if len(pods.Items) == 0 {
+		return "", fmt.Errorf("deployment for %s doesn't have any pods: %w", key, ErrNoInstance)
+	}
+
+	pod := pods.Items[0]
+	for _, p := range pods.Items[1:] {
+		if p.CreationTimestamp.After(pod.CreationTimestamp.Time) {
+			pod = p
+		}
+	}
+
+	// the logs are streamed instead of buffered by the client, and capped here too, since LimitBytes is only
+	// a hint that the kubelet can go over
+	limitBytes := maxLogBytes
+	stream, err := podsClient.GetLogs(pod.Name, &corev1.PodLogOptions{TailLines: &lines, LimitBytes: &limitBytes}).Stream(ctx)
+	if err != nil {
+		return "", fmt.Errorf("couldn't get the logs for %s: %v", key, err)
+	}
+	defer stream.Close()
+
+	logs, err := io.ReadAll(io.LimitReader(stream, maxLogBytes))
+	if err != nil {
+		return "", fmt.Errorf("couldn't read the logs for %s: %v", key, err)
+	}
+
+	return string(logs), nil
+}
+
+// Get an instance from the instance map, falling back to the cache for instances that this replica doesn't
+// know about. Instances found in the cache are added to the instance map
+func (im *InstanceManager) loadInstance(key InstanceKey) (*DeploymentInstance, bool) {
+	if di, ok := im.Instances.Load(key); ok {
+		return di, true
+	}
+
+	if im.Cache == nil {
+		return nil, false
+	}
+
+	di, err := im.Cache.Get(key)
+	if err != nil {
+		log.Printf("couldn't check the cache for %s: %v", key, err)
+		return nil, false
+	}
+
+	// only running instances are cached, but double check
+	if di == nil || di.State != Running {
+		return nil, false
+	}
+
+	di.mu = &sync.Mutex{}
+	di.im = im
+	di, _ = im.Instances.LoadOrStore(key, di)
+	return di, true
+}
+
+// Write an instance through to the cache, if enabled
+func (im *InstanceManager) cacheInstance(di *DeploymentInstance) {
+	if im.Cache == nil {
+		return
+	}
+
+	if err := im.Cache.Set(di); err != nil {
+		log.Printf("couldn't cache the instance for %s: %v", di.Key, err)
+	}
+}
+
+// Release the cross-replica lock for an instance
+// If it can't be released, it expires after the lock TTL, so failures are only logged
+func (im *InstanceManager) unlockInstance(key InstanceKey) {
+	if err := im.Locker.Unlock(context.Background(), key); err != nil {
+		log.Printf("couldn't release the lock for %s: %v", key, err)
+	}
+}
+
+// Remove the saved state and cache entry for an instance that has been destroyed
+// The instance is gone either way, so failures are only logged
+func (im *InstanceManager) forgetInstance(di *DeploymentInstance) {
+	// the destroy may have used up its context, and this is cleanup, so don't use it here
+	if err := im.Store.Delete(context.Background(), di); err != nil {
+		log.Printf("couldn't delete the saved state for %s: %v", di.Key, err)
+	}
+
+	if im.Cache != nil {
+		if err := im.Cache.Delete(di.Key); err != nil {
+			log.Printf("couldn't remove the instance for %s from the cache: %v", di.Key, err)
+		}
+	}
+}
+
+// Extend the expiration time of a deployment by the configured extension duration, capped at the max TTL
+// Returns the new expiration time as an RFC3339 timestamp
+func (im *InstanceManager) ExtendDeployment(ctx context.Context, teamId, challengeId string) (string, error) {
+	if err := im.beginOperation(); err != nil {
+		return "", err
+	}
+	defer im.inFlight.Done()
+
+	newExp, err := im.extendDeployment(ctx, teamId, challengeId)
+	recordOperation("extend", err)
+	return newExp, err
+}
+
+// Extend the expiration time of a deployment, see ExtendDeployment
+func (im *InstanceManager) extendDeployment(ctx context.Context, teamId, challengeId string) (string, error) {
+	// get a ptr to the instance
+	key := InstanceKey{TeamId: teamId, ChallengeId: challengeId}
+	di, ok := im.loadInstance(key)
+	if !ok || di == nil {
+		return "", fmt.Errorf("tried to extend a non-exist deployment for %s: %w", key, ErrNoInstance)
+	}
+
+	// hold the lock for the whole extension so the reaper can't destroy the instance mid-extend
+	di.mu.Lock()
+	defer di.mu.Unlock()
+
+	// validate state
+	if di.State != Running {
+		return "", fmt.Errorf("tried to extend a non-running deployment for %s (current state: %s): %w", key, di.State, ErrNoInstance)
+	}
+
+	now := time.Now().UTC()
+	if di.ExpTime == nil || di.ExpTime.Before(now) {
+		return "", fmt.Errorf("tried to extend an already expired deployment for %s (exp time: %s): %w", key, di.GetExpTime(), ErrNoInstance)
+	}
+
+	cfg := im.Config.snapshot()
+	if cfg.MaxExtensions > 0 && di.Extensions >= cfg.MaxExtensions {
+		return "", fmt.Errorf("deployment for %s has already been extended %d times: %w", key, di.Extensions, ErrMaxExtensions)
+	}
+
+	// compute the new expiration time
+	newExp := di.ExpTime.Add(cfg.ExtendDuration)
+	if cfg.MaxTTL > 0 {
+		if maxExp := now.Add(cfg.MaxTTL); newExp.After(maxExp) {
+			newExp = maxExp
+		}
+	}
+
+	// update the di instance and save it, putting the old expiration time back if it can't be saved
+	oldExp := di.ExpTime
+	di.setExpTime(&newExp)
+	if err := im.Store.Save(ctx, di); err != nil {
+		di.setExpTime(oldExp)
+		return "", fmt.Errorf("couldn't save the new expiration time to extend instance for %s: %v", key, err)
+	}
+	di.Extensions++
+	im.cacheInstance(di)
+
+	fields := di.logFields()
+	fields["expires_at"] = newExp.Format(time.RFC3339)
+	logEvent("instance extended", fields)
+	di.publishState()
+
+	return newExp.Format(time.RFC3339), nil
+}
+
+// Look up the connection info for a running instance again, from its service (or ingress), and save it.
+// This fixes a stale connection string (e.g., the service got a new address) without redeploying.
+// Returns the new connection string, ErrNoInstance if there isn't a running instance, or ErrBusy if it's being modified
+func (im *InstanceManager) RefreshConnection(ctx context.Context, teamId, challengeId string) (string, error) {
+	key := InstanceKey{TeamId: teamId, ChallengeId: challengeId}
+	di, ok := im.loadInstance(key)
+	if !ok || di == nil {
+		return "", fmt.Errorf("tried to refresh the connection for a non-exist deployment for %s: %w", key, ErrNoInstance)
+	}
+
+	// don't wait on a create/destroy that's in progress, the instance is going to change anyway
+	if !di.mu.TryLock() {
+		return "", fmt.Errorf("tried to refresh the connection for %s while it's being modified: %w", key, ErrBusy)
+	}
+	defer di.mu.Unlock()
+
+	if di.State != Running {
+		return "", fmt.Errorf("tried to refresh the connection for a non-running deployment for %s (current state: %s): %w", key, di.State, ErrNoInstance)
+	}
+
+	// nothing to look up in dry run mode
+	if im.Config.DryRun {
+		return di.GetCxn(), nil
+	}
+
+	// status requests read the connection info without holding mu, so it's set all at once with setCxn
+	if im.Config.IngressEnabled {
+		di.setCxn(di.Hostname, di.Port, di.Ports, im.Config.getIngressURL(im.Config.getIngressHost(di.AppName)))
+	} else {
+		service, err := im.Clientset.CoreV1().Services(di.Namespace).Get(ctx, di.AppName, metav1.GetOptions{})
+		if err != nil {
+			return "", fmt.Errorf("failed to retrieve connection info for %s: %v", key, err)
+		}
+
+		hostname, port, ok := im.Config.getServiceCxnInfo(service)
+		if !ok {
+			return "", fmt.Errorf("the %s service for %s doesn't have an address", im.Config.ServiceType, key)
+		}
+		di.setCxn(hostname, port, getInstancePorts(service), di.URL)
+	}
+	im.cacheInstance(di)
+
+	fields := di.logFields()
+	fields["host"] = di.GetCxn()
+	logEvent("refreshed instance connection", fields)
+
+	return di.GetCxn(), nil
+}
+
+// Destroy a challenge deployment
+func (im *InstanceManager) DestroyDeployment(ctx context.Context, teamId, challengeId string) error {
+	if err := im.beginOperation(); err != nil {
+		return err
+	}
+	defer im.inFlight.Done()
+
+	// get a ptr to the instance
+	key := InstanceKey{TeamId: teamId, ChallengeId: challengeId}
+	di, ok := im.loadInstance(key)
+	if !ok || di == nil {
+		err := fmt.Errorf("tried to destroy a non-exist deployment for %s: %w", key, ErrNoInstance)
+		recordOperation("destroy", err)
+		return err
+	}
+
+	// destroying an instance that is already gone isn't counted
+	err := di.destroyInstance(ctx, nil, nil, false)
+	if errors.Is(err, errNothingToDestroy) {
+		return nil
+	}
+	recordOperation("destroy", err)
+	return err
+}
+
+// Start a background goroutine that destroys expired instances on an interval, until the context is cancelled
+func (im *InstanceManager) StartReaper(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				im.reaperPass()
+			}
+		}
+	}()
+}
+
+// Run one pass of the reaper. A panic is logged and recovered, so one bad instance can't stop the reaper for good.
+// reaper-cronjob.yaml is an optional backstop for when chaldeploy isn't running at all
+func (im *InstanceManager) reaperPass() {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("the reaper panicked, it'll try again on the next pass: %v", r)
+		}
+	}()
+
+	// ctx stops the reaper, but a pass that already started gets to finish its destroys
+	if err := im.ReapExpired(context.Background()); err != nil {
+		log.Printf("couldn't destroy expired instances: %v", err)
+	}
+
+	if err := im.ReapFailed(context.Background(), time.Now().UTC()); err != nil {
+		log.Printf("couldn't clean up failed instances: %v", err)
+	}
+
+	im.WarnExpiring(time.Now().UTC())
+
+	if removed := im.PruneDestroyed(time.Now().UTC()); removed > 0 {
+		logEvent("removed destroyed instances", Fields{"count": removed})
+	}
+
+	if err := im.RetryFailedDestroys(context.Background(), time.Now()); err != nil {
+		log.Printf("couldn't retry the failed destroys: %v", err)
+	}
+}
+
+// delay before the first retry of a failed destroy. the reaper checks for retries every minute
+var destroyRetryBaseDelay = time.Minute
+
+// Get how long to wait before retrying a destroy that has failed a number of times, doubling each time up to an hour
+func destroyRetryDelay(failures int) time.Duration {
+	delay := destroyRetryBaseDelay
+	for i := 1; i < failures && delay < time.Hour; i++ {
+		delay *= 2
+	}
+	if delay > time.Hour {
+		delay = time.Hour
+	}
+
+	return delay
+}
+
+// Schedule another attempt to destroy an instance after a destroy failed, with exponential backoff. Once it has
+// failed more than config.MaxDestroyRetries times, it's left for an organizer to clean up, and the webhook is told.
+// The instance lock has to be held
+func (di *DeploymentInstance) scheduleDestroyRetry(now time.Time, err error) {
+	di.setDestroyFailures(di.DestroyFailures + 1)
+	di.NextDestroyRetry = nil
+
+	fields := di.logFields()
+	fields["failures"] = di.DestroyFailures
+	fields["error"] = err.Error()
+
+	if di.DestroyFailures > di.im.Config.MaxDestroyRetries {
+		logEvent("gave up on destroying instance, it has to be cleaned up by hand", fields)
+		webhooks.Notify(webhookEventDestroyFailed, di)
+		return
+	}
+
+	next := now.Add(destroyRetryDelay(di.DestroyFailures))
+	di.NextDestroyRetry = &next
+	fields["next_retry"] = next.Format(time.RFC3339)
+	logEvent("couldn't destroy instance, it'll be tried again", fields)
+}
+
+// Check if a failed destroy should be tried again: its retry is due, or it has been stuck Destroying for longer
+// than the destroy timeout without a destroy running (and it hasn't run out of retries).
+// Locked instances are skipped, since they're being modified right now
+func (di *DeploymentInstance) destroyRetryDue(now time.Time) bool {
+	if !di.mu.TryLock() {
+		return false
+	}
+	defer di.mu.Unlock()
+
+	if di.State == Destroyed || di.DestroyFailures > di.im.Config.MaxDestroyRetries {
+		return false
+	}
+	if di.NextDestroyRetry != nil {
+		return !di.NextDestroyRetry.After(now)
+	}
+
+	return di.State == Destroying && di.DestroyingSince != nil && now.Sub(*di.DestroyingSince) > di.im.Config.DestroyTimeout
+}
+
+// Try destroying the instances whose destroys failed again, once their backoff is up.
+// Instances that are stuck Destroying are picked up too
+func (im *InstanceManager) RetryFailedDestroys(ctx context.Context, now time.Time) error {
+	if err := im.beginOperation(); err != nil {
+		return err
+	}
+	defer im.inFlight.Done()
+
+	due := []*DeploymentInstance{}
+	im.Instances.Range(func(key InstanceKey, di *DeploymentInstance) bool {
+		if di.destroyRetryDue(now) {
+			due = append(due, di)
+		}
+
+		return true
+	})
+
+	var lastErr error = nil
+	numFailed := 0
+
+	for _, di := range due {
+		fields := di.logFields()
+		fields["failures"] = di.DestroyFailures
+		logEvent("retrying destroying instance", fields)
+
+		err := di.destroyInstance(ctx, nil, nil, true)
+		if errors.Is(err, errNothingToDestroy) {
+			continue
+		}
+		recordOperation("destroy", err)
+		if err != nil {
+			lastErr = err
+			numFailed += 1
+		}
+	}
+
+	if lastErr != nil {
+		return fmt.Errorf("failed to destroy %d instance(s) again, last error: %v", numFailed, lastErr)
+	}
+
+	return nil
+}
+
+// Warn the teams whose instances are about to expire, with a webhook event and an event on their event streams.
+// Each instance is only warned about once for its expiration time, and again if it gets extended
+func (im *InstanceManager) WarnExpiring(now time.Time) {
+	if im.Config.snapshot().ExpiryWarningWindow <= 0 {
+		return
+	}
+
+	im.Instances.Range(func(key InstanceKey, di *DeploymentInstance) bool {
+		di.warnIfExpiringSoon(now)
+		return true
+	})
+}
+
+// Send the expiring soon warning for an instance, if it's within the warning window and hasn't been warned yet.
+// Like the reaper, locked instances are skipped and checked again on the next pass. Returns whether a warning was sent
+func (di *DeploymentInstance) warnIfExpiringSoon(now time.Time) bool {
+	if !di.mu.TryLock() {
+		return false
+	}
+	defer di.mu.Unlock()
+
+	if di.State != Running || !di.isExpiringSoon(now) {
+		return false
+	}
+	if di.WarnedExpTime != nil && di.WarnedExpTime.Equal(*di.ExpTime) {
+		return false
+	}
+
+	expTime := *di.ExpTime
+	di.WarnedExpTime = &expTime
+
+	fields := di.logFields()
+	fields["expires_at"] = di.GetExpiresAt()
+	logEvent("instance is expiring soon", fields)
+	webhooks.Notify(webhookEventExpiringSoon, di)
+	di.publishEvent(eventExpiringSoon)
+
+	return true
+}
+
+// Check if an instance expires within the warning window (but hasn't expired yet)
+func (di *DeploymentInstance) isExpiringSoon(now time.Time) bool {
+	window := di.im.Config.snapshot().ExpiryWarningWindow
+	expTime := di.getExpiration()
+	if window <= 0 || expTime == nil || expTime.Before(now) {
+		return false
+	}
+
+	return expTime.Sub(now) <= window
+}
+
+// Start an instance operation, counting it as in progress until im.inFlight.Done() is called.
+// Returns ErrShuttingDown once Drain has been called, so nothing new is started while it waits
+func (im *InstanceManager) beginOperation() error {
+	im.drainMu.Lock()
+	defer im.drainMu.Unlock()
+
+	if im.draining {
+		return ErrShuttingDown
+	}
+
+	im.inFlight.Add(1)
+	return nil
+}
+
+// Stop new creates/extends/destroys from starting, and wait for the in-progress ones to finish, or until the context is done.
+// Used when shutting down, so instances aren't left half created (or half destroyed)
+func (im *InstanceManager) Drain(ctx context.Context) error {
+	im.drainMu.Lock()
+	im.draining = true
+	im.drainMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		im.inFlight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("instance operations were still running: %w", ctx.Err())
+	}
+}
+
+// Destroy every running instance that is past its expiration time, with up to config.ReaperConcurrency at once.
+// A failure to destroy one instance doesn't stop the rest from being reaped. Only one pass runs at a time,
+// so if the last pass is still going, this one is skipped
+func (im *InstanceManager) ReapExpired(ctx context.Context) error {
+	if !im.reapMu.TryLock() {
+		log.Println("the last reaper pass is still running, skipping this one")
+		return nil
+	}
+	defer im.reapMu.Unlock()
+
+	if err := im.beginOperation(); err != nil {
+		return err
+	}
+	defer im.inFlight.Done()
+
+	now := time.Now().UTC()
+
+	expired := []*DeploymentInstance{}
+	im.Instances.Range(func(key InstanceKey, di *DeploymentInstance) bool {
+		if di.isExpired(now) {
+			expired = append(expired, di)
+		}
+
+		return true
+	})
+
+	// the workers take instances off the channel until it's empty. destroyInstance takes the instance's
+	// locks, and checks that it's still expired, so it's fine if something else got to it first
+	var lastErr error = nil
+	numFailed := 0
+	var errMu sync.Mutex
+
+	queue := make(chan *DeploymentInstance, len(expired))
+	for _, di := range expired {
+		queue <- di
+	}
+	close(queue)
+
+	workers := im.Config.ReaperConcurrency
+	if workers < 1 {
+		workers = 1
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers && i < len(expired); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for di := range queue {
+				logEvent("instance expired, destroying it", Fields{"team_id": di.Key.TeamId, "challenge_id": di.Key.ChallengeId, "expired_at": di.GetExpTime()})
+
+				err := reapInstance(ctx, di, now)
+				if errors.Is(err, errNothingToDestroy) {
+					continue
+				}
+				recordOperation("reap", err)
+				if err != nil {
+					errMu.Lock()
+					lastErr = err
+					numFailed += 1
+					errMu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if lastErr != nil {
+		return fmt.Errorf("failed to destroy %d expired instance(s), last error: %v", numFailed, lastErr)
+	}
+
+	return nil
+}
+
+// Destroy an expired instance for the reaper. A panic is turned into an error, since it happens on a worker
+// goroutine where reaperPass can't recover it
+func reapInstance(ctx context.Context, di *DeploymentInstance, now time.Time) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panicked destroying the instance for %s: %v", di.Key.TeamId, r)
+		}
+	}()
+
+	return di.destroyInstance(ctx, &now, nil, false)
+}
+
+// Destroy the instances that failed to deploy more than config.FailedInstanceGracePeriod ago. Until then, their
+// namespaces are kept around so an organizer can see what went wrong
+func (im *InstanceManager) ReapFailed(ctx context.Context, now time.Time) error {
+	if err := im.beginOperation(); err != nil {
+		return err
+	}
+	defer im.inFlight.Done()
+
+	failedBefore := now.Add(-im.Config.FailedInstanceGracePeriod)
+
+	failed := []*DeploymentInstance{}
+	im.Instances.Range(func(key InstanceKey, di *DeploymentInstance) bool {
+		if di.failedBefore(failedBefore) {
+			failed = append(failed, di)
+		}
+
+		return true
+	})
+
+	var lastErr error = nil
+	numFailed := 0
+
+	// destroyInstance checks that it's still Failed once it has the lock, in case the team cleaned it up first
+	for _, di := range failed {
+		logEvent("cleaning up failed instance", di.logFields())
+
+		err := di.destroyInstance(ctx, nil, &failedBefore, false)
+		if errors.Is(err, errNothingToDestroy) {
+			continue
+		}
+		recordOperation("reap", err)
+		if err != nil {
+			lastErr = err
+			numFailed += 1
+		}
+	}
+
+	if lastErr != nil {
+		return fmt.Errorf("failed to clean up %d failed instance(s), last error: %v", numFailed, lastErr)
+	}
+
+	return nil
+}
+
+// how long a Destroyed instance is kept at least, so the team can still see why its last deploy failed
+var destroyedRetention = 10 * time.Minute
+
+// Remove the instances that don't need to be kept anymore from the instance map, so it doesn't keep growing over a long
+// CTF. A Destroyed instance is kept until its redeploy cooldown is over, and for at least destroyedRetention.
+// Returns how many instances were removed
+func (im *InstanceManager) PruneDestroyed(now time.Time) int {
+	removed := 0
+	for key, di := range im.Instances.Snapshot() {
+		if im.pruneInstance(key, di, now) {
+			removed++
+		}
+	}
+
+	return removed
+}
+
+// Remove an instance from the instance map if it can be pruned, see PruneDestroyed. The instance is locked while it's
+// removed, and createDeployment checks that the instance it locked is still in the map, so a create that loaded it just
+// before doesn't deploy an instance that isn't tracked anymore. Like the reaper, locked instances are skipped
+func (im *InstanceManager) pruneInstance(key InstanceKey, di *DeploymentInstance, now time.Time) bool {
+	if !di.mu.TryLock() {
+		return false
+	}
+	defer di.mu.Unlock()
+
+	if di.State != Destroyed || di.cooldownRemaining(now) > 0 {
+		return false
+	}
+	if di.DestroyedAt != nil && now.Sub(*di.DestroyedAt) < destroyedRetention {
+		return false
+	}
+
+	// instances are only ever added to the map if the key isn't there, so if this one is still in it, nothing can
+	// replace it while it's locked. if the key has a different instance now, it's put back (unless another one was
+	// added in the meantime)
+	current, ok := im.Instances.LoadAndDelete(key)
+	if !ok {
+		return false
+	}
+	if current != di {
+		im.Instances.LoadOrStore(key, current)
+		return false
+	}
+
+	return true
+}
+
+// Get how much longer the redeploy cooldown has for an instance, or 0 if it can be deployed now
+func (di *DeploymentInstance) cooldownRemaining(now time.Time) time.Duration {
+	cooldown := di.im.Config.snapshot().RedeployCooldown
+	if cooldown <= 0 || di.LastDestroyed == nil {
+		return 0
+	}
+
+	return di.LastDestroyed.Add(cooldown).Sub(now)
+}
+
+// Check if an instance is running and past its expiration time.
+// Instances that are locked (e.g., in the middle of being created) are treated as not expired, and will be
+// checked again on the next pass of the reaper.
+func (di *DeploymentInstance) isExpired(now time.Time) bool {
+	if !di.mu.TryLock() {
+		return false
+	}
+	defer di.mu.Unlock()
+
+	return di.State == Running && di.ExpTime != nil && di.ExpTime.Before(now)
+}
+
+// Check if an instance failed to deploy before a time. Locked instances are skipped, like isExpired
+func (di *DeploymentInstance) failedBefore(t time.Time) bool {
+	if !di.mu.TryLock() {
+		return false
+	}
+	defer di.mu.Unlock()
+
+	return di.State == Failed && di.FailedAt != nil && di.FailedAt.Before(t)
+}
+
+// destroy a deployment. it's fine if it doesn't need to be destroyed
+func (di *DeploymentInstance) DestroyInstance(ctx context.Context) error {
+	if err := di.destroyInstance(ctx, nil, nil, false); !errors.Is(err, errNothingToDestroy) {
+		return err
+	}
+
+	return nil
+}
+
+// destroy a deployment. if expiredBefore is set, the deployment is only destroyed if it is
+// still expired once the lock is held, so an instance that just got extended isn't torn down.
+// likewise, if failedBefore is set, the deployment is only destroyed if it is still Failed, and failed before then.
+// if retry is set, this is a retry of a destroy that failed, so an instance that is stuck Destroying is destroyed too.
+// if it fails once the teardown has started, another attempt is scheduled (see RetryFailedDestroys).
+// returns errNothingToDestroy if the instance didn't need to be destroyed
+func (di *DeploymentInstance) destroyInstance(ctx context.Context, expiredBefore, failedBefore *time.Time, retry bool) (err error) {
+	// acquire the lock on the deployment for the whole teardown, and mark it as being destroyed
+	di.mu.Lock()
+	defer di.mu.Unlock()
+	if di.State != Running && di.State != Failed && !(retry && di.State == Destroying) {
+		// deployment isn't running, probably already being destroyed, don't try to destroy it again
+		return errNothingToDestroy
+	}
+	if expiredBefore != nil && (di.ExpTime == nil || !di.ExpTime.Before(*expiredBefore)) {
+		return errNothingToDestroy
+	}
+	if failedBefore != nil && (di.State != Failed || di.FailedAt == nil || !di.FailedAt.Before(*failedBefore)) {
+		return errNothingToDestroy
+	}
+
+	// if the teardown fails before the namespace is deleted, a Failed instance stays Failed, anything else is Running again
+	wasFailed := di.State == Failed
+	restoreState := Running
+	if wasFailed {
+		restoreState = Failed
+	}
+
+	// make sure another replica isn't modifying the instance too
+	ctx, cancel := context.WithTimeout(ctx, di.im.Config.DestroyTimeout)
+	defer cancel()
+
+	if err := di.im.Locker.Lock(ctx, di.Key); err != nil {
+		return err
+	}
+	defer di.im.unlockInstance(di.Key)
+
+	start := time.Now()
+	di.setState(Destroying)
+	di.DestroyingSince = &start
+	di.publishState()
+
+	// once the instance is gone, clean up everything that was saved about it, start the redeploy cooldown, and tell the webhook.
+	// if it isn't gone, try again later. either way, the team's event streams get the new state (it goes back to Running, or
+	// Failed, if the destroy failed before the namespace was deleted)
+	defer func() {
+		defer di.publishState()
+
+		if err != nil {
+			di.scheduleDestroyRetry(time.Now(), err)
+		}
+
+		if di.State == Destroyed {
+			// the team didn't get to use a failed instance, so it doesn't start the redeploy cooldown
+			now := time.Now()
+			if !wasFailed {
+				di.LastDestroyed = &now
+			}
+			di.DestroyedAt = &now
+			di.FailedAt = nil
+			di.DestroyingSince = nil
+			di.setDestroyFailures(0)
+			di.NextDestroyRetry = nil
+			di.im.forgetInstance(di)
+
+			if expiredBefore != nil {
+				webhooks.Notify(webhookEventExpired, di)
+			} else {
+				webhooks.Notify(webhookEventDestroyed, di)
+			}
+		}
+	}()
+
+	// nothing was deployed in dry run mode, so there's nothing to tear down
+	if di.im.Config.DryRun {
+		di.setState(Destroyed)
+
+		fields := di.logFields()
+		fields["dry_run"] = true
+		logEvent("instance destroyed", fields)
+
+		return nil
+	}
+
+	// init client
+	client := di.im.Clientset.CoreV1().Namespaces()
+
+	// check if the namespace exists. only a NotFound means it's actually gone, any other error
+	// leaves the instance Running so the destroy can be retried
+	if _, err := client.Get(ctx, di.Namespace, metav1.GetOptions{}); apierrors.IsNotFound(err) {
+		di.setState(Destroyed)
+		return nil
+	} else if err != nil {
+		di.setState(restoreState)
+		return fmt.Errorf("failed to look up namespace %s: %v", di.Namespace, err)
+	}
+
+	// statefulset volume claims are deleted explicitly, see deleteVolumeClaims
+	if err := di.deleteVolumeClaims(ctx); err != nil {
+		di.setState(restoreState)
+		return err
+	}
+
+	// delete resources. the deployment and service both live in the instance namespace,
+	// so deleting the namespace cleans them up too (BlockUntilTerminated confirms it)
+	deletePolicy := metav1.DeletePropagationForeground
+
+	if err := client.Delete(ctx, di.Namespace, metav1.DeleteOptions{
+		PropagationPolicy: &deletePolicy,
+	}); apierrors.IsNotFound(err) {
+		di.setState(Destroyed)
+		return nil
+	} else if err != nil {
+		di.setState(restoreState)
+		return fmt.Errorf("failed to delete namespace %s: %v", di.Namespace, err)
+	}
+
+	// wait for the namespace to finish terminating. the instance stays Destroying until then.
+	// the namespace is already being deleted, so a disconnected client doesn't cut the wait short
+	termCtx, termCancel := context.WithTimeout(context.Background(), di.im.Config.DestroyTimeout)
+	defer termCancel()
+	if err := di.BlockUntilTerminated(termCtx); err != nil {
+		fields := di.logFields()
+		fields["duration_ms"] = time.Since(start).Milliseconds()
+		logEvent("namespace didn't finish terminating in time", fields)
+		return fmt.Errorf("failed to delete namespace %s: took too long to delete resource from k8s", di.Namespace)
+	}
+
+	di.setState(Destroyed)
+
+	fields := di.logFields()
+	fields["duration_ms"] = time.Since(start).Milliseconds()
+	logEvent("instance destroyed", fields)
+
+	return nil
+}
+
+// Exponential backoff spin until the deployment (or statefulset) has a ready replica and the service has an external address assigned.
+// With an ingress, the service doesn't get an address, so only the workload is waited on.
+// If a readiness probe is configured, a replica isn't ready until its probe passes, so this waits for the challenge to respond.
+// Returns nil once deployed, otherwise the error from the context being cancelled/timing out.
+func (di *DeploymentInstance) BlockUntilDeployed(ctx context.Context) error {
+	servicesClient := di.im.Clientset.CoreV1().Services(di.Namespace)
+
+	for counter := 1; ; counter++ {
+		if ready, err := di.getReadyReplicas(ctx); err == nil && ready > 0 {
+			if di.im.Config.IngressEnabled {
+				return nil
+			}
+
+			service, err := servicesClient.Get(ctx, di.AppName, metav1.GetOptions{})
+			if err == nil {
+				if _, _, ok := di.im.Config.getServiceCxnInfo(service); ok {
+					return nil
+				}
+			}
+		}
+
+		if err := sleepBackoff(ctx, counter); err != nil {
+			return err
+		}
+	}
+}
+
+// Sleep for an exponential backoff period (capped at 30s), or until the context is done
+// Returns the context's error if it finished first
+func sleepBackoff(ctx context.Context, counter int) error {
+	delay := time.Duration(math.Min(math.Pow(2, float64(counter)), 30)) * time.Second
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(delay):
+		return nil
+	}
+}
+
+// Exponential backoff spin until the deployment is terminated.
+// Returns nil once the namespace is gone, otherwise the error from the context being cancelled/timing out.
+func (di *DeploymentInstance) BlockUntilTerminated(ctx context.Context) error {
+	client := di.im.Clientset.CoreV1().Namespaces()
+
+	for counter := 1; ; counter++ {
+		// namespace won't be deleted until all of the resources contained within it are terminated
+		// wait for the ns to disappear
+		_, err := client.Get(ctx, di.Namespace, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+
+		if err := sleepBackoff(ctx, counter); err != nil {
+			return err
+		}
+	}
+}
+
+// Get a human readable string for the expiration time of a deployment
+func (di *DeploymentInstance) GetExpTime() string {
+	expTime := di.getExpiration()
+	if expTime == nil {
+		return "<unknown>"
+	}
+
+	return expTime.Format("2006-01-02 15:04:05 UTC")
+}
+
+// Get the expiration time of a deployment as an RFC3339 string, or "" if it isn't known
+func (di *DeploymentInstance) GetExpiresAt() string {
+	expTime := di.getExpiration()
+	if expTime == nil {
+		return ""
+	}
+
+	return expTime.Format(time.RFC3339)
+}
+
+// Get how many seconds a deployment has left before it expires, clamped at 0 since an expired
+// instance can still be around until the reaper gets to it
+func (di *DeploymentInstance) SecondsRemaining(now time.Time) int {
+	expTime := di.getExpiration()
+	if expTime == nil || !expTime.After(now) {
+		return 0
+	}
+
+	return int(expTime.Sub(now).Seconds())
+}
+
+// Get the structured log fields that identify an instance
+func (di *DeploymentInstance) logFields() Fields {
+	appName, _ := di.getNames()
+	return Fields{
+		"team_id":      di.Key.TeamId,
+		"challenge_id": di.Key.ChallengeId,
+		"app_name":     appName,
+		"state":        di.getState().String(),
+	}
 }
 
 /////////////////////////////////
@@ -453,36 +2073,39 @@ func getImageName(image string) string {
 }
 
 // get a labelselector object that can be used for the deployment and service objects
-func getSelector(appName, teamId string) *metav1.LabelSelector {
+func getSelector(appName, teamId string, spec ChallengeSpec) *metav1.LabelSelector {
 	return &metav1.LabelSelector{
 		MatchLabels: map[string]string{
 			"app":                              appName,
-			"chaldeploy.captaingee.ch/chal":    HashString(config.ChallengeName),
+			"chaldeploy.captaingee.ch/chal":    HashString(spec.Name),
 			"chaldeploy.captaingee.ch/team-id": teamId,
 		},
 	}
 }
 
 // get the namespace struct for the deployment
-func getNamespace(name, teamId string) *corev1.Namespace {
+func (c *Config) getNamespace(name, teamId string, spec ChallengeSpec) *corev1.Namespace {
+	labels := map[string]string{
+		"app.kubernetes.io/managed-by":        "chaldeploy",
+		"chaldeploy.captaingee.ch/chal":       HashString(spec.Name),
+		"chaldeploy.captaingee.ch/team-id":    teamId,
+		"chaldeploy.captaingee.ch/managed-by": "yes",
+	}
+	if c.EventId != "" {
+		labels[eventIdLabel] = c.EventId
+	}
+
 	return &corev1.Namespace{
 		ObjectMeta: metav1.ObjectMeta{
-			Name: name,
-			Labels: map[string]string{
-				"app.kubernetes.io/managed-by":        "chaldeploy",
-				"chaldeploy.captaingee.ch/chal":       HashString(config.ChallengeName),
-				"chaldeploy.captaingee.ch/team-id":    teamId,
-				"chaldeploy.captaingee.ch/managed-by": "yes",
-			},
+			Name:   name,
+			Labels: mergeLabels(c.ExtraNamespaceLabels, labels),
 		},
 	}
 }
 
-// get the deployment struct for the target app
-func getDeployment(appName, teamId string) *appsv1.Deployment {
-	selector := getSelector(appName, teamId)
-
-	b := false
+// get the deployment struct for the target app, with the env vars for the challenge container
+func (c *Config) getDeployment(appName, teamId string, spec ChallengeSpec, env []corev1.EnvVar) *appsv1.Deployment {
+	replicas := int32(c.Replicas)
 
 	return &appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
@@ -490,46 +2113,257 @@ func getDeployment(appName, teamId string) *appsv1.Deployment {
 			Labels: map[string]string{
 				"app":                              appName,
 				"app.kubernetes.io/managed-by":     "chaldeploy",
-				"chaldeploy.captaingee.ch/chal":    HashString(config.ChallengeName),
+				"chaldeploy.captaingee.ch/chal":    HashString(spec.Name),
 				"chaldeploy.captaingee.ch/team-id": teamId,
 			},
 		},
 		Spec: appsv1.DeploymentSpec{
-			Selector: selector,
-			Template: corev1.PodTemplateSpec{
-				ObjectMeta: metav1.ObjectMeta{
-					Labels: map[string]string{
-						"app":                              appName,
-						"app.kubernetes.io/managed-by":     "chaldeploy",
-						"chaldeploy.captaingee.ch/chal":    HashString(config.ChallengeName),
-						"chaldeploy.captaingee.ch/team-id": teamId,
-					},
-				},
-				Spec: corev1.PodSpec{
-					AutomountServiceAccountToken: &b,
-					Containers: []corev1.Container{
-						{
-							Name:  getImageName(config.ChallengeImage),
-							Image: config.ChallengeImage,
-							Ports: []corev1.ContainerPort{{ContainerPort: int32(config.ChallengePort)}},
-
-							// Resources: corev1.ResourceRequirements{
-							// 	Limits: corev1.ResourceList{
-							// 		corev1.ResourceCPU:    resource.MustParse("500m"), // TODO: configify these
-							// 		corev1.ResourceMemory: resource.MustParse("256Mi"),
-							// 	},
-							// },
-						},
-					},
+			Replicas: &replicas,
+			Selector: getSelector(appName, teamId, spec),
+			Strategy: c.getDeploymentStrategy(spec),
+			Template: c.getPodTemplate(appName, teamId, spec, env),
+		},
+	}
+}
+
+// get the pod template for the challenge pods, shared by the deployment and statefulset
+func (c *Config) getPodTemplate(appName, teamId string, spec ChallengeSpec, env []corev1.EnvVar) corev1.PodTemplateSpec {
+	b := false
+
+	var pullSecrets []corev1.LocalObjectReference
+	if c.ImagePullSecret != "" {
+		pullSecrets = []corev1.LocalObjectReference{{Name: c.ImagePullSecret}}
+	}
+
+	var volumes []corev1.Volume
+	var volumeMounts []corev1.VolumeMount
+	if len(c.ChallengeFiles) > 0 {
+		volumes = []corev1.Volume{getFilesVolume(appName)}
+		volumeMounts = []corev1.VolumeMount{{Name: filesVolumeName, MountPath: c.ChallengeMountPath, ReadOnly: true}}
+	}
+	if c.SharedVolume != "" {
+		volumes = append(volumes, getSharedVolume())
+		volumeMounts = append(volumeMounts, corev1.VolumeMount{Name: sharedVolumeName, MountPath: c.SharedVolume})
+	}
+	if c.ScratchVolumeSizeLimit != "" {
+		volumes = append(volumes, c.getScratchVolume())
+		volumeMounts = append(volumeMounts, corev1.VolumeMount{Name: scratchVolumeName, MountPath: c.ScratchMountPath})
+	}
+	if c.Workload == workloadStatefulSet {
+		// the volume comes from the statefulset's volume claim template
+		volumeMounts = append(volumeMounts, corev1.VolumeMount{Name: storageVolumeName, MountPath: c.StorageMountPath})
+	}
+
+	return corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels: mergeLabels(c.ExtraPodLabels, map[string]string{
+				"app":                              appName,
+				"app.kubernetes.io/managed-by":     "chaldeploy",
+				"chaldeploy.captaingee.ch/chal":    HashString(spec.Name),
+				"chaldeploy.captaingee.ch/team-id": teamId,
+			}),
+			Annotations: c.ExtraPodAnnotations,
+		},
+		Spec: corev1.PodSpec{
+			AutomountServiceAccountToken:  &b,
+			ImagePullSecrets:              pullSecrets,
+			SecurityContext:               &corev1.PodSecurityContext{SeccompProfile: c.getSeccompProfile(spec)},
+			Volumes:                       volumes,
+			NodeSelector:                  c.NodeSelector,
+			Tolerations:                   c.Tolerations,
+			Affinity:                      c.getAffinity(spec),
+			TerminationGracePeriodSeconds: c.getTerminationGracePeriod(),
+			InitContainers:                c.getInitContainers(spec, env, volumeMounts),
+			Containers: append([]corev1.Container{
+				{
+					Name:            getImageName(spec.Image),
+					Image:           spec.Image,
+					Command:         spec.Command,
+					Args:            spec.Args,
+					WorkingDir:      spec.WorkingDir,
+					Ports:           c.getContainerPorts(spec),
+					Resources:       c.getResourceRequirements(),
+					ImagePullPolicy: corev1.PullPolicy(c.ImagePullPolicy),
+					SecurityContext: c.getSecurityContext(spec),
+					Env:             env,
+					VolumeMounts:    volumeMounts,
+					ReadinessProbe:  c.getReadinessProbe(spec),
+					LivenessProbe:   c.getLivenessProbe(spec),
+					Lifecycle:       c.getLifecycle(),
 				},
-			},
+			}, c.getSidecarContainers(env, volumeMounts)...),
+		},
+	}
+}
+
+// get the resource limits and requests for the challenge container.
+// the quantities are validated at startup (and on a reload), so MustParse won't panic here
+func (c *Config) getResourceRequirements() corev1.ResourceRequirements {
+	limits := corev1.ResourceList{}
+	requests := corev1.ResourceList{}
+	cfg := c.snapshot()
+
+	if cfg.CPULimit != "" {
+		limits[corev1.ResourceCPU] = resource.MustParse(cfg.CPULimit)
+	}
+	if cfg.MemoryLimit != "" {
+		limits[corev1.ResourceMemory] = resource.MustParse(cfg.MemoryLimit)
+	}
+	if cfg.CPURequest != "" {
+		requests[corev1.ResourceCPU] = resource.MustParse(cfg.CPURequest)
+	}
+	if cfg.MemoryRequest != "" {
+		requests[corev1.ResourceMemory] = resource.MustParse(cfg.MemoryRequest)
+	}
+	if cfg.EphemeralStorageLimit != "" {
+		limits[corev1.ResourceEphemeralStorage] = resource.MustParse(cfg.EphemeralStorageLimit)
+	}
+	if cfg.EphemeralStorageRequest != "" {
+		requests[corev1.ResourceEphemeralStorage] = resource.MustParse(cfg.EphemeralStorageRequest)
+	}
+
+	return corev1.ResourceRequirements{Limits: limits, Requests: requests}
+}
+
+// Make sure none of the requests are bigger than their limit, which k8s would reject when creating the deployment
+func checkRequestsWithinLimits(reqs corev1.ResourceRequirements) error {
+	for name, request := range reqs.Requests {
+		if limit, ok := reqs.Limits[name]; ok && request.Cmp(limit) > 0 {
+			return fmt.Errorf("the %s request (%s) is more than the limit (%s)", name, request.String(), limit.String())
+		}
+	}
+
+	return nil
+}
+
+// get the security context for the challenge container. challenge binaries are untrusted, so by default the
+// container can't escalate privileges and has no capabilities. a challenge can replace this with its own
+func (c *Config) getSecurityContext(spec ChallengeSpec) *corev1.SecurityContext {
+	if spec.SecurityContext != nil {
+		return spec.SecurityContext.DeepCopy()
+	}
+
+	runAsNonRoot := c.RunAsNonRoot
+	allowPrivilegeEscalation := false
+	readOnlyRootFS := c.ReadOnlyRootFS
+
+	return &corev1.SecurityContext{
+		RunAsNonRoot:             &runAsNonRoot,
+		AllowPrivilegeEscalation: &allowPrivilegeEscalation,
+		ReadOnlyRootFilesystem:   &readOnlyRootFS,
+		Capabilities: &corev1.Capabilities{
+			Drop: []corev1.Capability{"ALL"},
 		},
 	}
 }
 
+// Parse a seccomp profile setting, which is RuntimeDefault, Unconfined, or localhost/<path>
+func parseSeccompProfile(profile string) (*corev1.SeccompProfile, error) {
+	switch {
+	case profile == string(corev1.SeccompProfileTypeRuntimeDefault):
+		return &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault}, nil
+	case profile == string(corev1.SeccompProfileTypeUnconfined):
+		return &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeUnconfined}, nil
+	case strings.HasPrefix(profile, "localhost/") && len(profile) > len("localhost/"):
+		path := strings.TrimPrefix(profile, "localhost/")
+		return &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeLocalhost, LocalhostProfile: &path}, nil
+	default:
+		return nil, fmt.Errorf("%s (must be RuntimeDefault, Unconfined, or localhost/<path>)", profile)
+	}
+}
+
+// get the seccomp profile for a challenge pod. the profiles are validated at startup, so an invalid one
+// doesn't happen here, but falls back to RuntimeDefault just in case
+func (c *Config) getSeccompProfile(spec ChallengeSpec) *corev1.SeccompProfile {
+	profile := c.SeccompProfile
+	if spec.SeccompProfile != "" {
+		profile = spec.SeccompProfile
+	}
+
+	if p, err := parseSeccompProfile(profile); err == nil {
+		return p
+	}
+
+	return &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault}
+}
+
+// Check if a deployment strategy setting is RollingUpdate or Recreate
+func isValidDeploymentStrategy(strategy string) bool {
+	return Contains([]string{string(appsv1.RollingUpdateDeploymentStrategyType), string(appsv1.RecreateDeploymentStrategyType)}, strategy)
+}
+
+// get the deployment strategy for a challenge. a challenge's strategy overrides the global one
+func (c *Config) getDeploymentStrategy(spec ChallengeSpec) appsv1.DeploymentStrategy {
+	strategy := c.DeploymentStrategy
+	if spec.DeploymentStrategy != "" {
+		strategy = spec.DeploymentStrategy
+	}
+
+	// leave it empty for k8s to default to RollingUpdate
+	if strategy != string(appsv1.RecreateDeploymentStrategyType) {
+		return appsv1.DeploymentStrategy{}
+	}
+
+	return appsv1.DeploymentStrategy{Type: appsv1.RecreateDeploymentStrategyType}
+}
+
+// get the handler used by the probes for a challenge, a TCP connection to the port or an HTTP GET for web challenges
+func (c *Config) getProbeHandler(spec ChallengeSpec) corev1.ProbeHandler {
+	port := intstr.FromInt(c.getPrimaryPort(spec).ContainerPort)
+	if spec.ProbeHttpPath != "" {
+		return corev1.ProbeHandler{HTTPGet: &corev1.HTTPGetAction{Path: spec.ProbeHttpPath, Port: port}}
+	}
+
+	return corev1.ProbeHandler{TCPSocket: &corev1.TCPSocketAction{Port: port}}
+}
+
+// Check if a protocol setting is TCP or UDP
+func isValidProtocol(protocol string) bool {
+	return Contains([]string{string(corev1.ProtocolTCP), string(corev1.ProtocolUDP)}, protocol)
+}
+
+// get the protocol for a challenge's port. a challenge's protocol overrides the global one
+func (c *Config) getProtocol(spec ChallengeSpec) corev1.Protocol {
+	if spec.Protocol != "" {
+		return corev1.Protocol(spec.Protocol)
+	}
+	if c.ChallengeProtocol != "" {
+		return corev1.Protocol(c.ChallengeProtocol)
+	}
+
+	return corev1.ProtocolTCP
+}
+
+// get the readiness probe for the challenge container, or nil if it's disabled.
+// probes connect to the primary port over TCP (or HTTP), so challenges where it's UDP don't get one
+func (c *Config) getReadinessProbe(spec ChallengeSpec) *corev1.Probe {
+	if !c.ReadinessProbeTCP || c.getPrimaryPort(spec).Protocol == string(corev1.ProtocolUDP) {
+		return nil
+	}
+
+	return &corev1.Probe{ProbeHandler: c.getProbeHandler(spec), PeriodSeconds: 5}
+}
+
+// get the liveness probe for the challenge container, or nil if it's disabled.
+// the initial delay gives the challenge some time to start before it can be killed for not responding.
+// like the readiness probe, UDP challenges don't get one
+func (c *Config) getLivenessProbe(spec ChallengeSpec) *corev1.Probe {
+	if !c.LivenessProbeTCP || c.getPrimaryPort(spec).Protocol == string(corev1.ProtocolUDP) {
+		return nil
+	}
+
+	return &corev1.Probe{ProbeHandler: c.getProbeHandler(spec), InitialDelaySeconds: 10}
+}
+
 // get the service struct for the target app
-func getService(appName, teamId string) *corev1.Service {
-	selector := getSelector(appName, teamId)
+func (c *Config) getService(appName, teamId string, spec ChallengeSpec) *corev1.Service {
+	selector := getSelector(appName, teamId, spec)
+
+	// with an ingress, the service only needs to be reachable by the ingress controller
+	serviceType := corev1.ServiceType(c.ServiceType)
+	if c.IngressEnabled {
+		serviceType = corev1.ServiceTypeClusterIP
+	}
 
 	return &corev1.Service{
 		ObjectMeta: metav1.ObjectMeta{
@@ -537,34 +2371,103 @@ func getService(appName, teamId string) *corev1.Service {
 			Labels: map[string]string{
 				"app":                              appName,
 				"app.kubernetes.io/managed-by":     "chaldeploy",
-				"chaldeploy.captaingee.ch/chal":    HashString(config.ChallengeName),
+				"chaldeploy.captaingee.ch/chal":    HashString(spec.Name),
 				"chaldeploy.captaingee.ch/team-id": teamId,
 			},
 		},
 		Spec: corev1.ServiceSpec{
-			Ports: []corev1.ServicePort{
-				{Port: int32(config.ChallengePort), TargetPort: intstr.FromInt(config.ChallengePort), Protocol: corev1.ProtocolTCP},
-			},
+			Ports:    c.getServicePorts(spec),
 			Selector: selector.MatchLabels,
-			Type:     corev1.ServiceTypeLoadBalancer,
+			Type:     serviceType,
+		},
+	}
+}
+
+// get the network policy that isolates an instance namespace. it applies to every pod in the namespace,
+// denies all egress, and only allows ingress to the challenge's public ports
+func (c *Config) getNetworkPolicy(appName, teamId string, spec ChallengeSpec) *networkingv1.NetworkPolicy {
+	ports := []networkingv1.NetworkPolicyPort{}
+	for _, p := range c.getPublicPorts(spec) {
+		port := intstr.FromInt(p.ContainerPort)
+		protocol := corev1.Protocol(p.Protocol)
+		ports = append(ports, networkingv1.NetworkPolicyPort{Protocol: &protocol, Port: &port})
+	}
+
+	return &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: appName,
+			Labels: map[string]string{
+				"app":                              appName,
+				"app.kubernetes.io/managed-by":     "chaldeploy",
+				"chaldeploy.captaingee.ch/chal":    HashString(spec.Name),
+				"chaldeploy.captaingee.ch/team-id": teamId,
+			},
 		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress},
+			Ingress: []networkingv1.NetworkPolicyIngressRule{
+				{Ports: ports},
+			},
+		},
+	}
+}
+
+// Get the connection info for a challenge service, based on the type of the service.
+// Returns the hostname and port, along with whether or not an address has been assigned yet
+func (c *Config) getServiceCxnInfo(service *corev1.Service) (string, int, bool) {
+	switch service.Spec.Type {
+	case corev1.ServiceTypeNodePort:
+		// the port is allocated by the cluster, the address is whatever the nodes are reachable at
+		if len(service.Spec.Ports) > 0 && service.Spec.Ports[0].NodePort != 0 {
+			return c.NodeAddress, int(service.Spec.Ports[0].NodePort), true
+		}
+	case corev1.ServiceTypeLoadBalancer:
+		// need to wait for the cloud provider to assign an lb
+		if len(service.Status.LoadBalancer.Ingress) > 0 && len(service.Spec.Ports) > 0 {
+			if ingress := service.Status.LoadBalancer.Ingress[0]; ingress.IP != "" {
+				return ingress.IP, int(service.Spec.Ports[0].Port), true
+			} else if ingress.Hostname != "" {
+				return ingress.Hostname, int(service.Spec.Ports[0].Port), true
+			}
+		}
 	}
+
+	return "", 0, false
 }
 
+// where k8s mounts the service account for a pod
+var serviceAccountPath = "/var/run/secrets/kubernetes.io/serviceaccount"
+
 // Identify the proper source for the cluster config and load it
 // Load order:
 //   - $CHALDEPLOY_K8SCONFIG
 //   - /var/run/secrets/kubernetes.io/serviceaccount
 //   - ~/.kube/config current context
-func getConfigForCluster() (*rest.Config, error) {
+//
+// The client rate limits from the config are set on it
+func (c *Config) getConfigForCluster() (*rest.Config, error) {
+	k8sConfig, err := c.loadConfigForCluster()
+	if err != nil {
+		return nil, err
+	}
+
+	k8sConfig.QPS = float32(c.K8sQPS)
+	k8sConfig.Burst = c.K8sBurst
+
+	return k8sConfig, nil
+}
+
+// load the cluster config, see getConfigForCluster
+func (c *Config) loadConfigForCluster() (*rest.Config, error) {
 	// check if a path to the k8s config was specified
-	if config.K8sConfigPath != "" {
-		log.Printf("using k8s config path from env var: %s", config.K8sConfigPath)
+	if c.K8sConfigPath != "" {
+		log.Printf("using k8s config path from env var: %s", c.K8sConfigPath)
 
 		// check if it exists
-		if _, err := os.Stat(config.K8sConfigPath); os.IsExist(err) {
+		if _, err := os.Stat(c.K8sConfigPath); err == nil {
 			// file exists, try to use it
-			k8sConfig, err := clientcmd.BuildConfigFromFlags("", config.K8sConfigPath)
+			k8sConfig, err := clientcmd.BuildConfigFromFlags("", c.K8sConfigPath)
 			if err != nil {
 				return nil, err
 			} else {
@@ -575,7 +2478,7 @@ func getConfigForCluster() (*rest.Config, error) {
 		}
 	} else {
 		// no path was specified, try an injected service account
-		if _, err := os.Stat("/var/run/secrets/kubernetes.io/serviceaccount"); os.IsExist(err) {
+		if _, err := os.Stat(serviceAccountPath); err == nil {
 			log.Println("found a service account, using k8s config from it")
 
 			// ref: https://github.com/kubernetes/client-go/blob/master/examples/in-cluster-client-configuration/main.go#L41
@@ -1,12 +1,1515 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/captainGeech42/chaldeploy/internal/generic_map"
 	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
 )
 
 func TestImageName(t *testing.T) {
 	assert.Equal(t, "test-nc", getImageName("captaingeech/test-nc:latest"))
 	assert.Equal(t, "ubuntu", getImageName("library.docker.io/_/ubuntu:18.04"))
 }
+
+func TestServiceCxnInfo(t *testing.T) {
+	config = &Config{NodeAddress: "chals.example.com"}
+
+	// load balancer without an ingress yet
+	lb := &corev1.Service{Spec: corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer, Ports: []corev1.ServicePort{{Port: 31337}}}}
+	_, _, ok := config.getServiceCxnInfo(lb)
+	assert.False(t, ok)
+
+	// load balancer with an ingress
+	lb.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{IP: "1.2.3.4"}}
+	host, port, ok := config.getServiceCxnInfo(lb)
+	assert.True(t, ok)
+	assert.Equal(t, "1.2.3.4", host)
+	assert.Equal(t, 31337, port)
+
+	// node port with an allocated port
+	np := &corev1.Service{Spec: corev1.ServiceSpec{Type: corev1.ServiceTypeNodePort, Ports: []corev1.ServicePort{{Port: 31337, NodePort: 30123}}}}
+	host, port, ok = config.getServiceCxnInfo(np)
+	assert.True(t, ok)
+	assert.Equal(t, "chals.example.com", host)
+	assert.Equal(t, 30123, port)
+}
+
+func TestIsExpired(t *testing.T) {
+	now := time.Now().UTC()
+	past := now.Add(-time.Minute)
+	future := now.Add(time.Minute)
+
+	di := &DeploymentInstance{State: Running, ExpTime: &past, mu: &sync.Mutex{}}
+	assert.True(t, di.isExpired(now))
+
+	// a locked instance is busy, leave it for the next pass
+	di.Lock()
+	assert.False(t, di.isExpired(now))
+	di.Unlock()
+
+	di.State = Destroying
+	assert.False(t, di.isExpired(now))
+
+	di.State = Running
+	di.ExpTime = &future
+	assert.False(t, di.isExpired(now))
+}
+
+func TestSecondsRemaining(t *testing.T) {
+	now := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	exp := now.Add(90 * time.Second)
+
+	di := &DeploymentInstance{}
+	assert.Equal(t, 0, di.SecondsRemaining(now))
+	assert.Equal(t, "", di.GetExpiresAt())
+
+	di.ExpTime = &exp
+	assert.Equal(t, 90, di.SecondsRemaining(now))
+	assert.Equal(t, "2022-10-01T12:01:30Z", di.GetExpiresAt())
+
+	// clamped once it's expired
+	assert.Equal(t, 0, di.SecondsRemaining(now.Add(time.Hour)))
+}
+
+func TestChallengeSpecObjects(t *testing.T) {
+	config = &Config{ServiceType: "LoadBalancer", Replicas: 2}
+	spec := ChallengeSpec{Name: "my chal", Image: "captaingeech/test-nc:latest", Port: 31337}
+
+	ns := config.getNamespace("chaldeploy-test", "team-id", spec)
+	assert.Equal(t, HashString("my chal"), ns.Labels["chaldeploy.captaingee.ch/chal"])
+
+	deployment := config.getDeployment("chaldeploy-test", "team-id", spec, nil)
+	assert.Equal(t, int32(2), *deployment.Spec.Replicas)
+	container := deployment.Spec.Template.Spec.Containers[0]
+	assert.Equal(t, "test-nc", container.Name)
+	assert.Equal(t, "captaingeech/test-nc:latest", container.Image)
+	assert.Equal(t, int32(31337), container.Ports[0].ContainerPort)
+
+	service := config.getService("chaldeploy-test", "team-id", spec)
+	assert.Equal(t, int32(31337), service.Spec.Ports[0].Port)
+	assert.Equal(t, HashString("my chal"), service.Spec.Selector["chaldeploy.captaingee.ch/chal"])
+}
+
+func TestInstanceManagerConfig(t *testing.T) {
+	spec := ChallengeSpec{Name: "my chal", Image: "captaingeech/test-nc:latest", Port: 31337}
+	for _, replicas := range []int{1, 2, 3} {
+		c := &Config{ServiceType: "LoadBalancer", Replicas: replicas}
+		deployment := c.getDeployment("chaldeploy-test", "team-id", spec, nil)
+		assert.Equal(t, int32(replicas), *deployment.Spec.Replicas)
+	}
+
+	// an instance manager (and its instances) use its own config and clientset, not the globals
+	ctx := context.Background()
+	otherClientset := newTestInstanceManager()
+	other := im
+	otherConfig := *config
+	otherConfig.NamespacePrefix = "other"
+	other.Config = &otherConfig
+	clientset := newTestInstanceManager()
+
+	_, err := other.CreateDeployment(ctx, "team1", DefaultChallengeId)
+	assert.Nil(t, err)
+	di := other.GetDeploymentInstance("team1", DefaultChallengeId)
+	assert.Equal(t, Running, di.State)
+	assert.Equal(t, "other-"+HashString("my chal")+"-"+HashString("team1"), di.Namespace)
+	assert.Nil(t, im.GetDeploymentInstance("team1", DefaultChallengeId))
+
+	_, err = otherClientset.CoreV1().Namespaces().Get(ctx, di.Namespace, metav1.GetOptions{})
+	assert.Nil(t, err)
+	_, err = clientset.CoreV1().Namespaces().Get(ctx, di.Namespace, metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err))
+
+	assert.Nil(t, other.DestroyDeployment(ctx, "team1", DefaultChallengeId))
+	assert.Equal(t, Destroyed, di.State)
+	_, err = otherClientset.CoreV1().Namespaces().Get(ctx, di.Namespace, metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err))
+}
+
+func TestChallengeCommand(t *testing.T) {
+	config = &Config{}
+	spec := ChallengeSpec{Name: "my chal", Image: "captaingeech/test-nc:latest", Port: 31337}
+
+	// the image's entrypoint is used by default
+	container := config.getDeployment("chaldeploy-test", "team-id", spec, nil).Spec.Template.Spec.Containers[0]
+	assert.Nil(t, container.Command)
+	assert.Nil(t, container.Args)
+
+	spec.Command = []string{"/usr/bin/socat"}
+	spec.Args = []string{"tcp-listen:31337,fork,reuseaddr", "exec:/chal/run"}
+	container = config.getDeployment("chaldeploy-test", "team-id", spec, nil).Spec.Template.Spec.Containers[0]
+	assert.Equal(t, []string{"/usr/bin/socat"}, container.Command)
+	assert.Equal(t, []string{"tcp-listen:31337,fork,reuseaddr", "exec:/chal/run"}, container.Args)
+
+	// just the args
+	spec.Command = nil
+	container = config.getDeployment("chaldeploy-test", "team-id", spec, nil).Spec.Template.Spec.Containers[0]
+	assert.Nil(t, container.Command)
+	assert.Equal(t, []string{"tcp-listen:31337,fork,reuseaddr", "exec:/chal/run"}, container.Args)
+}
+
+func TestChallengeWorkingDir(t *testing.T) {
+	config = &Config{}
+	spec := ChallengeSpec{Name: "my chal", Image: "captaingeech/test-nc:latest", Port: 31337}
+
+	container := config.getDeployment("chaldeploy-test", "team-id", spec, nil).Spec.Template.Spec.Containers[0]
+	assert.Empty(t, container.WorkingDir)
+
+	spec.WorkingDir = "/chal"
+	container = config.getDeployment("chaldeploy-test", "team-id", spec, nil).Spec.Template.Spec.Containers[0]
+	assert.Equal(t, "/chal", container.WorkingDir)
+}
+
+func TestResourceRequirements(t *testing.T) {
+	config = &Config{CPULimit: "500m", MemoryLimit: "256Mi", CPURequest: "100m"}
+
+	reqs := config.getResourceRequirements()
+	assert.Equal(t, "500m", reqs.Limits.Cpu().String())
+	assert.Equal(t, "256Mi", reqs.Limits.Memory().String())
+	assert.Equal(t, "100m", reqs.Requests.Cpu().String())
+
+	// unset quantities are left out
+	_, ok := reqs.Requests[corev1.ResourceMemory]
+	assert.False(t, ok)
+}
+
+func TestEphemeralStorageRequirements(t *testing.T) {
+	config = &Config{CPULimit: "500m", CPURequest: "100m", EphemeralStorageLimit: "1Gi", EphemeralStorageRequest: "100Mi"}
+
+	reqs := config.getResourceRequirements()
+	assert.Equal(t, "1Gi", reqs.Limits.StorageEphemeral().String())
+	assert.Equal(t, "100Mi", reqs.Requests.StorageEphemeral().String())
+	assert.Nil(t, checkRequestsWithinLimits(reqs))
+
+	// the requests can't be more than the limits
+	config.EphemeralStorageRequest = "2Gi"
+	assert.NotNil(t, checkRequestsWithinLimits(config.getResourceRequirements()))
+
+	config.EphemeralStorageRequest = "100Mi"
+	config.CPURequest = "1"
+	assert.NotNil(t, checkRequestsWithinLimits(config.getResourceRequirements()))
+
+	// a request without a limit is fine
+	config = &Config{MemoryRequest: "64Mi"}
+	assert.Nil(t, checkRequestsWithinLimits(config.getResourceRequirements()))
+}
+
+func TestImagePullSecret(t *testing.T) {
+	config = &Config{ImagePullPolicy: "IfNotPresent"}
+	spec := ChallengeSpec{Name: "my chal", Image: "registry.example.com/test-nc:latest", Port: 31337}
+
+	deployment := config.getDeployment("chaldeploy-test", "team-id", spec, nil)
+	assert.Empty(t, deployment.Spec.Template.Spec.ImagePullSecrets)
+	assert.Equal(t, corev1.PullIfNotPresent, deployment.Spec.Template.Spec.Containers[0].ImagePullPolicy)
+
+	config.ImagePullSecret = "regcred"
+	deployment = config.getDeployment("chaldeploy-test", "team-id", spec, nil)
+	assert.Equal(t, []corev1.LocalObjectReference{{Name: "regcred"}}, deployment.Spec.Template.Spec.ImagePullSecrets)
+}
+
+func TestSecurityContext(t *testing.T) {
+	config = &Config{RunAsNonRoot: true}
+	spec := ChallengeSpec{Name: "my chal", Image: "captaingeech/test-nc:latest", Port: 31337}
+
+	sc := config.getDeployment("chaldeploy-test", "team-id", spec, nil).Spec.Template.Spec.Containers[0].SecurityContext
+	assert.True(t, *sc.RunAsNonRoot)
+	assert.False(t, *sc.AllowPrivilegeEscalation)
+	assert.False(t, *sc.ReadOnlyRootFilesystem)
+	assert.Equal(t, []corev1.Capability{"ALL"}, sc.Capabilities.Drop)
+
+	config.ReadOnlyRootFS = true
+	assert.True(t, *config.getSecurityContext(spec).ReadOnlyRootFilesystem)
+
+	// a challenge can replace the default
+	assert.Nil(t, json.Unmarshal([]byte(`{"capabilities": {"add": ["SYS_PTRACE"]}}`), &spec.SecurityContext))
+	sc = config.getSecurityContext(spec)
+	assert.Equal(t, []corev1.Capability{"SYS_PTRACE"}, sc.Capabilities.Add)
+	assert.Nil(t, sc.RunAsNonRoot)
+}
+
+func TestSeccompProfile(t *testing.T) {
+	config = &Config{SeccompProfile: "RuntimeDefault"}
+	spec := ChallengeSpec{Name: "my chal", Image: "captaingeech/test-nc:latest", Port: 31337}
+
+	profile := config.getDeployment("chaldeploy-test", "team-id", spec, nil).Spec.Template.Spec.SecurityContext.SeccompProfile
+	assert.Equal(t, corev1.SeccompProfileTypeRuntimeDefault, profile.Type)
+
+	// a challenge can override it
+	spec.SeccompProfile = "localhost/profiles/chal.json"
+	profile = config.getSeccompProfile(spec)
+	assert.Equal(t, corev1.SeccompProfileTypeLocalhost, profile.Type)
+	assert.Equal(t, "profiles/chal.json", *profile.LocalhostProfile)
+
+	profile, err := parseSeccompProfile("Unconfined")
+	assert.Nil(t, err)
+	assert.Equal(t, corev1.SeccompProfileTypeUnconfined, profile.Type)
+
+	for _, invalid := range []string{"", "runtime/default", "localhost/", "Localhost"} {
+		_, err := parseSeccompProfile(invalid)
+		assert.NotNil(t, err, invalid)
+	}
+}
+
+func TestDeploymentStrategy(t *testing.T) {
+	config = &Config{DeploymentStrategy: "RollingUpdate"}
+	spec := ChallengeSpec{Name: "my chal", Image: "captaingeech/test-nc:latest", Port: 31337}
+
+	strategy := config.getDeployment("chaldeploy-test", "team-id", spec, nil).Spec.Strategy
+	assert.Equal(t, appsv1.DeploymentStrategy{}, strategy)
+
+	config.DeploymentStrategy = "Recreate"
+	strategy = config.getDeployment("chaldeploy-test", "team-id", spec, nil).Spec.Strategy
+	assert.Equal(t, appsv1.RecreateDeploymentStrategyType, strategy.Type)
+
+	// a challenge can override it
+	spec.DeploymentStrategy = "RollingUpdate"
+	assert.Equal(t, appsv1.DeploymentStrategy{}, config.getDeploymentStrategy(spec))
+
+	assert.True(t, isValidDeploymentStrategy("Recreate"))
+	for _, invalid := range []string{"", "recreate", "OnDelete"} {
+		assert.False(t, isValidDeploymentStrategy(invalid), invalid)
+	}
+}
+
+func TestProbes(t *testing.T) {
+	config = &Config{}
+	spec := ChallengeSpec{Name: "my chal", Image: "captaingeech/test-nc:latest", Port: 31337}
+
+	// disabled by default
+	container := config.getDeployment("chaldeploy-test", "team-id", spec, nil).Spec.Template.Spec.Containers[0]
+	assert.Nil(t, container.ReadinessProbe)
+	assert.Nil(t, container.LivenessProbe)
+
+	config.ReadinessProbeTCP = true
+	config.LivenessProbeTCP = true
+	container = config.getDeployment("chaldeploy-test", "team-id", spec, nil).Spec.Template.Spec.Containers[0]
+	assert.Equal(t, 31337, container.ReadinessProbe.TCPSocket.Port.IntValue())
+	assert.Equal(t, 31337, container.LivenessProbe.TCPSocket.Port.IntValue())
+
+	// web challenges can use an http probe instead
+	spec.ProbeHttpPath = "/healthz"
+	probe := config.getReadinessProbe(spec)
+	assert.Nil(t, probe.TCPSocket)
+	assert.Equal(t, "/healthz", probe.HTTPGet.Path)
+	assert.Equal(t, 31337, probe.HTTPGet.Port.IntValue())
+}
+
+func TestReaperPassRecovers(t *testing.T) {
+	clientset := newTestInstanceManager()
+	ctx := context.Background()
+
+	_, err := im.CreateDeployment(ctx, "team", DefaultChallengeId)
+	assert.Nil(t, err)
+	past := time.Now().UTC().Add(-time.Minute)
+	im.GetDeploymentInstance("team", DefaultChallengeId).setExpTime(&past)
+
+	clientset.PrependReactor("delete", "namespaces", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		panic("boom")
+	})
+
+	// a panic on a worker goroutine is a failed destroy
+	err = im.ReapExpired(ctx)
+	assert.ErrorContains(t, err, "boom")
+
+	assert.NotPanics(t, im.reaperPass)
+}
+
+func TestDrain(t *testing.T) {
+	// nothing in flight
+	assert.Nil(t, (&InstanceManager{}).Drain(context.Background()))
+
+	// an operation that doesn't finish in time
+	im := &InstanceManager{}
+	assert.Nil(t, im.beginOperation())
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, im.Drain(ctx), context.DeadlineExceeded)
+
+	// nothing new is started once it's draining
+	assert.ErrorIs(t, im.beginOperation(), ErrShuttingDown)
+
+	// and one that does finish
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		im.inFlight.Done()
+	}()
+	assert.Nil(t, im.Drain(context.Background()))
+}
+
+func TestDrainRejectsOperations(t *testing.T) {
+	newTestInstanceManager()
+	ctx := context.Background()
+	_, err := im.CreateDeployment(ctx, "team1", DefaultChallengeId)
+	assert.Nil(t, err)
+
+	assert.Nil(t, im.Drain(ctx))
+	_, err = im.CreateDeployment(ctx, "team2", DefaultChallengeId)
+	assert.ErrorIs(t, err, ErrShuttingDown)
+	assert.ErrorIs(t, im.StartDeployment("team2", DefaultChallengeId), ErrShuttingDown)
+	_, err = im.ExtendDeployment(ctx, "team1", DefaultChallengeId)
+	assert.ErrorIs(t, err, ErrShuttingDown)
+	assert.ErrorIs(t, im.DestroyDeployment(ctx, "team1", DefaultChallengeId), ErrShuttingDown)
+	assert.Equal(t, Running, im.GetDeploymentInstance("team1", DefaultChallengeId).State)
+}
+
+func TestDryRun(t *testing.T) {
+	config = &Config{
+		DryRun:          true,
+		NamespacePrefix: "chaldeploy",
+		InstanceStore:   "namespace",
+		Locker:          "lease",
+		InstanceTTL:     time.Hour,
+		DeployTimeout:   time.Minute,
+		DestroyTimeout:  time.Minute,
+		Challenges:      map[string]ChallengeSpec{DefaultChallengeId: {Name: "my chal", Image: "captaingeech/test-nc:latest", Port: 31337}},
+	}
+
+	// doesn't need a cluster
+	im = &InstanceManager{Config: config}
+	assert.Nil(t, im.Init(context.Background()))
+	assert.Nil(t, im.Clientset)
+	assert.Equal(t, NoopLocker{}, im.Locker)
+
+	cxn, err := im.CreateDeployment(context.Background(), "team1", DefaultChallengeId)
+	assert.Nil(t, err)
+	assert.Equal(t, "localhost:31337", cxn)
+
+	di := im.GetDeploymentInstance("team1", DefaultChallengeId)
+	assert.Equal(t, Running, di.State)
+	assert.NotNil(t, di.ExpTime)
+
+	cxn, err = im.CreateDeployment(context.Background(), "team1", DefaultChallengeId)
+	assert.Nil(t, err)
+	assert.Equal(t, "localhost:31337", cxn)
+
+	assert.Nil(t, im.DestroyDeployment(context.Background(), "team1", DefaultChallengeId))
+	assert.Equal(t, Destroyed, di.State)
+}
+
+// Set up the globals with an instance manager that uses a fake clientset. Deployments are created with a
+// ready replica and load balancers with an address, so creates don't have to wait around. Deleting a
+// namespace deletes the deployments and services in it, like a real cluster
+func newTestInstanceManager(objects ...runtime.Object) *fake.Clientset {
+	config = &Config{
+		ServiceType:     "LoadBalancer",
+		NamespacePrefix: "chaldeploy",
+		InstanceStore:   "namespace",
+		Locker:          "none",
+		Replicas:        1,
+		InstanceTTL:     time.Hour,
+		DeployTimeout:   time.Minute,
+		DestroyTimeout:  time.Minute,
+		Challenges:      map[string]ChallengeSpec{DefaultChallengeId: {Name: "my chal", Image: "captaingeech/test-nc:latest", Port: 31337}},
+	}
+
+	clientset := fake.NewSimpleClientset(objects...)
+	clientset.PrependReactor("create", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		action.(k8stesting.CreateAction).GetObject().(*appsv1.Deployment).Status.ReadyReplicas = 1
+		return false, nil, nil
+	})
+	clientset.PrependReactor("create", "statefulsets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		action.(k8stesting.CreateAction).GetObject().(*appsv1.StatefulSet).Status.ReadyReplicas = 1
+		return false, nil, nil
+	})
+	clientset.PrependReactor("create", "services", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		service := action.(k8stesting.CreateAction).GetObject().(*corev1.Service)
+		service.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{IP: "1.2.3.4"}}
+		return false, nil, nil
+	})
+	clientset.PrependReactor("delete", "namespaces", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		namespace := action.(k8stesting.DeleteAction).GetName()
+		for _, gvk := range []schema.GroupVersionKind{
+			appsv1.SchemeGroupVersion.WithKind("Deployment"),
+			appsv1.SchemeGroupVersion.WithKind("StatefulSet"),
+			corev1.SchemeGroupVersion.WithKind("Service"),
+			corev1.SchemeGroupVersion.WithKind("PersistentVolumeClaim"),
+			corev1.SchemeGroupVersion.WithKind("Secret"),
+			corev1.SchemeGroupVersion.WithKind("ConfigMap"),
+			networkingv1.SchemeGroupVersion.WithKind("Ingress"),
+		} {
+			gvr, _ := meta.UnsafeGuessKindToResource(gvk)
+			list, err := clientset.Tracker().List(gvr, gvk, namespace)
+			if err != nil {
+				return true, nil, err
+			}
+			objects, _ := meta.ExtractList(list)
+			for _, obj := range objects {
+				accessor, _ := meta.Accessor(obj)
+				clientset.Tracker().Delete(gvr, namespace, accessor.GetName())
+			}
+		}
+		return false, nil, nil
+	})
+
+	im = &InstanceManager{
+		Config:    config,
+		Clientset: clientset,
+		Instances: new(generic_map.MapOf[InstanceKey, *DeploymentInstance]),
+		Store:     &NamespaceInstanceStore{Clientset: clientset},
+		Locker:    NoopLocker{},
+	}
+
+	return clientset
+}
+
+func TestCreateDeployment(t *testing.T) {
+	clientset := newTestInstanceManager()
+	ctx := context.Background()
+
+	cxn, err := im.CreateDeployment(ctx, "team-id", DefaultChallengeId)
+	assert.Nil(t, err)
+	assert.Equal(t, "1.2.3.4:31337", cxn)
+
+	di := im.GetDeploymentInstance("team-id", DefaultChallengeId)
+	assert.Equal(t, Running, di.State)
+	assert.Equal(t, "chaldeploy-"+HashString("my chal")+"-"+HashString("team-id"), di.Namespace)
+
+	// the namespace is labelled, and has the expiration time on it
+	ns, err := clientset.CoreV1().Namespaces().Get(ctx, di.Namespace, metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, "team-id", ns.Labels["chaldeploy.captaingee.ch/team-id"])
+	assert.Equal(t, di.ExpTime.Format(time.RFC3339), ns.Annotations[expiresAtAnnotation])
+
+	// the deployment and service are in the instance namespace
+	deployment, err := clientset.AppsV1().Deployments(di.Namespace).Get(ctx, di.AppName, metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, "captaingeech/test-nc:latest", deployment.Spec.Template.Spec.Containers[0].Image)
+	assert.Equal(t, int32(1), *deployment.Spec.Replicas)
+
+	service, err := clientset.CoreV1().Services(di.Namespace).Get(ctx, di.AppName, metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, corev1.ServiceTypeLoadBalancer, service.Spec.Type)
+	assert.Equal(t, int32(31337), service.Spec.Ports[0].Port)
+
+	// no network policy unless it's enabled
+	policies, err := clientset.NetworkingV1().NetworkPolicies(di.Namespace).List(ctx, metav1.ListOptions{})
+	assert.Nil(t, err)
+	assert.Empty(t, policies.Items)
+}
+
+func TestCreateDeploymentEnv(t *testing.T) {
+	clientset := newTestInstanceManager()
+	config.ChallengeEnv = map[string]string{"TEAM_ID": "{{.TeamID}}"}
+	config.ChallengeSecretEnv = map[string]string{"SECRET": "hunter2-{{.ChallengeID}}"}
+	config.FlagTemplate = "flag{team_%s}"
+	config.FlagEnv = "FLAG"
+	ctx := context.Background()
+
+	_, err := im.CreateDeployment(ctx, "team-id", DefaultChallengeId)
+	assert.Nil(t, err)
+	di := im.GetDeploymentInstance("team-id", DefaultChallengeId)
+
+	deployment, err := clientset.AppsV1().Deployments(di.Namespace).Get(ctx, di.AppName, metav1.GetOptions{})
+	assert.Nil(t, err)
+	env := deployment.Spec.Template.Spec.Containers[0].Env
+	assert.Len(t, env, 3)
+	assert.Equal(t, "FLAG", env[0].Name)
+	assert.Empty(t, env[0].Value)
+	assert.Equal(t, "SECRET", env[1].Name)
+	assert.Empty(t, env[1].Value)
+	assert.Equal(t, corev1.EnvVar{Name: "TEAM_ID", Value: "team-id"}, env[2])
+
+	// the secret values are only in the secret
+	secret, err := clientset.CoreV1().Secrets(di.Namespace).Get(ctx, getEnvSecretName(di.AppName), metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, "hunter2-default", secret.StringData["SECRET"])
+	assert.Equal(t, "flag{team_team-id}", secret.StringData["FLAG"])
+}
+
+func TestChallengeFiles(t *testing.T) {
+	clientset := newTestInstanceManager()
+	config.ChallengeFiles = map[string]string{"team.txt": "{{.TeamID}}"}
+	config.ChallengeMountPath = "/challenge"
+	ctx := context.Background()
+
+	_, err := im.CreateDeployment(ctx, "team-id", DefaultChallengeId)
+	assert.Nil(t, err)
+	di := im.GetDeploymentInstance("team-id", DefaultChallengeId)
+
+	configMap, err := clientset.CoreV1().ConfigMaps(di.Namespace).Get(ctx, getFilesConfigMapName(di.AppName), metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"team.txt": "team-id"}, configMap.Data)
+
+	deployment, err := clientset.AppsV1().Deployments(di.Namespace).Get(ctx, di.AppName, metav1.GetOptions{})
+	assert.Nil(t, err)
+	podSpec := deployment.Spec.Template.Spec
+	assert.Equal(t, []corev1.Volume{getFilesVolume(di.AppName)}, podSpec.Volumes)
+	assert.Equal(t, []corev1.VolumeMount{{Name: filesVolumeName, MountPath: "/challenge", ReadOnly: true}}, podSpec.Containers[0].VolumeMounts)
+
+	// torn down with the namespace
+	assert.Nil(t, im.DestroyDeployment(ctx, "team-id", DefaultChallengeId))
+	_, err = clientset.CoreV1().ConfigMaps(di.Namespace).Get(ctx, getFilesConfigMapName(di.AppName), metav1.GetOptions{})
+	assert.NotNil(t, err)
+}
+
+func TestCreateDeploymentFailure(t *testing.T) {
+	clientset := newTestInstanceManager()
+	fail := true
+	clientset.PrependReactor("create", "services", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return fail, nil, errors.New("asdf")
+	})
+	ctx := context.Background()
+	countNamespaces := func() int {
+		namespaces, err := clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+		assert.Nil(t, err)
+		return len(namespaces.Items)
+	}
+
+	_, err := im.CreateDeployment(ctx, "team-id", DefaultChallengeId)
+	assert.NotNil(t, err)
+
+	// the namespace is kept, and the instance has to be cleaned up before it can be created again
+	assert.Equal(t, 1, countNamespaces())
+	di := im.GetDeploymentInstance("team-id", DefaultChallengeId)
+	assert.Equal(t, Failed, di.State)
+	assert.NotNil(t, di.FailedAt)
+	assert.NotEmpty(t, di.DeployError)
+	_, err = im.CreateDeployment(ctx, "team-id", DefaultChallengeId)
+	assert.ErrorIs(t, err, ErrFailed)
+
+	// destroying it doesn't start the cooldown
+	config.RedeployCooldown = time.Hour
+	assert.Nil(t, im.DestroyDeployment(ctx, "team-id", DefaultChallengeId))
+	assert.Equal(t, 0, countNamespaces())
+	assert.Equal(t, Destroyed, di.State)
+	assert.Nil(t, di.FailedAt)
+	assert.Nil(t, di.LastDestroyed)
+
+	// the failure is cleared once it's deployed again
+	fail = false
+	_, err = im.CreateDeployment(ctx, "team-id", DefaultChallengeId)
+	assert.Nil(t, err)
+	assert.Equal(t, Running, di.State)
+	assert.Empty(t, di.DeployError)
+}
+
+func TestCreateDeploymentFailureState(t *testing.T) {
+	clientset := newTestInstanceManager()
+	ctx := context.Background()
+
+	// the namespace update for the failed marker happens after the deploy failed, check the state then
+	var states []InstanceState
+	clientset.PrependReactor("create", "services", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("asdf")
+	})
+	clientset.PrependReactor("update", "namespaces", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		states = append(states, im.GetDeploymentInstance("team-id", DefaultChallengeId).getState())
+		return false, nil, nil
+	})
+
+	_, err := im.CreateDeployment(ctx, "team-id", DefaultChallengeId)
+	assert.NotNil(t, err)
+	assert.NotEmpty(t, states)
+	for _, s := range states {
+		assert.Equal(t, Deploying, s)
+	}
+	assert.Equal(t, Failed, im.GetDeploymentInstance("team-id", DefaultChallengeId).getState())
+}
+
+func TestFailedInstancesCount(t *testing.T) {
+	clientset := newTestInstanceManager()
+	clientset.PrependReactor("create", "services", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("asdf")
+	})
+	config.MaxInstancesPerTeam = 1
+	config.Challenges["web"] = ChallengeSpec{Name: "web chal", Image: "captaingeech/test-web:latest", Port: 8080}
+	ctx := context.Background()
+
+	// a failed instance still has its namespace, so it counts against the caps
+	_, err := im.CreateDeployment(ctx, "team-id", DefaultChallengeId)
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, im.countActiveInstances())
+	assert.Equal(t, 1, im.countTeamInstances("team-id"))
+
+	var teamLimitErr *TeamLimitError
+	_, err = im.CreateDeployment(ctx, "team-id", "web")
+	assert.ErrorAs(t, err, &teamLimitErr)
+
+	config.MaxConcurrentInstances = 1
+	_, err = im.CreateDeployment(ctx, "other-team", DefaultChallengeId)
+	assert.ErrorIs(t, err, ErrCapacityReached)
+}
+
+func TestDiscoverFailedInstance(t *testing.T) {
+	clientset := newTestInstanceManager()
+	clientset.PrependReactor("create", "services", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("asdf")
+	})
+	ctx := context.Background()
+
+	_, err := im.CreateDeployment(ctx, "team-id", DefaultChallengeId)
+	assert.NotNil(t, err)
+	failed := im.GetDeploymentInstance("team-id", DefaultChallengeId)
+	ns, err := clientset.CoreV1().Namespaces().Get(ctx, failed.Namespace, metav1.GetOptions{})
+	assert.Nil(t, err)
+
+	// after a restart, the instance is still failed, with the same error
+	newTestInstanceManager(ns)
+	assert.Nil(t, im.discoverExistingInstances(ctx))
+	di := im.GetDeploymentInstance("team-id", DefaultChallengeId)
+	assert.Equal(t, Failed, di.State)
+	assert.Equal(t, failed.DeployError, di.DeployError)
+	assert.Equal(t, failed.FailedAt.Unix(), di.FailedAt.Unix())
+}
+
+func TestCreateDeploymentFailureBeforeNamespace(t *testing.T) {
+	clientset := newTestInstanceManager()
+	clientset.PrependReactor("create", "namespaces", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("asdf")
+	})
+	ctx := context.Background()
+
+	// there's nothing to clean up, so it can be created again right away
+	_, err := im.CreateDeployment(ctx, "team-id", DefaultChallengeId)
+	assert.NotNil(t, err)
+	di := im.GetDeploymentInstance("team-id", DefaultChallengeId)
+	assert.Equal(t, Destroyed, di.State)
+	assert.NotEmpty(t, di.DeployError)
+}
+
+func TestReapFailed(t *testing.T) {
+	clientset := newTestInstanceManager()
+	config.FailedInstanceGracePeriod = 10 * time.Minute
+	fail := true
+	clientset.PrependReactor("create", "services", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return fail, nil, errors.New("asdf")
+	})
+	ctx := context.Background()
+
+	_, err := im.CreateDeployment(ctx, "team-id", DefaultChallengeId)
+	assert.NotNil(t, err)
+	di := im.GetDeploymentInstance("team-id", DefaultChallengeId)
+
+	// kept for the grace period
+	assert.Nil(t, im.ReapFailed(ctx, time.Now().UTC().Add(5*time.Minute)))
+	assert.Equal(t, Failed, di.State)
+
+	assert.Nil(t, im.ReapFailed(ctx, time.Now().UTC().Add(15*time.Minute)))
+	assert.Equal(t, Destroyed, di.State)
+	namespaces, err := clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	assert.Nil(t, err)
+	assert.Empty(t, namespaces.Items)
+
+	// an instance that was cleaned up and deployed again isn't touched
+	fail = false
+	_, err = im.CreateDeployment(ctx, "team-id", DefaultChallengeId)
+	assert.Nil(t, err)
+	assert.Nil(t, im.ReapFailed(ctx, time.Now().UTC().Add(15*time.Minute)))
+	assert.Equal(t, Running, di.State)
+}
+
+func TestPruneDestroyed(t *testing.T) {
+	newTestInstanceManager()
+	config.RedeployCooldown = 30 * time.Minute
+	ctx := context.Background()
+
+	_, err := im.CreateDeployment(ctx, "team1", DefaultChallengeId)
+	assert.Nil(t, err)
+	assert.Nil(t, im.DestroyDeployment(ctx, "team1", DefaultChallengeId))
+	_, err = im.CreateDeployment(ctx, "team2", DefaultChallengeId)
+	assert.Nil(t, err)
+
+	// an instance that was never deployed (here, because of the team cap) has nothing worth keeping
+	config.MaxInstancesPerTeam = 1
+	config.Challenges["web"] = ChallengeSpec{Name: "web chal", Image: "captaingeech/test-web:latest", Port: 8080}
+	_, err = im.CreateDeployment(ctx, "team2", "web")
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, im.PruneDestroyed(time.Now().UTC()))
+	assert.Nil(t, im.GetDeploymentInstance("team2", "web"))
+
+	// the destroyed instance is kept for its cooldown, and the running one isn't touched
+	assert.Equal(t, 0, im.PruneDestroyed(time.Now().UTC().Add(20*time.Minute)))
+	assert.NotNil(t, im.GetDeploymentInstance("team1", DefaultChallengeId))
+
+	assert.Equal(t, 1, im.PruneDestroyed(time.Now().UTC().Add(40*time.Minute)))
+	assert.Nil(t, im.GetDeploymentInstance("team1", DefaultChallengeId))
+	assert.Equal(t, Running, im.GetDeploymentInstance("team2", DefaultChallengeId).State)
+
+	// the team can deploy again after its instance was removed
+	_, err = im.CreateDeployment(ctx, "team1", DefaultChallengeId)
+	assert.Nil(t, err)
+	assert.Equal(t, Running, im.GetDeploymentInstance("team1", DefaultChallengeId).State)
+}
+
+func TestPruneDestroyedRetention(t *testing.T) {
+	clientset := newTestInstanceManager()
+	clientset.PrependReactor("create", "namespaces", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("asdf")
+	})
+	ctx := context.Background()
+
+	// without a cooldown, a failed deploy is still kept for a while so the team can see what happened
+	_, err := im.CreateDeployment(ctx, "team1", DefaultChallengeId)
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, im.PruneDestroyed(time.Now().UTC().Add(time.Minute)))
+	assert.NotEmpty(t, im.GetDeploymentInstance("team1", DefaultChallengeId).DeployError)
+
+	assert.Equal(t, 1, im.PruneDestroyed(time.Now().UTC().Add(destroyedRetention+time.Minute)))
+	assert.Nil(t, im.GetDeploymentInstance("team1", DefaultChallengeId))
+}
+
+// pruning while the team is creating and destroying its instance never loses track of a deployed instance. a create
+// that loaded the instance just before it was pruned has to start over, instead of deploying an instance that isn't in the map
+func TestPruneDestroyedConcurrentCreate(t *testing.T) {
+	clientset := newTestInstanceManager()
+	oldRetention := destroyedRetention
+	destroyedRetention = 0
+	t.Cleanup(func() { destroyedRetention = oldRetention })
+	ctx := context.Background()
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				im.PruneDestroyed(time.Now().UTC())
+			}
+		}
+	}()
+
+	// the pruner can have the instance locked while it's checked, which makes a create/destroy busy
+	for i := 0; i < 50; i++ {
+		if _, err := im.CreateDeployment(ctx, "team1", DefaultChallengeId); err != nil {
+			assert.ErrorIs(t, err, ErrBusy)
+			continue
+		}
+		if err := im.DestroyDeployment(ctx, "team1", DefaultChallengeId); err != nil {
+			assert.ErrorIs(t, err, ErrBusy)
+		}
+	}
+	// the pruner can have the instance locked for a moment
+	_, err := im.CreateDeployment(ctx, "team1", DefaultChallengeId)
+	for errors.Is(err, ErrBusy) {
+		_, err = im.CreateDeployment(ctx, "team1", DefaultChallengeId)
+	}
+	close(done)
+	wg.Wait()
+	assert.Nil(t, err)
+
+	// the only namespace left is the one for the instance in the map
+	namespaces, err := clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	assert.Nil(t, err)
+	assert.Len(t, namespaces.Items, 1)
+	di := im.GetDeploymentInstance("team1", DefaultChallengeId)
+	assert.Equal(t, Running, di.State)
+	assert.Equal(t, namespaces.Items[0].Name, di.Namespace)
+}
+
+func TestCreateInstanceObjectsFailure(t *testing.T) {
+	// the deployment, service, and ingress are created at the same time, so any of them failing fails the instance
+	for _, resource := range []string{"deployments", "services", "ingresses"} {
+		clientset := newTestInstanceManager()
+		config.IngressEnabled = true
+		config.BaseDomain = "chals.example.com"
+		clientset.PrependReactor("create", resource, func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, errors.New("asdf")
+		})
+		ctx := context.Background()
+
+		_, err := im.CreateDeployment(ctx, "team-id", DefaultChallengeId)
+		assert.ErrorContains(t, err, "asdf", resource)
+
+		namespaces, err := clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+		assert.Nil(t, err)
+		assert.Len(t, namespaces.Items, 1, resource)
+		assert.Equal(t, Failed, im.GetDeploymentInstance("team-id", DefaultChallengeId).State, resource)
+	}
+}
+
+func TestStateTransitions(t *testing.T) {
+	clientset := newTestInstanceManager()
+	ctx := context.Background()
+
+	countNamespaces := func() int {
+		namespaces, err := clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+		assert.Nil(t, err)
+		return len(namespaces.Items)
+	}
+
+	// Destroyed -> Running
+	_, err := im.CreateDeployment(ctx, "team-id", DefaultChallengeId)
+	assert.Nil(t, err)
+	di := im.GetDeploymentInstance("team-id", DefaultChallengeId)
+	assert.Equal(t, Running, di.State)
+	assert.Equal(t, 1, countNamespaces())
+
+	// creating again is idempotent, it gives back the running instance without touching it
+	cxn, err := im.CreateDeployment(ctx, "team-id", DefaultChallengeId)
+	assert.Nil(t, err)
+	assert.Equal(t, "1.2.3.4:31337", cxn)
+	assert.Equal(t, Running, di.State)
+	assert.Equal(t, 1, countNamespaces())
+
+	// can't create or destroy while the instance is being destroyed
+	di.State = Destroying
+	_, err = im.CreateDeployment(ctx, "team-id", DefaultChallengeId)
+	assert.ErrorIs(t, err, ErrBusy)
+	assert.Nil(t, im.DestroyDeployment(ctx, "team-id", DefaultChallengeId))
+	assert.Equal(t, Destroying, di.State)
+	assert.Equal(t, 1, countNamespaces())
+	di.State = Running
+
+	// Running -> Destroyed
+	assert.Nil(t, im.DestroyDeployment(ctx, "team-id", DefaultChallengeId))
+	assert.Equal(t, Destroyed, di.State)
+	assert.Equal(t, 0, countNamespaces())
+
+	// destroying again is a noop
+	assert.Nil(t, im.DestroyDeployment(ctx, "team-id", DefaultChallengeId))
+	assert.Equal(t, Destroyed, di.State)
+
+	// Destroyed -> Running again, reusing the same instance
+	_, err = im.CreateDeployment(ctx, "team-id", DefaultChallengeId)
+	assert.Nil(t, err)
+	assert.Equal(t, Running, di.State)
+	assert.Same(t, di, im.GetDeploymentInstance("team-id", DefaultChallengeId))
+	assert.Equal(t, 1, countNamespaces())
+}
+
+func TestMaxExtensions(t *testing.T) {
+	newTestInstanceManager()
+	config.ExtendDuration = time.Hour
+	config.MaxExtensions = 2
+	ctx := context.Background()
+
+	_, err := im.CreateDeployment(ctx, "team-id", DefaultChallengeId)
+	assert.Nil(t, err)
+
+	for i := 0; i < 2; i++ {
+		_, err = im.ExtendDeployment(ctx, "team-id", DefaultChallengeId)
+		assert.Nil(t, err)
+	}
+
+	di := im.GetDeploymentInstance("team-id", DefaultChallengeId)
+	expTime := *di.ExpTime
+	_, err = im.ExtendDeployment(ctx, "team-id", DefaultChallengeId)
+	assert.ErrorIs(t, err, ErrMaxExtensions)
+	assert.Equal(t, expTime, *di.ExpTime)
+	assert.Equal(t, 2, di.Extensions)
+
+	// recreating the instance resets the count
+	assert.Nil(t, im.DestroyDeployment(ctx, "team-id", DefaultChallengeId))
+	_, err = im.CreateDeployment(ctx, "team-id", DefaultChallengeId)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, di.Extensions)
+	_, err = im.ExtendDeployment(ctx, "team-id", DefaultChallengeId)
+	assert.Nil(t, err)
+}
+
+func TestConcurrentCreateDestroy(t *testing.T) {
+	clientset := newTestInstanceManager()
+	ctx := context.Background()
+
+	// count namespace creates, and any that happen while the namespace still exists (e.g., mid-destroy)
+	var creates, duplicates int32
+	clientset.PrependReactor("create", "namespaces", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		atomic.AddInt32(&creates, 1)
+		ns := action.(k8stesting.CreateAction).GetObject().(*corev1.Namespace)
+		if _, err := clientset.Tracker().Get(corev1.SchemeGroupVersion.WithResource("namespaces"), "", ns.Name); err == nil {
+			atomic.AddInt32(&duplicates, 1)
+		}
+		return false, nil, nil
+	})
+
+	var wg sync.WaitGroup
+	var successfulCreates int32
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i%3 == 2 {
+				im.DestroyDeployment(ctx, "team-id", DefaultChallengeId)
+			} else if _, err := im.CreateDeployment(ctx, "team-id", DefaultChallengeId); err == nil {
+				atomic.AddInt32(&successfulCreates, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Zero(t, atomic.LoadInt32(&duplicates))
+	assert.Equal(t, atomic.LoadInt32(&creates), atomic.LoadInt32(&successfulCreates))
+	assert.NotZero(t, atomic.LoadInt32(&creates))
+
+	// only one instance was ever stored, and the cluster matches its final state (no orphaned namespace)
+	di := im.GetDeploymentInstance("team-id", DefaultChallengeId)
+	namespaces, err := clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	assert.Nil(t, err)
+	if di.State == Running {
+		assert.Len(t, namespaces.Items, 1)
+	} else {
+		assert.Equal(t, Destroyed, di.State)
+		assert.Empty(t, namespaces.Items)
+	}
+}
+
+func TestGetInstanceLogs(t *testing.T) {
+	clientset := newTestInstanceManager()
+	ctx := context.Background()
+
+	// no instance
+	_, err := im.GetInstanceLogs(ctx, "team-id", DefaultChallengeId, 100)
+	assert.ErrorIs(t, err, ErrNoInstance)
+
+	// instance without a pod yet
+	_, err = im.CreateDeployment(ctx, "team-id", DefaultChallengeId)
+	assert.Nil(t, err)
+	_, err = im.GetInstanceLogs(ctx, "team-id", DefaultChallengeId, 100)
+	assert.ErrorIs(t, err, ErrNoInstance)
+
+	// the fake clientset always returns the same logs
+	di := im.GetDeploymentInstance("team-id", DefaultChallengeId)
+	_, err = clientset.CoreV1().Pods(di.Namespace).Create(ctx, &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:   di.AppName + "-asdf",
+		Labels: getSelector(di.AppName, "team-id", di.Challenge).MatchLabels,
+	}}, metav1.CreateOptions{})
+	assert.Nil(t, err)
+
+	logs, err := im.GetInstanceLogs(ctx, "team-id", DefaultChallengeId, 100)
+	assert.Nil(t, err)
+	assert.Equal(t, "fake logs", logs)
+
+	// the logs are cut off at the size limit
+	defer func(limit int64) { maxLogBytes = limit }(maxLogBytes)
+	maxLogBytes = 4
+	logs, err = im.GetInstanceLogs(ctx, "team-id", DefaultChallengeId, 100)
+	assert.Nil(t, err)
+	assert.Equal(t, "fake", logs)
+
+	// another team's instance isn't visible
+	_, err = im.GetInstanceLogs(ctx, "other-team", DefaultChallengeId, 100)
+	assert.ErrorIs(t, err, ErrNoInstance)
+}
+
+func TestRedeployCooldown(t *testing.T) {
+	newTestInstanceManager()
+	config.RedeployCooldown = time.Minute
+	ctx := context.Background()
+
+	_, err := im.CreateDeployment(ctx, "team-id", DefaultChallengeId)
+	assert.Nil(t, err)
+	assert.Nil(t, im.DestroyDeployment(ctx, "team-id", DefaultChallengeId))
+
+	// too soon
+	_, err = im.CreateDeployment(ctx, "team-id", DefaultChallengeId)
+	var cooldownErr *CooldownError
+	assert.ErrorAs(t, err, &cooldownErr)
+	assert.Greater(t, cooldownErr.Remaining, 59*time.Second)
+
+	// once the cooldown is over
+	di := im.GetDeploymentInstance("team-id", DefaultChallengeId)
+	lastDestroyed := time.Now().Add(-2 * time.Minute)
+	di.LastDestroyed = &lastDestroyed
+	_, err = im.CreateDeployment(ctx, "team-id", DefaultChallengeId)
+	assert.Nil(t, err)
+
+	// no cooldown by default
+	config.RedeployCooldown = 0
+	assert.Nil(t, im.DestroyDeployment(ctx, "team-id", DefaultChallengeId))
+	_, err = im.CreateDeployment(ctx, "team-id", DefaultChallengeId)
+	assert.Nil(t, err)
+}
+
+func TestNetworkPolicy(t *testing.T) {
+	spec := ChallengeSpec{Name: "my chal", Image: "captaingeech/test-nc:latest", Port: 31337}
+
+	policy := config.getNetworkPolicy("chaldeploy-test", "team-id", spec)
+
+	// applies to every pod in the namespace, and denies all egress
+	assert.Empty(t, policy.Spec.PodSelector.MatchLabels)
+	assert.ElementsMatch(t, []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress}, policy.Spec.PolicyTypes)
+	assert.Empty(t, policy.Spec.Egress)
+
+	// only the challenge port is open
+	assert.Len(t, policy.Spec.Ingress, 1)
+	assert.Empty(t, policy.Spec.Ingress[0].From)
+	assert.Len(t, policy.Spec.Ingress[0].Ports, 1)
+	assert.Equal(t, 31337, policy.Spec.Ingress[0].Ports[0].Port.IntValue())
+	assert.Equal(t, corev1.ProtocolTCP, *policy.Spec.Ingress[0].Ports[0].Protocol)
+}
+
+func TestIngress(t *testing.T) {
+	tlsSecret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "wildcard-tls", Namespace: "default"}, Type: corev1.SecretTypeTLS}
+	clientset := newTestInstanceManager(tlsSecret)
+	config.IngressEnabled = true
+	config.BaseDomain = "chals.example.com"
+	config.IngressClass = "nginx"
+	config.IngressTLSSecret = "wildcard-tls"
+	config.IngressTLSSecretNamespace = "default"
+	ctx := context.Background()
+
+	cxn, err := im.CreateDeployment(ctx, "team-id", DefaultChallengeId)
+	assert.Nil(t, err)
+	di := im.GetDeploymentInstance("team-id", DefaultChallengeId)
+	host := config.getIngressHost(di.AppName)
+	assert.Regexp(t, `^[0-9a-f]{16}\.chals\.example\.com$`, host)
+	assert.Equal(t, "https://"+host, cxn)
+	assert.Equal(t, cxn, di.GetCxn())
+
+	// round trips through the clientset
+	ingress, err := clientset.NetworkingV1().Ingresses(di.Namespace).Get(ctx, di.AppName, metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, "nginx", *ingress.Spec.IngressClassName)
+	assert.Equal(t, []networkingv1.IngressTLS{{Hosts: []string{host}, SecretName: "wildcard-tls"}}, ingress.Spec.TLS)
+	assert.Len(t, ingress.Spec.Rules, 1)
+	assert.Equal(t, host, ingress.Spec.Rules[0].Host)
+	backend := ingress.Spec.Rules[0].HTTP.Paths[0].Backend.Service
+	assert.Equal(t, di.AppName, backend.Name)
+	assert.Equal(t, int32(31337), backend.Port.Number)
+
+	// the service isn't exposed outside of the cluster, and the tls secret is copied over
+	service, err := clientset.CoreV1().Services(di.Namespace).Get(ctx, di.AppName, metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, corev1.ServiceTypeClusterIP, service.Spec.Type)
+	_, err = clientset.CoreV1().Secrets(di.Namespace).Get(ctx, "wildcard-tls", metav1.GetOptions{})
+	assert.Nil(t, err)
+
+	// torn down with the namespace
+	assert.Nil(t, im.DestroyDeployment(ctx, "team-id", DefaultChallengeId))
+	_, err = clientset.NetworkingV1().Ingresses(di.Namespace).Get(ctx, di.AppName, metav1.GetOptions{})
+	assert.NotNil(t, err)
+
+	// http without a tls secret
+	config.IngressTLSSecret = ""
+	assert.Equal(t, "http://"+host, config.getIngressURL(host))
+	assert.Empty(t, config.getIngress(di.AppName, "team-id", di.Challenge).Spec.TLS)
+}
+
+func TestIngressCertManager(t *testing.T) {
+	clientset := newTestInstanceManager()
+	config.IngressEnabled = true
+	config.BaseDomain = "chals.example.com"
+	config.TLSEnabled = true
+	config.CertIssuer = "letsencrypt-prod"
+	config.CertIssuerAnnotation = "cert-manager.io/cluster-issuer"
+	ctx := context.Background()
+
+	cxn, err := im.CreateDeployment(ctx, "team-id", DefaultChallengeId)
+	assert.Nil(t, err)
+	di := im.GetDeploymentInstance("team-id", DefaultChallengeId)
+	host := config.getIngressHost(di.AppName)
+	assert.Equal(t, "https://"+host, cxn)
+
+	ingress, err := clientset.NetworkingV1().Ingresses(di.Namespace).Get(ctx, di.AppName, metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"cert-manager.io/cluster-issuer": "letsencrypt-prod"}, ingress.Annotations)
+	assert.Equal(t, []networkingv1.IngressTLS{{Hosts: []string{host}, SecretName: di.AppName + "-tls"}}, ingress.Spec.TLS)
+
+	// the annotation key is configurable
+	config.CertIssuerAnnotation = "cert-manager.io/issuer"
+	assert.Equal(t, map[string]string{"cert-manager.io/issuer": "letsencrypt-prod"}, config.getIngress(di.AppName, "team-id", di.Challenge).Annotations)
+}
+
+// minimal kubeconfig for testing the cluster config load order
+const testKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- cluster:
+    server: https://1.2.3.4:6443
+  name: test
+contexts:
+- context:
+    cluster: test
+    user: test
+  name: test
+current-context: test
+users:
+- name: test
+  user:
+    token: asdf
+`
+
+func TestClusterConfigFromPath(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kubeconfig")
+	assert.Nil(t, os.WriteFile(path, []byte(testKubeconfig), 0600))
+
+	config = &Config{K8sConfigPath: path, K8sQPS: 50, K8sBurst: 100}
+	k8sConfig, err := config.getConfigForCluster()
+	assert.Nil(t, err)
+	assert.Equal(t, "https://1.2.3.4:6443", k8sConfig.Host)
+
+	// the client rate limits are applied
+	assert.Equal(t, float32(50), k8sConfig.QPS)
+	assert.Equal(t, 100, k8sConfig.Burst)
+
+	// a path that doesn't exist shouldn't fall back to anything else
+	config = &Config{K8sConfigPath: filepath.Join(t.TempDir(), "nope")}
+	_, err = config.getConfigForCluster()
+	assert.NotNil(t, err)
+}
+
+func TestClusterConfigFromHomeDir(t *testing.T) {
+	oldServiceAccountPath := serviceAccountPath
+	serviceAccountPath = filepath.Join(t.TempDir(), "serviceaccount")
+	defer func() { serviceAccountPath = oldServiceAccountPath }()
+
+	config = &Config{}
+
+	// nothing to load
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	_, err := config.getConfigForCluster()
+	assert.NotNil(t, err)
+
+	// ~/.kube/config exists
+	assert.Nil(t, os.MkdirAll(filepath.Join(home, ".kube"), 0700))
+	assert.Nil(t, os.WriteFile(filepath.Join(home, ".kube", "config"), []byte(testKubeconfig), 0600))
+	k8sConfig, err := config.getConfigForCluster()
+	assert.Nil(t, err)
+	assert.Equal(t, "https://1.2.3.4:6443", k8sConfig.Host)
+}
+
+func TestReserveCapacity(t *testing.T) {
+	im := &InstanceManager{Instances: new(generic_map.MapOf[InstanceKey, *DeploymentInstance])}
+	im.Instances.Store(InstanceKey{TeamId: "team1"}, &DeploymentInstance{State: Running})
+	im.Instances.Store(InstanceKey{TeamId: "team2"}, &DeploymentInstance{State: Destroying})
+	im.Instances.Store(InstanceKey{TeamId: "team3"}, &DeploymentInstance{State: Destroyed})
+	assert.Equal(t, 2, im.countActiveInstances())
+
+	// no cap
+	im.Config = &Config{}
+	assert.Nil(t, im.reserveCapacity())
+	im.releaseCapacity()
+	assert.Equal(t, 0, im.pendingCreates)
+
+	// room for one more, and pending creates count against the cap
+	im.Config = &Config{MaxConcurrentInstances: 3}
+	assert.Nil(t, im.reserveCapacity())
+	assert.ErrorIs(t, im.reserveCapacity(), ErrCapacityReached)
+
+	// once the pending create is done, there's room again
+	im.releaseCapacity()
+	assert.Nil(t, im.reserveCapacity())
+	im.releaseCapacity()
+
+	// a successful create counts as running instead
+	im.Instances.Store(InstanceKey{TeamId: "team4"}, &DeploymentInstance{State: Running})
+	assert.ErrorIs(t, im.reserveCapacity(), ErrCapacityReached)
+}
+
+// the state and expiration time can be read while an instance is being created/destroyed (run with -race to check for data races)
+func TestReadStateDuringChanges(t *testing.T) {
+	newTestInstanceManager()
+	ctx := context.Background()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 5; i++ {
+			_, err := im.CreateDeployment(ctx, "team1", DefaultChallengeId)
+			assert.Nil(t, err)
+			assert.Nil(t, im.DestroyDeployment(ctx, "team1", DefaultChallengeId))
+		}
+	}()
+
+	for {
+		select {
+		case <-done:
+			assert.Equal(t, 0, im.countActiveInstances())
+			return
+		default:
+		}
+
+		im.countActiveInstances()
+		im.countTeamInstances("team1")
+		getStatusResponse(im.GetDeploymentInstance("team1", DefaultChallengeId), time.Now())
+		NewHandlers(im).listAdminInstances(AdminInstanceFilter{State: "Running"})
+	}
+}
+
+func TestListInstancesDuringDestroyRetry(t *testing.T) {
+	clientset := newTestInstanceManager()
+	config.MaxDestroyRetries = 100
+	clientset.PrependReactor("delete", "namespaces", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("asdf")
+	})
+	ctx := context.Background()
+
+	_, err := im.CreateDeployment(ctx, "team1", DefaultChallengeId)
+	assert.Nil(t, err)
+
+	// the admin API reads the destroy failures while they're being counted
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 5; i++ {
+			assert.NotNil(t, im.DestroyDeployment(ctx, "team1", DefaultChallengeId))
+		}
+	}()
+
+	h := NewHandlers(im)
+	for {
+		select {
+		case <-done:
+			instances := h.listAdminInstances(AdminInstanceFilter{})
+			assert.Len(t, instances, 1)
+			assert.Greater(t, instances[0].DestroyFailures, 0)
+			return
+		default:
+		}
+
+		h.listAdminInstances(AdminInstanceFilter{})
+	}
+}
+
+func TestMaxInstancesPerTeam(t *testing.T) {
+	newTestInstanceManager()
+	config.MaxInstancesPerTeam = 1
+	config.Challenges["web"] = ChallengeSpec{Name: "web chal", Image: "captaingeech/test-web:latest", Port: 8080}
+	ctx := context.Background()
+
+	_, err := im.CreateDeployment(ctx, "team1", DefaultChallengeId)
+	assert.Nil(t, err)
+
+	// the cap is across challenges
+	_, err = im.CreateDeployment(ctx, "team1", "web")
+	var teamLimitErr *TeamLimitError
+	assert.ErrorAs(t, err, &teamLimitErr)
+	assert.Equal(t, 1, teamLimitErr.Limit)
+	assert.Equal(t, Destroyed, im.GetDeploymentInstance("team1", "web").State)
+
+	// but not across teams
+	_, err = im.CreateDeployment(ctx, "team2", "web")
+	assert.Nil(t, err)
+
+	// once the first instance is gone, there's room for the other challenge
+	assert.Nil(t, im.DestroyDeployment(ctx, "team1", DefaultChallengeId))
+	_, err = im.CreateDeployment(ctx, "team1", "web")
+	assert.Nil(t, err)
+	assert.Empty(t, im.pendingTeamCreates)
+}
+
+func TestUDPChallenge(t *testing.T) {
+	config = &Config{ChallengeProtocol: "TCP", ReadinessProbeTCP: true, LivenessProbeTCP: true, ServiceType: "LoadBalancer"}
+	spec := ChallengeSpec{Name: "my chal", Image: "captaingeech/test-nc:latest", Port: 31337}
+
+	// TCP by default
+	container := config.getDeployment("chaldeploy-test", "team-id", spec, nil).Spec.Template.Spec.Containers[0]
+	assert.Equal(t, corev1.ProtocolTCP, container.Ports[0].Protocol)
+	assert.NotNil(t, container.ReadinessProbe)
+	assert.Equal(t, corev1.ProtocolTCP, config.getService("chaldeploy-test", "team-id", spec).Spec.Ports[0].Protocol)
+
+	// a challenge can use UDP, for the container, service, and network policy, and doesn't get probes
+	spec.Protocol = "UDP"
+	container = config.getDeployment("chaldeploy-test", "team-id", spec, nil).Spec.Template.Spec.Containers[0]
+	assert.Equal(t, corev1.ProtocolUDP, container.Ports[0].Protocol)
+	assert.Nil(t, container.ReadinessProbe)
+	assert.Nil(t, container.LivenessProbe)
+	assert.Equal(t, corev1.ProtocolUDP, config.getService("chaldeploy-test", "team-id", spec).Spec.Ports[0].Protocol)
+	assert.Equal(t, corev1.ProtocolUDP, *config.getNetworkPolicy("chaldeploy-test", "team-id", spec).Spec.Ingress[0].Ports[0].Protocol)
+
+	// or the global setting can
+	spec.Protocol = ""
+	config.ChallengeProtocol = "UDP"
+	assert.Equal(t, corev1.ProtocolUDP, config.getProtocol(spec))
+
+	assert.True(t, isValidProtocol("UDP"))
+	for _, invalid := range []string{"", "udp", "SCTP"} {
+		assert.False(t, isValidProtocol(invalid), invalid)
+	}
+}
+
+func TestWarnExpiring(t *testing.T) {
+	newTestInstanceManager()
+	config.ExpiryWarningWindow = 5 * time.Minute
+	config.ExtendDuration = time.Hour
+	ctx := context.Background()
+
+	_, err := im.CreateDeployment(ctx, "team1", DefaultChallengeId)
+	assert.Nil(t, err)
+	di := im.GetDeploymentInstance("team1", DefaultChallengeId)
+
+	// not within the window yet
+	now := time.Now().UTC()
+	assert.False(t, di.warnIfExpiringSoon(now))
+
+	// only warned once per expiration time
+	soon := di.ExpTime.Add(-time.Minute)
+	assert.True(t, di.warnIfExpiringSoon(soon))
+	assert.False(t, di.warnIfExpiringSoon(soon.Add(30*time.Second)))
+	assert.True(t, getStatusResponse(di, soon).ExpiringSoon)
+
+	// already expired, that's the reaper's job
+	assert.False(t, di.isExpiringSoon(di.ExpTime.Add(time.Second)))
+
+	// extending it means it can be warned about again
+	_, err = im.ExtendDeployment(ctx, "team1", DefaultChallengeId)
+	assert.Nil(t, err)
+	assert.False(t, getStatusResponse(di, soon).ExpiringSoon)
+	assert.True(t, di.warnIfExpiringSoon(di.ExpTime.Add(-time.Minute)))
+
+	// disabled
+	config.ExpiryWarningWindow = 0
+	assert.False(t, di.isExpiringSoon(di.ExpTime.Add(-time.Second)))
+}
+
+func TestReapExpired(t *testing.T) {
+	newTestInstanceManager()
+	config.ReaperConcurrency = 4
+	ctx := context.Background()
+
+	past := time.Now().UTC().Add(-time.Minute)
+	for i := 0; i < 25; i++ {
+		teamId := fmt.Sprintf("team%d", i)
+		_, err := im.CreateDeployment(ctx, teamId, DefaultChallengeId)
+		assert.Nil(t, err)
+
+		// every other instance is expired
+		if i%2 == 0 {
+			im.GetDeploymentInstance(teamId, DefaultChallengeId).ExpTime = &past
+		}
+	}
+
+	// skipped while another pass is running
+	im.reapMu.Lock()
+	assert.Nil(t, im.ReapExpired(ctx))
+	assert.Equal(t, 25, im.countInstances(Running))
+	im.reapMu.Unlock()
+
+	assert.Nil(t, im.ReapExpired(ctx))
+	assert.Equal(t, 12, im.countInstances(Running))
+	for i := 0; i < 25; i += 2 {
+		assert.Equal(t, Destroyed, im.GetDeploymentInstance(fmt.Sprintf("team%d", i), DefaultChallengeId).State)
+	}
+}
+
+func TestDestroyRetryDelay(t *testing.T) {
+	assert.Equal(t, time.Minute, destroyRetryDelay(1))
+	assert.Equal(t, 2*time.Minute, destroyRetryDelay(2))
+	assert.Equal(t, 16*time.Minute, destroyRetryDelay(5))
+	assert.Equal(t, time.Hour, destroyRetryDelay(100))
+}
+
+func TestRetryFailedDestroys(t *testing.T) {
+	clientset := newTestInstanceManager()
+	config.MaxDestroyRetries = 2
+	ctx := context.Background()
+
+	failing := true
+	clientset.PrependReactor("delete", "namespaces", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if failing {
+			return true, nil, errors.New("asdf")
+		}
+		return false, nil, nil
+	})
+
+	_, err := im.CreateDeployment(ctx, "team1", DefaultChallengeId)
+	assert.Nil(t, err)
+	di := im.GetDeploymentInstance("team1", DefaultChallengeId)
+
+	// the failed destroy is scheduled to be tried again
+	now := time.Now()
+	assert.NotNil(t, im.DestroyDeployment(ctx, "team1", DefaultChallengeId))
+	assert.Equal(t, 1, di.DestroyFailures)
+	assert.NotNil(t, di.NextDestroyRetry)
+
+	// not until the backoff is up
+	assert.Nil(t, im.RetryFailedDestroys(ctx, now))
+	assert.Equal(t, 1, di.DestroyFailures)
+
+	assert.NotNil(t, im.RetryFailedDestroys(ctx, now.Add(2*time.Minute)))
+	assert.Equal(t, 2, di.DestroyFailures)
+	assert.NotNil(t, im.RetryFailedDestroys(ctx, now.Add(time.Hour)))
+	assert.Equal(t, 3, di.DestroyFailures)
+
+	// out of retries
+	assert.Nil(t, di.NextDestroyRetry)
+	assert.False(t, di.destroyRetryDue(now.Add(24*time.Hour)))
+
+	// once the cluster is working again, a retry cleans up the instance
+	failing = false
+	di.DestroyFailures = 1
+	next := now.Add(time.Minute)
+	di.NextDestroyRetry = &next
+	assert.Nil(t, im.RetryFailedDestroys(ctx, now.Add(2*time.Minute)))
+	assert.Equal(t, Destroyed, di.State)
+	assert.Equal(t, 0, di.DestroyFailures)
+	assert.Nil(t, di.NextDestroyRetry)
+}
+
+func TestRetryStuckDestroy(t *testing.T) {
+	newTestInstanceManager()
+	ctx := context.Background()
+
+	_, err := im.CreateDeployment(ctx, "team1", DefaultChallengeId)
+	assert.Nil(t, err)
+	di := im.GetDeploymentInstance("team1", DefaultChallengeId)
+
+	// an instance that's only just started being destroyed is left alone
+	now := time.Now()
+	di.State = Destroying
+	di.DestroyingSince = &now
+	assert.False(t, di.destroyRetryDue(now))
+
+	// one that's been Destroying past the timeout is retried
+	assert.Nil(t, im.RetryFailedDestroys(ctx, now.Add(2*config.DestroyTimeout)))
+	assert.Equal(t, Destroyed, di.State)
+}
+
+func TestRefreshConnection(t *testing.T) {
+	clientset := newTestInstanceManager()
+	ctx := context.Background()
+
+	_, err := im.RefreshConnection(ctx, "team-id", DefaultChallengeId)
+	assert.ErrorIs(t, err, ErrNoInstance)
+
+	cxn, err := im.CreateDeployment(ctx, "team-id", DefaultChallengeId)
+	assert.Nil(t, err)
+	assert.Equal(t, "1.2.3.4:31337", cxn)
+	di := im.GetDeploymentInstance("team-id", DefaultChallengeId)
+
+	// the load balancer got a new address
+	service, err := clientset.CoreV1().Services(di.Namespace).Get(ctx, di.AppName, metav1.GetOptions{})
+	assert.Nil(t, err)
+	service.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{IP: "5.6.7.8"}}
+	_, err = clientset.CoreV1().Services(di.Namespace).UpdateStatus(ctx, service, metav1.UpdateOptions{})
+	assert.Nil(t, err)
+
+	// status requests can read the connection info while it's refreshed
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			di.GetCxn()
+		}
+	}()
+
+	cxn, err = im.RefreshConnection(ctx, "team-id", DefaultChallengeId)
+	<-done
+	assert.Nil(t, err)
+	assert.Equal(t, "5.6.7.8:31337", cxn)
+	assert.Equal(t, "5.6.7.8:31337", im.GetDeploymentInstance("team-id", DefaultChallengeId).GetCxn())
+
+	// busy while it's being modified
+	di.Lock()
+	_, err = im.RefreshConnection(ctx, "team-id", DefaultChallengeId)
+	assert.ErrorIs(t, err, ErrBusy)
+	di.Unlock()
+
+	assert.Nil(t, im.DestroyDeployment(ctx, "team-id", DefaultChallengeId))
+	_, err = im.RefreshConnection(ctx, "team-id", DefaultChallengeId)
+	assert.ErrorIs(t, err, ErrNoInstance)
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// annotation on the instance namespace that holds the expiration time (RFC3339)
+const expiresAtAnnotation = "chaldeploy.captaingee.ch/expires-at"
+
+// label that older versions of chaldeploy kept the expiration time in (as a unix timestamp).
+// namespaces that still have it are moved over to expiresAtAnnotation when they're loaded
+const legacyExpirationLabel = "chaldeploy.captaingee.ch/expiration-time"
+
+// InstanceStore persists the instance state that can't be recovered from the cluster objects,
+// so that restarting chaldeploy doesn't reset every team's expiration time
+type InstanceStore interface {
+	// Save the expiration time of an instance
+	Save(ctx context.Context, di *DeploymentInstance) error
+
+	// Load the saved expiration time of an instance. Returns nil if nothing was saved for it
+	Load(ctx context.Context, di *DeploymentInstance) (*time.Time, error)
+
+	// Delete the saved state for an instance once it has been destroyed
+	Delete(ctx context.Context, di *DeploymentInstance) error
+}
+
+// Create the instance store selected by the config
+func newInstanceStore(clientset kubernetes.Interface) (InstanceStore, error) {
+	switch config.InstanceStore {
+	case "namespace":
+		// in dry run mode there aren't any namespaces to save the state on
+		if config.DryRun {
+			return NoopInstanceStore{}, nil
+		}
+		return &NamespaceInstanceStore{Clientset: clientset}, nil
+	case "redis":
+		opts, err := redis.ParseURL(config.RedisUrl)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't parse the redis url: %v", err)
+		}
+		return &RedisInstanceStore{Client: redis.NewClient(opts)}, nil
+	default:
+		return nil, fmt.Errorf("unknown instance store: %s", config.InstanceStore)
+	}
+}
+
+/////////////////////////////////
+
+// NoopInstanceStore doesn't save anything, for dry run mode
+type NoopInstanceStore struct{}
+
+func (NoopInstanceStore) Save(ctx context.Context, di *DeploymentInstance) error {
+	return nil
+}
+
+func (NoopInstanceStore) Load(ctx context.Context, di *DeploymentInstance) (*time.Time, error) {
+	return nil, nil
+}
+
+func (NoopInstanceStore) Delete(ctx context.Context, di *DeploymentInstance) error {
+	return nil
+}
+
+/////////////////////////////////
+
+// NamespaceInstanceStore saves the instance state as annotations on the instance namespace
+// The state is cleaned up along with the namespace, so nothing needs to be deleted
+type NamespaceInstanceStore struct {
+	Clientset kubernetes.Interface
+}
+
+func (s *NamespaceInstanceStore) Save(ctx context.Context, di *DeploymentInstance) error {
+	if di.ExpTime == nil {
+		return fmt.Errorf("instance for %s doesn't have an expiration time to save", di.Key)
+	}
+
+	namespacesClient := s.Clientset.CoreV1().Namespaces()
+	ns, err := namespacesClient.Get(ctx, di.Namespace, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("couldn't get namespace %s: %v", di.Namespace, err)
+	}
+
+	if ns.Annotations == nil {
+		ns.Annotations = map[string]string{}
+	}
+	ns.Annotations[expiresAtAnnotation] = di.ExpTime.UTC().Format(time.RFC3339)
+
+	if _, err := namespacesClient.Update(ctx, ns, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("couldn't update namespace %s: %v", di.Namespace, err)
+	}
+
+	return nil
+}
+
+func (s *NamespaceInstanceStore) Load(ctx context.Context, di *DeploymentInstance) (*time.Time, error) {
+	ns, err := s.Clientset.CoreV1().Namespaces().Get(ctx, di.Namespace, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get namespace %s: %v", di.Namespace, err)
+	}
+
+	value, ok := ns.Annotations[expiresAtAnnotation]
+	if !ok {
+		return s.migrateLegacyLabel(ctx, ns)
+	}
+
+	expTime, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse the expiration time on namespace %s: %s", di.Namespace, value)
+	}
+	expTime = expTime.UTC()
+
+	return &expTime, nil
+}
+
+func (s *NamespaceInstanceStore) Delete(ctx context.Context, di *DeploymentInstance) error {
+	return nil
+}
+
+// Load the expiration time from the label older versions of chaldeploy used, and rewrite it as the annotation.
+// Returns nil if the namespace doesn't have the label either. The time is returned even if the namespace
+// couldn't be rewritten, since the label is still there to try again with on the next load
+func (s *NamespaceInstanceStore) migrateLegacyLabel(ctx context.Context, ns *corev1.Namespace) (*time.Time, error) {
+	value, ok := ns.Labels[legacyExpirationLabel]
+	if !ok {
+		return nil, nil
+	}
+
+	expTimeInt, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse the old expiration time label on namespace %s: %s", ns.Name, value)
+	}
+	expTime := time.Unix(expTimeInt, 0).UTC()
+
+	if ns.Annotations == nil {
+		ns.Annotations = map[string]string{}
+	}
+	ns.Annotations[expiresAtAnnotation] = expTime.Format(time.RFC3339)
+	delete(ns.Labels, legacyExpirationLabel)
+
+	if _, err := s.Clientset.CoreV1().Namespaces().Update(ctx, ns, metav1.UpdateOptions{}); err != nil {
+		log.Printf("couldn't move the expiration time on namespace %s to the annotation: %v", ns.Name, err)
+	} else {
+		log.Printf("moved the expiration time on namespace %s from the old label to the annotation", ns.Name)
+	}
+
+	return &expTime, nil
+}
+
+/////////////////////////////////
+
+// RedisInstanceStore saves the instance state in redis, keyed by the team and challenge ids
+// Keys expire along with the instance, so a missed delete doesn't leave them around forever
+type RedisInstanceStore struct {
+	Client *redis.Client
+}
+
+// get the redis key for an instance
+func getRedisKey(key InstanceKey) string {
+	return fmt.Sprintf("chaldeploy:expires-at:%s:%s", key.TeamId, key.ChallengeId)
+}
+
+func (s *RedisInstanceStore) Save(ctx context.Context, di *DeploymentInstance) error {
+	if di.ExpTime == nil {
+		return fmt.Errorf("instance for %s doesn't have an expiration time to save", di.Key)
+	}
+
+	// a ttl of 0 means the key never expires, so make sure it's always positive
+	ttl := time.Until(*di.ExpTime)
+	if ttl < time.Second {
+		ttl = time.Second
+	}
+
+	if err := s.Client.Set(ctx, getRedisKey(di.Key), di.ExpTime.Format(time.RFC3339), ttl).Err(); err != nil {
+		return fmt.Errorf("couldn't save the expiration time for %s to redis: %v", di.Key, err)
+	}
+
+	return nil
+}
+
+func (s *RedisInstanceStore) Load(ctx context.Context, di *DeploymentInstance) (*time.Time, error) {
+	value, err := s.Client.Get(ctx, getRedisKey(di.Key)).Result()
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("couldn't load the expiration time for %s from redis: %v", di.Key, err)
+	}
+
+	expTime, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse the expiration time for %s: %s", di.Key, value)
+	}
+	expTime = expTime.UTC()
+
+	return &expTime, nil
+}
+
+func (s *RedisInstanceStore) Delete(ctx context.Context, di *DeploymentInstance) error {
+	if err := s.Client.Del(ctx, getRedisKey(di.Key)).Err(); err != nil {
+		return fmt.Errorf("couldn't delete the expiration time for %s from redis: %v", di.Key, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestNamespaceInstanceStore(t *testing.T) {
+	clientset := fake.NewSimpleClientset(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "chaldeploy-asdf-team"}})
+	s := &NamespaceInstanceStore{Clientset: clientset}
+	ctx := context.Background()
+
+	di := &DeploymentInstance{
+		Key:       InstanceKey{TeamId: "team", ChallengeId: DefaultChallengeId},
+		Namespace: "chaldeploy-asdf-team",
+	}
+
+	// nothing saved yet
+	expTime, err := s.Load(ctx, di)
+	assert.Nil(t, err)
+	assert.Nil(t, expTime)
+
+	// save and load it back
+	exp := time.Date(2022, 11, 3, 12, 30, 0, 0, time.UTC)
+	di.ExpTime = &exp
+	assert.Nil(t, s.Save(ctx, di))
+
+	expTime, err = s.Load(ctx, di)
+	assert.Nil(t, err)
+	assert.Equal(t, exp, *expTime)
+
+	ns, err := clientset.CoreV1().Namespaces().Get(ctx, di.Namespace, metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, "2022-11-03T12:30:00Z", ns.Annotations[expiresAtAnnotation])
+
+	// deleting is a noop, the annotation goes away with the namespace
+	assert.Nil(t, s.Delete(ctx, di))
+
+	// missing namespace
+	di.Namespace = "nope"
+	_, err = s.Load(ctx, di)
+	assert.NotNil(t, err)
+	assert.NotNil(t, s.Save(ctx, di))
+}
+
+func TestNamespaceInstanceStoreInvalid(t *testing.T) {
+	clientset := fake.NewSimpleClientset(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:        "chaldeploy-asdf-team",
+		Annotations: map[string]string{expiresAtAnnotation: "tomorrow"},
+	}})
+	s := &NamespaceInstanceStore{Clientset: clientset}
+
+	di := &DeploymentInstance{Namespace: "chaldeploy-asdf-team"}
+
+	_, err := s.Load(context.Background(), di)
+	assert.NotNil(t, err)
+
+	// can't save an instance without an expiration time
+	assert.NotNil(t, s.Save(context.Background(), di))
+}
+
+// namespaces from older versions have the expiration time in a label, which is moved over to the annotation
+func TestNamespaceInstanceStoreLegacyLabel(t *testing.T) {
+	exp := time.Date(2022, 11, 3, 12, 30, 0, 0, time.UTC)
+	clientset := fake.NewSimpleClientset(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:   "chaldeploy-asdf-team",
+		Labels: map[string]string{"chaldeploy.captaingee.ch/team-id": "team", legacyExpirationLabel: strconv.Itoa(int(exp.Unix()))},
+	}})
+	s := &NamespaceInstanceStore{Clientset: clientset}
+	ctx := context.Background()
+
+	di := &DeploymentInstance{Namespace: "chaldeploy-asdf-team"}
+	expTime, err := s.Load(ctx, di)
+	assert.Nil(t, err)
+	assert.Equal(t, exp, *expTime)
+
+	// it was rewritten in the new format, and the other labels were kept
+	ns, err := clientset.CoreV1().Namespaces().Get(ctx, di.Namespace, metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, "2022-11-03T12:30:00Z", ns.Annotations[expiresAtAnnotation])
+	assert.NotContains(t, ns.Labels, legacyExpirationLabel)
+	assert.Equal(t, "team", ns.Labels["chaldeploy.captaingee.ch/team-id"])
+
+	expTime, err = s.Load(ctx, di)
+	assert.Nil(t, err)
+	assert.Equal(t, exp, *expTime)
+
+	// an unparseable label is an error, like an unparseable annotation
+	clientset = fake.NewSimpleClientset(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:   "chaldeploy-asdf-team",
+		Labels: map[string]string{legacyExpirationLabel: "tomorrow"},
+	}})
+	s = &NamespaceInstanceStore{Clientset: clientset}
+	_, err = s.Load(ctx, di)
+	assert.NotNil(t, err)
+}
+
+func TestRedisKey(t *testing.T) {
+	assert.Equal(t, "chaldeploy:expires-at:team:default", getRedisKey(InstanceKey{TeamId: "team", ChallengeId: DefaultChallengeId}))
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// InstructionsTemplateData is the data available to a challenge's connection instructions template.
+// It has everything the env var templates do, plus the connection info for the instance
+type InstructionsTemplateData struct {
+	EnvTemplateData
+
+	// connection string for the instance, the same as the host in /api/create and /api/status
+	Host string
+
+	// hostname and (first public) port for connecting to the instance. not set if it's exposed with an ingress
+	Hostname string
+	Port     int
+
+	// url for connecting to the instance, if it's exposed with an ingress
+	URL string
+}
+
+// get the connection instructions template for a challenge, preferring the challenge's own over the global one
+func getInstructionsTemplate(spec ChallengeSpec) string {
+	if spec.Instructions != "" {
+		return spec.Instructions
+	}
+
+	return config.ChallengeInstructions
+}
+
+// Render a connection instructions template
+func renderInstructions(instructions string, data InstructionsTemplateData) (string, error) {
+	t, err := template.New("instructions").Option("missingkey=error").Parse(instructions)
+	if err != nil {
+		return "", fmt.Errorf("couldn't parse the instructions template: %v", err)
+	}
+
+	sb := &strings.Builder{}
+	if err := t.Execute(sb, data); err != nil {
+		return "", fmt.Errorf("couldn't render the instructions template: %v", err)
+	}
+
+	return sb.String(), nil
+}
+
+// Make sure an instructions template only uses the available data
+func validateInstructions(instructions string) error {
+	_, err := renderInstructions(instructions, InstructionsTemplateData{})
+	return err
+}
+
+// Get the connection instructions for a running instance, or "" if its challenge doesn't have any.
+// A template that can't be rendered is logged instead of failing the request, since the instance is still usable
+func (di *DeploymentInstance) GetInstructions() string {
+	instructions := getInstructionsTemplate(di.Challenge)
+	if instructions == "" {
+		return ""
+	}
+
+	// status requests call this without holding mu, so the names and connection info are read under stateMu
+	di.stateMu.RLock()
+	data := InstructionsTemplateData{
+		EnvTemplateData: EnvTemplateData{TeamID: di.Key.TeamId, ChallengeID: di.Key.ChallengeId, AppName: di.AppName, Namespace: di.Namespace},
+		Host:            di.getCxnLocked(),
+		Hostname:        di.Hostname,
+		Port:            di.Port,
+		URL:             di.URL,
+	}
+	di.stateMu.RUnlock()
+
+	rendered, err := renderInstructions(instructions, data)
+	if err != nil {
+		logEvent("couldn't render the connection instructions", Fields{"team_id": di.Key.TeamId, "challenge_id": di.Key.ChallengeId, "error": err.Error()})
+		return ""
+	}
+
+	return rendered
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderInstructions(t *testing.T) {
+	data := InstructionsTemplateData{EnvTemplateData: EnvTemplateData{TeamID: "team1"}, Hostname: "1.2.3.4", Port: 31337}
+
+	instructions, err := renderInstructions("nc {{.Hostname}} {{.Port}} (team {{.TeamID}})", data)
+	assert.Nil(t, err)
+	assert.Equal(t, "nc 1.2.3.4 31337 (team team1)", instructions)
+
+	_, err = renderInstructions("{{.Password}}", data)
+	assert.NotNil(t, err)
+
+	assert.Nil(t, validateInstructions(""))
+	assert.Nil(t, validateInstructions("ssh ctf@{{.Hostname}} -p {{.Port}}"))
+	assert.NotNil(t, validateInstructions("{{.Hostname"))
+	assert.NotNil(t, validateInstructions("{{.Flag}}"))
+}
+
+func TestGetInstructions(t *testing.T) {
+	config = &Config{ChallengeInstructions: "nc {{.Hostname}} {{.Port}}"}
+	di := &DeploymentInstance{Key: InstanceKey{TeamId: "team1", ChallengeId: DefaultChallengeId}, Hostname: "1.2.3.4", Port: 31337}
+
+	assert.Equal(t, "nc 1.2.3.4 31337", di.GetInstructions())
+
+	// the challenge's own template wins
+	di.Challenge.Instructions = "connect to {{.Host}}"
+	assert.Equal(t, "connect to 1.2.3.4:31337", di.GetInstructions())
+
+	// no instructions
+	config.ChallengeInstructions = ""
+	di.Challenge.Instructions = ""
+	assert.Equal(t, "", di.GetInstructions())
+}
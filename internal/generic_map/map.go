@@ -266,8 +266,9 @@ func (e *entry[V]) tryLoadOrStore(i V) (actual V, loaded, ok bool) {
 	}
 }
 
-// Delete deletes the value for a key.
-func (m *MapOf[K, V]) Delete(key K) {
+// LoadAndDelete deletes the value for a key, returning the previous value if any.
+// The loaded result reports whether the key was present.
+func (m *MapOf[K, V]) LoadAndDelete(key K) (value V, loaded bool) {
 	read, _ := m.read.Load().(readOnly[K, V])
 	e, ok := read.m[key]
 	if !ok && read.amended {
@@ -275,23 +276,34 @@ func (m *MapOf[K, V]) Delete(key K) {
 		read, _ = m.read.Load().(readOnly[K, V])
 		e, ok = read.m[key]
 		if !ok && read.amended {
+			e, ok = m.dirty[key]
 			delete(m.dirty, key)
+			// Regardless of whether the entry was present, record a miss: this key
+			// will take the slow path until the dirty map is promoted to the read
+			// map.
+			m.missLocked()
 		}
 		m.mu.Unlock()
 	}
 	if ok {
-		e.delete()
+		return e.delete()
 	}
+	return value, false
+}
+
+// Delete deletes the value for a key.
+func (m *MapOf[K, V]) Delete(key K) {
+	m.LoadAndDelete(key)
 }
 
-func (e *entry[V]) delete() (hadValue bool) {
+func (e *entry[V]) delete() (value V, ok bool) {
 	for {
 		p := atomic.LoadPointer(&e.p)
 		if p == nil || p == expunged {
-			return false
+			return value, false
 		}
 		if atomic.CompareAndSwapPointer(&e.p, p, nil) {
-			return true
+			return *(*V)(p), true
 		}
 	}
 }
@@ -339,6 +351,24 @@ func (m *MapOf[K, V]) Range(f func(key K, value V) bool) {
 	}
 }
 
+// Snapshot returns a copy of the map's contents as a plain Go map.
+//
+// Snapshot is built with Range, so it has the same consistency guarantees: every
+// key that is present for the whole call is included, and each value is one that
+// was stored for its key at some point during the call, but the copy as a whole
+// does not necessarily correspond to the MapOf's contents at any single point in
+// time. Unlike the view seen by Range, the copy does not change afterwards, so it
+// can be sorted, filtered, or iterated slowly without racing with writers.
+// Only the map is copied, not the values themselves.
+func (m *MapOf[K, V]) Snapshot() map[K]V {
+	snapshot := map[K]V{}
+	m.Range(func(key K, value V) bool {
+		snapshot[key] = value
+		return true
+	})
+	return snapshot
+}
+
 func (m *MapOf[K, V]) missLocked() {
 	m.misses++
 	if m.misses < len(m.dirty) {
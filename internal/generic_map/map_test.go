@@ -0,0 +1,162 @@
+package generic_map
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRange(t *testing.T) {
+	m := new(MapOf[string, int])
+	m.Store("a", 1)
+	m.Store("b", 2)
+	m.Store("c", 3)
+	m.Delete("c")
+
+	seen := map[string]int{}
+	m.Range(func(key string, value int) bool {
+		seen[key] = value
+		return true
+	})
+	assert.Equal(t, map[string]int{"a": 1, "b": 2}, seen)
+
+	// returning false stops the iteration
+	calls := 0
+	m.Range(func(key string, value int) bool {
+		calls++
+		return false
+	})
+	assert.Equal(t, 1, calls)
+}
+
+func TestSnapshot(t *testing.T) {
+	m := new(MapOf[string, int])
+	assert.Empty(t, m.Snapshot())
+
+	m.Store("a", 1)
+	m.Store("b", 2)
+	snapshot := m.Snapshot()
+	assert.Equal(t, map[string]int{"a": 1, "b": 2}, snapshot)
+
+	// the copy doesn't follow later changes, and changing it doesn't touch the map
+	m.Store("a", 10)
+	m.Delete("b")
+	m.Store("c", 3)
+	assert.Equal(t, map[string]int{"a": 1, "b": 2}, snapshot)
+
+	snapshot["d"] = 4
+	_, ok := m.Load("d")
+	assert.False(t, ok)
+}
+
+// Range and Snapshot can run while other goroutines are writing to the map (run with -race to check for data races).
+// The keys that are there the whole time are always seen, and each value is one that was stored for its key
+func TestConcurrentIteration(t *testing.T) {
+	m := new(MapOf[int, int])
+	for i := 0; i < 100; i++ {
+		m.Store(i, i)
+	}
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for n := 0; ; n++ {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				// overwrite the stable keys with values that are still valid for them, and churn through others
+				m.Store(n%100, n%100)
+				m.Store(1000+w, n)
+				m.LoadOrStore(2000+n%50, n)
+				m.Delete(2000 + (n+25)%50)
+			}
+		}(w)
+	}
+
+	for i := 0; i < 100; i++ {
+		snapshot := m.Snapshot()
+		for k := 0; k < 100; k++ {
+			assert.Equal(t, k, snapshot[k])
+		}
+
+		seen := 0
+		m.Range(func(key, value int) bool {
+			if key < 100 {
+				assert.Equal(t, key, value)
+				seen++
+			}
+			return true
+		})
+		assert.Equal(t, 100, seen)
+	}
+
+	close(done)
+	wg.Wait()
+}
+
+func TestLoadAndDelete(t *testing.T) {
+	m := new(MapOf[string, int])
+	m.Store("a", 1)
+
+	value, loaded := m.LoadAndDelete("a")
+	assert.True(t, loaded)
+	assert.Equal(t, 1, value)
+	_, ok := m.Load("a")
+	assert.False(t, ok)
+
+	// already gone
+	value, loaded = m.LoadAndDelete("a")
+	assert.False(t, loaded)
+	assert.Equal(t, 0, value)
+
+	// a key that's only in the dirty map (stored since the last promotion) can be deleted too
+	m.Store("b", 2)
+	m.Range(func(key string, value int) bool { return true })
+	m.Store("c", 3)
+	value, loaded = m.LoadAndDelete("c")
+	assert.True(t, loaded)
+	assert.Equal(t, 3, value)
+	assert.Equal(t, map[string]int{"b": 2}, m.Snapshot())
+
+	m.Delete("b")
+	assert.Empty(t, m.Snapshot())
+}
+
+// when goroutines race to delete the same key, only one of them gets the value
+func TestConcurrentLoadAndDelete(t *testing.T) {
+	m := new(MapOf[int, int])
+	for i := 0; i < 1000; i++ {
+		m.Store(i, i)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	deleted := map[int]int{}
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				if _, loaded := m.LoadAndDelete(i); loaded {
+					mu.Lock()
+					deleted[i]++
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Len(t, deleted, 1000)
+	for _, count := range deleted {
+		assert.Equal(t, 1, count)
+	}
+	assert.Empty(t, m.Snapshot())
+}
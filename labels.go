@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// prefix for the labels chaldeploy uses to find and select its objects. extra labels and annotations can't use it
+const reservedLabelPrefix = "chaldeploy.captaingee.ch/"
+
+// Merge chaldeploy's own labels (or annotations) over the extra ones from the config, so the extra ones can't
+// replace the ones chaldeploy needs (e.g., `app`, which the deployment and service select on)
+func mergeLabels(extra, own map[string]string) map[string]string {
+	merged := make(map[string]string, len(extra)+len(own))
+	for k, v := range extra {
+		merged[k] = v
+	}
+	for k, v := range own {
+		merged[k] = v
+	}
+
+	return merged
+}
+
+// check that a label or annotation key is valid, and doesn't use the reserved prefix
+func validateExtraKey(k string) error {
+	if errs := validation.IsQualifiedName(k); len(errs) > 0 {
+		return fmt.Errorf("%s isn't a valid key: %s", k, strings.Join(errs, ", "))
+	}
+	if strings.HasPrefix(k, reservedLabelPrefix) {
+		return fmt.Errorf("%s uses the %s prefix, which is reserved for chaldeploy", k, reservedLabelPrefix)
+	}
+
+	return nil
+}
+
+// Make sure the extra labels are valid k8s labels, and don't use the reserved prefix
+func validateExtraLabels(labels map[string]string) error {
+	for _, k := range SortedKeys(labels) {
+		v := labels[k]
+		if err := validateExtraKey(k); err != nil {
+			return err
+		}
+		if errs := validation.IsValidLabelValue(v); len(errs) > 0 {
+			return fmt.Errorf("%s isn't a valid value for label %s: %s", v, k, strings.Join(errs, ", "))
+		}
+	}
+
+	return nil
+}
+
+// Make sure the extra annotations have valid keys that don't use the reserved prefix. annotation values can be anything
+func validateExtraAnnotations(annotations map[string]string) error {
+	for _, k := range SortedKeys(annotations) {
+		if err := validateExtraKey(k); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
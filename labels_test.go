@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergeLabels(t *testing.T) {
+	merged := mergeLabels(map[string]string{"team": "infra", "app": "asdf"}, map[string]string{"app": "chaldeploy-test"})
+	assert.Equal(t, map[string]string{"team": "infra", "app": "chaldeploy-test"}, merged)
+
+	assert.Equal(t, map[string]string{"app": "chaldeploy-test"}, mergeLabels(nil, map[string]string{"app": "chaldeploy-test"}))
+}
+
+func TestExtraLabels(t *testing.T) {
+	config = &Config{
+		ExtraPodLabels:       map[string]string{"team": "infra", "app": "asdf"},
+		ExtraPodAnnotations:  map[string]string{"sidecar.istio.io/inject": "false"},
+		ExtraNamespaceLabels: map[string]string{"pod-security.kubernetes.io/enforce": "restricted", "app.kubernetes.io/managed-by": "asdf"},
+	}
+	spec := ChallengeSpec{Name: "my chal", Image: "captaingeech/test-nc:latest", Port: 31337}
+
+	pod := config.getDeployment("chaldeploy-test", "team-id", spec, nil).Spec.Template
+	assert.Equal(t, "infra", pod.Labels["team"])
+	assert.Equal(t, "chaldeploy-test", pod.Labels["app"])
+	assert.Equal(t, "team-id", pod.Labels["chaldeploy.captaingee.ch/team-id"])
+	assert.Equal(t, "false", pod.Annotations["sidecar.istio.io/inject"])
+
+	ns := config.getNamespace("chaldeploy-test", "team-id", spec)
+	assert.Equal(t, "restricted", ns.Labels["pod-security.kubernetes.io/enforce"])
+	assert.Equal(t, "chaldeploy", ns.Labels["app.kubernetes.io/managed-by"])
+	assert.Equal(t, "yes", ns.Labels["chaldeploy.captaingee.ch/managed-by"])
+}
+
+func TestValidateExtraLabels(t *testing.T) {
+	assert.Nil(t, validateExtraLabels(nil))
+	assert.Nil(t, validateExtraLabels(map[string]string{"team": "infra", "example.com/owner": ""}))
+
+	assert.NotNil(t, validateExtraLabels(map[string]string{"chaldeploy.captaingee.ch/team-id": "asdf"}))
+	assert.NotNil(t, validateExtraLabels(map[string]string{"not a label": "infra"}))
+	assert.NotNil(t, validateExtraLabels(map[string]string{"team": "not a value"}))
+
+	assert.Nil(t, validateExtraAnnotations(map[string]string{"example.com/notes": "anything goes here!"}))
+	assert.NotNil(t, validateExtraAnnotations(map[string]string{"chaldeploy.captaingee.ch/expires-at": "asdf"}))
+	assert.NotNil(t, validateExtraAnnotations(map[string]string{"": "asdf"}))
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Locker guards an instance against being modified by multiple chaldeploy replicas at once.
+// This is on top of the per-instance mutex, which only covers a single replica
+type Locker interface {
+	// Acquire the lock for an instance. Returns ErrBusy if another replica holds it
+	Lock(ctx context.Context, key InstanceKey) error
+
+	// Release the lock for an instance
+	Unlock(ctx context.Context, key InstanceKey) error
+}
+
+// Create the locker selected by the config
+func newLocker(clientset kubernetes.Interface, identity string) (Locker, error) {
+	// leases need a cluster, and there's nothing to lock in dry run mode anyways
+	if config.DryRun {
+		return NoopLocker{}, nil
+	}
+
+	switch config.Locker {
+	case "none":
+		return NoopLocker{}, nil
+	case "lease":
+		return &LeaseLocker{
+			Clientset: clientset,
+			Namespace: config.LockNamespace,
+			Identity:  identity,
+			TTL:       config.LockTTL,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown locker: %s", config.Locker)
+	}
+}
+
+/////////////////////////////////
+
+// NoopLocker doesn't do any locking, for running a single replica
+type NoopLocker struct{}
+
+func (NoopLocker) Lock(ctx context.Context, key InstanceKey) error {
+	return nil
+}
+
+func (NoopLocker) Unlock(ctx context.Context, key InstanceKey) error {
+	return nil
+}
+
+/////////////////////////////////
+
+// LeaseLocker locks instances with k8s Lease objects.
+// A lease that isn't released (e.g., the replica holding it crashed) expires after the TTL, and can then be
+// taken over by another replica
+type LeaseLocker struct {
+	Clientset kubernetes.Interface
+
+	// namespace the leases are created in
+	Namespace string
+
+	// holder identity for the leases taken by this replica
+	Identity string
+
+	// how long a lease is held before it expires
+	TTL time.Duration
+}
+
+// get the lease name for an instance
+func getLeaseName(key InstanceKey) string {
+	return fmt.Sprintf("chaldeploy-lock-%s-%s", HashString(key.TeamId), HashString(key.ChallengeId))
+}
+
+// check if a lease is expired
+func isLeaseExpired(lease *coordinationv1.Lease, now time.Time) bool {
+	if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return true
+	}
+
+	return lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second).Before(now)
+}
+
+func (l *LeaseLocker) Lock(ctx context.Context, key InstanceKey) error {
+	now := metav1.NewMicroTime(time.Now())
+	ttl := int32(l.TTL.Seconds())
+	spec := coordinationv1.LeaseSpec{
+		HolderIdentity:       &l.Identity,
+		LeaseDurationSeconds: &ttl,
+		AcquireTime:          &now,
+		RenewTime:            &now,
+	}
+
+	leasesClient := l.Clientset.CoordinationV1().Leases(l.Namespace)
+	lease := &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: getLeaseName(key),
+			Labels: map[string]string{
+				"app.kubernetes.io/managed-by": "chaldeploy",
+			},
+		},
+		Spec: spec,
+	}
+
+	_, err := leasesClient.Create(ctx, lease, metav1.CreateOptions{})
+	if err == nil {
+		return nil
+	} else if !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("couldn't create lease for %s: %v", key, err)
+	}
+
+	// the lease already exists, it can only be taken over if it expired
+	existing, err := leasesClient.Get(ctx, lease.Name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("couldn't get lease for %s: %v", key, err)
+	}
+
+	if !isLeaseExpired(existing, now.Time) {
+		return fmt.Errorf("lock for %s is held by %s: %w", key, *existing.Spec.HolderIdentity, ErrBusy)
+	}
+
+	// the resource version on the existing lease makes sure only one replica can take it over
+	existing.Spec = spec
+	if _, err := leasesClient.Update(ctx, existing, metav1.UpdateOptions{}); apierrors.IsConflict(err) {
+		return fmt.Errorf("lock for %s was taken over by another replica: %w", key, ErrBusy)
+	} else if err != nil {
+		return fmt.Errorf("couldn't take over lease for %s: %v", key, err)
+	}
+
+	return nil
+}
+
+func (l *LeaseLocker) Unlock(ctx context.Context, key InstanceKey) error {
+	leasesClient := l.Clientset.CoordinationV1().Leases(l.Namespace)
+
+	lease, err := leasesClient.Get(ctx, getLeaseName(key), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("couldn't get lease for %s: %v", key, err)
+	}
+
+	// don't release a lease that expired and was taken over by another replica
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != l.Identity {
+		return nil
+	}
+
+	if err := leasesClient.Delete(ctx, lease.Name, metav1.DeleteOptions{
+		Preconditions: &metav1.Preconditions{ResourceVersion: &lease.ResourceVersion},
+	}); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("couldn't delete lease for %s: %v", key, err)
+	}
+
+	return nil
+}
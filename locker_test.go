@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestLeaseLocker(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	ctx := context.Background()
+	key := InstanceKey{TeamId: "team", ChallengeId: DefaultChallengeId}
+
+	a := &LeaseLocker{Clientset: clientset, Namespace: "default", Identity: "replica-a", TTL: time.Minute}
+	b := &LeaseLocker{Clientset: clientset, Namespace: "default", Identity: "replica-b", TTL: time.Minute}
+
+	// a gets the lock, b has to wait
+	assert.Nil(t, a.Lock(ctx, key))
+	assert.True(t, errors.Is(b.Lock(ctx, key), ErrBusy))
+
+	// other instances aren't affected
+	assert.Nil(t, b.Lock(ctx, InstanceKey{TeamId: "team2", ChallengeId: DefaultChallengeId}))
+
+	// b can't release a's lock
+	assert.Nil(t, b.Unlock(ctx, key))
+	assert.True(t, errors.Is(b.Lock(ctx, key), ErrBusy))
+
+	// once a releases it, b can get it
+	assert.Nil(t, a.Unlock(ctx, key))
+	assert.Nil(t, b.Lock(ctx, key))
+	assert.Nil(t, b.Unlock(ctx, key))
+
+	// releasing a lock that isn't held is fine
+	assert.Nil(t, b.Unlock(ctx, key))
+}
+
+func TestLeaseLockerExpired(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	ctx := context.Background()
+	key := InstanceKey{TeamId: "team", ChallengeId: DefaultChallengeId}
+
+	a := &LeaseLocker{Clientset: clientset, Namespace: "default", Identity: "replica-a", TTL: time.Minute}
+	b := &LeaseLocker{Clientset: clientset, Namespace: "default", Identity: "replica-b", TTL: time.Minute}
+
+	// a takes the lock and "crashes" without releasing it
+	assert.Nil(t, a.Lock(ctx, key))
+
+	leasesClient := clientset.CoordinationV1().Leases("default")
+	lease, err := leasesClient.Get(ctx, getLeaseName(key), metav1.GetOptions{})
+	assert.Nil(t, err)
+	old := metav1.NewMicroTime(time.Now().Add(-2 * time.Minute))
+	lease.Spec.RenewTime = &old
+	_, err = leasesClient.Update(ctx, lease, metav1.UpdateOptions{})
+	assert.Nil(t, err)
+
+	// b can take it over
+	assert.Nil(t, b.Lock(ctx, key))
+
+	lease, err = leasesClient.Get(ctx, getLeaseName(key), metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, "replica-b", *lease.Spec.HolderIdentity)
+
+	// a releasing its old lock doesn't release b's
+	assert.Nil(t, a.Unlock(ctx, key))
+	assert.True(t, errors.Is(a.Lock(ctx, key), ErrBusy))
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fields are the structured data attached to a log event
+type Fields map[string]any
+
+// field names that hold secrets, and are never logged
+var secretFields = []string{"auth_token", "login_token", "token", "session_key"}
+
+// the configured log format, text or json
+var logFormat = "text"
+
+// jsonLogWriter writes log entries as one JSON object per line
+type jsonLogWriter struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+var jsonLog = &jsonLogWriter{out: os.Stderr}
+
+// Write a log entry with a message and fields
+func (w *jsonLogWriter) writeEntry(msg string, fields Fields) {
+	entry := map[string]any{}
+	for k, v := range fields {
+		entry[k] = v
+	}
+	entry["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	entry["msg"] = msg
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		line, _ = json.Marshal(map[string]any{"time": entry["time"], "msg": msg, "log_error": err.Error()})
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.out.Write(append(line, '\n'))
+}
+
+// implement io.Writer so the log package output is wrapped as JSON too. log calls Write once per line
+func (w *jsonLogWriter) Write(p []byte) (int, error) {
+	w.writeEntry(strings.TrimSuffix(string(p), "\n"), nil)
+	return len(p), nil
+}
+
+// Switch the log output to the configured format
+func setupLogging(format string) {
+	logFormat = format
+
+	if format == "json" {
+		log.SetFlags(0)
+		log.SetOutput(jsonLog)
+	}
+}
+
+// Mask the values of any fields that hold secrets
+func redactFields(fields Fields) Fields {
+	redacted := Fields{}
+	for k, v := range fields {
+		if Contains(secretFields, k) {
+			redacted[k] = "[redacted]"
+		} else {
+			redacted[k] = v
+		}
+	}
+
+	return redacted
+}
+
+// Log an event with structured fields. In text mode the fields are appended to the message as key=value pairs
+func logEvent(msg string, fields Fields) {
+	fields = redactFields(fields)
+
+	if logFormat == "json" {
+		jsonLog.writeEntry(msg, fields)
+		return
+	}
+
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	sb := &strings.Builder{}
+	sb.WriteString(msg)
+	for _, k := range keys {
+		fmt.Fprintf(sb, " %s=%v", k, fields[k])
+	}
+
+	log.Println(sb.String())
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJsonLogWriter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := &jsonLogWriter{out: buf}
+
+	w.writeEntry("instance deployed", Fields{"team_id": "team1", "duration_ms": 1234})
+	w.Write([]byte("plain log line\n"))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(t, lines, 2)
+
+	entry := map[string]any{}
+	assert.Nil(t, json.Unmarshal([]byte(lines[0]), &entry))
+	assert.Equal(t, "instance deployed", entry["msg"])
+	assert.Equal(t, "team1", entry["team_id"])
+	assert.Equal(t, float64(1234), entry["duration_ms"])
+	assert.NotEmpty(t, entry["time"])
+
+	entry = map[string]any{}
+	assert.Nil(t, json.Unmarshal([]byte(lines[1]), &entry))
+	assert.Equal(t, "plain log line", entry["msg"])
+}
+
+func TestRedactFields(t *testing.T) {
+	fields := redactFields(Fields{"team_id": "team1", "auth_token": "hunter2", "token": "hunter2"})
+
+	assert.Equal(t, "team1", fields["team_id"])
+	assert.Equal(t, "[redacted]", fields["auth_token"])
+	assert.Equal(t, "[redacted]", fields["token"])
+}
+
+func TestLogEventText(t *testing.T) {
+	buf := &bytes.Buffer{}
+	log.SetOutput(buf)
+	defer log.SetOutput(os.Stderr)
+
+	logEvent("instance destroyed", Fields{"team_id": "team1", "app_name": "chal", "auth_token": "hunter2"})
+
+	assert.Contains(t, buf.String(), "instance destroyed app_name=chal auth_token=[redacted] team_id=team1")
+	assert.NotContains(t, buf.String(), "hunter2")
+}
@@ -1,29 +1,35 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net/http"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/sessions"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 // globals
 var config *Config = nil
 var store *sessions.CookieStore = nil
 var im *InstanceManager = nil
+var authProvider AuthProvider = nil
+var limiter *TeamRateLimiter = nil
+var userInfoCache *UserInfoCache = nil
 
 // Log the incoming requests
 func loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// don't log healthcheck b/c i don't care
-		if r.RequestURI == "/healthcheck" {
-			return
+		// don't log health checks b/c i don't care
+		if !Contains([]string{"/healthcheck", "/healthz", "/readyz"}, r.RequestURI) {
+			log.Printf("%s request from %s to %s", r.Method, r.RemoteAddr, r.RequestURI)
 		}
 
-		log.Printf("%s request from %s to %s", r.Method, r.RemoteAddr, r.RequestURI)
-
 		next.ServeHTTP(w, r)
 	})
 }
@@ -34,7 +40,7 @@ type sessionHandler func(w http.ResponseWriter, r *http.Request, s *sessions.Ses
 func (h sessionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// make sure the session global is set
 	if store == nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		writeInternalError(w)
 		log.Println("store global isn't set, couldn't execute http handler with session info")
 	} else {
 		s, _ := store.Get(r, "session")
@@ -51,47 +57,125 @@ func main() {
 		config = c
 	}
 
+	// make sure the config is usable before doing anything with it
+	if err := config.Validate(); err != nil {
+		log.Fatalln(err)
+	}
+
+	// set up logging first, so everything after this uses the right format
+	setupLogging(config.LogFormat)
+
+	// warn about config that works, but probably isn't what was meant
+	if config.FlagTemplate != "" && config.FlagSecret == "" {
+		log.Println("WARNING: no flag secret is set, so the flags include the team id and can be guessed by other teams")
+	}
+	for i, key := range getSessionKeys() {
+		if isPlaceholderSessionKey(key) {
+			log.Printf("WARNING: session key %d looks like a placeholder/dev key, use a randomly generated one", i)
+		}
+	}
+	if !config.CookieSecure {
+		log.Println("WARNING: the session cookie isn't Secure, so a team's session can be hijacked over plain http")
+	}
+
 	// initialize router
 	router := mux.NewRouter()
 
 	// initialize session store
-	if sessKeyLen := len(config.SessionKey); !Contains([]int{32, 64}, sessKeyLen) {
-		log.Fatalf("the session key is an invalid length: %d (must be 32 or 64)", sessKeyLen)
+	store = newSessionStore(getSessionKeys())
+
+	// initialize the auth provider
+	if p, err := newAuthProvider(newScoreboardClient()); err != nil {
+		log.Fatalf("couldn't init the auth provider: %v", err)
+	} else {
+		authProvider = p
+	}
+	if config.UserInfoCacheTTL > 0 {
+		userInfoCache = NewUserInfoCache(config.UserInfoCacheTTL, config.UserInfoCacheSize)
+		authProvider = &CachingAuthProvider{AuthProvider: authProvider, Cache: userInfoCache}
+	}
+
+	// initialize the webhook, before any instances can change
+	if config.WebhookURL != "" {
+		webhooks = NewWebhookNotifier(config.WebhookURL, config.WebhookSecret, config.WebhookTimeout)
+		webhooks.Start()
 	}
-	store = sessions.NewCookieStore([]byte(config.SessionKey))
-	store.Options.SameSite = http.SameSiteStrictMode
 
 	// initialize instance manager
-	im = &InstanceManager{}
-	if err := im.Init(); err != nil {
+	if config.DryRun {
+		log.Println("DRY RUN MODE: not connecting to a k8s cluster, instances won't actually be deployed")
+	}
+	im = &InstanceManager{Config: config}
+	if err := im.Init(context.Background()); err != nil {
 		log.Fatalf("couldn't init InstanceManager: %v", err)
 	}
 
+	// the background threads run until chaldeploy is told to shut down
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
 	// start background thread to destroy expired instances
-	go func(im *InstanceManager) {
-		for {
-			if err := im.DestroyExpiredInstances(); err != nil {
-				log.Printf("couldn't destroy expired instances: %v", err)
-				return
-			}
-
-			time.Sleep(time.Duration(1) * time.Minute)
-		}
-	}(im)
+	im.StartReaper(ctx, time.Duration(1)*time.Minute)
+
+	// start background thread to keep the warm pool filled, if enabled
+	im.StartWarmPoolFiller(ctx, time.Duration(30)*time.Second)
+
+	// initialize the rate limiter, pruning teams that have been idle long enough to have a full bucket again.
+	// it's set up even if there's no limit, so one can be set by reloading the config
+	limiter = NewTeamRateLimiter(config.CreateRatePerMinute)
+	limiter.StartPruner(ctx, time.Duration(10)*time.Minute)
+
+	// reload the runtime-safe part of the config on SIGHUP
+	startConfigReloader(ctx)
 
 	// setup router
-	// TODO: admin route to look for things stuck in "Destroying" state
+	h := NewHandlers(im)
 	router.Use(loggingMiddleware)
 	router.HandleFunc("/", indexPage).Methods("GET")
 	router.HandleFunc("/healthcheck", healthCheck).Methods("GET")
+	router.HandleFunc("/healthz", healthCheck).Methods("GET")
+	router.HandleFunc("/readyz", h.readyCheck).Methods("GET")
 	router.Path("/api/auth").Handler(sessionHandler(authRequest)).Methods("POST")
-	router.Path("/api/status").Handler(sessionHandler(statusRequest)).Methods("GET")
-	router.Path("/api/create").Handler(sessionHandler(createInstanceRequest)).Methods("POST")
-	router.Path("/api/extend").Handler(sessionHandler(extendInstanceRequest)).Methods("POST")
-	router.Path("/api/destroy").Handler(sessionHandler(destroyInstanceRequest)).Methods("POST")
+	router.Path("/api/logout").Handler(csrfProtected(h.logoutRequest)).Methods("POST")
+	router.Path("/api/status").Handler(sessionHandler(h.statusRequest)).Methods("GET")
+	router.Path("/api/create").Handler(csrfProtected(rateLimited(limiter, h.createInstanceRequest))).Methods("POST")
+	router.Path("/api/extend").Handler(csrfProtected(rateLimited(limiter, h.extendInstanceRequest))).Methods("POST")
+	router.Path("/api/destroy").Handler(csrfProtected(rateLimited(limiter, h.destroyInstanceRequest))).Methods("POST")
+	router.Path("/api/logs").Handler(rateLimited(limiter, h.logsRequest)).Methods("GET")
+	router.Path("/api/connection").Handler(csrfProtected(rateLimited(limiter, h.connectionRequest))).Methods("POST")
+	router.Path("/api/events").Handler(sessionHandler(h.eventsRequest)).Methods("GET")
+	router.Path("/api/admin/instances").HandlerFunc(adminOnly(h.adminListInstancesRequest)).Methods("GET")
+	router.Path("/api/admin/instances/{teamId}").HandlerFunc(adminOnly(h.adminDestroyInstanceRequest)).Methods("DELETE")
+	if config.MetricsEnabled {
+		registerMetrics(im)
+		router.Handle("/metrics", promhttp.Handler()).Methods("GET")
+	}
 	router.PathPrefix("/").Handler(http.FileServer(http.Dir("./static/")))
 
 	// start the server
-	log.Println("starting server on port 5050")
-	log.Fatalln(http.ListenAndServe(":5050", router))
+	srv := &http.Server{Addr: ":5050", Handler: corsMiddleware(router)}
+	srv.RegisterOnShutdown(instanceEvents.Close) // the event streams would hold up the shutdown otherwise
+	go func() {
+		log.Println("starting server on port 5050")
+		if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalln(err)
+		}
+	}()
+
+	// wait for a signal, then let the in-progress requests and instance operations finish.
+	// the reaper and pruner have already stopped, since ctx is done
+	<-ctx.Done()
+	stop()
+	log.Printf("shutting down, waiting up to %s for requests and instance operations to finish", config.DrainTimeout)
+
+	drainCtx, cancel := context.WithTimeout(context.Background(), config.DrainTimeout)
+	defer cancel()
+	if err := srv.Shutdown(drainCtx); err != nil {
+		log.Printf("couldn't finish all of the requests before shutting down: %v", err)
+	}
+	if err := im.Drain(drainCtx); err != nil {
+		log.Printf("couldn't finish all of the instance operations before shutting down: %v", err)
+	}
+
+	log.Println("shut down")
 }
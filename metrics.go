@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// number of create/extend/destroy operations, by operation
+	metricOperations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "chaldeploy_operations_total",
+		Help: "Number of instance operations, by operation (create, extend, destroy, reap)",
+	}, []string{"operation"})
+
+	// number of failed create/extend/destroy operations, by operation
+	metricOperationFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "chaldeploy_operation_failures_total",
+		Help: "Number of instance operations that failed, by operation (create, extend, destroy, reap)",
+	}, []string{"operation"})
+
+	// number of create/extend/destroy operations that were turned down, by operation
+	metricOperationRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "chaldeploy_operation_rejections_total",
+		Help: "Number of instance operations that were turned down because of the instance's state or a limit, by operation (create, extend, destroy)",
+	}, []string{"operation"})
+
+	// how long it takes for a new instance to become ready
+	metricDeployReadySeconds = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "chaldeploy_deploy_ready_seconds",
+		Help:    "Time from starting to deploy an instance until it is ready",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 10),
+	})
+)
+
+// Count an instance operation, and whether it failed or was turned down. An operation that is turned down (e.g.
+// a create for a team that already has an instance) is the team's mistake, not chaldeploy's, so it isn't a failure
+func recordOperation(operation string, err error) {
+	metricOperations.WithLabelValues(operation).Inc()
+	if isRejection(err) {
+		metricOperationRejections.WithLabelValues(operation).Inc()
+	} else if err != nil {
+		metricOperationFailures.WithLabelValues(operation).Inc()
+	}
+}
+
+// Check if an operation was turned down because of the state of the instance or a limit, instead of failing
+func isRejection(err error) bool {
+	var cooldownErr *CooldownError
+	var teamLimitErr *TeamLimitError
+
+	for _, sentinel := range []error{ErrNoInstance, ErrAlreadyDeployed, ErrBusy, ErrCapacityReached, ErrMaxExtensions, ErrFailed} {
+		if errors.Is(err, sentinel) {
+			return true
+		}
+	}
+
+	return errors.As(err, &cooldownErr) || errors.As(err, &teamLimitErr)
+}
+
+// Register the metrics with the default prometheus registry, including gauges for the instances in each state
+func registerMetrics(im *InstanceManager) {
+	prometheus.MustRegister(metricOperations, metricOperationFailures, metricOperationRejections, metricDeployReadySeconds)
+
+	for _, state := range []InstanceState{Deploying, Running, Destroying, Destroyed, Failed} {
+		state := state
+		prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "chaldeploy_instances",
+			Help:        "Number of instances, by state",
+			ConstLabels: prometheus.Labels{"state": state.String()},
+		}, func() float64 {
+			return float64(im.countInstances(state))
+		}))
+	}
+}
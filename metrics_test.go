@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/captainGeech42/chaldeploy/internal/generic_map"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordOperation(t *testing.T) {
+	ops := testutil.ToFloat64(metricOperations.WithLabelValues("create"))
+	failures := testutil.ToFloat64(metricOperationFailures.WithLabelValues("create"))
+	rejections := testutil.ToFloat64(metricOperationRejections.WithLabelValues("create"))
+
+	recordOperation("create", nil)
+	recordOperation("create", errors.New("asdf"))
+
+	// the team already having an instance (a 409) isn't a failure
+	recordOperation("create", fmt.Errorf("deployment for team is already being modified: %w", ErrBusy))
+	recordOperation("create", &CooldownError{Remaining: time.Minute})
+
+	assert.Equal(t, ops+4, testutil.ToFloat64(metricOperations.WithLabelValues("create")))
+	assert.Equal(t, failures+1, testutil.ToFloat64(metricOperationFailures.WithLabelValues("create")))
+	assert.Equal(t, rejections+2, testutil.ToFloat64(metricOperationRejections.WithLabelValues("create")))
+}
+
+// reaper passes that don't find anything to destroy aren't counted
+func TestRecordReapNoop(t *testing.T) {
+	newTestInstanceManager()
+	ctx := context.Background()
+	_, err := im.CreateDeployment(ctx, "team1", DefaultChallengeId)
+	assert.Nil(t, err)
+
+	reaps := testutil.ToFloat64(metricOperations.WithLabelValues("reap"))
+	destroys := testutil.ToFloat64(metricOperations.WithLabelValues("destroy"))
+
+	// it was extended after the reaper saw it was expired
+	assert.Equal(t, errNothingToDestroy, im.GetDeploymentInstance("team1", DefaultChallengeId).destroyInstance(ctx, &time.Time{}, nil, false))
+	assert.Nil(t, im.ReapExpired(ctx))
+	assert.Nil(t, im.ReapFailed(ctx, time.Now().UTC().Add(time.Hour)))
+	assert.Equal(t, reaps, testutil.ToFloat64(metricOperations.WithLabelValues("reap")))
+
+	// destroying it twice only counts once
+	assert.Nil(t, im.DestroyDeployment(ctx, "team1", DefaultChallengeId))
+	assert.Nil(t, im.DestroyDeployment(ctx, "team1", DefaultChallengeId))
+	assert.Equal(t, destroys+1, testutil.ToFloat64(metricOperations.WithLabelValues("destroy")))
+}
+
+func TestCountInstances(t *testing.T) {
+	im := &InstanceManager{Instances: new(generic_map.MapOf[InstanceKey, *DeploymentInstance])}
+	im.Instances.Store(InstanceKey{TeamId: "team1"}, &DeploymentInstance{State: Running})
+	im.Instances.Store(InstanceKey{TeamId: "team2"}, &DeploymentInstance{State: Running})
+	im.Instances.Store(InstanceKey{TeamId: "team3"}, &DeploymentInstance{State: Destroyed})
+
+	assert.Equal(t, 2, im.countInstances(Running))
+	assert.Equal(t, 0, im.countInstances(Destroying))
+	assert.Equal(t, 1, im.countInstances(Destroyed))
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// label for telling apart the instances of different CTFs on the same cluster, set to config.EventId
+const eventIdLabel = "chaldeploy.captaingee.ch/event-id"
+
+// the length of the hashes in an instance name, plus the dashes before them
+const instanceNameSuffixLen = 2 * (1 + 16)
+
+// Make sure the namespace prefix is a valid DNS-1123 label, and leaves enough room for the rest of the instance names
+func validateNamespacePrefix(prefix string) error {
+	if errs := validation.IsDNS1123Label(prefix); len(errs) > 0 {
+		return fmt.Errorf("%s isn't a valid k8s name: %s", prefix, strings.Join(errs, ", "))
+	}
+	if maxLen := validation.DNS1123LabelMaxLength - instanceNameSuffixLen; len(prefix) > maxLen {
+		return fmt.Errorf("%s is too long: %d (must be at most %d chars)", prefix, len(prefix), maxLen)
+	}
+
+	return nil
+}
+
+// Make sure the event id can be used as a label value
+func validateEventId(eventId string) error {
+	if errs := validation.IsValidLabelValue(eventId); len(errs) > 0 {
+		return fmt.Errorf("%s can't be used in a k8s label: %s", eventId, strings.Join(errs, ", "))
+	}
+
+	return nil
+}
+
+// Get the label selector for the namespaces of this chaldeploy's instances. If an event id is set, only its namespaces are
+// selected, otherwise only the namespaces without one are, so CTFs sharing a cluster don't pick up each other's instances
+func (c *Config) getNamespaceSelector() string {
+	if c.EventId != "" {
+		return fmt.Sprintf("chaldeploy.captaingee.ch/managed-by=yes,%s=%s", eventIdLabel, c.EventId)
+	}
+
+	return fmt.Sprintf("chaldeploy.captaingee.ch/managed-by=yes,!%s", eventIdLabel)
+}
+
+// Get the name used for an instance's namespace, deployment, and service, from the namespace prefix, challenge name, and team id.
+// The name has to be a DNS-1123 label (at most 63 chars), since it's used as the namespace and service names.
+// The team id comes from the scoreboard, so it's hashed rather than cleaned up: stripping or lowercasing characters
+// could make two teams end up with the same name. The hash is lowercase hex, so the name is always valid
+func (c *Config) getInstanceName(spec ChallengeSpec, teamId string) (string, error) {
+	if errs := validation.IsValidLabelValue(teamId); len(errs) > 0 || teamId == "" {
+		// the team id is also used as a label value, so it has to be a valid one
+		return "", fmt.Errorf("team id %q can't be used in a k8s label: %s", teamId, strings.Join(errs, ", "))
+	}
+
+	name := fmt.Sprintf("%s-%s-%s", c.NamespacePrefix, HashString(spec.Name), HashString(teamId))
+
+	if errs := validation.IsDNS1123Label(name); len(errs) > 0 {
+		return "", fmt.Errorf("the instance name for team %s isn't a valid k8s name: %s", teamId, strings.Join(errs, ", "))
+	}
+
+	return name, nil
+}
+
+// Make sure every challenge has a different name. The instance names and labels use a hash of the challenge name,
+// so two challenges with the same name would share (and clobber) each other's instances
+func validateChallengeNames(challenges map[string]ChallengeSpec) error {
+	ids := make([]string, 0, len(challenges))
+	for id := range challenges {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	hashes := map[string]string{}
+	for _, id := range ids {
+		hash := HashString(challenges[id].Name)
+		if other, ok := hashes[hash]; ok {
+			return fmt.Errorf("challenges %s and %s have the same name hash (%s), give them different names", other, id, hash)
+		}
+		hashes[hash] = id
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+func TestGetInstanceName(t *testing.T) {
+	config = &Config{NamespacePrefix: "chaldeploy"}
+	specs := []ChallengeSpec{
+		{Name: "my chal"},
+		{Name: strings.Repeat("a really long challenge name ", 20)},
+		{Name: strings.Repeat("a really long challenge name ", 20) + "2"},
+	}
+	teamIds := []string{
+		"3fa85f64-5717-4562-b3fc-2c963f66afa6",
+		"3FA85F64-5717-4562-B3FC-2C963F66AFA6",
+		"3fa85f6457174562b3fc2c963f66afa6",
+		"1",
+		"team.1",
+		"team_1",
+		"team-1",
+		"team1",
+		"Team1",
+		strings.Repeat("a", 63),
+	}
+
+	seen := map[string]bool{}
+	for _, spec := range specs {
+		for _, teamId := range teamIds {
+			name, err := config.getInstanceName(spec, teamId)
+			assert.Nil(t, err, teamId)
+			assert.Empty(t, validation.IsDNS1123Label(name), name)
+
+			// teams that only differ by case or punctuation don't collide
+			assert.False(t, seen[name], name)
+			seen[name] = true
+		}
+	}
+
+	// team ids that can't be a label value are rejected
+	for _, teamId := range []string{"", "team 1", "../team", "team\n1", "-team", strings.Repeat("a", 64)} {
+		_, err := config.getInstanceName(specs[0], teamId)
+		assert.NotNil(t, err, teamId)
+	}
+}
+
+func TestValidateChallengeNames(t *testing.T) {
+	assert.Nil(t, validateChallengeNames(map[string]ChallengeSpec{"default": {Name: "chal 1"}, "other": {Name: "chal 2"}}))
+	assert.NotNil(t, validateChallengeNames(map[string]ChallengeSpec{"default": {Name: "chal 1"}, "other": {Name: "chal 1"}}))
+}
+
+func TestValidateNamespacePrefix(t *testing.T) {
+	assert.Nil(t, validateNamespacePrefix("chaldeploy"))
+	assert.Nil(t, validateNamespacePrefix(strings.Repeat("a", 29)))
+
+	for _, prefix := range []string{"", "Chaldeploy", "ctf_2022", "-ctf", strings.Repeat("a", 30)} {
+		assert.NotNil(t, validateNamespacePrefix(prefix), prefix)
+	}
+
+	// the longest prefix still makes valid names
+	config = &Config{NamespacePrefix: strings.Repeat("a", 29)}
+	name, err := config.getInstanceName(ChallengeSpec{Name: "my chal"}, "team1")
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(name, config.NamespacePrefix+"-"))
+}
+
+func TestEventIdDiscovery(t *testing.T) {
+	newTestInstanceManager()
+	spec := config.Challenges[DefaultChallengeId]
+
+	config.EventId = "ctf-a"
+	nsA := config.getNamespace("chaldeploy-a", "team1", spec)
+	assert.Equal(t, "ctf-a", nsA.Labels[eventIdLabel])
+	config.EventId = "ctf-b"
+	nsB := config.getNamespace("chaldeploy-b", "team2", spec)
+	config.EventId = ""
+	nsNone := config.getNamespace("chaldeploy-none", "team3", spec)
+	assert.NotContains(t, nsNone.Labels, eventIdLabel)
+
+	teams := func(eventId string) []string {
+		newTestInstanceManager(nsA, nsB, nsNone)
+		config.EventId = eventId
+		assert.Nil(t, im.discoverExistingInstances(context.Background()))
+
+		found := []string{}
+		im.Instances.Range(func(key InstanceKey, di *DeploymentInstance) bool {
+			found = append(found, key.TeamId)
+			return true
+		})
+		return found
+	}
+
+	// each CTF only picks up its own instances
+	assert.Equal(t, []string{"team1"}, teams("ctf-a"))
+	assert.Equal(t, []string{"team2"}, teams("ctf-b"))
+	assert.Equal(t, []string{"team3"}, teams(""))
+}
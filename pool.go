@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// label on the namespaces of warm pool instances, removed once the instance is given to a team
+const warmPoolLabel = "chaldeploy.captaingee.ch/warm-pool"
+
+// Check if a namespace is for an instance in the warm pool, that hasn't been given to a team yet
+func isWarmNamespace(ns *corev1.Namespace) bool {
+	return ns.Labels[warmPoolLabel] == "yes"
+}
+
+// Get the label selector for the namespaces of a challenge's warm pool instances
+func (c *Config) getWarmPoolSelector(spec ChallengeSpec) string {
+	return fmt.Sprintf("%s,%s=yes,chaldeploy.captaingee.ch/chal=%s", c.getNamespaceSelector(), warmPoolLabel, HashString(spec.Name))
+}
+
+// Get a name for a warm pool instance. There's no team yet, so a random suffix is used in place of the team id hash
+// (it's the same length, so the names fit the same way)
+func (c *Config) getWarmInstanceName(spec ChallengeSpec) (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("couldn't generate a name for a warm instance: %v", err)
+	}
+
+	return fmt.Sprintf("%s-%s-%s", c.NamespacePrefix, HashString(spec.Name), hex.EncodeToString(b)), nil
+}
+
+// get the namespace struct for a warm pool instance. it doesn't have a team id label until it's claimed
+func (c *Config) getWarmNamespace(name string, spec ChallengeSpec) *corev1.Namespace {
+	namespace := c.getNamespace(name, "", spec)
+	delete(namespace.Labels, "chaldeploy.captaingee.ch/team-id")
+	namespace.Labels[warmPoolLabel] = "yes"
+
+	return namespace
+}
+
+// Check if any of the templates render differently depending on the team. Warm pool instances are deployed before
+// there's a team, so they can't be used for a challenge with per-team env vars or files
+func templatesUseTeamId(templates map[string]string) bool {
+	a, errA := renderTemplates(templates, EnvTemplateData{TeamID: "a"})
+	b, errB := renderTemplates(templates, EnvTemplateData{TeamID: "b"})
+	if errA != nil || errB != nil {
+		return true
+	}
+
+	return !reflect.DeepEqual(a, b)
+}
+
+// List a challenge's warm pool namespaces that aren't being deleted, oldest first (so the ones that are most likely
+// to be ready are handed out first). Ties are broken by name, so every replica sorts them the same way
+func (im *InstanceManager) listWarmNamespaces(ctx context.Context, spec ChallengeSpec) ([]corev1.Namespace, error) {
+	list, err := im.Clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{LabelSelector: im.Config.getWarmPoolSelector(spec)})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list the warm pool namespaces for %s: %v", spec.Name, err)
+	}
+
+	namespaces := []corev1.Namespace{}
+	for _, ns := range list.Items {
+		if ns.DeletionTimestamp == nil {
+			namespaces = append(namespaces, ns)
+		}
+	}
+
+	sort.Slice(namespaces, func(i, j int) bool {
+		ti, tj := namespaces[i].CreationTimestamp, namespaces[j].CreationTimestamp
+		if !ti.Equal(&tj) {
+			return ti.Before(&tj)
+		}
+		return namespaces[i].Name < namespaces[j].Name
+	})
+
+	return namespaces, nil
+}
+
+// Take an instance from the warm pool for a team, by labelling its namespace with the team id. If one is claimed,
+// di is pointed at it and true is returned. If the pool is empty (or disabled), false is returned, and the instance
+// has to be deployed the usual way
+func (im *InstanceManager) claimWarmInstance(ctx context.Context, di *DeploymentInstance) bool {
+	if im.Config.WarmPoolSize <= 0 {
+		return false
+	}
+
+	namespaces, err := im.listWarmNamespaces(ctx, di.Challenge)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+
+	client := im.Clientset.CoreV1().Namespaces()
+	for _, ns := range namespaces {
+		ns := ns
+		delete(ns.Labels, warmPoolLabel)
+		ns.Labels["chaldeploy.captaingee.ch/team-id"] = di.Key.TeamId
+
+		// the update has the resource version from the list, so it fails with a conflict if another request (or replica)
+		// claimed the namespace first. in that case, try the next one
+		if _, err := client.Update(ctx, &ns, metav1.UpdateOptions{}); apierrors.IsConflict(err) || apierrors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			log.Printf("couldn't claim warm instance %s for %s: %v", ns.Name, di.Key, err)
+			return false
+		}
+
+		di.setNames(ns.Name, ns.Name)
+		logEvent("claimed a warm instance", di.logFields())
+
+		// refill the pool without waiting for the next pass
+		select {
+		case im.warmPoolWake <- struct{}{}:
+		default:
+		}
+
+		return true
+	}
+
+	logEvent("the warm pool is empty, deploying a new instance", di.logFields())
+	return false
+}
+
+// Deploy an instance of a challenge into the warm pool, without a team
+func (im *InstanceManager) createWarmInstance(ctx context.Context, challengeId string, spec ChallengeSpec) error {
+	name, err := im.Config.getWarmInstanceName(spec)
+	if err != nil {
+		return err
+	}
+
+	di := &DeploymentInstance{
+		Key:       InstanceKey{ChallengeId: challengeId},
+		Challenge: spec,
+		AppName:   name,
+		Namespace: name,
+		mu:        &sync.Mutex{},
+		im:        im,
+	}
+
+	if _, err := im.Clientset.CoreV1().Namespaces().Create(ctx, im.Config.getWarmNamespace(name, spec), metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("failed to create the namespace for warm instance %s: %v", name, err)
+	}
+	if err := im.createInstanceResources(ctx, di); err != nil {
+		im.cleanupFailedDeployment(name)
+		return err
+	}
+
+	logEvent("warm instance created", Fields{"challenge_id": challengeId, "namespace": name})
+	return nil
+}
+
+// Top up the warm pool for every challenge to config.WarmPoolSize instances, and delete any extras (e.g., from replicas
+// racing to fill it, or the size being lowered). The newest extras are deleted, and every replica picks the same ones.
+// A challenge that fails is logged and skipped, so it doesn't stop the rest from being filled
+func (im *InstanceManager) FillWarmPool(ctx context.Context) error {
+	challengeIds := make([]string, 0, len(im.Config.Challenges))
+	for id := range im.Config.Challenges {
+		challengeIds = append(challengeIds, id)
+	}
+	sort.Strings(challengeIds)
+
+	var lastErr error = nil
+	numFailed := 0
+	for _, challengeId := range challengeIds {
+		if err := im.fillChallengeWarmPool(ctx, challengeId, im.Config.Challenges[challengeId]); err != nil {
+			logEvent("couldn't fill the warm pool", Fields{"challenge_id": challengeId, "error": err.Error()})
+			lastErr = err
+			numFailed += 1
+		}
+	}
+
+	if lastErr != nil {
+		return fmt.Errorf("failed to fill the warm pool for %d challenge(s), last error: %v", numFailed, lastErr)
+	}
+
+	return nil
+}
+
+// Top up the warm pool for one challenge, and delete its extras
+func (im *InstanceManager) fillChallengeWarmPool(ctx context.Context, challengeId string, spec ChallengeSpec) error {
+	namespaces, err := im.listWarmNamespaces(ctx, spec)
+	if err != nil {
+		return err
+	}
+
+	for i := len(namespaces); i < im.Config.WarmPoolSize; i++ {
+		if err := im.createWarmInstance(ctx, challengeId, spec); err != nil {
+			return err
+		}
+	}
+
+	for i := im.Config.WarmPoolSize; i < len(namespaces); i++ {
+		// the precondition makes sure it wasn't claimed since it was listed
+		ns := namespaces[i]
+		err := im.Clientset.CoreV1().Namespaces().Delete(ctx, ns.Name, metav1.DeleteOptions{
+			Preconditions: &metav1.Preconditions{ResourceVersion: &ns.ResourceVersion},
+		})
+		if apierrors.IsConflict(err) || apierrors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("couldn't delete extra warm instance %s: %v", ns.Name, err)
+		}
+		logEvent("deleted an extra warm instance", Fields{"challenge_id": challengeId, "namespace": ns.Name})
+	}
+
+	return nil
+}
+
+// Keep the warm pool filled in the background. It's checked every interval, and right after an instance is taken from it
+func (im *InstanceManager) StartWarmPoolFiller(ctx context.Context, interval time.Duration) {
+	if im.Config.WarmPoolSize <= 0 || im.Config.DryRun {
+		return
+	}
+
+	im.warmPoolWake = make(chan struct{}, 1)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			if err := im.FillWarmPool(ctx); err != nil {
+				log.Printf("couldn't fill the warm pool: %v", err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-im.warmPoolWake:
+			}
+		}
+	}()
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestTemplatesUseTeamId(t *testing.T) {
+	assert.False(t, templatesUseTeamId(nil))
+	assert.False(t, templatesUseTeamId(map[string]string{"A": "asdf", "B": "{{.ChallengeID}}-{{.AppName}}"}))
+	assert.True(t, templatesUseTeamId(map[string]string{"A": "asdf", "B": "team {{.TeamID}}"}))
+	assert.True(t, templatesUseTeamId(map[string]string{"A": "{{.Asdf}}"}))
+}
+
+func TestFillWarmPool(t *testing.T) {
+	clientset := newTestInstanceManager()
+	config.WarmPoolSize = 2
+	ctx := context.Background()
+	spec := config.Challenges[DefaultChallengeId]
+
+	assert.Nil(t, im.FillWarmPool(ctx))
+	namespaces, err := im.listWarmNamespaces(ctx, spec)
+	assert.Nil(t, err)
+	assert.Len(t, namespaces, 2)
+
+	// the warm instances don't belong to a team, and aren't picked up as instances on a restart
+	for _, ns := range namespaces {
+		assert.Regexp(t, `^chaldeploy-`+HashString("my chal")+`-[0-9a-f]{16}$`, ns.Name)
+		assert.NotContains(t, ns.Labels, "chaldeploy.captaingee.ch/team-id")
+		_, err := clientset.AppsV1().Deployments(ns.Name).Get(ctx, ns.Name, metav1.GetOptions{})
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, im.discoverExistingInstances(ctx))
+	assert.Equal(t, 0, im.countInstances(Running))
+
+	// already full, so nothing changes
+	assert.Nil(t, im.FillWarmPool(ctx))
+	namespaces, err = im.listWarmNamespaces(ctx, spec)
+	assert.Nil(t, err)
+	assert.Len(t, namespaces, 2)
+
+	// extras are deleted
+	config.WarmPoolSize = 1
+	assert.Nil(t, im.FillWarmPool(ctx))
+	namespaces, err = im.listWarmNamespaces(ctx, spec)
+	assert.Nil(t, err)
+	assert.Len(t, namespaces, 1)
+}
+
+func TestFillWarmPoolFailure(t *testing.T) {
+	clientset := newTestInstanceManager()
+	config.WarmPoolSize = 1
+	config.Challenges["broken"] = ChallengeSpec{Name: "broken chal", Image: "captaingeech/test-chal:latest", Port: 1337}
+	ctx := context.Background()
+
+	brokenPrefix := "chaldeploy-" + HashString("broken chal")
+	clientset.PrependReactor("create", "namespaces", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if strings.HasPrefix(action.(k8stesting.CreateAction).GetObject().(*corev1.Namespace).Name, brokenPrefix) {
+			return true, nil, errors.New("nope")
+		}
+		return false, nil, nil
+	})
+
+	// the broken challenge is sorted first, but the other one is still filled
+	err := im.FillWarmPool(ctx)
+	assert.ErrorContains(t, err, "failed to fill the warm pool for 1 challenge(s)")
+	namespaces, err := im.listWarmNamespaces(ctx, config.Challenges[DefaultChallengeId])
+	assert.Nil(t, err)
+	assert.Len(t, namespaces, 1)
+}
+
+func TestClaimWarmInstance(t *testing.T) {
+	clientset := newTestInstanceManager()
+	config.WarmPoolSize = 1
+	ctx := context.Background()
+	spec := config.Challenges[DefaultChallengeId]
+
+	assert.Nil(t, im.FillWarmPool(ctx))
+	namespaces, err := im.listWarmNamespaces(ctx, spec)
+	assert.Nil(t, err)
+	warmName := namespaces[0].Name
+
+	// the first team gets the warm instance
+	cxn, err := im.CreateDeployment(ctx, "team1", DefaultChallengeId)
+	assert.Nil(t, err)
+	assert.Equal(t, "1.2.3.4:31337", cxn)
+	di := im.GetDeploymentInstance("team1", DefaultChallengeId)
+	assert.Equal(t, warmName, di.Namespace)
+	assert.Equal(t, warmName, di.AppName)
+
+	ns, err := clientset.CoreV1().Namespaces().Get(ctx, warmName, metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, "team1", ns.Labels["chaldeploy.captaingee.ch/team-id"])
+	assert.False(t, isWarmNamespace(ns))
+	assert.Equal(t, di.ExpTime.Format(time.RFC3339), ns.Annotations[expiresAtAnnotation])
+
+	// the pool is empty now, so the second team gets a new instance
+	_, err = im.CreateDeployment(ctx, "team2", DefaultChallengeId)
+	assert.Nil(t, err)
+	di = im.GetDeploymentInstance("team2", DefaultChallengeId)
+	assert.Equal(t, "chaldeploy-"+HashString("my chal")+"-"+HashString("team2"), di.Namespace)
+
+	// the claimed instance is picked up on a restart like any other
+	im.Instances.Delete(InstanceKey{TeamId: "team1", ChallengeId: DefaultChallengeId})
+	assert.Nil(t, im.discoverExistingInstances(ctx))
+	di = im.GetDeploymentInstance("team1", DefaultChallengeId)
+	assert.Equal(t, warmName, di.Namespace)
+	assert.Equal(t, Running, di.State)
+
+	// once it's destroyed, the team's next instance isn't reusing the warm instance's name
+	assert.Nil(t, im.DestroyDeployment(ctx, "team1", DefaultChallengeId))
+	_, err = im.CreateDeployment(ctx, "team1", DefaultChallengeId)
+	assert.Nil(t, err)
+	di = im.GetDeploymentInstance("team1", DefaultChallengeId)
+	assert.Equal(t, "chaldeploy-"+HashString("my chal")+"-"+HashString("team1"), di.Namespace)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// name of the port for challenges that only set a single port
+const defaultPortName = "chal"
+
+// InstancePort is a publicly exposed port of a running instance
+type InstancePort struct {
+	// name of the port, from the challenge's PortSpec
+	Name string
+
+	// port to connect to, which is the node port for NodePort services
+	Port int
+}
+
+// Get the ports for a challenge, with the protocols filled in. A challenge that only sets "port" has a single
+// public port, named "chal"
+func (c *Config) getPorts(spec ChallengeSpec) []PortSpec {
+	if len(spec.Ports) == 0 {
+		return []PortSpec{{Name: defaultPortName, ContainerPort: spec.Port, Protocol: string(c.getProtocol(spec)), Public: true}}
+	}
+
+	ports := make([]PortSpec, len(spec.Ports))
+	for i, p := range spec.Ports {
+		ports[i] = p
+		if p.Protocol == "" {
+			ports[i].Protocol = string(c.getProtocol(spec))
+		}
+	}
+
+	return ports
+}
+
+// Get the ports for a challenge that are exposed by its service
+func (c *Config) getPublicPorts(spec ChallengeSpec) []PortSpec {
+	ports := []PortSpec{}
+	for _, p := range c.getPorts(spec) {
+		if p.Public {
+			ports = append(ports, p)
+		}
+	}
+
+	return ports
+}
+
+// Get the main port for a challenge, which is the first public one. It's used for the probes and the ingress,
+// and is what's shown to teams if the challenge only has one public port. The ports are validated at startup,
+// so there's always a public port
+func (c *Config) getPrimaryPort(spec ChallengeSpec) PortSpec {
+	if ports := c.getPublicPorts(spec); len(ports) > 0 {
+		return ports[0]
+	}
+
+	return PortSpec{}
+}
+
+// get the container ports for a challenge container
+func (c *Config) getContainerPorts(spec ChallengeSpec) []corev1.ContainerPort {
+	ports := []corev1.ContainerPort{}
+	for _, p := range c.getPorts(spec) {
+		ports = append(ports, corev1.ContainerPort{Name: p.Name, ContainerPort: int32(p.ContainerPort), Protocol: corev1.Protocol(p.Protocol)})
+	}
+
+	return ports
+}
+
+// get the service ports for a challenge's public ports
+func (c *Config) getServicePorts(spec ChallengeSpec) []corev1.ServicePort {
+	ports := []corev1.ServicePort{}
+	for _, p := range c.getPublicPorts(spec) {
+		ports = append(ports, corev1.ServicePort{
+			Name:       p.Name,
+			Port:       int32(p.ContainerPort),
+			TargetPort: intstr.FromInt(p.ContainerPort),
+			Protocol:   corev1.Protocol(p.Protocol),
+		})
+	}
+
+	return ports
+}
+
+// Get the ports to connect to for each of the ports on a service, once it's been assigned an address.
+// Like getServiceCxnInfo, NodePort services use the node ports
+func getInstancePorts(service *corev1.Service) []InstancePort {
+	ports := []InstancePort{}
+	for _, p := range service.Spec.Ports {
+		port := int(p.Port)
+		if service.Spec.Type == corev1.ServiceTypeNodePort {
+			port = int(p.NodePort)
+		}
+		ports = append(ports, InstancePort{Name: p.Name, Port: port})
+	}
+
+	return ports
+}
+
+// Make sure a challenge has valid ports: either a single port, or a list of uniquely named ports with at least one public
+func (c *Config) validatePorts(spec ChallengeSpec) error {
+	if len(spec.Ports) == 0 {
+		if !IsValidPort(spec.Port) {
+			return fmt.Errorf("the port is invalid: %d (must be 1-65535)", spec.Port)
+		}
+		return nil
+	}
+
+	if spec.Port != 0 {
+		return fmt.Errorf("only one of port or ports can be set")
+	}
+
+	names := map[string]bool{}
+	containerPorts := map[string]bool{}
+	hasPublic := false
+	for _, p := range spec.Ports {
+		if errs := validation.IsValidPortName(p.Name); len(errs) > 0 {
+			return fmt.Errorf("%s isn't a valid port name: %s", p.Name, strings.Join(errs, ", "))
+		}
+		if names[p.Name] {
+			return fmt.Errorf("there's more than one port named %s", p.Name)
+		}
+		names[p.Name] = true
+
+		if !IsValidPort(p.ContainerPort) {
+			return fmt.Errorf("the port for %s is invalid: %d (must be 1-65535)", p.Name, p.ContainerPort)
+		}
+		if p.Protocol != "" && !isValidProtocol(p.Protocol) {
+			return fmt.Errorf("the protocol for %s is invalid: %s (must be TCP or UDP)", p.Name, p.Protocol)
+		}
+
+		protocol := p.Protocol
+		if protocol == "" {
+			protocol = string(c.getProtocol(spec))
+		}
+		key := fmt.Sprintf("%d/%s", p.ContainerPort, protocol)
+		if containerPorts[key] {
+			return fmt.Errorf("port %d is used more than once", p.ContainerPort)
+		}
+		containerPorts[key] = true
+
+		hasPublic = hasPublic || p.Public
+	}
+
+	if !hasPublic {
+		return fmt.Errorf("at least one port has to be public")
+	}
+
+	return nil
+}
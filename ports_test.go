@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestSinglePort(t *testing.T) {
+	config = &Config{ChallengeProtocol: "TCP"}
+	spec := ChallengeSpec{Name: "my chal", Port: 31337}
+
+	assert.Equal(t, []PortSpec{{Name: "chal", ContainerPort: 31337, Protocol: "TCP", Public: true}}, config.getPorts(spec))
+	assert.Equal(t, 31337, config.getPrimaryPort(spec).ContainerPort)
+	assert.Nil(t, config.validatePorts(spec))
+}
+
+func TestMultiplePorts(t *testing.T) {
+	config = &Config{ChallengeProtocol: "TCP", ReadinessProbeTCP: true}
+	spec := ChallengeSpec{Name: "my chal", Image: "captaingeech/test-nc:latest", Ports: []PortSpec{
+		{Name: "debug", ContainerPort: 9000},
+		{Name: "http", ContainerPort: 8080, Public: true},
+		{Name: "game", ContainerPort: 7777, Protocol: "UDP", Public: true},
+	}}
+	assert.Nil(t, config.validatePorts(spec))
+
+	// every port is on the container
+	container := config.getDeployment("chaldeploy-test", "team-id", spec, nil).Spec.Template.Spec.Containers[0]
+	assert.Equal(t, []corev1.ContainerPort{
+		{Name: "debug", ContainerPort: 9000, Protocol: corev1.ProtocolTCP},
+		{Name: "http", ContainerPort: 8080, Protocol: corev1.ProtocolTCP},
+		{Name: "game", ContainerPort: 7777, Protocol: corev1.ProtocolUDP},
+	}, container.Ports)
+
+	// the probe uses the first public port
+	assert.Equal(t, 8080, container.ReadinessProbe.TCPSocket.Port.IntValue())
+
+	// only the public ports are on the service and allowed by the network policy
+	service := config.getService("chaldeploy-test", "team-id", spec)
+	assert.Len(t, service.Spec.Ports, 2)
+	assert.Equal(t, "http", service.Spec.Ports[0].Name)
+	assert.Equal(t, int32(8080), service.Spec.Ports[0].Port)
+	assert.Equal(t, "game", service.Spec.Ports[1].Name)
+	assert.Equal(t, corev1.ProtocolUDP, service.Spec.Ports[1].Protocol)
+
+	policyPorts := config.getNetworkPolicy("chaldeploy-test", "team-id", spec).Spec.Ingress[0].Ports
+	assert.Len(t, policyPorts, 2)
+	assert.Equal(t, 7777, policyPorts[1].Port.IntValue())
+
+	// each public port is in the connection string
+	service.Spec.Type = corev1.ServiceTypeNodePort
+	service.Spec.Ports[0].NodePort = 30001
+	service.Spec.Ports[1].NodePort = 30002
+	di := &DeploymentInstance{Hostname: "1.2.3.4", Port: 30001, Ports: getInstancePorts(service)}
+	assert.Equal(t, "1.2.3.4:30001 (http), 1.2.3.4:30002 (game)", di.GetCxn())
+}
+
+func TestValidatePorts(t *testing.T) {
+	config = &Config{ChallengeProtocol: "TCP"}
+
+	for _, spec := range []ChallengeSpec{
+		{Port: 0},
+		{Port: 65536},
+		{Port: 1337, Ports: []PortSpec{{Name: "http", ContainerPort: 8080, Public: true}}},
+		{Ports: []PortSpec{{Name: "http", ContainerPort: 8080}}},
+		{Ports: []PortSpec{{Name: "http", ContainerPort: 8080, Public: true}, {Name: "http", ContainerPort: 8081}}},
+		{Ports: []PortSpec{{Name: "http", ContainerPort: 8080, Public: true}, {Name: "other", ContainerPort: 8080, Protocol: "TCP"}}},
+		{Ports: []PortSpec{{Name: "not a name", ContainerPort: 8080, Public: true}}},
+		{Ports: []PortSpec{{Name: "http", ContainerPort: 0, Public: true}}},
+		{Ports: []PortSpec{{Name: "http", ContainerPort: 8080, Protocol: "SCTP", Public: true}}},
+	} {
+		assert.NotNil(t, config.validatePorts(spec), spec)
+	}
+
+	// the same port number can be used for TCP and UDP
+	assert.Nil(t, config.validatePorts(ChallengeSpec{Ports: []PortSpec{
+		{Name: "tcp", ContainerPort: 8080, Public: true},
+		{Name: "udp", ContainerPort: 8080, Protocol: "UDP", Public: true},
+	}}))
+}
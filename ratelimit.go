@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"golang.org/x/time/rate"
+)
+
+// rate limiter state for a single team
+type teamLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// TeamRateLimiter is a per-team token bucket rate limiter
+type TeamRateLimiter struct {
+	// how many requests a team can make per minute (and in a burst), 0 for no limit
+	perMinute int
+
+	// lock for perMinute and the limiters map
+	mu sync.Mutex
+
+	// map of team id -> limiter
+	limiters map[string]*teamLimiter
+}
+
+// Create a rate limiter that allows each team perMinute requests per minute (or any number of them, if it's 0)
+func NewTeamRateLimiter(perMinute int) *TeamRateLimiter {
+	return &TeamRateLimiter{
+		perMinute: perMinute,
+		limiters:  map[string]*teamLimiter{},
+	}
+}
+
+// Take a token from the team's bucket, if there is one
+// If the team is out of tokens, returns false and how long until the next token is available
+func (l *TeamRateLimiter) Allow(teamId string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.perMinute <= 0 {
+		return true, 0
+	}
+
+	tl, ok := l.limiters[teamId]
+	if !ok {
+		tl = &teamLimiter{limiter: rate.NewLimiter(rate.Limit(float64(l.perMinute)/60), l.perMinute)}
+		l.limiters[teamId] = tl
+	}
+
+	now := time.Now()
+	tl.lastSeen = now
+
+	r := tl.limiter.ReserveN(now, 1)
+	if delay := r.DelayFrom(now); delay > 0 {
+		// don't hold onto the token, the request isn't going to wait for it
+		r.CancelAt(now)
+		return false, delay
+	}
+
+	return true, 0
+}
+
+// Change how many requests each team can make per minute, for a config reload. The teams' buckets keep their tokens,
+// up to the new burst size
+func (l *TeamRateLimiter) SetRate(perMinute int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if perMinute == l.perMinute {
+		return
+	}
+
+	l.perMinute = perMinute
+	for _, tl := range l.limiters {
+		tl.limiter.SetLimit(rate.Limit(float64(perMinute) / 60))
+		tl.limiter.SetBurst(perMinute)
+	}
+}
+
+// Remove the state for teams that haven't made a request in a while
+// A team that has been idle long enough has a full bucket again, so this doesn't change any limits
+func (l *TeamRateLimiter) Prune(idle time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	cutoff := time.Now().Add(-idle)
+	for teamId, tl := range l.limiters {
+		if tl.lastSeen.Before(cutoff) {
+			delete(l.limiters, teamId)
+		}
+	}
+}
+
+// Start a background goroutine that prunes idle teams on an interval, until the context is cancelled
+func (l *TeamRateLimiter) StartPruner(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				l.Prune(interval)
+			}
+		}
+	}()
+}
+
+// Wrap a handler with the per-team rate limit. If the team is over the limit, a 429 is returned with a
+// Retry-After header. Unauthenticated requests are passed through, since the handler rejects them anyways
+func rateLimited(limiter *TeamRateLimiter, h sessionHandler) sessionHandler {
+	return func(w http.ResponseWriter, r *http.Request, s *sessions.Session) {
+		if limiter != nil {
+			if teamId, ok := getSessionTeamId(s); ok {
+				if allowed, delay := limiter.Allow(teamId); !allowed {
+					log.Printf("rate limiting %s (ID: %s), retry after %s", s.Values["teamName"], teamId, delay)
+					retryAfter := int(math.Ceil(delay.Seconds()))
+					w.Header().Set("Retry-After", fmt.Sprintf("%d", retryAfter))
+					writeJSONError(w, http.StatusTooManyRequests, errCodeRateLimited, fmt.Sprintf("too many requests, try again in %d seconds", retryAfter))
+					return
+				}
+			}
+		}
+
+		h(w, r, s)
+	}
+}
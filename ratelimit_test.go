@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTeamRateLimiter(t *testing.T) {
+	l := NewTeamRateLimiter(2)
+
+	// burst is the per minute rate
+	allowed, _ := l.Allow("team1")
+	assert.True(t, allowed)
+	allowed, _ = l.Allow("team1")
+	assert.True(t, allowed)
+
+	allowed, delay := l.Allow("team1")
+	assert.False(t, allowed)
+	assert.Greater(t, delay, time.Duration(0))
+	assert.LessOrEqual(t, delay, 30*time.Second)
+
+	// other teams have their own bucket
+	allowed, _ = l.Allow("team2")
+	assert.True(t, allowed)
+
+	// pruning only removes idle teams
+	l.Prune(time.Hour)
+	assert.Len(t, l.limiters, 2)
+	l.limiters["team2"].lastSeen = time.Now().Add(-2 * time.Hour)
+	l.Prune(time.Hour)
+	assert.Len(t, l.limiters, 1)
+	assert.Contains(t, l.limiters, "team1")
+}
+
+func TestTeamRateLimiterSetRate(t *testing.T) {
+	l := NewTeamRateLimiter(1)
+	allowed, _ := l.Allow("team1")
+	assert.True(t, allowed)
+	allowed, _ = l.Allow("team1")
+	assert.False(t, allowed)
+
+	// a higher rate applies to the teams that already have a bucket, so they get a token back sooner
+	l.SetRate(60)
+	allowed, delay := l.Allow("team1")
+	assert.False(t, allowed)
+	assert.LessOrEqual(t, delay, time.Second)
+
+	// 0 means no limit
+	l.SetRate(0)
+	for i := 0; i < 100; i++ {
+		allowed, _ = l.Allow("team2")
+		assert.True(t, allowed)
+	}
+}
+
+func TestRateLimitedHandler(t *testing.T) {
+	ok := func(w http.ResponseWriter, r *http.Request, s *sessions.Session) {
+		w.WriteHeader(http.StatusOK)
+	}
+	h := rateLimited(NewTeamRateLimiter(1), ok)
+
+	s := sessions.NewSession(sessions.NewCookieStore([]byte("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")), "session")
+	s.IsNew = false
+	s.Values["id"] = "team1"
+
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest(http.MethodPost, "/api/create", nil), s)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	h(w, httptest.NewRequest(http.MethodPost, "/api/create", nil), s)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+
+	// unauthenticated requests go through to the handler
+	w = httptest.NewRecorder()
+	h(w, httptest.NewRequest(http.MethodPost, "/api/create", nil), sessions.NewSession(nil, "session"))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// no limiter
+	w = httptest.NewRecorder()
+	rateLimited(nil, ok)(w, httptest.NewRequest(http.MethodPost, "/api/create", nil), s)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
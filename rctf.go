@@ -2,8 +2,8 @@ package main
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -17,7 +17,7 @@ type RctfResponse struct {
 
 // Data from /api/v1/auth/login
 type RctfAuthData struct {
-	AuthToken string `json:"authToken"`
+	AuthToken Secret `json:"authToken"`
 }
 
 // Response to /api/v1/auth/login
@@ -38,13 +38,22 @@ type RctfUserInfoResponse struct {
 	Data RctfUserInfoData `json:"data"`
 }
 
+// RctfProvider authenticates teams with their rCTF login token
+type RctfProvider struct {
+	// base url of the rCTF server, without a trailing slash
+	Url string
+
+	// client for the rCTF API, with a timeout so a slow server can't hang auth requests
+	Client *http.Client
+}
+
 // Validate the login token from the user and get a auth token back
-// If there is an error getting an auth token, returns (nil, error)
+// If there is an error getting an auth token, returns ("", error)
 // If comms are successful but auth is bad, returns ("", nil)
 // Otherwise, returns (authToken, nil)
-func authToRctf(loginToken string) (string, error) {
-	if config == nil {
-		return "", errors.New("config global isn't set")
+func (p *RctfProvider) Authenticate(loginToken string) (string, error) {
+	if err := validateRctfLoginToken(loginToken); err != nil {
+		return "", err
 	}
 
 	reqBody, err := json.Marshal(map[string]string{
@@ -55,7 +64,14 @@ func authToRctf(loginToken string) (string, error) {
 		return "", err
 	}
 
-	resp, err := http.Post(config.RctfServer+"/api/v1/auth/login", "application/json", bytes.NewBuffer(reqBody))
+	resp, err := doScoreboardRequest(p.Client, func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodPost, p.Url+"/api/v1/auth/login", bytes.NewReader(reqBody))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	})
 	if err != nil {
 		return "", err
 	}
@@ -76,43 +92,53 @@ func authToRctf(loginToken string) (string, error) {
 		return "", nil
 	}
 
-	return rctfResp.Data.AuthToken, nil
+	return string(rctfResp.Data.AuthToken), nil
 }
 
-// Get user info from the rCTF API
-func getUserInfo(authToken string) (*RctfUserInfoData, error) {
-	if config == nil {
-		return nil, errors.New("config global isn't set")
+// Make sure a login token looks like one rCTF would generate, so junk isn't sent to the API
+// rCTF login tokens are standard base64
+func validateRctfLoginToken(loginToken string) error {
+	decoded, err := base64.StdEncoding.DecodeString(loginToken)
+	if err != nil {
+		return fmt.Errorf("login token isn't valid base64: %w", ErrMalformedToken)
 	}
 
-	req, err := http.NewRequest(http.MethodGet, config.RctfServer+"/api/v1/users/me", nil)
-	if err != nil {
-		return nil, err
+	if len(decoded) == 0 {
+		return fmt.Errorf("login token is empty: %w", ErrMalformedToken)
 	}
 
-	req.Header.Set("Authorization", "Bearer "+authToken)
+	return nil
+}
 
-	client := http.Client{}
-	resp, err := client.Do(req)
+// Get user info from the rCTF API
+func (p *RctfProvider) UserInfo(authToken string) (UserInfo, error) {
+	resp, err := doScoreboardRequest(p.Client, func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodGet, p.Url+"/api/v1/users/me", nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+authToken)
+		return req, nil
+	})
 	if err != nil {
-		return nil, err
+		return UserInfo{}, err
 	}
 	defer resp.Body.Close()
 
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
+		return UserInfo{}, err
 	}
 
 	rctfResp := RctfUserInfoResponse{}
 	err = json.Unmarshal(respBody, &rctfResp)
 	if err != nil {
-		return nil, err
+		return UserInfo{}, err
 	}
 
 	if rctfResp.Kind != "goodUserData" {
-		return nil, fmt.Errorf("got bad data from rCTF api (%s): %s", rctfResp.Kind, rctfResp.Message)
+		return UserInfo{}, fmt.Errorf("got bad data from rCTF api (%s): %s", rctfResp.Kind, rctfResp.Message)
 	}
 
-	return &rctfResp.Data, nil
+	return UserInfo{TeamName: rctfResp.Data.TeamName, Id: rctfResp.Data.Id}, nil
 }
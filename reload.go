@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// The settings that can be changed without restarting chaldeploy, by sending it a SIGHUP. They only affect what happens
+// from then on: the running instances keep their expiration times and resources, and new ones get the new values.
+// Everything else only takes effect on a restart. Each field has the same name and type as the one in Config
+type reloadableSettings struct {
+	InstanceTTL             time.Duration
+	ExtendDuration          time.Duration
+	MaxTTL                  time.Duration
+	MaxExtensions           int
+	ExpiryWarningWindow     time.Duration
+	RedeployCooldown        time.Duration
+	MaxConcurrentInstances  int
+	MaxInstancesPerTeam     int
+	CreateRatePerMinute     int
+	CPULimit                string
+	MemoryLimit             string
+	CPURequest              string
+	MemoryRequest           string
+	EphemeralStorageLimit   string
+	EphemeralStorageRequest string
+}
+
+// the names of the Config fields that can be reloaded, from reloadableSettings
+var reloadableFields = getReloadableFields()
+
+func getReloadableFields() []string {
+	t := reflect.TypeOf(reloadableSettings{})
+	fields := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		fields = append(fields, t.Field(i).Name)
+	}
+
+	return fields
+}
+
+// lock for changing the reloadable settings in the config while chaldeploy is running (the instance manager shares the
+// config global with main). they're read through snapshot(), and the other settings never change once it has started,
+// so they're read directly
+var configMu sync.RWMutex
+
+// Get a copy of the reloadable settings. The copy is consistent, so a reload in the middle of an operation doesn't
+// mix old and new values
+func (c *Config) snapshot() reloadableSettings {
+	configMu.RLock()
+	defer configMu.RUnlock()
+
+	return reloadableSettings{
+		InstanceTTL:             c.InstanceTTL,
+		ExtendDuration:          c.ExtendDuration,
+		MaxTTL:                  c.MaxTTL,
+		MaxExtensions:           c.MaxExtensions,
+		ExpiryWarningWindow:     c.ExpiryWarningWindow,
+		RedeployCooldown:        c.RedeployCooldown,
+		MaxConcurrentInstances:  c.MaxConcurrentInstances,
+		MaxInstancesPerTeam:     c.MaxInstancesPerTeam,
+		CreateRatePerMinute:     c.CreateRatePerMinute,
+		CPULimit:                c.CPULimit,
+		MemoryLimit:             c.MemoryLimit,
+		CPURequest:              c.CPURequest,
+		MemoryRequest:           c.MemoryRequest,
+		EphemeralStorageLimit:   c.EphemeralStorageLimit,
+		EphemeralStorageRequest: c.EphemeralStorageRequest,
+	}
+}
+
+// Apply the reloadable settings from a freshly loaded config to the config global.
+// Returns the names of the settings that were changed, and of the ones that changed but need a restart to take effect
+func applyReloadedConfig(newConfig *Config) (changed []string, needRestart []string) {
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	cur := reflect.ValueOf(config).Elem()
+	next := reflect.ValueOf(newConfig).Elem()
+	t := cur.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Name
+		if reflect.DeepEqual(cur.Field(i).Interface(), next.Field(i).Interface()) {
+			continue
+		}
+
+		if Contains(reloadableFields, name) {
+			cur.Field(i).Set(next.Field(i))
+			changed = append(changed, name)
+		} else {
+			needRestart = append(needRestart, name)
+		}
+	}
+
+	return changed, needRestart
+}
+
+// Re-read the config (from the env and the config file, the same way as at startup) and apply the reloadable settings.
+// The new config is validated on its own values (e.g. its requests against its limits). If it isn't valid, nothing is changed
+func reloadConfig() error {
+	newConfig, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if err := newConfig.Validate(); err != nil {
+		return err
+	}
+
+	changed, needRestart := applyReloadedConfig(newConfig)
+	if limiter != nil {
+		limiter.SetRate(newConfig.CreateRatePerMinute)
+	}
+
+	if len(changed) == 0 {
+		logEvent("reloaded the config, nothing changed", Fields{})
+	} else {
+		logEvent("reloaded the config", Fields{"changed": strings.Join(changed, ",")})
+	}
+	if len(needRestart) > 0 {
+		log.Printf("WARNING: these settings changed, but need a restart to take effect: %s", strings.Join(needRestart, ", "))
+	}
+
+	return nil
+}
+
+// Reload the config every time chaldeploy gets a SIGHUP, until the context is done
+func startConfigReloader(ctx context.Context) {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(sighup)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-sighup:
+				if err := reloadConfig(); err != nil {
+					log.Printf("couldn't reload the config, keeping the current one: %v", err)
+				}
+			}
+		}
+	}()
+}
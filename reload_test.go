@@ -0,0 +1,84 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReloadConfig(t *testing.T) {
+	loadValidConfig(t)
+	limiter = NewTeamRateLimiter(config.CreateRatePerMinute)
+	t.Cleanup(func() { limiter = nil })
+
+	// the runtime-safe settings are applied, the others are left alone until a restart
+	t.Setenv("CHALDEPLOY_INSTANCE_TTL", "2h")
+	t.Setenv("CHALDEPLOY_CPU_LIMIT", "1")
+	t.Setenv("CHALDEPLOY_CREATE_RATE_PER_MINUTE", "10")
+	t.Setenv("CHALDEPLOY_IMAGE", "newimg:latest")
+	assert.Nil(t, reloadConfig())
+	assert.Equal(t, 2*time.Hour, config.InstanceTTL)
+	assert.Equal(t, "1", config.CPULimit)
+	assert.Equal(t, 10, limiter.perMinute)
+	assert.Equal(t, "testimg:latest", config.ChallengeImage)
+	assert.Equal(t, "testimg:latest", config.Challenges[DefaultChallengeId].Image)
+
+	// an invalid config isn't applied at all
+	t.Setenv("CHALDEPLOY_INSTANCE_TTL", "3h")
+	t.Setenv("CHALDEPLOY_CPU_LIMIT", "lots")
+	assert.NotNil(t, reloadConfig())
+	assert.Equal(t, 2*time.Hour, config.InstanceTTL)
+	assert.Equal(t, "1", config.CPULimit)
+
+	// the new requests are checked against the new limits, not the current ones
+	t.Setenv("CHALDEPLOY_CPU_LIMIT", "1")
+	t.Setenv("CHALDEPLOY_MEMORY_LIMIT", "128Mi")
+	t.Setenv("CHALDEPLOY_MEMORY_REQUEST", "256Mi")
+	assert.ErrorContains(t, reloadConfig(), "the memory request (256Mi) is more than the limit (128Mi)")
+	assert.Equal(t, "256Mi", config.MemoryLimit)
+	assert.Equal(t, "64Mi", config.MemoryRequest)
+}
+
+func TestReloadableSettings(t *testing.T) {
+	// every reloadable setting is a Config field, and is copied by snapshot
+	c := &Config{}
+	cv := reflect.ValueOf(c).Elem()
+	settings := reflect.TypeOf(reloadableSettings{})
+	for i := 0; i < settings.NumField(); i++ {
+		name := settings.Field(i).Name
+		field := cv.FieldByName(name)
+		if assert.True(t, field.IsValid(), name) {
+			assert.Equal(t, settings.Field(i).Type, field.Type(), name)
+			switch field.Kind() {
+			case reflect.String:
+				field.SetString(name)
+			default:
+				field.SetInt(int64(len(name)))
+			}
+		}
+	}
+
+	snapshot := reflect.ValueOf(c.snapshot())
+	for _, name := range reloadableFields {
+		assert.Equal(t, cv.FieldByName(name).Interface(), snapshot.FieldByName(name).Interface(), name)
+	}
+}
+
+func TestApplyReloadedConfig(t *testing.T) {
+	config = &Config{InstanceTTL: time.Hour, MaxExtensions: 1, ChallengeName: "my chal"}
+
+	changed, needRestart := applyReloadedConfig(&Config{InstanceTTL: time.Hour, MaxExtensions: 2, ChallengeName: "new chal", DryRun: true})
+	assert.Equal(t, []string{"MaxExtensions"}, changed)
+	assert.Equal(t, []string{"ChallengeName", "DryRun"}, needRestart)
+	assert.Equal(t, 2, config.MaxExtensions)
+	assert.Equal(t, "my chal", config.ChallengeName)
+	assert.False(t, config.DryRun)
+
+	// a snapshot isn't affected by later reloads
+	snapshot := config.snapshot()
+	applyReloadedConfig(&Config{InstanceTTL: 2 * time.Hour})
+	assert.Equal(t, time.Hour, snapshot.InstanceTTL)
+	assert.Equal(t, 2*time.Hour, config.InstanceTTL)
+}
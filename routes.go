@@ -1,16 +1,23 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	// deliberately using this instead of html/template to leave html comments in more easily.
 	// templated data is not user controlled
 	"text/template"
 
+	"fmt"
 	"io"
+	"math"
+	"mime"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"log"
 
@@ -18,209 +25,493 @@ import (
 )
 
 // don't flame me, i'm lazy
-var cachedIndex = ""
+// map of challenge id -> rendered index page
+var cachedIndex = map[string]string{}
 var cachedIndexLock sync.Mutex
 
+// The API handlers that manage instances, with the instance manager they use
+type Handlers struct {
+	im *InstanceManager
+}
+
+func NewHandlers(im *InstanceManager) *Handlers {
+	return &Handlers{im: im}
+}
+
+// data passed into the index template
+type indexTemplateData struct {
+	ChallengeId   string
+	ChallengeName string
+}
+
+// GET /
+// Renders the page for the challenge in the challengeId query parameter (or the default challenge)
 func indexPage(w http.ResponseWriter, r *http.Request) {
 	if config == nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		log.Println("indexPage was called before config was set, can't render template")
+		return
 	}
 
-	// check if the index has been rendered yet
-	if cachedIndex == "" {
-		log.Println("need to render the index page")
-
-		// index hasn't been rendered yet. lock the resource and render it
-		cachedIndexLock.Lock()
-		defer cachedIndexLock.Unlock()
-
-		if cachedIndex == "" {
-			// why do we check it again? good question, smart reader who is smarter than the dingdong who wrote this
-			// method! i think its possible for a second caller of this function to get into this code path by
-			// getting blocked waiting for the lock, and we don't want them to re-render the template if they don't
-			// need to. so, allow them to bail out and prevent re-rendering. stupid? yes. works? probably. need it?
-			// not a clue. have fun.
+	challengeId, ok := getRequestChallengeId(r)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
 
-			log.Println("actually rendering the index page")
+	// check if the index has been rendered yet for this challenge. the lock is held for the whole
+	// render, so a second caller waiting on it will find the page already rendered
+	cachedIndexLock.Lock()
+	defer cachedIndexLock.Unlock()
 
-			t, err := template.ParseFiles("templates/index.html")
-			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
-				log.Printf("failed to parse index template: %v", err)
-				return
-			}
+	if _, ok := cachedIndex[challengeId]; !ok {
+		log.Printf("rendering the index page for %s", challengeId)
 
-			sb := &strings.Builder{}
-			err = t.Execute(sb, config)
-			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
-				log.Printf("failed to render index template: %v", err)
-				return
-			}
+		t, err := template.ParseFiles("templates/index.html")
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			log.Printf("failed to parse index template: %v", err)
+			return
+		}
 
-			cachedIndex = sb.String()
-		} else {
-			log.Println("index page got rendered for me, yeet")
+		sb := &strings.Builder{}
+		err = t.Execute(sb, indexTemplateData{ChallengeId: challengeId, ChallengeName: config.Challenges[challengeId].Name})
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			log.Printf("failed to render index template: %v", err)
+			return
 		}
+
+		cachedIndex[challengeId] = sb.String()
 	}
 
-	w.Write([]byte(cachedIndex))
+	w.Write([]byte(cachedIndex[challengeId]))
 }
 
-// GET /healthcheck
+// GET /healthcheck, /healthz
+// Liveness check, only checks that the process is serving requests
 func healthCheck(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("app good to go"))
 }
 
-// POST /api/auth
-// Takes the auth url/login token, and gets an auth token for the rCTF api
-// Returns back the team name and 200 if successful, otherwise 403/500+
-func authRequest(w http.ResponseWriter, r *http.Request, s *sessions.Session) {
+// GET /readyz
+// Readiness check, returns 503 if the k8s API can't be reached (since instances can't be managed without it)
+func (h *Handlers) readyCheck(w http.ResponseWriter, r *http.Request) {
+	if err := h.im.CheckCluster(r.Context()); err != nil {
+		log.Printf("readiness check failed, couldn't reach the cluster: %v", err)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("can't reach the cluster"))
+		return
+	}
+
+	w.Write([]byte("app good to go"))
+}
+
+// Body of an auth request
+type AuthRequest struct {
+	// the login url or login token
+	Token string `json:"token"`
+}
+
+// Get the login url/token out of an auth request. JSON bodies (with a Content-Type of application/json) are decoded
+// as an AuthRequest, otherwise the whole body is the token, for older clients
+func readAuthRequest(r *http.Request) (string, error) {
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		log.Printf("error handling client auth, couldn't read body: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+		return "", fmt.Errorf("couldn't read body: %v", err)
 	}
 
-	bodyStr := string(body)
-	parts := strings.Split(bodyStr, "/login?token=")
-	loginToken := parts[len(parts)-1]
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		return string(body), nil
+	}
 
-	// check if the token is url encoded, and decode if so
-	if strings.Contains(loginToken, "%") {
-		loginToken, err = url.QueryUnescape(loginToken)
-		if err != nil {
-			log.Printf("error handling client auth, couldn't decode login token: %v", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
+	// the json errors don't include the token, so it's ok to pass them along
+	req := AuthRequest{}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return "", fmt.Errorf("%w: couldn't decode the request: %v", ErrMalformedToken, err)
+	}
+
+	return req.Token, nil
+}
+
+// POST /api/auth
+// Takes the auth url/login token, and gets an auth token from the auth provider (rCTF or CTFd)
+// The body is an AuthRequest as JSON, or just the token as text
+// Returns back the team name and 200 if successful, 400 if the request/token is malformed, otherwise 403/500+
+func authRequest(w http.ResponseWriter, r *http.Request, s *sessions.Session) {
+	body, err := readAuthRequest(r)
+	if errors.Is(err, ErrMalformedToken) {
+		log.Printf("error handling client auth, got a malformed request: %v", err)
+		writeJSONError(w, http.StatusBadRequest, errCodeMalformedToken, "malformed auth request")
+		return
+	} else if err != nil {
+		log.Printf("error handling client auth, %v", err)
+		writeInternalError(w)
+		return
 	}
 
-	authToken, err := authToRctf(loginToken)
+	loginToken, err := parseLoginToken(body)
 	if err != nil {
-		log.Printf("error handling client auth, couldn't auth to rCTF: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("error handling client auth, couldn't parse login token: %v", err)
+		writeJSONError(w, http.StatusBadRequest, errCodeMalformedToken, "that doesn't look like a valid token/url")
+		return
+	}
+
+	authToken, err := authProvider.Authenticate(loginToken)
+	if errors.Is(err, ErrMalformedToken) {
+		log.Printf("error handling client auth, got a malformed login token: %v", err)
+		writeJSONError(w, http.StatusBadRequest, errCodeMalformedToken, "that doesn't look like a valid token/url")
+		return
+	} else if errors.Is(err, ErrScoreboardUnavailable) {
+		log.Printf("error handling client auth, couldn't reach %s: %v", config.AuthProvider, err)
+		writeJSONError(w, http.StatusBadGateway, errCodeScoreboardUnavailable, "couldn't reach the scoreboard, try again in a bit")
+		return
+	} else if err != nil {
+		log.Printf("error handling client auth, couldn't auth to %s: %v", config.AuthProvider, err)
+		writeInternalError(w)
 		return
 	}
 
 	if authToken == "" {
-		w.WriteHeader(http.StatusForbidden)
+		writeJSONError(w, http.StatusForbidden, errCodeAuthFailed, "couldn't auth to the scoreboard, bad token/url?")
 		return
 	}
 
 	// have a valid auth token, get team info
-	userInfo, err := getUserInfo(authToken)
-	if err != nil {
-		log.Printf("error handling client auth, couldn't get user info from rCTF: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+	userInfo, err := authProvider.UserInfo(authToken)
+	if errors.Is(err, ErrScoreboardUnavailable) {
+		log.Printf("error handling client auth, couldn't reach %s: %v", config.AuthProvider, err)
+		writeJSONError(w, http.StatusBadGateway, errCodeScoreboardUnavailable, "couldn't reach the scoreboard, try again in a bit")
+		return
+	} else if err != nil {
+		log.Printf("error handling client auth, couldn't get user info from %s: %v", config.AuthProvider, err)
+		writeInternalError(w)
 		return
 	}
 
-	// save the team data to the user's session
+	// save the team data to the user's session. the auth token isn't needed after this, and isn't saved
+	// since the session cookie is only signed, not encrypted. instances are keyed on the team id, so a team
+	// that auths again (e.g., after its session expired) gets its running instances back
 	s.Values["teamName"] = userInfo.TeamName
 	s.Values["id"] = userInfo.Id
-	s.Values["authToken"] = authToken
+	if config.CSRFEnabled {
+		// new token for each login, so it isn't the same as a token from before
+		if s.Values["csrfToken"], err = newCSRFToken(); err != nil {
+			log.Printf("error handling client auth, %v", err)
+			writeInternalError(w)
+			return
+		}
+		w.Header().Set(csrfHeader, s.Values["csrfToken"].(string))
+	}
 	if err = s.Save(r, w); err != nil {
 		log.Printf("error handling client auth, couldn't save the session: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		writeInternalError(w)
 		return
 	}
 
-	log.Printf("successfully authenticated %s (ID: %s)", userInfo.TeamName, userInfo.Id)
+	logEvent("successfully authenticated", Fields{"team_id": userInfo.Id, "team_name": userInfo.TeamName})
 
 	// send back the team name
 	w.Write([]byte(userInfo.TeamName))
 }
 
+// POST /api/logout
+// Clear the team's session, and forget the cached team info so the next auth gets it from the scoreboard again.
+// If config.DestroyOnLogout is set, the team's running instances are destroyed too
+// Returns 200, even if the session wasn't authenticated, or 500 if an instance couldn't be destroyed
+func (h *Handlers) logoutRequest(w http.ResponseWriter, r *http.Request, s *sessions.Session) {
+	if teamId, ok := getSessionTeamId(s); ok {
+		if userInfoCache != nil {
+			userInfoCache.DeleteTeam(teamId)
+		}
+
+		if config.DestroyOnLogout {
+			if err := h.destroyTeamInstances(r.Context(), teamId); err != nil {
+				logEvent("couldn't destroy instances on logout", Fields{"team_id": teamId, "error": err.Error()})
+				writeInternalError(w)
+				return
+			}
+		}
+
+		logEvent("logged out", Fields{"team_id": teamId, "team_name": s.Values["teamName"]})
+	}
+
+	// expire the session cookie
+	s.Values = map[interface{}]interface{}{}
+	s.Options.MaxAge = -1
+	if err := s.Save(r, w); err != nil {
+		log.Printf("error handling logout, couldn't save the session: %v", err)
+		writeInternalError(w)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// Destroy all of a team's running instances. Every challenge is tried, even if one of them fails
+func (h *Handlers) destroyTeamInstances(ctx context.Context, teamId string) error {
+	var lastErr error
+
+	for challengeId := range config.Challenges {
+		if di := h.im.GetDeploymentInstance(teamId, challengeId); di == nil || di.getState() != Running {
+			continue
+		}
+
+		logEvent("destroying instance", Fields{"team_id": teamId, "challenge_id": challengeId})
+		if err := h.im.DestroyDeployment(ctx, teamId, challengeId); err != nil && !errors.Is(err, ErrNoInstance) {
+			lastErr = fmt.Errorf("couldn't destroy the instance of %s: %w", challengeId, err)
+		}
+	}
+
+	return lastErr
+}
+
+// Get the login token out of the body of an auth request, which is either a login url with a token query parameter or the token itself
+// The token is url decoded if needed, including tokens that were encoded more than once
+func parseLoginToken(body string) (string, error) {
+	loginToken := strings.TrimSpace(body)
+
+	if IsAbsoluteUrl(loginToken) {
+		u, err := url.Parse(loginToken)
+		if err != nil {
+			return "", errors.New("couldn't parse login url")
+		}
+
+		// a raw '+' in the token is part of the token, not an encoded space
+		query, err := url.ParseQuery(strings.ReplaceAll(u.RawQuery, "+", "%2B"))
+		if err != nil {
+			return "", errors.New("couldn't parse the query of the login url, it has an invalid escape")
+		}
+		loginToken = query.Get("token")
+	}
+
+	// check if the token is url encoded, and decode if so. PathUnescape is used so a '+' in the token is kept
+	for i := 0; strings.Contains(loginToken, "%"); i++ {
+		if i == 3 {
+			return "", errors.New("login token is encoded too many times")
+		}
+
+		// the unescape error has part of the token in it, so it isn't passed along
+		decoded, err := url.PathUnescape(loginToken)
+		if err != nil {
+			return "", errors.New("couldn't decode login token, it has an invalid escape")
+		}
+		loginToken = decoded
+	}
+
+	if loginToken == "" {
+		return "", errors.New("login token is empty")
+	}
+
+	return loginToken, nil
+}
+
+// Get the team id for an authenticated session
+// Returns false if the session hasn't been authenticated
+func getSessionTeamId(s *sessions.Session) (string, bool) {
+	if s.IsNew {
+		return "", false
+	}
+
+	teamId, ok := s.Values["id"].(string)
+	return teamId, ok
+}
+
+// Get the id of the challenge a request is for, from the challengeId query parameter
+// This is used by all of the instance routes. If the parameter isn't set, the default challenge is used.
+// Returns false if the challenge isn't configured
+func getRequestChallengeId(r *http.Request) (string, bool) {
+	challengeId := r.URL.Query().Get("challengeId")
+	if challengeId == "" {
+		challengeId = DefaultChallengeId
+	}
+
+	_, ok := config.Challenges[challengeId]
+	return challengeId, ok
+}
+
 type StatusResponse struct {
-	State   string `json:"state"` // "active" || "inactive"
-	Host    string `json:"host,omitempty"`
-	ExpTime string `json:"expTime,omitempty"`
+	State            string `json:"state"`             // "deploying" || "active" || "destroying" || "inactive" || "error"
+	Message          string `json:"message,omitempty"` // why the last deploy failed, only set for "error"
+	Host             string `json:"host,omitempty"`
+	ExpTime          string `json:"expTime,omitempty"`
+	ExpiresAt        string `json:"expiresAt,omitempty"`        // RFC3339, only set for active instances
+	SecondsRemaining *int   `json:"secondsRemaining,omitempty"` // only set for active instances
+	ExpiringSoon     bool   `json:"expiringSoon,omitempty"`     // if the instance expires within the warning window
+	Instructions     string `json:"instructions,omitempty"`     // how to connect to the instance, if the challenge has instructions
+}
+
+// Get the status of an instance for a team. di can be nil if the team doesn't have one
+func getStatusResponse(di *DeploymentInstance, now time.Time) StatusResponse {
+	if di == nil {
+		return StatusResponse{State: "inactive"}
+	}
+
+	state := di.getState()
+	if state == Running {
+		remaining := di.SecondsRemaining(now)
+		return StatusResponse{State: "active", Host: di.GetCxn(), ExpTime: di.GetExpTime(), ExpiresAt: di.GetExpiresAt(), SecondsRemaining: &remaining, ExpiringSoon: di.isExpiringSoon(now), Instructions: di.GetInstructions()}
+	} else if state == Destroying {
+		return StatusResponse{State: "destroying"}
+	} else if state == Deploying {
+		return StatusResponse{State: "deploying"}
+	} else if (state == Failed || state == Destroyed) && di.DeployError != "" {
+		return StatusResponse{State: "error", Message: di.DeployError}
+	}
+
+	return StatusResponse{State: "inactive"}
 }
 
 // GET /api/status
 // Get the status of the team's deployment
-func statusRequest(w http.ResponseWriter, r *http.Request, s *sessions.Session) {
+func (h *Handlers) statusRequest(w http.ResponseWriter, r *http.Request, s *sessions.Session) {
 	// make sure the session is valid
-	if _, exists := s.Values["id"]; s.IsNew || !exists {
-		w.WriteHeader(http.StatusForbidden)
+	teamId, ok := getSessionTeamId(s)
+	if !ok {
+		writeJSONError(w, http.StatusForbidden, errCodeNotAuthenticated, "not authenticated, please auth again")
 		return
 	}
 
-	/// get the deployment instance
-	di := im.GetDeploymentInstance(s.Values["id"].(string))
-
-	var resp StatusResponse
+	// make sure the challenge exists
+	challengeId, ok := getRequestChallengeId(r)
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, errCodeUnknownChallenge, "unknown challenge")
+		return
+	}
 
-	if di != nil && di.State == Running {
-		resp = StatusResponse{State: "active", Host: di.GetCxn(), ExpTime: di.GetExpTime()}
-	} else {
-		resp = StatusResponse{State: "inactive"}
+	// the frontend gets the csrf token from here after a page load
+	if err := issueCSRFToken(w, r, s); err != nil {
+		log.Printf("error handling status request, %v", err)
+		writeInternalError(w)
+		return
 	}
 
-	respBytes, err := json.Marshal(resp)
+	/// get the deployment instance
+	di := h.im.GetDeploymentInstance(teamId, challengeId)
+
+	respBytes, err := json.Marshal(getStatusResponse(di, time.Now()))
 	if err != nil {
 		log.Printf("error handling status request, couldn't marshal response data: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		writeInternalError(w)
 		return
 	}
 
 	w.Write(respBytes)
 }
 
-type CreateInstanceResponse struct {
-	Host string `json:"host"` // host:port string
-}
-
 // POST /api/create
-// Create a deployment instance for the team
-func createInstanceRequest(w http.ResponseWriter, r *http.Request, s *sessions.Session) {
+// Start deploying an instance of a challenge for the team. It's deployed in the background, so this doesn't wait for it to be ready:
+// 202 means the deploy started, with the status (the same JSON as /api/status) in the body and a Location header to poll for it.
+// The instance is "deploying" until it's "active", or "error" (with a message) if it fails.
+// Creating is idempotent: if the team already has a running instance, the response is 200 with its status (and connection info).
+// 409 means the team's instance is being modified, or failed and has to be destroyed first, 503 means the cap on
+// concurrent instances has been reached (or chaldeploy is shutting down), 429 means the team is rate limited or the instance was destroyed
+// too recently to redeploy (with a Retry-After header either way)
+func (h *Handlers) createInstanceRequest(w http.ResponseWriter, r *http.Request, s *sessions.Session) {
 	// make sure the session is valid
-	if _, exists := s.Values["id"]; s.IsNew || !exists {
-		w.WriteHeader(http.StatusForbidden)
+	teamId, ok := getSessionTeamId(s)
+	if !ok {
+		writeJSONError(w, http.StatusForbidden, errCodeNotAuthenticated, "not authenticated, please auth again")
 		return
 	}
 
-	log.Printf("Deploying instance for %s (ID: %s)", s.Values["teamName"], s.Values["id"])
+	// make sure the challenge exists
+	challengeId, ok := getRequestChallengeId(r)
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, errCodeUnknownChallenge, "unknown challenge")
+		return
+	}
 
-	// create the deployment
-	cxn, err := im.CreateDeployment(s.Values["id"].(string))
-	if err != nil {
-		log.Printf("couldn't create a deployment for %s: %v", s.Values["teamName"], err)
-		w.WriteHeader(http.StatusInternalServerError)
+	logEvent("deploying instance", Fields{"team_id": teamId, "team_name": s.Values["teamName"], "challenge_id": challengeId})
+
+	// start the deployment
+	status := http.StatusAccepted
+	err := h.im.StartDeployment(teamId, challengeId)
+	var cooldownErr *CooldownError
+	var teamLimitErr *TeamLimitError
+	if errors.As(err, &cooldownErr) {
+		logEvent("couldn't create instance", Fields{"team_id": teamId, "challenge_id": challengeId, "error": err.Error()})
+		retryAfter := int(math.Ceil(cooldownErr.Remaining.Seconds()))
+		w.Header().Set("Retry-After", fmt.Sprintf("%d", retryAfter))
+		writeJSONError(w, http.StatusTooManyRequests, errCodeCooldown, fmt.Sprintf("your instance was destroyed recently, try again in %d seconds", retryAfter))
+		return
+	} else if errors.Is(err, ErrAlreadyDeployed) {
+		// the team gets its running instance back
+		logEvent("instance is already running", Fields{"team_id": teamId, "challenge_id": challengeId})
+		status = http.StatusOK
+	} else if errors.Is(err, ErrBusy) {
+		logEvent("couldn't create instance", Fields{"team_id": teamId, "challenge_id": challengeId, "error": err.Error()})
+		writeJSONError(w, http.StatusConflict, errCodeBusy, "your instance is busy, try again in a bit")
+		return
+	} else if errors.Is(err, ErrFailed) {
+		logEvent("couldn't create instance", Fields{"team_id": teamId, "challenge_id": challengeId, "error": err.Error()})
+		writeJSONError(w, http.StatusConflict, errCodeInstanceFailed, "your last instance failed to deploy, destroy it before creating a new one")
+		return
+	} else if errors.Is(err, ErrCapacityReached) {
+		logEvent("couldn't create instance", Fields{"team_id": teamId, "challenge_id": challengeId, "error": err.Error()})
+		writeJSONError(w, http.StatusServiceUnavailable, errCodeCapacityReached, "too many instances are running right now, please try again later")
+		return
+	} else if errors.As(err, &teamLimitErr) {
+		logEvent("couldn't create instance", Fields{"team_id": teamId, "challenge_id": challengeId, "error": err.Error()})
+		writeJSONError(w, http.StatusTooManyRequests, errCodeTeamLimitReached, fmt.Sprintf("you can only have %d instance(s) at once, destroy one first", teamLimitErr.Limit))
+		return
+	} else if errors.Is(err, ErrShuttingDown) {
+		writeShuttingDownError(w)
+		return
+	} else if err != nil {
+		logEvent("couldn't create instance", Fields{"team_id": teamId, "challenge_id": challengeId, "error": err.Error()})
+		writeInternalError(w)
 		return
 	}
 
-	resp := CreateInstanceResponse{Host: cxn}
-	respBytes, err := json.Marshal(resp)
+	respBytes, err := json.Marshal(getStatusResponse(h.im.GetDeploymentInstance(teamId, challengeId), time.Now()))
 	if err != nil {
 		log.Printf("error handling create instance request, couldn't marshal response data: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		writeInternalError(w)
 		return
 	}
 
 	w.Header().Add("Content-type", "application/json")
+	w.Header().Set("Location", "/api/status?challengeId="+url.QueryEscape(challengeId))
+	w.WriteHeader(status)
 	w.Write(respBytes)
 }
 
 // POST /api/extend
 // Extend the timeout for a deployment instance
-// Response on 200 is the new expiration timestamp
-func extendInstanceRequest(w http.ResponseWriter, r *http.Request, s *sessions.Session) {
+// Response on 200 is the new expiration timestamp (RFC3339), 404 if there isn't a running instance, 503 if chaldeploy is shutting down
+func (h *Handlers) extendInstanceRequest(w http.ResponseWriter, r *http.Request, s *sessions.Session) {
 	// make sure the session is valid
-	if _, exists := s.Values["id"]; s.IsNew || !exists {
-		w.WriteHeader(http.StatusForbidden)
+	teamId, ok := getSessionTeamId(s)
+	if !ok {
+		writeJSONError(w, http.StatusForbidden, errCodeNotAuthenticated, "not authenticated, please auth again")
 		return
 	}
 
-	log.Printf("Extending instance for %s (ID: %s)", s.Values["teamName"], s.Values["id"])
+	// make sure the challenge exists
+	challengeId, ok := getRequestChallengeId(r)
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, errCodeUnknownChallenge, "unknown challenge")
+		return
+	}
 
-	newExp, err := im.ExtendDeployment(s.Values["id"].(string))
-	if err != nil {
-		log.Printf("couldn't extend deployment for %s: %v", s.Values["teamName"], err)
-		w.WriteHeader(http.StatusInternalServerError)
+	logEvent("extending instance", Fields{"team_id": teamId, "team_name": s.Values["teamName"], "challenge_id": challengeId})
+
+	newExp, err := h.im.ExtendDeployment(r.Context(), teamId, challengeId)
+	if errors.Is(err, ErrNoInstance) {
+		logEvent("couldn't extend instance", Fields{"team_id": teamId, "challenge_id": challengeId, "error": err.Error()})
+		writeJSONError(w, http.StatusNotFound, errCodeNoInstance, "you don't have a running instance")
+		return
+	} else if errors.Is(err, ErrMaxExtensions) {
+		logEvent("couldn't extend instance", Fields{"team_id": teamId, "challenge_id": challengeId, "error": err.Error()})
+		writeJSONError(w, http.StatusTooManyRequests, errCodeMaxExtensions, fmt.Sprintf("your instance can only be extended %d times, destroy it and make a new one if you need more time", config.snapshot().MaxExtensions))
+		return
+	} else if errors.Is(err, ErrShuttingDown) {
+		writeShuttingDownError(w)
+		return
+	} else if err != nil {
+		logEvent("couldn't extend instance", Fields{"team_id": teamId, "challenge_id": challengeId, "error": err.Error()})
+		writeInternalError(w)
 		return
 	}
 
@@ -230,21 +521,139 @@ func extendInstanceRequest(w http.ResponseWriter, r *http.Request, s *sessions.S
 
 // POST /api/destroy
 // Destroy a deployment instance
-// 200 means successfully destroy
-func destroyInstanceRequest(w http.ResponseWriter, r *http.Request, s *sessions.Session) {
+// 200 means successfully destroy, 409 means the instance is being modified by another request, 503 means chaldeploy is shutting down
+func (h *Handlers) destroyInstanceRequest(w http.ResponseWriter, r *http.Request, s *sessions.Session) {
 	// make sure the session is valid
-	if _, exists := s.Values["id"]; s.IsNew || !exists {
-		w.WriteHeader(http.StatusForbidden)
+	teamId, ok := getSessionTeamId(s)
+	if !ok {
+		writeJSONError(w, http.StatusForbidden, errCodeNotAuthenticated, "not authenticated, please auth again")
+		return
+	}
+
+	// make sure the challenge exists
+	challengeId, ok := getRequestChallengeId(r)
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, errCodeUnknownChallenge, "unknown challenge")
 		return
 	}
 
-	log.Printf("Destroying instance for %s (ID: %s)", s.Values["teamName"], s.Values["id"])
+	logEvent("destroying instance", Fields{"team_id": teamId, "team_name": s.Values["teamName"], "challenge_id": challengeId})
 
-	if err := im.DestroyDeployment(s.Values["id"].(string)); err != nil {
-		log.Printf("error handling delete instance request, couldn't delete deployment: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+	if err := h.im.DestroyDeployment(r.Context(), teamId, challengeId); errors.Is(err, ErrBusy) {
+		logEvent("couldn't destroy instance", Fields{"team_id": teamId, "challenge_id": challengeId, "error": err.Error()})
+		writeJSONError(w, http.StatusConflict, errCodeBusy, "your instance is busy, try again in a bit")
+		return
+	} else if errors.Is(err, ErrShuttingDown) {
+		writeShuttingDownError(w)
+		return
+	} else if err != nil {
+		logEvent("couldn't destroy instance", Fields{"team_id": teamId, "challenge_id": challengeId, "error": err.Error()})
+		writeInternalError(w)
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
 }
+
+type ConnectionResponse struct {
+	Host string `json:"host"` // host:port string
+}
+
+// POST /api/connection
+// Look up the connection info for the team's instance again, in case it changed (e.g., the service got a new address),
+// without redeploying it. Response on 200 is the connection info, 404 if there isn't a running instance, 409 if it's being modified
+func (h *Handlers) connectionRequest(w http.ResponseWriter, r *http.Request, s *sessions.Session) {
+	// make sure the session is valid
+	teamId, ok := getSessionTeamId(s)
+	if !ok {
+		writeJSONError(w, http.StatusForbidden, errCodeNotAuthenticated, "not authenticated, please auth again")
+		return
+	}
+
+	// make sure the challenge exists
+	challengeId, ok := getRequestChallengeId(r)
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, errCodeUnknownChallenge, "unknown challenge")
+		return
+	}
+
+	cxn, err := h.im.RefreshConnection(r.Context(), teamId, challengeId)
+	if errors.Is(err, ErrNoInstance) {
+		writeJSONError(w, http.StatusNotFound, errCodeNoInstance, "you don't have a running instance")
+		return
+	} else if errors.Is(err, ErrBusy) {
+		writeJSONError(w, http.StatusConflict, errCodeBusy, "your instance is busy, try again in a bit")
+		return
+	} else if err != nil {
+		logEvent("couldn't refresh instance connection", Fields{"team_id": teamId, "challenge_id": challengeId, "error": err.Error()})
+		writeInternalError(w)
+		return
+	}
+
+	respBytes, err := json.Marshal(ConnectionResponse{Host: cxn})
+	if err != nil {
+		log.Printf("error handling connection request, couldn't marshal response data: %v", err)
+		writeInternalError(w)
+		return
+	}
+
+	w.Header().Add("Content-type", "application/json")
+	w.Write(respBytes)
+}
+
+// GET /api/logs
+// Get the last lines of the logs from the team's instance, from the lines query parameter (default 100, max 500)
+// Response on 200 is the logs as text, 404 if there isn't a running instance, 400 if lines is invalid
+func (h *Handlers) logsRequest(w http.ResponseWriter, r *http.Request, s *sessions.Session) {
+	// make sure the session is valid
+	teamId, ok := getSessionTeamId(s)
+	if !ok {
+		writeJSONError(w, http.StatusForbidden, errCodeNotAuthenticated, "not authenticated, please auth again")
+		return
+	}
+
+	// make sure the challenge exists
+	challengeId, ok := getRequestChallengeId(r)
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, errCodeUnknownChallenge, "unknown challenge")
+		return
+	}
+
+	lines, ok := getRequestLogLines(r)
+	if !ok {
+		writeJSONError(w, http.StatusBadRequest, errCodeBadRequest, fmt.Sprintf("lines must be a positive number (max %d)", maxLogLines))
+		return
+	}
+
+	logs, err := h.im.GetInstanceLogs(r.Context(), teamId, challengeId, lines)
+	if errors.Is(err, ErrNoInstance) {
+		writeJSONError(w, http.StatusNotFound, errCodeNoInstance, "you don't have a running instance")
+		return
+	} else if err != nil {
+		logEvent("couldn't get instance logs", Fields{"team_id": teamId, "challenge_id": challengeId, "error": err.Error()})
+		writeInternalError(w)
+		return
+	}
+
+	w.Header().Add("Content-type", "text/plain")
+	w.Write([]byte(logs))
+}
+
+// Get the number of log lines a request is for, from the lines query parameter. Defaults to 100, and is capped at maxLogLines
+// Returns false if the parameter isn't a positive number
+func getRequestLogLines(r *http.Request) (int64, bool) {
+	param := r.URL.Query().Get("lines")
+	if param == "" {
+		return 100, true
+	}
+
+	lines, err := strconv.ParseInt(param, 10, 64)
+	if err != nil || lines <= 0 {
+		return 0, false
+	}
+	if lines > maxLogLines {
+		lines = maxLogLines
+	}
+
+	return lines, true
+}
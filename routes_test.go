@@ -0,0 +1,558 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestParseLoginToken(t *testing.T) {
+	// bare token
+	token, err := parseLoginToken("ab+/cd==")
+	assert.Nil(t, err)
+	assert.Equal(t, "ab+/cd==", token)
+
+	// login url
+	token, err = parseLoginToken("https://2021.redpwn.net/login?token=ab%2B%2Fcd%3D%3D\n")
+	assert.Nil(t, err)
+	assert.Equal(t, "ab+/cd==", token)
+
+	// double encoded
+	token, err = parseLoginToken("https://2021.redpwn.net/login?token=ab%252B%252Fcd%253D%253D")
+	assert.Nil(t, err)
+	assert.Equal(t, "ab+/cd==", token)
+
+	// extra query params, in any order, and surrounding whitespace
+	token, err = parseLoginToken("  https://2021.redpwn.net/login?utm_source=discord&token=ab%2B%2Fcd%3D%3D&next=%2Fchals \t")
+	assert.Nil(t, err)
+	assert.Equal(t, "ab+/cd==", token)
+
+	// a different login path, with a raw '+' in the token
+	token, err = parseLoginToken("http://ctf.example.com/auth/login/?token=ab+/cd==")
+	assert.Nil(t, err)
+	assert.Equal(t, "ab+/cd==", token)
+
+	// url without a token
+	_, err = parseLoginToken("https://2021.redpwn.net/login?next=%2Fchals")
+	assert.NotNil(t, err)
+
+	// empty body
+	_, err = parseLoginToken("")
+	assert.NotNil(t, err)
+
+	// missing token
+	_, err = parseLoginToken("https://2021.redpwn.net/login?token=")
+	assert.NotNil(t, err)
+
+	// bad encoding
+	_, err = parseLoginToken("ab%ZZcd")
+	assert.NotNil(t, err)
+}
+
+func TestReadAuthRequest(t *testing.T) {
+	request := func(contentType, body string) (string, error) {
+		r := httptest.NewRequest(http.MethodPost, "/api/auth", strings.NewReader(body))
+		if contentType != "" {
+			r.Header.Set("Content-Type", contentType)
+		}
+		return readAuthRequest(r)
+	}
+
+	token, err := request("application/json", `{"token": "https://2021.redpwn.net/login?token=ab%2B%2Fcd%3D%3D"}`)
+	assert.Nil(t, err)
+	assert.Equal(t, "https://2021.redpwn.net/login?token=ab%2B%2Fcd%3D%3D", token)
+
+	token, err = request("application/json; charset=utf-8", `{"token": "ab+/cd=="}`)
+	assert.Nil(t, err)
+	assert.Equal(t, "ab+/cd==", token)
+
+	// raw text bodies still work
+	token, err = request("", "ab+/cd==")
+	assert.Nil(t, err)
+	assert.Equal(t, "ab+/cd==", token)
+	token, err = request("text/plain", "ab+/cd==")
+	assert.Nil(t, err)
+	assert.Equal(t, "ab+/cd==", token)
+
+	// malformed json
+	for _, body := range []string{"ab+/cd==", `{"token": `, `{"token": 1}`} {
+		_, err = request("application/json", body)
+		assert.ErrorIs(t, err, ErrMalformedToken, body)
+	}
+}
+
+func TestValidateRctfLoginToken(t *testing.T) {
+	assert.Nil(t, validateRctfLoginToken("ab+/cd=="))
+	assert.ErrorIs(t, validateRctfLoginToken("not a token"), ErrMalformedToken)
+	assert.ErrorIs(t, validateRctfLoginToken("ab%2B"), ErrMalformedToken)
+}
+
+func TestAuthRequestMalformed(t *testing.T) {
+	authProvider = &RctfProvider{Url: "http://127.0.0.1:0", Client: http.DefaultClient}
+
+	for _, body := range []string{"", "https://2021.redpwn.net/login?token=", "not a token"} {
+		r := httptest.NewRequest(http.MethodPost, "/api/auth", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		s := sessions.NewSession(sessions.NewCookieStore([]byte("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")), "session")
+
+		authRequest(w, r, s)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+
+		resp := ErrorResponse{}
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp), body)
+		assert.Equal(t, errCodeMalformedToken, resp.Code, body)
+		assert.NotEmpty(t, resp.Error, body)
+	}
+
+	// malformed json
+	r := httptest.NewRequest(http.MethodPost, "/api/auth", strings.NewReader(`{"token": `))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	authRequest(w, r, sessions.NewSession(sessions.NewCookieStore([]byte("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")), "session"))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestAuthRequestDoesntLogToken(t *testing.T) {
+	loginToken := "c2VjcmV0bG9naW50b2tlbg=="
+	authToken := "secretauthtoken"
+
+	// fake rCTF server that hands out an auth token, then fails to get the user info
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/auth/login":
+			fmt.Fprintf(w, `{"kind":"goodLogin","data":{"authToken":"%s"}}`, authToken)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+			io.WriteString(w, "oops")
+		}
+	}))
+	defer srv.Close()
+	authProvider = &RctfProvider{Url: srv.URL, Client: http.DefaultClient}
+
+	buf := &bytes.Buffer{}
+	log.SetOutput(buf)
+	defer log.SetOutput(os.Stderr)
+
+	for _, body := range []string{loginToken, "https://2021.redpwn.net/login?token=" + loginToken + "%ZZ"} {
+		r := httptest.NewRequest(http.MethodPost, "/api/auth", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		s := sessions.NewSession(sessions.NewCookieStore([]byte("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")), "session")
+
+		authRequest(w, r, s)
+		assert.NotEqual(t, http.StatusOK, w.Code)
+		assert.NotContains(t, w.Body.String(), loginToken)
+		assert.NotContains(t, w.Body.String(), authToken)
+	}
+
+	assert.NotEmpty(t, buf.String())
+	assert.NotContains(t, buf.String(), loginToken)
+	assert.NotContains(t, buf.String(), authToken)
+}
+
+func TestSecret(t *testing.T) {
+	data := RctfAuthData{AuthToken: "secretauthtoken"}
+
+	for _, format := range []string{"%s", "%v", "%+v", "%#v"} {
+		assert.NotContains(t, fmt.Sprintf(format, data), "secretauthtoken", format)
+	}
+
+	b, err := json.Marshal(data)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(b), "secretauthtoken")
+
+	// the token is still parsed from the rCTF response
+	assert.Nil(t, json.Unmarshal([]byte(`{"authToken":"secretauthtoken"}`), &data))
+	assert.Equal(t, "secretauthtoken", string(data.AuthToken))
+}
+
+func TestGetRequestLogLines(t *testing.T) {
+	lines, ok := getRequestLogLines(httptest.NewRequest(http.MethodGet, "/api/logs", nil))
+	assert.True(t, ok)
+	assert.Equal(t, int64(100), lines)
+
+	lines, ok = getRequestLogLines(httptest.NewRequest(http.MethodGet, "/api/logs?lines=20", nil))
+	assert.True(t, ok)
+	assert.Equal(t, int64(20), lines)
+
+	// capped
+	lines, ok = getRequestLogLines(httptest.NewRequest(http.MethodGet, "/api/logs?lines=100000", nil))
+	assert.True(t, ok)
+	assert.Equal(t, int64(maxLogLines), lines)
+
+	for _, invalid := range []string{"0", "-1", "asdf"} {
+		_, ok = getRequestLogLines(httptest.NewRequest(http.MethodGet, "/api/logs?lines="+invalid, nil))
+		assert.False(t, ok, invalid)
+	}
+}
+
+func TestWriteJSONError(t *testing.T) {
+	w := httptest.NewRecorder()
+	w.Header().Set("Retry-After", "30")
+	writeJSONError(w, http.StatusTooManyRequests, errCodeRateLimited, "too many requests")
+
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.Equal(t, "30", w.Header().Get("Retry-After"))
+	assert.JSONEq(t, `{"error": "too many requests", "code": "rate_limited"}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	writeInternalError(w)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"internal_error"`)
+}
+
+func TestReadyCheck(t *testing.T) {
+	clientset := newTestInstanceManager()
+	h := NewHandlers(im)
+
+	w := httptest.NewRecorder()
+	h.readyCheck(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// cluster is unreachable
+	clientset.PrependReactor("list", "namespaces", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("connection refused")
+	})
+	w = httptest.NewRecorder()
+	h.readyCheck(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	// no cluster in dry run mode
+	config.DryRun = true
+	w = httptest.NewRecorder()
+	h.readyCheck(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestLoggingMiddlewareHealthCheck(t *testing.T) {
+	buf := &bytes.Buffer{}
+	log.SetOutput(buf)
+	defer log.SetOutput(os.Stderr)
+
+	h := loggingMiddleware(http.HandlerFunc(healthCheck))
+
+	// health checks still get handled, they just aren't logged
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthcheck", nil))
+	assert.Equal(t, "app good to go", w.Body.String())
+	assert.Empty(t, buf.String())
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Contains(t, buf.String(), "GET request from")
+}
+
+func TestLogoutRequest(t *testing.T) {
+	h := &Handlers{}
+	config = &Config{Challenges: map[string]ChallengeSpec{DefaultChallengeId: {}}}
+	store = newSessionStore([]string{"aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"})
+	userInfoCache = NewUserInfoCache(time.Minute, 10)
+	defer func() { userInfoCache = nil }()
+	userInfoCache.Set("token1", UserInfo{Id: "team1"})
+	userInfoCache.Set("token2", UserInfo{Id: "team2"})
+
+	// make a session cookie for team1
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/api/auth", nil)
+	s, _ := store.Get(r, "session")
+	s.Values["id"] = "team1"
+	assert.Nil(t, s.Save(r, w))
+	cookie := w.Result().Cookies()[0]
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodPost, "/api/logout", nil)
+	r.AddCookie(cookie)
+	sessionHandler(h.logoutRequest).ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// the cookie is expired, and the team's cached info is gone
+	cookies := w.Result().Cookies()
+	assert.Len(t, cookies, 1)
+	assert.Equal(t, -1, cookies[0].MaxAge)
+	_, ok := userInfoCache.Get("token1")
+	assert.False(t, ok)
+	_, ok = userInfoCache.Get("token2")
+	assert.True(t, ok)
+
+	// the session isn't authenticated anymore
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodGet, "/api/status", nil)
+	r.AddCookie(cookies[0])
+	sessionHandler(h.statusRequest).ServeHTTP(w, r)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestLogoutDestroysInstances(t *testing.T) {
+	newTestInstanceManager()
+	h := NewHandlers(im)
+	config.DestroyOnLogout = true
+	ctx := context.Background()
+
+	_, err := im.CreateDeployment(ctx, "team1", DefaultChallengeId)
+	assert.Nil(t, err)
+
+	s := sessions.NewSession(sessions.NewCookieStore([]byte("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")), "session")
+	s.Values["id"] = "team1"
+	w := httptest.NewRecorder()
+	h.logoutRequest(w, httptest.NewRequest(http.MethodPost, "/api/logout", nil), s)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	di := im.GetDeploymentInstance("team1", DefaultChallengeId)
+	assert.Equal(t, Destroyed, di.State)
+}
+
+func TestStatusRequestRemainingTime(t *testing.T) {
+	newTestInstanceManager()
+	h := NewHandlers(im)
+
+	s := sessions.NewSession(sessions.NewCookieStore([]byte("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")), "session")
+	s.Values["id"] = "team1"
+	status := func() StatusResponse {
+		w := httptest.NewRecorder()
+		h.statusRequest(w, httptest.NewRequest(http.MethodGet, "/api/status", nil), s)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		resp := StatusResponse{}
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp
+	}
+
+	resp := status()
+	assert.Equal(t, "inactive", resp.State)
+	assert.Nil(t, resp.SecondsRemaining)
+
+	w := httptest.NewRecorder()
+	h.createInstanceRequest(w, httptest.NewRequest(http.MethodPost, "/api/create", nil), s)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	im.inFlight.Wait()
+
+	di := im.GetDeploymentInstance("team1", DefaultChallengeId)
+	resp = status()
+	assert.Equal(t, "active", resp.State)
+	assert.Equal(t, di.ExpTime.Format(time.RFC3339), resp.ExpiresAt)
+	assert.InDelta(t, config.InstanceTTL.Seconds(), *resp.SecondsRemaining, 5)
+}
+
+func TestReauthKeepsInstance(t *testing.T) {
+	newTestInstanceManager()
+	h := NewHandlers(im)
+
+	// fake rCTF server that always logs in as the same team
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/auth/login":
+			io.WriteString(w, `{"kind":"goodLogin","data":{"authToken":"authtoken"}}`)
+		case "/api/v1/users/me":
+			io.WriteString(w, `{"kind":"goodUserData","data":{"name":"team one","id":"team1"}}`)
+		}
+	}))
+	defer srv.Close()
+	authProvider = &RctfProvider{Url: srv.URL, Client: http.DefaultClient}
+
+	newSession := func() *sessions.Session {
+		return sessions.NewSession(sessions.NewCookieStore([]byte("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")), "session")
+	}
+	auth := func(s *sessions.Session) {
+		w := httptest.NewRecorder()
+		authRequest(w, httptest.NewRequest(http.MethodPost, "/api/auth", strings.NewReader("c2VjcmV0bG9naW50b2tlbg==")), s)
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+	status := func(s *sessions.Session) (int, StatusResponse) {
+		w := httptest.NewRecorder()
+		h.statusRequest(w, httptest.NewRequest(http.MethodGet, "/api/status", nil), s)
+
+		resp := StatusResponse{}
+		if w.Code == http.StatusOK {
+			assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		}
+		return w.Code, resp
+	}
+
+	s := newSession()
+	auth(s)
+	w := httptest.NewRecorder()
+	h.createInstanceRequest(w, httptest.NewRequest(http.MethodPost, "/api/create", nil), s)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	im.inFlight.Wait()
+	_, createResp := status(s)
+
+	// the session expired (or the cookie was cleared)
+	s = newSession()
+	code, _ := status(s)
+	assert.Equal(t, http.StatusForbidden, code)
+
+	// authing again gets the team back to the instance that's still running
+	auth(s)
+	code, resp := status(s)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "active", resp.State)
+	assert.Equal(t, createResp.Host, resp.Host)
+	assert.Equal(t, createResp.ExpiresAt, resp.ExpiresAt)
+}
+
+func TestConnectionRequest(t *testing.T) {
+	newTestInstanceManager()
+	h := NewHandlers(im)
+
+	s := sessions.NewSession(sessions.NewCookieStore([]byte("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")), "session")
+	s.Values["id"] = "team1"
+	connection := func() (int, ConnectionResponse) {
+		w := httptest.NewRecorder()
+		h.connectionRequest(w, httptest.NewRequest(http.MethodPost, "/api/connection", nil), s)
+
+		resp := ConnectionResponse{}
+		if w.Code == http.StatusOK {
+			assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		}
+		return w.Code, resp
+	}
+
+	code, _ := connection()
+	assert.Equal(t, http.StatusNotFound, code)
+
+	_, err := im.CreateDeployment(context.Background(), "team1", DefaultChallengeId)
+	assert.Nil(t, err)
+	code, resp := connection()
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "1.2.3.4:31337", resp.Host)
+}
+
+func TestCreateRequestTeamLimit(t *testing.T) {
+	newTestInstanceManager()
+	h := NewHandlers(im)
+	config.MaxInstancesPerTeam = 1
+	config.Challenges["web"] = ChallengeSpec{Name: "web chal", Image: "captaingeech/test-web:latest", Port: 8080}
+
+	s := sessions.NewSession(sessions.NewCookieStore([]byte("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")), "session")
+	s.Values["id"] = "team1"
+
+	w := httptest.NewRecorder()
+	h.createInstanceRequest(w, httptest.NewRequest(http.MethodPost, "/api/create", nil), s)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	im.inFlight.Wait()
+
+	w = httptest.NewRecorder()
+	h.createInstanceRequest(w, httptest.NewRequest(http.MethodPost, "/api/create?challengeId=web", nil), s)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Contains(t, w.Body.String(), errCodeTeamLimitReached)
+}
+
+func TestCreateRequestInBackground(t *testing.T) {
+	clientset := newTestInstanceManager()
+	h := NewHandlers(im)
+
+	s := sessions.NewSession(sessions.NewCookieStore([]byte("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")), "session")
+	s.Values["id"] = "team1"
+	status := func() StatusResponse {
+		w := httptest.NewRecorder()
+		h.statusRequest(w, httptest.NewRequest(http.MethodGet, "/api/status", nil), s)
+		resp := StatusResponse{}
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp
+	}
+
+	// hold up the deploy so it can be seen in progress
+	release := make(chan struct{})
+	clientset.PrependReactor("create", "namespaces", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		<-release
+		return false, nil, nil
+	})
+
+	w := httptest.NewRecorder()
+	h.createInstanceRequest(w, httptest.NewRequest(http.MethodPost, "/api/create", nil), s)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, "/api/status?challengeId=default", w.Header().Get("Location"))
+	resp := StatusResponse{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "deploying", resp.State)
+
+	// a second create is rejected while the first is deploying
+	w = httptest.NewRecorder()
+	h.createInstanceRequest(w, httptest.NewRequest(http.MethodPost, "/api/create", nil), s)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	close(release)
+	im.inFlight.Wait()
+	assert.Equal(t, "active", status().State)
+
+	// creating again once it's running gives back the running instance
+	w = httptest.NewRecorder()
+	h.createInstanceRequest(w, httptest.NewRequest(http.MethodPost, "/api/create", nil), s)
+	assert.Equal(t, http.StatusOK, w.Code)
+	resp = StatusResponse{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "active", resp.State)
+	assert.Equal(t, "1.2.3.4:31337", resp.Host)
+
+	// a deploy that fails in the background shows up as an error, until the next deploy starts
+	assert.Nil(t, im.DestroyDeployment(context.Background(), "team1", DefaultChallengeId))
+	clientset.PrependReactor("create", "services", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("asdf")
+	})
+	w = httptest.NewRecorder()
+	h.createInstanceRequest(w, httptest.NewRequest(http.MethodPost, "/api/create", nil), s)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	im.inFlight.Wait()
+
+	resp = status()
+	assert.Equal(t, "error", resp.State)
+	assert.NotEmpty(t, resp.Message)
+	assert.NotContains(t, resp.Message, "asdf")
+}
+
+// the handlers only use the instance manager they were given, not the im global
+func TestHandlersUseTheirInstanceManager(t *testing.T) {
+	newTestInstanceManager()
+	other := im
+	newTestInstanceManager()
+	h := NewHandlers(other)
+
+	s := sessions.NewSession(sessions.NewCookieStore([]byte("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")), "session")
+	s.Values["id"] = "team1"
+	w := httptest.NewRecorder()
+	h.createInstanceRequest(w, httptest.NewRequest(http.MethodPost, "/api/create", nil), s)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	other.inFlight.Wait()
+
+	di := other.GetDeploymentInstance("team1", DefaultChallengeId)
+	assert.Equal(t, Running, di.State)
+	assert.Nil(t, im.GetDeploymentInstance("team1", DefaultChallengeId))
+
+	w = httptest.NewRecorder()
+	h.statusRequest(w, httptest.NewRequest(http.MethodGet, "/api/status", nil), s)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"state":"active"`)
+
+	w = httptest.NewRecorder()
+	h.destroyInstanceRequest(w, httptest.NewRequest(http.MethodPost, "/api/destroy", nil), s)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, Destroyed, di.State)
+}
+
+func TestRequestsWhileShuttingDown(t *testing.T) {
+	newTestInstanceManager()
+	h := NewHandlers(im)
+	assert.Nil(t, im.Drain(context.Background()))
+
+	s := sessions.NewSession(sessions.NewCookieStore([]byte("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")), "session")
+	s.Values["id"] = "team1"
+	w := httptest.NewRecorder()
+	h.createInstanceRequest(w, httptest.NewRequest(http.MethodPost, "/api/create", nil), s)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), errCodeShuttingDown)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// get the affinity for a challenge's pods. if anti-affinity is enabled, pods of the same challenge prefer to be on
+// different nodes, so the instances are spread across the cluster. it's only a preference, so instances can still be
+// deployed when there are more of them than nodes
+func (c *Config) getAffinity(spec ChallengeSpec) *corev1.Affinity {
+	if !c.PodAntiAffinity {
+		return nil
+	}
+
+	return &corev1.Affinity{
+		PodAntiAffinity: &corev1.PodAntiAffinity{
+			PreferredDuringSchedulingIgnoredDuringExecution: []corev1.WeightedPodAffinityTerm{
+				{
+					Weight: 100,
+					PodAffinityTerm: corev1.PodAffinityTerm{
+						LabelSelector: &metav1.LabelSelector{
+							MatchLabels: map[string]string{"chaldeploy.captaingee.ch/chal": HashString(spec.Name)},
+						},
+						// instances are in their own namespaces, so match the pods in every namespace
+						NamespaceSelector: &metav1.LabelSelector{},
+						TopologyKey:       corev1.LabelHostname,
+					},
+				},
+			},
+		},
+	}
+}
+
+// Make sure the node selector labels and values are valid k8s labels
+func validateNodeSelector(nodeSelector map[string]string) error {
+	for _, k := range SortedKeys(nodeSelector) {
+		v := nodeSelector[k]
+		if errs := validation.IsQualifiedName(k); len(errs) > 0 {
+			return fmt.Errorf("%s isn't a valid label: %s", k, strings.Join(errs, ", "))
+		}
+		if errs := validation.IsValidLabelValue(v); len(errs) > 0 {
+			return fmt.Errorf("%s isn't a valid value for label %s: %s", v, k, strings.Join(errs, ", "))
+		}
+	}
+
+	return nil
+}
+
+// Make sure the tolerations have a valid operator and effect, rather than having every deployment rejected
+func validateTolerations(tolerations []corev1.Toleration) error {
+	for i, t := range tolerations {
+		switch t.Operator {
+		case corev1.TolerationOpExists:
+			if t.Value != "" {
+				return fmt.Errorf("toleration %d can't have a value with the Exists operator", i)
+			}
+		case corev1.TolerationOpEqual, "":
+			if t.Key == "" {
+				return fmt.Errorf("toleration %d needs a key with the Equal operator", i)
+			}
+		default:
+			return fmt.Errorf("toleration %d has an invalid operator: %s (must be Exists or Equal)", i, t.Operator)
+		}
+
+		if t.Key != "" {
+			if errs := validation.IsQualifiedName(t.Key); len(errs) > 0 {
+				return fmt.Errorf("toleration %d has an invalid key: %s", i, strings.Join(errs, ", "))
+			}
+		}
+		if !Contains([]corev1.TaintEffect{"", corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute}, t.Effect) {
+			return fmt.Errorf("toleration %d has an invalid effect: %s", i, t.Effect)
+		}
+		if t.TolerationSeconds != nil && t.Effect != corev1.TaintEffectNoExecute {
+			return fmt.Errorf("toleration %d can only set tolerationSeconds with the NoExecute effect", i)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestScheduling(t *testing.T) {
+	config = &Config{
+		Replicas:     1,
+		NodeSelector: map[string]string{"chaldeploy.captaingee.ch/challenges": "true"},
+		Tolerations:  []corev1.Toleration{{Key: "challenges", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoSchedule}},
+	}
+	spec := ChallengeSpec{Name: "my chal", Image: "captaingeech/test-nc:latest", Port: 31337}
+
+	podSpec := config.getDeployment("chaldeploy-test", "team-id", spec, nil).Spec.Template.Spec
+	assert.Equal(t, config.NodeSelector, podSpec.NodeSelector)
+	assert.Equal(t, config.Tolerations, podSpec.Tolerations)
+	assert.Nil(t, podSpec.Affinity)
+
+	// spread across nodes by challenge
+	config.PodAntiAffinity = true
+	podSpec = config.getDeployment("chaldeploy-test", "team-id", spec, nil).Spec.Template.Spec
+	terms := podSpec.Affinity.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution
+	assert.Len(t, terms, 1)
+	assert.Equal(t, corev1.LabelHostname, terms[0].PodAffinityTerm.TopologyKey)
+	assert.Equal(t, map[string]string{"chaldeploy.captaingee.ch/chal": HashString("my chal")}, terms[0].PodAffinityTerm.LabelSelector.MatchLabels)
+	assert.Empty(t, podSpec.Affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution)
+}
+
+func TestValidateNodeSelector(t *testing.T) {
+	assert.Nil(t, validateNodeSelector(nil))
+	assert.Nil(t, validateNodeSelector(map[string]string{"kubernetes.io/os": "linux", "dedicated": ""}))
+	assert.NotNil(t, validateNodeSelector(map[string]string{"not a label": "x"}))
+	assert.NotNil(t, validateNodeSelector(map[string]string{"dedicated": "not a value"}))
+}
+
+func TestValidateTolerations(t *testing.T) {
+	seconds := int64(30)
+
+	assert.Nil(t, validateTolerations(nil))
+	assert.Nil(t, validateTolerations([]corev1.Toleration{
+		{Key: "challenges", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoSchedule},
+		{Key: "challenges", Value: "true"},
+		{Operator: corev1.TolerationOpExists},
+		{Key: "challenges", Operator: corev1.TolerationOpEqual, Value: "true", Effect: corev1.TaintEffectNoExecute, TolerationSeconds: &seconds},
+	}))
+
+	assert.NotNil(t, validateTolerations([]corev1.Toleration{{Key: "challenges", Operator: "Sometimes"}}))
+	assert.NotNil(t, validateTolerations([]corev1.Toleration{{Key: "challenges", Operator: corev1.TolerationOpExists, Value: "true"}}))
+	assert.NotNil(t, validateTolerations([]corev1.Toleration{{Value: "true"}}))
+	assert.NotNil(t, validateTolerations([]corev1.Toleration{{Key: "challenges", Effect: "NoRunning"}}))
+	assert.NotNil(t, validateTolerations([]corev1.Toleration{{Key: "challenges", Effect: corev1.TaintEffectNoSchedule, TolerationSeconds: &seconds}}))
+}
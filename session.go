@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/sessions"
+)
+
+// Parse a SameSite cookie mode (Strict, Lax, or None)
+func parseSameSite(mode string) (http.SameSite, error) {
+	switch strings.ToLower(mode) {
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "lax":
+		return http.SameSiteLaxMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	default:
+		return http.SameSiteDefaultMode, fmt.Errorf("invalid SameSite mode: %s (must be Strict, Lax, or None)", mode)
+	}
+}
+
+// Get the options for the session cookie from the config. The cookie holds the team's auth token,
+// so it's always HttpOnly. The SameSite mode is validated at startup
+func getSessionOptions() *sessions.Options {
+	sameSite, _ := parseSameSite(config.CookieSameSite)
+
+	return &sessions.Options{
+		Path:     "/",
+		Domain:   config.CookieDomain,
+		MaxAge:   int(config.SessionMaxAge.Seconds()),
+		Secure:   config.CookieSecure,
+		HttpOnly: true,
+		SameSite: sameSite,
+	}
+}
+
+// Get the session keys, the current key first and then the previous ones
+func getSessionKeys() []string {
+	return append([]string{config.SessionKey}, config.PreviousSessionKeys...)
+}
+
+// Check if a session key looks like a placeholder (e.g., the one from the README) instead of a random key
+func isPlaceholderSessionKey(key string) bool {
+	chars := map[rune]bool{}
+	for _, c := range key {
+		chars[c] = true
+	}
+
+	// random keys have way more distinct chars than this
+	return len(chars) < 8
+}
+
+// Create the session store, with the cookie options from the config.
+// Cookies are signed with the first key, and can be verified with any of them
+func newSessionStore(keys []string) *sessions.CookieStore {
+	// securecookie takes hash/encryption key pairs. sessions are only signed, not encrypted, so the encryption keys are nil
+	keyPairs := [][]byte{}
+	for _, key := range keys {
+		keyPairs = append(keyPairs, []byte(key), nil)
+	}
+
+	s := sessions.NewCookieStore(keyPairs...)
+	s.Options = getSessionOptions()
+	// the securecookie max age has to match the cookie's, or it expires the session at the default 30 days
+	s.MaxAge(s.Options.MaxAge)
+
+	return s
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSameSite(t *testing.T) {
+	for mode, expected := range map[string]http.SameSite{"Strict": http.SameSiteStrictMode, "lax": http.SameSiteLaxMode, "None": http.SameSiteNoneMode} {
+		sameSite, err := parseSameSite(mode)
+		assert.Nil(t, err, mode)
+		assert.Equal(t, expected, sameSite, mode)
+	}
+
+	_, err := parseSameSite("asdf")
+	assert.NotNil(t, err)
+}
+
+func TestSessionCookieOptions(t *testing.T) {
+	config = &Config{CookieSecure: true, CookieSameSite: "Lax", CookieDomain: "deploy.example.com", SessionMaxAge: 48 * time.Hour}
+	s := newSessionStore([]string{"aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"})
+
+	r := httptest.NewRequest(http.MethodGet, "/api/status", nil)
+	w := httptest.NewRecorder()
+	session, _ := s.Get(r, "session")
+	session.Values["teamId"] = "team1"
+	assert.Nil(t, session.Save(r, w))
+
+	cookies := w.Result().Cookies()
+	assert.Len(t, cookies, 1)
+	assert.True(t, cookies[0].Secure)
+	assert.True(t, cookies[0].HttpOnly)
+	assert.Equal(t, http.SameSiteLaxMode, cookies[0].SameSite)
+	assert.Equal(t, "deploy.example.com", cookies[0].Domain)
+	assert.Equal(t, int((48 * time.Hour).Seconds()), cookies[0].MaxAge)
+}
+
+func TestSessionKeyRotation(t *testing.T) {
+	config = &Config{SessionKey: "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", SessionMaxAge: time.Hour, CookieSameSite: "Lax"}
+	oldStore := newSessionStore(getSessionKeys())
+
+	// save a session with the old key
+	r := httptest.NewRequest(http.MethodGet, "/api/status", nil)
+	w := httptest.NewRecorder()
+	session, _ := oldStore.Get(r, "session")
+	session.Values["teamId"] = "team1"
+	assert.Nil(t, session.Save(r, w))
+	cookie := w.Result().Cookies()[0]
+
+	load := func() (string, error) {
+		r := httptest.NewRequest(http.MethodGet, "/api/status", nil)
+		r.AddCookie(cookie)
+		session, err := newSessionStore(getSessionKeys()).Get(r, "session")
+		teamId, _ := session.Values["teamId"].(string)
+		return teamId, err
+	}
+
+	// still valid after rotating the key
+	config.SessionKey = "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+	config.PreviousSessionKeys = []string{"aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"}
+	assert.Equal(t, []string{"bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb", "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"}, getSessionKeys())
+	teamId, err := load()
+	assert.Nil(t, err)
+	assert.Equal(t, "team1", teamId)
+
+	// invalid once the old key is dropped
+	config.PreviousSessionKeys = nil
+	teamId, err = load()
+	assert.NotNil(t, err)
+	assert.Empty(t, teamId)
+}
+
+func TestPlaceholderSessionKey(t *testing.T) {
+	assert.True(t, isPlaceholderSessionKey("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"))
+	assert.True(t, isPlaceholderSessionKey("abababababababababababababababab"))
+	assert.False(t, isPlaceholderSessionKey("3f9c1e0b7a5d2c8e4f6a1b9d0c7e5a3b"))
+}
@@ -6,12 +6,43 @@ ELEMS = {
     destroy: document.getElementById("btn-destroy-instance"),
     authStatus: document.getElementById("span-auth-status"),
     instanceStatus: document.getElementById("span-instance-status"),
+    instanceSpinner: document.getElementById("spinner-instance-status"),
     rctfAuthUrlField: document.getElementById("ta-rctf-auth-url"),
     toastContainer: document.getElementById("toast-container"),
     noticeToast: document.getElementById("notice-toast"),
     errorToast: document.getElementById("error-toast"),
 }
 
+// id of the challenge this page is for
+CHALLENGE_ID = document.body.dataset.challengeId;
+
+// csrf token for the create/extend/destroy routes, from the X-CSRF-Token header on auth/status responses
+CSRF_TOKEN = null;
+
+// stream of instance state changes from /api/events, once authenticated
+EVENTS = null;
+
+// Save the csrf token from a response, if it has one
+function saveCsrfToken(r) {
+    const token = r.headers.get("X-CSRF-Token");
+    if (token) {
+        CSRF_TOKEN = token;
+    }
+}
+
+// Make a POST request to an instance API route, with the csrf token
+function instancePost(path) {
+    return fetch(instanceUrl(path), {
+        method: "POST",
+        headers: CSRF_TOKEN ? {"X-CSRF-Token": CSRF_TOKEN} : {}
+    });
+}
+
+// Get the URL for an instance API route for the challenge on this page
+function instanceUrl(path) {
+    return `${path}?challengeId=${encodeURIComponent(CHALLENGE_ID)}`;
+}
+
 // Enable a button to be clicked
 function enableButton(btn) {
     if (btn.classList.contains("disabled")) {
@@ -57,6 +88,15 @@ function toggleStateButtons(isActive) {
     }
 }
 
+// Show or hide the spinner next to the instance status
+function toggleSpinner(isBusy) {
+    if (isBusy) {
+        ELEMS.instanceSpinner.classList.remove("d-none");
+    } else {
+        ELEMS.instanceSpinner.classList.add("d-none");
+    }
+}
+
 // Launch a toast
 function showToast(targetToast, text) {
     // make the toast element
@@ -87,6 +127,14 @@ function showErrorToast(text) {
     showToast(ELEMS.errorToast, text);
 }
 
+// Get the message from an API error response, which is JSON like {"error": "...", "code": "..."}
+// Falls back to the default message if there isn't one
+function errorMessage(r, fallback) {
+    return r.json()
+        .then(data => data?.error || fallback)
+        .catch(() => fallback);
+}
+
 // Handler for when the contents of the auth url field change
 function onAuthFieldChange(e) {
     if (e?.target?.value?.length > 0) {
@@ -102,14 +150,19 @@ function onAuthenticate(e) {
 
     fetch("/api/auth", {
         method: "POST",
-        body: ELEMS.rctfAuthUrlField.value
+        headers: {"Content-Type": "application/json"},
+        body: JSON.stringify({token: ELEMS.rctfAuthUrlField.value})
     }).then(r => {
+        saveCsrfToken(r);
         if (r.status === 403) {
             showErrorToast("Couldn't auth");
-            statusError(ELEMS.authStatus, "Couldn't auth to rCTF, bad token/URL?");
+            statusError(ELEMS.authStatus, "Couldn't auth to the scoreboard, bad token/URL?");
+        } else if (r.status === 400) {
+            showErrorToast("Couldn't auth");
+            errorMessage(r, "That doesn't look like a valid token/URL").then(msg => statusError(ELEMS.authStatus, msg));
         } else if (r.status >= 400) {
             showErrorToast("Couldn't auth");
-            statusError(ELEMS.authStatus, "Server error, contact an @Admin");
+            errorMessage(r, "Server error, contact an @Admin").then(msg => statusError(ELEMS.authStatus, msg));
         } else {
             return r.text();
         }
@@ -121,6 +174,7 @@ function onAuthenticate(e) {
             ELEMS.rctfAuthUrlField.readOnly = true;
 
             getInstanceStatus();
+            subscribeToEvents();
         }
     });
 }
@@ -130,50 +184,126 @@ function onAuthenticate(e) {
 function getInstanceStatus() {
     statusInfo(ELEMS.instanceStatus, "(fetching status...)");
 
-    fetch("/api/status")
+    fetch(instanceUrl("/api/status"))
         .then(r => {
+            saveCsrfToken(r);
             if (r.status === 403) {
                 showErrorToast("Couldn't get instance status");
                 statusError(ELEMS.authStatus, "Please refresh the page and re-authenticate");
             } else if (r.status >= 400) {
                 showErrorToast("Couldn't get instance status");
-                statusError(ELEMS.instanceStatus, "Server error, contact an @Admin");
+                errorMessage(r, "Server error, contact an @Admin").then(msg => statusError(ELEMS.instanceStatus, msg));
             } else {
                 return r.json()
             }
         })
         .then(data => {
             if (data) {
-                if (data?.state === "active") {
-                    statusSuccess(ELEMS.instanceStatus, `Active instance available at ${data?.host}, expires at ${data?.expTime}`);
-                    toggleStateButtons(true);
-                } else if (data?.state === "inactive") {
-                    statusInfo(ELEMS.instanceStatus, "No active instance");
-                    toggleStateButtons(false);
-                } else {
-                    statusError(ELEMS.instanceStatus, "Couldn't get instance info, contact an @Admin");
-                    console.error(data);
-                }
+                showInstanceStatus(data);
             }
         });
 }
 
+// Show the instance status from /api/status (or an event), and enable buttons accordingly
+function showInstanceStatus(data) {
+    toggleSpinner(data?.state === "destroying" || data?.state === "deploying");
+
+    if (data?.state === "active") {
+        statusSuccess(ELEMS.instanceStatus, `Active instance available at ${data?.host}, expires at ${data?.expTime}`);
+        if (data?.instructions) {
+            ELEMS.instanceStatus.innerText += `\n${data.instructions}`;
+        }
+        toggleStateButtons(true);
+    } else if (data?.state === "destroying") {
+        // the instance is still being torn down. the event stream says when it's done, otherwise check back in a bit
+        statusInfo(ELEMS.instanceStatus, "Instance is being destroyed");
+        disableButton(ELEMS.create);
+        disableButton(ELEMS.extend);
+        disableButton(ELEMS.destroy);
+        if (EVENTS === null) {
+            setTimeout(getInstanceStatus, 5000);
+        }
+    } else if (data?.state === "deploying") {
+        // the instance is deployed in the background, same as destroying
+        statusInfo(ELEMS.instanceStatus, "(creating instance, may take a few minutes...)");
+        disableButton(ELEMS.create);
+        disableButton(ELEMS.extend);
+        disableButton(ELEMS.destroy);
+        if (EVENTS === null) {
+            setTimeout(getInstanceStatus, 5000);
+        }
+    } else if (data?.state === "inactive") {
+        statusInfo(ELEMS.instanceStatus, "No active instance");
+        toggleStateButtons(false);
+    } else if (data?.state === "error") {
+        statusError(ELEMS.instanceStatus, data?.message);
+        toggleStateButtons(false);
+        // a failed instance has to be destroyed before a new one can be created
+        enableButton(ELEMS.destroy);
+    } else {
+        statusError(ELEMS.instanceStatus, "Couldn't get instance info, contact an @Admin");
+        console.error(data);
+    }
+}
+
+// Subscribe to the instance's state changes, so the status is updated without polling
+function subscribeToEvents() {
+    if (EVENTS !== null || typeof EventSource === "undefined") {
+        return;
+    }
+
+    EVENTS = new EventSource(instanceUrl("/api/events"));
+    for (const state of ["deploying", "active", "destroying", "inactive"]) {
+        EVENTS.addEventListener(state, e => showInstanceStatus(JSON.parse(e.data)));
+    }
+    EVENTS.addEventListener("deploy_failed", e => {
+        const data = JSON.parse(e.data);
+        showErrorToast("Couldn't create instance");
+        showInstanceStatus(data);
+    });
+    EVENTS.addEventListener("expiring-soon", e => {
+        const data = JSON.parse(e.data);
+        showNoticeToast(`Your instance expires in ${Math.ceil(data?.secondsRemaining / 60)} minute(s), extend it to keep it`);
+        showInstanceStatus(data);
+    });
+}
+
 // Handler for the Create Instance button being clicked
 function onCreate(e) {
     statusInfo(ELEMS.instanceStatus, "(creating instance, may take a few minutes...)");
     disableButton(ELEMS.create);
     
-    fetch("/api/create", { method: "POST" })
+    instancePost("/api/create")
         .then(r => {
             if (r.status === 403) {
                 showErrorToast("Couldn't create instance");
                 statusError(ELEMS.authStatus, "Please refresh the page and re-authenticate");
+            } else if (r.status === 429) {
+                // either rate limited, or the team just destroyed an instance and has to wait to redeploy
+                const retryAfter = r.headers.get("Retry-After");
+                showErrorToast(retryAfter ? `Too many requests, try again in ${retryAfter} seconds` : "Too many requests, try again in a bit");
+                getInstanceStatus();
+            } else if (r.status === 409) {
+                showErrorToast("Your instance is busy, try again in a bit");
+                getInstanceStatus();
+            } else if (r.status === 503) {
+                showErrorToast("Too many instances are running right now, please try again later");
+                getInstanceStatus();
             } else if (r.status >= 400) {
                 showErrorToast("Couldn't create instance");
-                statusError(ELEMS.instanceStatus, "Server error, contact an @Admin");
+                errorMessage(r, "Server error, contact an @Admin").then(msg => statusError(ELEMS.instanceStatus, msg));
+            } else if (r.status === 200) {
+                // the team already had a running instance, and got it back
+                return r.json().then(data => {
+                    showNoticeToast("You already have an instance");
+                    showInstanceStatus(data);
+                });
             } else {
-                showNoticeToast("Instance created");
-                getInstanceStatus();
+                // the instance is deployed in the background, the status says when it's ready
+                return r.json().then(data => {
+                    showNoticeToast("Creating instance");
+                    showInstanceStatus(data);
+                });
             }
         });
 }
@@ -184,14 +314,20 @@ function onExtend(e) {
     disableButton(ELEMS.extend);
     disableButton(ELEMS.destroy);
     
-    fetch("/api/extend", { method: "POST" })
+    instancePost("/api/extend")
         .then(r => {
             if (r.status === 403) {
                 showErrorToast("Couldn't extend instance");
                 statusError(ELEMS.authStatus, "Please refresh the page and re-authenticate");
+            } else if (r.status === 429) {
+                showErrorToast("Too many requests, try again in a bit");
+                getInstanceStatus();
+            } else if (r.status === 404) {
+                showErrorToast("Couldn't extend instance");
+                getInstanceStatus();
             } else if (r.status >= 400) {
                 showErrorToast("Couldn't extend instance");
-                statusError(ELEMS.instanceStatus, "Server error, contact an @Admin");
+                errorMessage(r, "Server error, contact an @Admin").then(msg => statusError(ELEMS.instanceStatus, msg));
             } else {
                 return r.text();
             }
@@ -210,14 +346,20 @@ function onDestroy(e) {
     disableButton(ELEMS.extend);
     disableButton(ELEMS.destroy);
     
-    fetch("/api/destroy", { method: "POST" })
+    instancePost("/api/destroy")
         .then(r => {
             if (r.status === 403) {
                 showErrorToast("Couldn't destroy instance");
                 statusError(ELEMS.authStatus, "Please refresh the page and re-authenticate");
+            } else if (r.status === 429) {
+                showErrorToast("Too many requests, try again in a bit");
+                getInstanceStatus();
+            } else if (r.status === 409) {
+                showErrorToast("Instance is busy, try again in a bit");
+                getInstanceStatus();
             } else if (r.status >= 400) {
                 showErrorToast("Couldn't destroy instance");
-                statusError(ELEMS.instanceStatus, "Server error, contact an @Admin");
+                errorMessage(r, "Server error, contact an @Admin").then(msg => statusError(ELEMS.instanceStatus, msg));
             } else {
                 showNoticeToast("Instance destroyed");
                 getInstanceStatus();
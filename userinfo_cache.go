@@ -0,0 +1,136 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"sync"
+	"time"
+)
+
+// UserInfoCache caches the team info from the scoreboard, keyed on the auth token, so repeated auths
+// don't have to ask the scoreboard again. Entries expire after a TTL, and the least recently used
+// entries are evicted once the cache is full
+type UserInfoCache struct {
+	ttl     time.Duration
+	maxSize int
+
+	mu      sync.Mutex
+	entries map[[sha256.Size]byte]*list.Element
+	order   *list.List // most recently used at the front
+
+	now func() time.Time
+}
+
+type userInfoCacheEntry struct {
+	key     [sha256.Size]byte
+	info    UserInfo
+	expTime time.Time
+}
+
+// Create a user info cache that holds up to maxSize entries for ttl each
+func NewUserInfoCache(ttl time.Duration, maxSize int) *UserInfoCache {
+	return &UserInfoCache{
+		ttl:     ttl,
+		maxSize: maxSize,
+		entries: map[[sha256.Size]byte]*list.Element{},
+		order:   list.New(),
+		now:     time.Now,
+	}
+}
+
+// the auth tokens are hashed so they aren't kept in memory
+func getUserInfoCacheKey(authToken string) [sha256.Size]byte {
+	return sha256.Sum256([]byte(authToken))
+}
+
+// Get the cached team info for an auth token, if it hasn't expired
+func (c *UserInfoCache) Get(authToken string) (UserInfo, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[getUserInfoCacheKey(authToken)]
+	if !ok {
+		return UserInfo{}, false
+	}
+
+	entry := elem.Value.(*userInfoCacheEntry)
+	if !c.now().Before(entry.expTime) {
+		c.removeElement(elem)
+		return UserInfo{}, false
+	}
+
+	c.order.MoveToFront(elem)
+	return entry.info, true
+}
+
+// Cache the team info for an auth token, evicting the least recently used entry if the cache is full
+func (c *UserInfoCache) Set(authToken string, info UserInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := getUserInfoCacheKey(authToken)
+	expTime := c.now().Add(c.ttl)
+
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*userInfoCacheEntry)
+		entry.info = info
+		entry.expTime = expTime
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&userInfoCacheEntry{key: key, info: info, expTime: expTime})
+
+	for c.order.Len() > c.maxSize {
+		c.removeElement(c.order.Back())
+	}
+}
+
+// Remove all of the cached entries for a team, so the next auth gets fresh info from the scoreboard
+func (c *UserInfoCache) DeleteTeam(teamId string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for elem := c.order.Front(); elem != nil; {
+		next := elem.Next()
+		if elem.Value.(*userInfoCacheEntry).info.Id == teamId {
+			c.removeElement(elem)
+		}
+		elem = next
+	}
+}
+
+// Get the number of cached entries, including expired ones that haven't been cleaned up yet
+func (c *UserInfoCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.order.Len()
+}
+
+// c.mu must be held
+func (c *UserInfoCache) removeElement(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.entries, elem.Value.(*userInfoCacheEntry).key)
+}
+
+// CachingAuthProvider wraps an AuthProvider, caching the team info it returns
+type CachingAuthProvider struct {
+	AuthProvider
+	Cache *UserInfoCache
+}
+
+func (p *CachingAuthProvider) UserInfo(authToken string) (UserInfo, error) {
+	if info, ok := p.Cache.Get(authToken); ok {
+		return info, nil
+	}
+
+	// errors aren't cached, so a scoreboard outage doesn't stick around
+	info, err := p.AuthProvider.UserInfo(authToken)
+	if err != nil {
+		return info, err
+	}
+
+	p.Cache.Set(authToken, info)
+	return info, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserInfoCache(t *testing.T) {
+	now := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	c := NewUserInfoCache(time.Minute, 2)
+	c.now = func() time.Time { return now }
+
+	c.Set("token1", UserInfo{TeamName: "team 1", Id: "team1"})
+	info, ok := c.Get("token1")
+	assert.True(t, ok)
+	assert.Equal(t, "team1", info.Id)
+
+	_, ok = c.Get("token2")
+	assert.False(t, ok)
+
+	// least recently used entry is evicted
+	c.Set("token2", UserInfo{Id: "team2"})
+	c.Get("token1")
+	c.Set("token3", UserInfo{Id: "team3"})
+	assert.Equal(t, 2, c.Len())
+	_, ok = c.Get("token2")
+	assert.False(t, ok)
+	_, ok = c.Get("token1")
+	assert.True(t, ok)
+
+	// entries expire
+	now = now.Add(time.Minute)
+	_, ok = c.Get("token1")
+	assert.False(t, ok)
+	assert.Equal(t, 1, c.Len())
+
+	// all of a team's entries are removed
+	c.Set("token1", UserInfo{Id: "team1"})
+	c.Set("token4", UserInfo{Id: "team1"})
+	c.DeleteTeam("team1")
+	assert.Equal(t, 0, c.Len())
+}
+
+// AuthProvider that counts the calls to UserInfo
+type countingAuthProvider struct {
+	calls int
+	err   error
+}
+
+func (p *countingAuthProvider) Authenticate(token string) (string, error) {
+	return token, nil
+}
+
+func (p *countingAuthProvider) UserInfo(authToken string) (UserInfo, error) {
+	p.calls++
+	return UserInfo{TeamName: "team " + authToken, Id: authToken}, p.err
+}
+
+func TestCachingAuthProvider(t *testing.T) {
+	inner := &countingAuthProvider{}
+	p := &CachingAuthProvider{AuthProvider: inner, Cache: NewUserInfoCache(time.Minute, 10)}
+
+	for i := 0; i < 3; i++ {
+		info, err := p.UserInfo("team1")
+		assert.Nil(t, err)
+		assert.Equal(t, "team1", info.Id)
+	}
+	assert.Equal(t, 1, inner.calls)
+
+	// errors aren't cached
+	inner.err = ErrScoreboardUnavailable
+	_, err := p.UserInfo("team2")
+	assert.True(t, errors.Is(err, ErrScoreboardUnavailable))
+	inner.err = nil
+	_, err = p.UserInfo("team2")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, inner.calls)
+}
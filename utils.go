@@ -3,6 +3,8 @@ package main
 import (
 	"crypto/sha256"
 	"fmt"
+	"net/url"
+	"sort"
 
 	"github.com/captainGeech42/chaldeploy/internal/generic_map"
 )
@@ -18,10 +20,38 @@ func Contains[T comparable](haystack []T, needle T) bool {
 	return false
 }
 
+// Get the keys of a map in sorted order, so loops over it (and the errors they return) are deterministic
+func SortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// Check if a port number is a valid TCP/UDP port, 1-65535
+func IsValidPort(port int) bool {
+	return port >= 1 && port <= 65535
+}
+
+// Check if a string is an absolute http(s) url, like https://2021.redpwn.net
+func IsAbsoluteUrl(s string) bool {
+	u, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
 // Cache of hashed values
 var hashCache = new(generic_map.MapOf[string, string])
 
 // Compute a non-cryptographic secure hash for a string (uses SHA256)
+// The hash is the first 16 hex chars (64 bits) of the digest, which is plenty to keep a CTF's challenge names apart.
+// It's used in instance names and labels, so changing the length would orphan the instances that already exist
 func HashString(message string) string {
 	// check if the hash has already been computed, and return it if it has
 	if d, ok := hashCache.Load(message); ok {
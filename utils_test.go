@@ -12,7 +12,31 @@ func TestHash(t *testing.T) {
 	assert.Equal(t, "2ba5182aef96aaf7", HashString("hello world what a sweet hash"))
 }
 
+func TestIsAbsoluteUrl(t *testing.T) {
+	assert.True(t, IsAbsoluteUrl("https://2021.redpwn.net"))
+	assert.True(t, IsAbsoluteUrl("http://ctfd.local:8000/"))
+	assert.False(t, IsAbsoluteUrl("2021.redpwn.net"))
+	assert.False(t, IsAbsoluteUrl("/api/v1"))
+	assert.False(t, IsAbsoluteUrl("ftp://2021.redpwn.net"))
+	assert.False(t, IsAbsoluteUrl("https://"))
+	assert.False(t, IsAbsoluteUrl(""))
+}
+
+func TestIsValidPort(t *testing.T) {
+	assert.True(t, IsValidPort(1))
+	assert.True(t, IsValidPort(31337))
+	assert.True(t, IsValidPort(65535))
+	assert.False(t, IsValidPort(0))
+	assert.False(t, IsValidPort(-1))
+	assert.False(t, IsValidPort(65536))
+}
+
 func TestContains(t *testing.T) {
 	assert.True(t, Contains([]int{1, 2, 3}, 3))
 	assert.False(t, Contains([]int{1, 2, 3}, 5))
 }
+
+func TestSortedKeys(t *testing.T) {
+	assert.Equal(t, []string{"a", "b", "c"}, SortedKeys(map[string]int{"c": 3, "a": 1, "b": 2}))
+	assert.Empty(t, SortedKeys(map[string]string{}))
+}
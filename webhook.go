@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// how many events can be waiting to be sent before new ones are dropped
+const webhookQueueSize = 100
+
+// how many times an event is tried before giving up on it
+const webhookMaxAttempts = 3
+
+// delay before the first retry of an event, doubled for each retry after that. a var so tests can shorten it
+var webhookRetryDelay = time.Second
+
+// the header with the HMAC-SHA256 of the payload, if a webhook secret is set
+const webhookSignatureHeader = "X-Chaldeploy-Signature"
+
+// the webhook notifier, nil if webhooks are disabled
+var webhooks *WebhookNotifier = nil
+
+// the kinds of instance events sent to the webhook
+const (
+	webhookEventCreated       = "created"
+	webhookEventDestroyed     = "destroyed"
+	webhookEventExpired       = "expired"
+	webhookEventExpiringSoon  = "expiring-soon"
+	webhookEventDestroyFailed = "destroy-failed"
+)
+
+// WebhookEvent is the JSON payload POSTed to the webhook when an instance changes
+type WebhookEvent struct {
+	Event       string `json:"event"`
+	TeamId      string `json:"teamId"`
+	ChallengeId string `json:"challengeId"`
+	Host        string `json:"host,omitempty"` // connection string for the instance
+	Time        string `json:"time"`           // RFC3339
+}
+
+// WebhookNotifier sends instance events to a webhook in the background, so a slow or broken webhook never holds up a request.
+// Delivery is best effort: events are retried a few times, and dropped if the queue is full
+type WebhookNotifier struct {
+	url    string
+	secret string
+	client *http.Client
+	queue  chan WebhookEvent
+}
+
+// Make a new webhook notifier. Start has to be called for the events to actually be sent
+func NewWebhookNotifier(url, secret string, timeout time.Duration) *WebhookNotifier {
+	return &WebhookNotifier{
+		url:    url,
+		secret: secret,
+		client: &http.Client{Timeout: timeout},
+		queue:  make(chan WebhookEvent, webhookQueueSize),
+	}
+}
+
+// Start a background goroutine that sends the queued events, one at a time
+func (n *WebhookNotifier) Start() {
+	go func() {
+		for event := range n.queue {
+			if err := n.send(event); err != nil {
+				logEvent("couldn't send webhook event", Fields{"event": event.Event, "team_id": event.TeamId, "challenge_id": event.ChallengeId, "error": err.Error()})
+			}
+		}
+	}()
+}
+
+// Queue an event for an instance. Safe to call on a nil notifier (when webhooks are disabled), and never blocks
+func (n *WebhookNotifier) Notify(event string, di *DeploymentInstance) {
+	if n == nil {
+		return
+	}
+
+	e := WebhookEvent{
+		Event:       event,
+		TeamId:      di.Key.TeamId,
+		ChallengeId: di.Key.ChallengeId,
+		Host:        di.GetCxn(),
+		Time:        time.Now().UTC().Format(time.RFC3339),
+	}
+
+	select {
+	case n.queue <- e:
+	default:
+		log.Printf("the webhook queue is full, dropping the %s event for %s", event, di.Key)
+	}
+}
+
+// Get the signature for a payload, the hex encoded HMAC-SHA256 of it with the webhook secret
+func signWebhookPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// POST an event to the webhook, retrying with backoff if it can't be reached or doesn't return a 2xx
+func (n *WebhookNotifier) send(event WebhookEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("couldn't marshal the event: %v", err)
+	}
+
+	var lastErr error
+
+	for attempt := 0; attempt < webhookMaxAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(webhookRetryDelay << (attempt - 1))
+		}
+
+		req, err := http.NewRequest(http.MethodPost, n.url, bytes.NewReader(payload))
+		if err != nil {
+			return fmt.Errorf("couldn't make the request: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if n.secret != "" {
+			req.Header.Set(webhookSignatureHeader, signWebhookPayload(n.secret, payload))
+		}
+
+		resp, err := n.client.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			lastErr = fmt.Errorf("got status %d", resp.StatusCode)
+			continue
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("gave up after %d attempts: %v", webhookMaxAttempts, lastErr)
+}
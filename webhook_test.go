@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhookSend(t *testing.T) {
+	webhookRetryDelay = time.Millisecond
+	defer func() { webhookRetryDelay = time.Second }()
+
+	var calls atomic.Int32
+	var got WebhookEvent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.Equal(t, signWebhookPayload("hunter2", body), r.Header.Get(webhookSignatureHeader))
+		assert.Nil(t, json.Unmarshal(body, &got))
+	}))
+	defer srv.Close()
+
+	n := NewWebhookNotifier(srv.URL, "hunter2", time.Second)
+	event := WebhookEvent{Event: webhookEventCreated, TeamId: "team1", ChallengeId: "default", Host: "1.2.3.4:31337", Time: "2022-10-01T12:00:00Z"}
+
+	// retried after the 500
+	assert.Nil(t, n.send(event))
+	assert.Equal(t, int32(2), calls.Load())
+	assert.Equal(t, event, got)
+
+	// gives up eventually
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer broken.Close()
+	assert.NotNil(t, NewWebhookNotifier(broken.URL, "", time.Second).send(event))
+}
+
+func TestSignWebhookPayload(t *testing.T) {
+	// echo -n '{}' | openssl dgst -sha256 -hmac hunter2
+	assert.Equal(t, "sha256=603176255680307a81ec5b984e3a7b4143d0aef1fd1576987618e55c50868ad7", signWebhookPayload("hunter2", []byte("{}")))
+}
+
+func TestWebhookNotify(t *testing.T) {
+	di := &DeploymentInstance{Key: InstanceKey{TeamId: "team1", ChallengeId: "default"}, Hostname: "1.2.3.4", Port: 31337}
+
+	// a nil notifier (webhooks disabled) is a no-op
+	var disabled *WebhookNotifier
+	disabled.Notify(webhookEventCreated, di)
+
+	n := NewWebhookNotifier("http://localhost", "", time.Second)
+	n.Notify(webhookEventDestroyed, di)
+
+	event := <-n.queue
+	assert.Equal(t, webhookEventDestroyed, event.Event)
+	assert.Equal(t, "team1", event.TeamId)
+	assert.Equal(t, "default", event.ChallengeId)
+	assert.Equal(t, "1.2.3.4:31337", event.Host)
+
+	// events are dropped instead of blocking when the queue is full
+	for i := 0; i < webhookQueueSize+10; i++ {
+		n.Notify(webhookEventCreated, di)
+	}
+	assert.Len(t, n.queue, webhookQueueSize)
+}
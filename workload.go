@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// the kinds of workloads the challenge pods can be run with
+const (
+	workloadDeployment  = "Deployment"
+	workloadStatefulSet = "StatefulSet"
+)
+
+// name of the persistent volume for statefulset challenges, from the volume claim template
+const storageVolumeName = "data"
+
+// get the name of the headless service that governs a statefulset
+func getHeadlessServiceName(appName string) string {
+	return appName + "-headless"
+}
+
+// check if the workload kind is supported
+func isValidWorkload(workload string) bool {
+	return workload == workloadDeployment || workload == workloadStatefulSet
+}
+
+// get the volume claim template for the persistent volume of a statefulset challenge.
+// the storage size is validated at startup, so MustParse won't panic here
+func (c *Config) getVolumeClaimTemplate(appName, teamId string, spec ChallengeSpec) corev1.PersistentVolumeClaim {
+	var storageClass *string
+	if c.StorageClass != "" {
+		storageClass = &c.StorageClass
+	}
+
+	return corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: storageVolumeName,
+			Labels: map[string]string{
+				"app":                              appName,
+				"app.kubernetes.io/managed-by":     "chaldeploy",
+				"chaldeploy.captaingee.ch/chal":    HashString(spec.Name),
+				"chaldeploy.captaingee.ch/team-id": teamId,
+			},
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes:      []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			StorageClassName: storageClass,
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse(c.StorageSize)},
+			},
+		},
+	}
+}
+
+// get the statefulset struct for the target app, for challenges that need a persistent volume and a stable pod name.
+// it uses the same pod template as the deployment, with the volume from the claim template mounted at config.StorageMountPath
+func (c *Config) getStatefulSet(appName, teamId string, spec ChallengeSpec, env []corev1.EnvVar) *appsv1.StatefulSet {
+	replicas := int32(c.Replicas)
+
+	return &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: appName,
+			Labels: map[string]string{
+				"app":                              appName,
+				"app.kubernetes.io/managed-by":     "chaldeploy",
+				"chaldeploy.captaingee.ch/chal":    HashString(spec.Name),
+				"chaldeploy.captaingee.ch/team-id": teamId,
+			},
+		},
+		Spec: appsv1.StatefulSetSpec{
+			Replicas:             &replicas,
+			Selector:             getSelector(appName, teamId, spec),
+			ServiceName:          getHeadlessServiceName(appName),
+			Template:             c.getPodTemplate(appName, teamId, spec, env),
+			VolumeClaimTemplates: []corev1.PersistentVolumeClaim{c.getVolumeClaimTemplate(appName, teamId, spec)},
+			// the claims are deleted explicitly when the instance is destroyed too, this just covers the statefulset
+			// being deleted some other way
+			PersistentVolumeClaimRetentionPolicy: &appsv1.StatefulSetPersistentVolumeClaimRetentionPolicy{
+				WhenDeleted: appsv1.DeletePersistentVolumeClaimRetentionPolicyType,
+				WhenScaled:  appsv1.RetainPersistentVolumeClaimRetentionPolicyType,
+			},
+		},
+	}
+}
+
+// get the headless service for a statefulset, which gives its pods stable DNS names. the statefulset needs its own,
+// since the service that teams connect to is a load balancer/node port (or has a cluster IP with an ingress)
+func (c *Config) getHeadlessService(appName, teamId string, spec ChallengeSpec) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: getHeadlessServiceName(appName),
+			Labels: map[string]string{
+				"app":                              appName,
+				"app.kubernetes.io/managed-by":     "chaldeploy",
+				"chaldeploy.captaingee.ch/chal":    HashString(spec.Name),
+				"chaldeploy.captaingee.ch/team-id": teamId,
+			},
+		},
+		Spec: corev1.ServiceSpec{
+			ClusterIP: corev1.ClusterIPNone,
+			Ports:     c.getServicePorts(spec),
+			Selector:  getSelector(appName, teamId, spec).MatchLabels,
+		},
+	}
+}
+
+// Create the workload (the deployment or statefulset, depending on config.Workload) for an instance.
+// A statefulset gets its headless service created first
+func (im *InstanceManager) createWorkload(ctx context.Context, di *DeploymentInstance, env []corev1.EnvVar) error {
+	teamId, spec := di.Key.TeamId, di.Challenge
+
+	if im.Config.Workload == workloadStatefulSet {
+		headlessService := im.Config.getHeadlessService(di.AppName, teamId, spec)
+		if _, err := im.Clientset.CoreV1().Services(di.Namespace).Create(ctx, headlessService, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("failed to create the headless service for %s: %v", di.AppName, err)
+		}
+
+		statefulSet := im.Config.getStatefulSet(di.AppName, teamId, spec, env)
+		if _, err := im.Clientset.AppsV1().StatefulSets(di.Namespace).Create(ctx, statefulSet, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("failed to create the statefulset for %s: %v", di.AppName, err)
+		}
+		return nil
+	}
+
+	deployment := im.Config.getDeployment(di.AppName, teamId, spec, env)
+	if _, err := im.Clientset.AppsV1().Deployments(di.Namespace).Create(ctx, deployment, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("failed to create the deployment for %s: %v", di.AppName, err)
+	}
+	return nil
+}
+
+// Get the number of ready pods in an instance's workload
+func (di *DeploymentInstance) getReadyReplicas(ctx context.Context) (int32, error) {
+	if di.im.Config.Workload == workloadStatefulSet {
+		statefulSet, err := di.im.Clientset.AppsV1().StatefulSets(di.Namespace).Get(ctx, di.AppName, metav1.GetOptions{})
+		if err != nil {
+			return 0, err
+		}
+		return statefulSet.Status.ReadyReplicas, nil
+	}
+
+	deployment, err := di.im.Clientset.AppsV1().Deployments(di.Namespace).Get(ctx, di.AppName, metav1.GetOptions{})
+	if err != nil {
+		return 0, err
+	}
+	return deployment.Status.ReadyReplicas, nil
+}
+
+// Delete the persistent volume claims in an instance's namespace. They'd be deleted along with the namespace anyway,
+// but this makes sure they're gone even if something else in the namespace holds up its teardown.
+// Nothing to do if the challenges don't use a statefulset
+func (di *DeploymentInstance) deleteVolumeClaims(ctx context.Context) error {
+	if di.im.Config.Workload != workloadStatefulSet {
+		return nil
+	}
+
+	if err := di.im.Clientset.CoreV1().PersistentVolumeClaims(di.Namespace).DeleteCollection(ctx, metav1.DeleteOptions{}, metav1.ListOptions{}); err != nil {
+		return fmt.Errorf("failed to delete the volume claims in %s: %v", di.Namespace, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestStatefulSet(t *testing.T) {
+	config = &Config{Replicas: 1, Workload: workloadStatefulSet, StorageSize: "1Gi", StorageMountPath: "/data"}
+	spec := ChallengeSpec{Name: "my chal", Image: "captaingeech/test-nc:latest", Port: 31337}
+
+	statefulSet := config.getStatefulSet("chaldeploy-test", "team-id", spec, nil)
+	assert.Equal(t, "chaldeploy-test", statefulSet.Name)
+	assert.Equal(t, "chaldeploy-test-headless", statefulSet.Spec.ServiceName)
+	assert.Equal(t, int32(1), *statefulSet.Spec.Replicas)
+	assert.Equal(t, getSelector("chaldeploy-test", "team-id", spec), statefulSet.Spec.Selector)
+
+	// the volume is sized from the config, and uses the cluster's default storage class if one isn't set
+	assert.Len(t, statefulSet.Spec.VolumeClaimTemplates, 1)
+	claim := statefulSet.Spec.VolumeClaimTemplates[0]
+	assert.Equal(t, storageVolumeName, claim.Name)
+	assert.Equal(t, "1Gi", claim.Spec.Resources.Requests.Storage().String())
+	assert.Equal(t, []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce}, claim.Spec.AccessModes)
+	assert.Nil(t, claim.Spec.StorageClassName)
+
+	// and is mounted in the challenge container
+	container := statefulSet.Spec.Template.Spec.Containers[0]
+	assert.Equal(t, "captaingeech/test-nc:latest", container.Image)
+	assert.Contains(t, container.VolumeMounts, corev1.VolumeMount{Name: storageVolumeName, MountPath: "/data"})
+
+	config.StorageClass = "fast"
+	claim = config.getStatefulSet("chaldeploy-test", "team-id", spec, nil).Spec.VolumeClaimTemplates[0]
+	assert.Equal(t, "fast", *claim.Spec.StorageClassName)
+
+	// deployments don't get the volume
+	config.Workload = workloadDeployment
+	assert.Empty(t, config.getDeployment("chaldeploy-test", "team-id", spec, nil).Spec.Template.Spec.Containers[0].VolumeMounts)
+}
+
+func TestCreateStatefulSet(t *testing.T) {
+	clientset := newTestInstanceManager()
+	config.Workload = workloadStatefulSet
+	config.StorageSize = "1Gi"
+	config.StorageMountPath = "/data"
+	ctx := context.Background()
+
+	cxn, err := im.CreateDeployment(ctx, "team-id", DefaultChallengeId)
+	assert.Nil(t, err)
+	assert.Equal(t, "1.2.3.4:31337", cxn)
+	di := im.GetDeploymentInstance("team-id", DefaultChallengeId)
+
+	// a statefulset is created instead of a deployment
+	statefulSet, err := clientset.AppsV1().StatefulSets(di.Namespace).Get(ctx, di.AppName, metav1.GetOptions{})
+	assert.Nil(t, err)
+
+	// governed by a headless service, not the one teams connect to
+	headless, err := clientset.CoreV1().Services(di.Namespace).Get(ctx, statefulSet.Spec.ServiceName, metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, corev1.ClusterIPNone, headless.Spec.ClusterIP)
+	assert.Equal(t, getSelector(di.AppName, "team-id", di.Challenge).MatchLabels, headless.Spec.Selector)
+	deployments, err := clientset.AppsV1().Deployments(di.Namespace).List(ctx, metav1.ListOptions{})
+	assert.Nil(t, err)
+	assert.Empty(t, deployments.Items)
+
+	// the volume claims are deleted along with the instance
+	clientset.ClearActions()
+	assert.Nil(t, im.DestroyDeployment(ctx, "team-id", DefaultChallengeId))
+	deletedClaims := false
+	for _, action := range clientset.Actions() {
+		if action.Matches("delete-collection", "persistentvolumeclaims") {
+			deletedClaims = true
+			assert.Equal(t, di.Namespace, action.(k8stesting.DeleteCollectionAction).GetNamespace())
+		}
+	}
+	assert.True(t, deletedClaims)
+}